@@ -0,0 +1,3 @@
+module awesomeProject8/gojsonpointer
+
+go 1.12
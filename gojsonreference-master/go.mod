@@ -0,0 +1,5 @@
+module github.com/xeipuuv/gojsonreference
+
+go 1.12
+
+require github.com/xeipuuv/gojsonpointer v0.0.0-20190905194746-02993c407bfb
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// headerSize is the size of the header starting every message, the ID of
+// the run (so that messages of other runs on the topic are not measured)
+// and the publish time, from which the end-to-end latency is measured
+const headerSize = 16
+
+func encodeHeader(b []byte, runID uint64, t time.Time) {
+	binary.BigEndian.PutUint64(b[:8], runID)
+	binary.BigEndian.PutUint64(b[8:16], uint64(t.UnixNano()))
+}
+
+func decodeHeader(b []byte) (uint64, time.Time, bool) {
+	if len(b) < headerSize {
+		return 0, time.Time{}, false
+	}
+	runID := binary.BigEndian.Uint64(b[:8])
+	ns := int64(binary.BigEndian.Uint64(b[8:16]))
+	return runID, time.Unix(0, ns), true
+}
+
+// Bench publishes messages to a topic for a duration, while consumers of
+// each channel receive them, and measures the rates and latencies
+type Bench struct {
+	NSQDTCPAddrs   []string
+	Topic          string
+	Channels       []string
+	Producers      int
+	Consumers      int
+	BatchSize      int
+	Rate           float64
+	Size           string
+	Sizes          SizeDistribution
+	Duration       time.Duration
+	DrainTimeout   time.Duration
+	ProducerConfig *nsq.Config
+	ConsumerConfig *nsq.Config
+}
+
+type benchProducer struct {
+	producer *nsq.Producer
+	rng      *rand.Rand
+
+	published int64
+	bytes     int64
+	latency   Histogram
+	err       error
+}
+
+// run publishes batches of messages (at the rate of the producer if any)
+// until the deadline or stopChan is closed
+func (p *benchProducer) run(b *Bench, runID uint64, deadline time.Time, stopChan chan struct{}) {
+	var interval time.Duration
+	if b.Rate > 0 {
+		interval = time.Duration(float64(time.Second) * float64(b.BatchSize*b.Producers) / b.Rate)
+	}
+
+	batch := make([][]byte, b.BatchSize)
+	sizes := make([]int, b.BatchSize)
+	var buf []byte
+	next := time.Now()
+	for {
+		if interval > 0 {
+			if d := time.Until(next); d > 0 {
+				select {
+				case <-time.After(d):
+				case <-stopChan:
+					return
+				}
+			}
+			next = next.Add(interval)
+		}
+		select {
+		case <-stopChan:
+			return
+		default:
+		}
+		now := time.Now()
+		if !now.Before(deadline) {
+			return
+		}
+
+		total := 0
+		for i := range sizes {
+			sizes[i] = b.Sizes.Next(p.rng)
+			if sizes[i] < headerSize {
+				sizes[i] = headerSize
+			}
+			total += sizes[i]
+		}
+		if len(buf) < total {
+			buf = make([]byte, total)
+			p.rng.Read(buf)
+		}
+		offset := 0
+		for i, n := range sizes {
+			batch[i] = buf[offset : offset+n]
+			encodeHeader(batch[i], runID, now)
+			offset += n
+		}
+
+		var err error
+		if len(batch) == 1 {
+			err = p.producer.Publish(b.Topic, batch[0])
+		} else {
+			err = p.producer.MultiPublish(b.Topic, batch)
+		}
+		if err != nil {
+			p.err = err
+			return
+		}
+		p.latency.Record(time.Since(now))
+		atomic.AddInt64(&p.published, int64(len(batch)))
+		atomic.AddInt64(&p.bytes, int64(total))
+	}
+}
+
+// messageKey identifies a message, its ID is only unique on its nsqd
+type messageKey struct {
+	addr string
+	id   nsq.MessageID
+}
+
+// channelMessages are the messages received by the consumers of a channel,
+// so that redeliveries are not counted as received
+type channelMessages struct {
+	sync.Mutex
+	seen map[messageKey]struct{}
+}
+
+func (cm *channelMessages) add(m *nsq.Message) bool {
+	key := messageKey{m.NSQDAddress, m.ID}
+	cm.Lock()
+	defer cm.Unlock()
+	if _, ok := cm.seen[key]; ok {
+		return false
+	}
+	cm.seen[key] = struct{}{}
+	return true
+}
+
+type benchConsumer struct {
+	consumer *nsq.Consumer
+	runID    uint64
+	messages *channelMessages
+
+	received     int64
+	bytes        int64
+	redelivered  int64
+	foreign      int64
+	lastReceived int64
+	latency      Histogram
+}
+
+func (c *benchConsumer) HandleMessage(m *nsq.Message) error {
+	now := time.Now()
+	runID, published, ok := decodeHeader(m.Body)
+	if !ok || runID != c.runID {
+		atomic.AddInt64(&c.foreign, 1)
+		return nil
+	}
+	if !c.messages.add(m) {
+		atomic.AddInt64(&c.redelivered, 1)
+		return nil
+	}
+	c.latency.Record(now.Sub(published))
+	atomic.AddInt64(&c.bytes, int64(len(m.Body)))
+	atomic.StoreInt64(&c.lastReceived, now.UnixNano())
+	atomic.AddInt64(&c.received, 1)
+	return nil
+}
+
+// Run subscribes the consumers, publishes for the duration (or until
+// termChan), then waits for the consumers to receive the published messages
+// (up to the drain timeout) and returns the report
+func (b *Bench) Run(termChan chan os.Signal) (*Report, error) {
+	runID := uint64(rand.Int63())
+	logger := log.New(os.Stderr, "", log.Flags())
+
+	// consumers subscribe first for their channels to receive every message,
+	// they are stopped once drained (stopping again is a no-op)
+	var consumers []*benchConsumer
+	defer func() {
+		for _, c := range consumers {
+			c.consumer.Stop()
+			<-c.consumer.StopChan
+		}
+	}()
+	for _, channel := range b.Channels {
+		messages := &channelMessages{seen: make(map[messageKey]struct{})}
+		for i := 0; i < b.Consumers; i++ {
+			consumer, err := nsq.NewConsumer(b.Topic, channel, b.ConsumerConfig)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create nsq.Consumer - %s", err)
+			}
+			consumer.SetLogger(logger, nsq.LogLevelError)
+			c := &benchConsumer{consumer: consumer, runID: runID, messages: messages}
+			consumer.AddHandler(c)
+			consumers = append(consumers, c)
+			err = consumer.ConnectToNSQDs(b.NSQDTCPAddrs)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var producers []*benchProducer
+	defer func() {
+		for _, p := range producers {
+			p.producer.Stop()
+		}
+	}()
+	for i := 0; i < b.Producers; i++ {
+		addr := b.NSQDTCPAddrs[i%len(b.NSQDTCPAddrs)]
+		producer, err := nsq.NewProducer(addr, b.ProducerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create nsq.Producer - %s", err)
+		}
+		producer.SetLogger(logger, nsq.LogLevelWarning)
+		producers = append(producers, &benchProducer{
+			producer: producer,
+			rng:      rand.New(rand.NewSource(rand.Int63())),
+		})
+		err = producer.Ping()
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s - %s", addr, err)
+		}
+	}
+
+	start := time.Now()
+	log.Printf("publishing to %s for %s", b.Topic, b.Duration)
+	stopChan := make(chan struct{})
+	doneChan := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, p := range producers {
+		wg.Add(1)
+		go func(p *benchProducer) {
+			p.run(b, runID, start.Add(b.Duration), stopChan)
+			wg.Done()
+		}(p)
+	}
+	go func() {
+		wg.Wait()
+		close(doneChan)
+	}()
+	interrupted := false
+	select {
+	case <-doneChan:
+	case <-termChan:
+		interrupted = true
+		close(stopChan)
+		<-doneChan
+	}
+	publishDuration := time.Since(start)
+
+	var published int64
+	for _, p := range producers {
+		published += p.published
+	}
+	expected := published * int64(len(b.Channels))
+	received := func() int64 {
+		var n int64
+		for _, c := range consumers {
+			n += atomic.LoadInt64(&c.received)
+		}
+		return n
+	}
+	if len(consumers) > 0 && !interrupted && received() < expected {
+		log.Printf("waiting up to %s for %d messages", b.DrainTimeout, expected-received())
+		ticker := time.NewTicker(10 * time.Millisecond)
+		timeout := time.After(b.DrainTimeout)
+	drain:
+		for received() < expected {
+			select {
+			case <-ticker.C:
+			case <-timeout:
+				break drain
+			case <-termChan:
+				interrupted = true
+				break drain
+			}
+		}
+		ticker.Stop()
+	}
+	for _, c := range consumers {
+		c.consumer.Stop()
+		<-c.consumer.StopChan
+	}
+
+	report := &Report{
+		Start: start,
+		Config: ReportConfig{
+			NSQDTCPAddresses: b.NSQDTCPAddrs,
+			Topic:            b.Topic,
+			Channels:         b.Channels,
+			Producers:        b.Producers,
+			Consumers:        b.Consumers,
+			BatchSize:        b.BatchSize,
+			Rate:             b.Rate,
+			Size:             b.Size,
+			DurationSeconds:  b.Duration.Seconds(),
+		},
+	}
+	if interrupted {
+		report.Errors = append(report.Errors, "interrupted")
+	}
+
+	var publishLatency Histogram
+	for i, p := range producers {
+		report.Publish.Messages += p.published
+		report.Publish.Bytes += p.bytes
+		publishLatency.Merge(&p.latency)
+		if p.err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("producer %d (%s) - %s", i, p.producer, p.err))
+		}
+	}
+	report.Publish.DurationSeconds = publishDuration.Seconds()
+	report.Publish.MessagesPerSecond = perSecond(report.Publish.Messages, report.Publish.DurationSeconds)
+	report.Publish.BytesPerSecond = perSecond(report.Publish.Bytes, report.Publish.DurationSeconds)
+	report.Publish.Latency = newLatencyReport(&publishLatency)
+
+	if len(consumers) == 0 {
+		return report, nil
+	}
+	consume := &ConsumeReport{Expected: expected}
+	var latency Histogram
+	var lastReceived int64
+	for _, c := range consumers {
+		consume.Messages += c.received
+		consume.Bytes += c.bytes
+		consume.Redelivered += c.redelivered
+		consume.Foreign += c.foreign
+		latency.Merge(&c.latency)
+		if c.lastReceived > lastReceived {
+			lastReceived = c.lastReceived
+		}
+	}
+	if consume.Messages < expected {
+		consume.Missing = expected - consume.Messages
+		report.Errors = append(report.Errors, fmt.Sprintf("%d messages not received", consume.Missing))
+	}
+	if lastReceived > 0 {
+		consume.DurationSeconds = time.Unix(0, lastReceived).Sub(start).Seconds()
+	}
+	consume.MessagesPerSecond = perSecond(consume.Messages, consume.DurationSeconds)
+	consume.BytesPerSecond = perSecond(consume.Bytes, consume.DurationSeconds)
+	consume.Latency = newLatencyReport(&latency)
+	report.Consume = consume
+	return report, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// subBucketBits is the number of significant bits of the values counted by a
+// bucket, values are recorded with a relative error under 1/2^subBucketBits
+const subBucketBits = 4
+
+const subBuckets = 1 << subBucketBits
+
+// Histogram counts durations in log-linear buckets, in constant memory
+// whatever the number of durations. It is not safe for concurrent use.
+type Histogram struct {
+	counts []int64
+	count  int64
+	sum    float64
+	min    int64
+	max    int64
+}
+
+// bucketIndex returns the bucket of a value, values under subBuckets have
+// their own bucket, then each power of two is split in subBuckets buckets
+func bucketIndex(v int64) int {
+	if v < subBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - subBucketBits - 1
+	return (shift+1)*subBuckets + int(v>>uint(shift)) - subBuckets
+}
+
+// bucketBounds returns the lowest and highest values of a bucket
+func bucketBounds(i int) (int64, int64) {
+	if i < subBuckets {
+		return int64(i), int64(i)
+	}
+	shift := uint(i/subBuckets - 1)
+	sub := int64(i%subBuckets + subBuckets)
+	return sub << shift, (sub+1)<<shift - 1
+}
+
+// Record counts a duration, negative durations (eg. of clocks out of sync)
+// are counted as 0
+func (h *Histogram) Record(d time.Duration) {
+	v := int64(d)
+	if v < 0 {
+		v = 0
+	}
+	i := bucketIndex(v)
+	for len(h.counts) <= i {
+		h.counts = append(h.counts, 0)
+	}
+	h.counts[i]++
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	h.count++
+	h.sum += float64(v)
+}
+
+// Merge adds the durations counted by another histogram
+func (h *Histogram) Merge(o *Histogram) {
+	if o.count == 0 {
+		return
+	}
+	for len(h.counts) < len(o.counts) {
+		h.counts = append(h.counts, 0)
+	}
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	if h.count == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	if o.max > h.max {
+		h.max = o.max
+	}
+	h.count += o.count
+	h.sum += o.sum
+}
+
+func (h *Histogram) Count() int64 {
+	return h.count
+}
+
+func (h *Histogram) Min() time.Duration {
+	return time.Duration(h.min)
+}
+
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(h.max)
+}
+
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / float64(h.count))
+}
+
+// Quantile returns the duration under which are the q (0 to 1) fraction of
+// the durations, the highest value of its bucket
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.count)))
+	var cumulative int64
+	for i, c := range h.counts {
+		cumulative += c
+		if cumulative >= rank {
+			_, v := bucketBounds(i)
+			if v > h.max {
+				v = h.max
+			}
+			if v < h.min {
+				v = h.min
+			}
+			return time.Duration(v)
+		}
+	}
+	return time.Duration(h.max)
+}
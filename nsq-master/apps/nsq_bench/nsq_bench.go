@@ -0,0 +1,168 @@
+// This is an NSQ client that benchmarks nsqd: producers publish messages to
+// a topic for a duration while consumers of its channels receive them, and
+// it reports the rates and latencies, end-to-end from the publish time
+// embedded in messages (the clocks of hosts running producers and consumers
+// should be in sync).
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+	"github.com/nsqio/nsq/internal/app"
+	"github.com/nsqio/nsq/internal/protocol"
+	"github.com/nsqio/nsq/internal/version"
+)
+
+var (
+	showVersion  = flag.Bool("version", false, "print version string")
+	topic        = flag.String("topic", "nsq_bench", "NSQ topic to publish and receive messages on")
+	channel      = flag.String("channel", "nsq_bench", "NSQ channel to receive messages on (suffixed by _<n> if --channels > 1)")
+	runfor       = flag.Duration("runfor", 10*time.Second, "duration of time to publish messages")
+	drainTimeout = flag.Duration("drain-timeout", 10*time.Second, "duration of time to wait for consumers to receive the published messages")
+	numProducers = flag.Int("producers", 1, "number of producers, connected round-robin to the nsqd")
+	numConsumers = flag.Int("consumers", 1, "number of consumers per channel, connected to every nsqd (0 to only publish)")
+	numChannels  = flag.Int("channels", 1, "number of channels, each receives every message")
+	size         = flag.String("size", "200", "message size in bytes: <n>, <min>-<max> (uniform), normal:<mean>,<stddev> or weighted:<n>=<weight>[,<n>=<weight>...] (at least 16, the size of the header)")
+	batchSize    = flag.Int("batch-size", 1, "number of messages per publish (MPUB if > 1)")
+	rate         = flag.Float64("rate", 0, "target rate of messages/second across producers, 0 for as fast as possible")
+	maxInFlight  = flag.Int("max-in-flight", 200, "max number of messages to allow in flight per consumer")
+	reportFile   = flag.String("report", "", "write the results as JSON to this file (- for stdout)")
+	label        = flag.String("label", "", "label of the run in the report, eg. the version or commit benchmarked")
+
+	nsqdTCPAddrs = app.StringArray{}
+)
+
+func init() {
+	flag.Var(&nsqdTCPAddrs, "nsqd-tcp-address", "nsqd TCP address (may be given multiple times)")
+}
+
+func main() {
+	producerCfg := nsq.NewConfig()
+	consumerCfg := nsq.NewConfig()
+	flag.Var(&nsq.ConfigFlag{producerCfg}, "producer-opt", "option to passthrough to nsq.Producer (may be given multiple times, http://godoc.org/github.com/nsqio/go-nsq#Config)")
+	flag.Var(&nsq.ConfigFlag{consumerCfg}, "consumer-opt", "option to passthrough to nsq.Consumer (may be given multiple times, http://godoc.org/github.com/nsqio/go-nsq#Config)")
+
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Printf("nsq_bench v%s\n", version.Binary)
+		return
+	}
+
+	log.SetPrefix("[nsq_bench] ")
+
+	if !protocol.IsValidTopicName(*topic) {
+		log.Fatal("--topic is invalid")
+	}
+
+	if len(nsqdTCPAddrs) == 0 {
+		log.Fatal("--nsqd-tcp-address required")
+	}
+
+	if *numProducers < 1 || *numConsumers < 0 || *numChannels < 1 {
+		log.Fatal("--producers and --channels should be at least 1, --consumers at least 0")
+	}
+
+	if *batchSize < 1 {
+		log.Fatal("--batch-size should be at least 1")
+	}
+
+	if *rate < 0 {
+		log.Fatal("--rate should be positive")
+	}
+
+	if *runfor <= 0 {
+		log.Fatal("--runfor should be positive")
+	}
+
+	sizes, err := ParseSizeDistribution(*size)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	channels := []string{*channel}
+	if *numChannels > 1 {
+		channels = nil
+		for i := 0; i < *numChannels; i++ {
+			channels = append(channels, fmt.Sprintf("%s_%d", *channel, i))
+		}
+	}
+	for _, c := range channels {
+		if !protocol.IsValidChannelName(c) {
+			log.Fatalf("--channel is invalid (%s)", c)
+		}
+	}
+
+	rand.Seed(time.Now().UnixNano())
+
+	termChan := make(chan os.Signal, 1)
+	signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM)
+
+	userAgent := fmt.Sprintf("nsq_bench/%s go-nsq/%s", version.Binary, nsq.VERSION)
+	producerCfg.UserAgent = userAgent
+	consumerCfg.UserAgent = userAgent
+	consumerCfg.MaxInFlight = *maxInFlight
+
+	b := &Bench{
+		NSQDTCPAddrs:   nsqdTCPAddrs,
+		Topic:          *topic,
+		Channels:       channels,
+		Producers:      *numProducers,
+		Consumers:      *numConsumers,
+		BatchSize:      *batchSize,
+		Rate:           *rate,
+		Size:           *size,
+		Sizes:          sizes,
+		Duration:       *runfor,
+		DrainTimeout:   *drainTimeout,
+		ProducerConfig: producerCfg,
+		ConsumerConfig: consumerCfg,
+	}
+	report, err := b.Run(termChan)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report.Label = *label
+	report.Version = version.Binary
+
+	logReport(report)
+	for _, e := range report.Errors {
+		log.Printf("ERROR: %s", e)
+	}
+
+	if *reportFile != "" {
+		err = writeReport(report, *reportFile)
+		if err != nil {
+			log.Fatalf("failed to write report - %s", err)
+		}
+	}
+
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+}
+
+func logReport(r *Report) {
+	us := func(v float64) time.Duration {
+		return time.Duration(v * float64(time.Microsecond))
+	}
+	p := r.Publish
+	log.Printf("published %d messages in %.3fs - %.3fmb/s - %.3fops/s - ack latency p50 %s p99 %s max %s",
+		p.Messages, p.DurationSeconds, p.BytesPerSecond/1024/1024, p.MessagesPerSecond,
+		us(p.Latency.Percentiles["p50"]), us(p.Latency.Percentiles["p99"]), us(p.Latency.MaxUs))
+	if c := r.Consume; c != nil {
+		log.Printf("received %d of %d messages in %.3fs - %.3fmb/s - %.3fops/s - end-to-end latency p50 %s p99 %s p99.9 %s max %s",
+			c.Messages, c.Expected, c.DurationSeconds, c.BytesPerSecond/1024/1024, c.MessagesPerSecond,
+			us(c.Latency.Percentiles["p50"]), us(c.Latency.Percentiles["p99"]), us(c.Latency.Percentiles["p99.9"]),
+			us(c.Latency.MaxUs))
+	}
+}
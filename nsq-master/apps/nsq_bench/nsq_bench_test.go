@@ -0,0 +1,135 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+func TestParseSizeDistribution(t *testing.T) {
+	for _, s := range []string{"", "0", "-1", "a", "10-", "20-10", "normal:", "normal:10", "normal:-1,1",
+		"weighted:", "weighted:10", "weighted:10=0", "weighted:10=1,x=1"} {
+		_, err := ParseSizeDistribution(s)
+		if err == nil {
+			t.Errorf("%q should fail", s)
+		}
+	}
+
+	r := rand.New(rand.NewSource(1))
+	tests := []struct {
+		s     string
+		valid func(n int) bool
+	}{
+		{"200", func(n int) bool { return n == 200 }},
+		{"10-20", func(n int) bool { return n >= 10 && n <= 20 }},
+		{"normal:100,10", func(n int) bool { return n > 0 }},
+		{"normal:1,100", func(n int) bool { return n > 0 }},
+		{"weighted:16=9,1024=1", func(n int) bool { return n == 16 || n == 1024 }},
+	}
+	for _, tt := range tests {
+		d, err := ParseSizeDistribution(tt.s)
+		if err != nil {
+			t.Fatalf("%q: %s", tt.s, err)
+		}
+		seen := make(map[int]bool)
+		for i := 0; i < 1000; i++ {
+			n := d.Next(r)
+			if !tt.valid(n) {
+				t.Fatalf("%q: invalid size %d", tt.s, n)
+			}
+			seen[n] = true
+		}
+		if tt.s != "200" && len(seen) < 2 {
+			t.Fatalf("%q: only sizes %v", tt.s, seen)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	for v := int64(0); v < 1<<20; v += 1 + v/7 {
+		lower, upper := bucketBounds(bucketIndex(v))
+		if v < lower || v > upper || float64(upper-lower) > float64(v)/subBuckets {
+			t.Fatalf("%d in bucket [%d, %d]", v, lower, upper)
+		}
+	}
+
+	var a, b Histogram
+	if a.Quantile(0.5) != 0 || a.Mean() != 0 {
+		t.Fatal("empty histogram should be 0")
+	}
+	for i := 1; i <= 500; i++ {
+		a.Record(time.Duration(i) * time.Microsecond)
+		b.Record(time.Duration(500+i) * time.Microsecond)
+	}
+	a.Record(-time.Second)
+	a.Merge(&b)
+	a.Merge(&Histogram{})
+
+	if a.Count() != 1001 || a.Min() != 0 || a.Max() != time.Millisecond {
+		t.Fatalf("got count %d, min %s, max %s", a.Count(), a.Min(), a.Max())
+	}
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		want := float64(time.Millisecond) * q
+		got := float64(a.Quantile(q))
+		if got < want || got > want*(1+1.0/subBuckets) {
+			t.Errorf("quantile %v: got %s, want about %s", q, time.Duration(got), time.Duration(want))
+		}
+	}
+	if a.Quantile(1) != time.Millisecond {
+		t.Errorf("quantile 1: got %s", a.Quantile(1))
+	}
+
+	r := newLatencyReport(&a)
+	var count int64
+	for _, bucket := range r.Buckets {
+		count += bucket.Count
+	}
+	if count != 1001 || r.Percentiles["p99.9"] != 1000 {
+		t.Fatalf("got %d latencies in buckets, p99.9 %v", count, r.Percentiles)
+	}
+}
+
+func TestHeader(t *testing.T) {
+	now := time.Now()
+	b := make([]byte, 20)
+	encodeHeader(b, 42, now)
+	runID, ts, ok := decodeHeader(b)
+	if !ok || runID != 42 || !ts.Equal(now) {
+		t.Fatalf("got %v %d %s", ok, runID, ts)
+	}
+	_, _, ok = decodeHeader(b[:headerSize-1])
+	if ok {
+		t.Fatal("short bodies should not be decoded")
+	}
+}
+
+func TestConsumerRedeliveries(t *testing.T) {
+	messages := &channelMessages{seen: make(map[messageKey]struct{})}
+	a := &benchConsumer{runID: 42, messages: messages}
+	b := &benchConsumer{runID: 42, messages: messages}
+	message := func(id string, addr string, runID uint64) *nsq.Message {
+		var mid nsq.MessageID
+		copy(mid[:], id)
+		body := make([]byte, headerSize)
+		encodeHeader(body, runID, time.Now())
+		m := nsq.NewMessage(mid, body)
+		m.NSQDAddress = addr
+		return m
+	}
+
+	a.HandleMessage(message("1", "nsqd1:4150", 42))
+	b.HandleMessage(message("1", "nsqd1:4150", 42)) // redelivered to another consumer
+	a.HandleMessage(message("1", "nsqd2:4150", 42)) // same ID on another nsqd
+	a.HandleMessage(message("2", "nsqd1:4150", 42))
+	a.HandleMessage(message("2", "nsqd1:4150", 42))
+	b.HandleMessage(message("3", "nsqd1:4150", 7))
+
+	received := a.received + b.received
+	redelivered := a.redelivered + b.redelivered
+	foreign := a.foreign + b.foreign
+	if received != 3 || redelivered != 2 || foreign != 1 {
+		t.Fatalf("got %d received, %d redelivered, %d foreign", received, redelivered, foreign)
+	}
+}
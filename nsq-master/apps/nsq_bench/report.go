@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+)
+
+// reportQuantiles are the quantiles of latencies in reports
+var reportQuantiles = []float64{0.5, 0.9, 0.95, 0.99, 0.999}
+
+// Report is the result of a run, written as JSON by --report to track
+// regressions across runs
+type Report struct {
+	Label   string         `json:"label,omitempty"`
+	Version string         `json:"version"`
+	Start   time.Time      `json:"start"`
+	Config  ReportConfig   `json:"config"`
+	Publish PublishReport  `json:"publish"`
+	Consume *ConsumeReport `json:"consume,omitempty"`
+	Errors  []string       `json:"errors,omitempty"`
+}
+
+type ReportConfig struct {
+	NSQDTCPAddresses []string `json:"nsqd_tcp_addresses"`
+	Topic            string   `json:"topic"`
+	Channels         []string `json:"channels"`
+	Producers        int      `json:"producers"`
+	Consumers        int      `json:"consumers"`
+	BatchSize        int      `json:"batch_size"`
+	Rate             float64  `json:"rate"`
+	Size             string   `json:"size"`
+	DurationSeconds  float64  `json:"duration_seconds"`
+}
+
+type PublishReport struct {
+	DurationSeconds   float64 `json:"duration_seconds"`
+	Messages          int64   `json:"messages"`
+	Bytes             int64   `json:"bytes"`
+	MessagesPerSecond float64 `json:"messages_per_second"`
+	BytesPerSecond    float64 `json:"bytes_per_second"`
+	// Latency is of publishes (one per batch), until nsqd acknowledges them
+	Latency *LatencyReport `json:"latency"`
+}
+
+type ConsumeReport struct {
+	DurationSeconds   float64 `json:"duration_seconds"`
+	Messages          int64   `json:"messages"`
+	Bytes             int64   `json:"bytes"`
+	MessagesPerSecond float64 `json:"messages_per_second"`
+	BytesPerSecond    float64 `json:"bytes_per_second"`
+	// Expected is the number of published messages times the number of
+	// channels, Missing those not received before --drain-timeout
+	Expected int64 `json:"expected"`
+	Missing  int64 `json:"missing"`
+	// Redelivered messages were already received on their channel, they are
+	// not counted in Messages
+	Redelivered int64 `json:"redelivered"`
+	// Foreign messages are those of other runs (or publishers) of the topic
+	Foreign int64 `json:"foreign"`
+	// Latency is end-to-end, from the publish time embedded in messages
+	Latency *LatencyReport `json:"latency"`
+}
+
+type LatencyReport struct {
+	Count       int64              `json:"count"`
+	MinUs       float64            `json:"min_us"`
+	MeanUs      float64            `json:"mean_us"`
+	MaxUs       float64            `json:"max_us"`
+	Percentiles map[string]float64 `json:"percentiles_us"`
+	Buckets     []LatencyBucket    `json:"buckets"`
+}
+
+// LatencyBucket is the count of latencies up to (and including) LeUs, and
+// above the previous bucket
+type LatencyBucket struct {
+	LeUs  float64 `json:"le_us"`
+	Count int64   `json:"count"`
+}
+
+func microseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Microsecond)
+}
+
+func newLatencyReport(h *Histogram) *LatencyReport {
+	r := &LatencyReport{
+		Count:       h.Count(),
+		MinUs:       microseconds(h.Min()),
+		MeanUs:      microseconds(h.Mean()),
+		MaxUs:       microseconds(h.Max()),
+		Percentiles: make(map[string]float64),
+		Buckets:     []LatencyBucket{},
+	}
+	for _, q := range reportQuantiles {
+		r.Percentiles["p"+strconv.FormatFloat(q*100, 'f', -1, 64)] = microseconds(h.Quantile(q))
+	}
+	for i, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+		_, upper := bucketBounds(i)
+		r.Buckets = append(r.Buckets, LatencyBucket{microseconds(time.Duration(upper)), c})
+	}
+	return r
+}
+
+func perSecond(n int64, seconds float64) float64 {
+	if seconds <= 0 {
+		return 0
+	}
+	return float64(n) / seconds
+}
+
+// writeReport writes a report as JSON to a file, or stdout for "-"
+func writeReport(r *Report, fileName string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if fileName == "-" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return ioutil.WriteFile(fileName, b, 0644)
+}
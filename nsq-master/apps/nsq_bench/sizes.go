@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// SizeDistribution draws the sizes of published messages
+type SizeDistribution interface {
+	Next(r *rand.Rand) int
+}
+
+// ParseSizeDistribution parses a --size value, one of
+//
+//	<n>                          every message is n bytes
+//	<min>-<max>                  uniform between min and max bytes
+//	normal:<mean>,<stddev>       normal, at least 1 byte
+//	weighted:<n>=<weight>,...    n bytes for weight/total of the messages
+func ParseSizeDistribution(s string) (SizeDistribution, error) {
+	var d SizeDistribution
+	var err error
+	switch {
+	case strings.HasPrefix(s, "normal:"):
+		d, err = parseNormalSize(strings.TrimPrefix(s, "normal:"))
+	case strings.HasPrefix(s, "weighted:"):
+		d, err = parseWeightedSize(strings.TrimPrefix(s, "weighted:"))
+	case strings.Contains(s, "-"):
+		parts := strings.SplitN(s, "-", 2)
+		var u uniformSize
+		u.min, err = parseSize(parts[0])
+		if err == nil {
+			u.max, err = parseSize(parts[1])
+		}
+		if err == nil && u.min > u.max {
+			err = errors.New("min is greater than max")
+		}
+		d = u
+	default:
+		var n int
+		n, err = parseSize(s)
+		d = fixedSize(n)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid size distribution %q - %s", s, err)
+	}
+	return d, nil
+}
+
+func parseSize(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("size %d should be positive", n)
+	}
+	return n, nil
+}
+
+func parseNormalSize(s string) (SizeDistribution, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return nil, errors.New("should be normal:<mean>,<stddev>")
+	}
+	mean, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return nil, err
+	}
+	stddev, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return nil, err
+	}
+	if mean <= 0 || stddev < 0 {
+		return nil, errors.New("mean should be positive and stddev not negative")
+	}
+	return normalSize{mean, stddev}, nil
+}
+
+func parseWeightedSize(s string) (SizeDistribution, error) {
+	var w weightedSize
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.New("should be weighted:<n>=<weight>,...")
+		}
+		n, err := parseSize(kv[0])
+		if err != nil {
+			return nil, err
+		}
+		weight, err := strconv.Atoi(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		if weight <= 0 {
+			return nil, fmt.Errorf("weight %d should be positive", weight)
+		}
+		w.total += weight
+		w.sizes = append(w.sizes, n)
+		w.cumulative = append(w.cumulative, w.total)
+	}
+	return &w, nil
+}
+
+type fixedSize int
+
+func (d fixedSize) Next(r *rand.Rand) int {
+	return int(d)
+}
+
+type uniformSize struct {
+	min int
+	max int
+}
+
+func (d uniformSize) Next(r *rand.Rand) int {
+	return d.min + r.Intn(d.max-d.min+1)
+}
+
+type normalSize struct {
+	mean   float64
+	stddev float64
+}
+
+func (d normalSize) Next(r *rand.Rand) int {
+	n := int(math.Round(r.NormFloat64()*d.stddev + d.mean))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+type weightedSize struct {
+	sizes      []int
+	cumulative []int
+	total      int
+}
+
+func (d *weightedSize) Next(r *rand.Rand) int {
+	x := r.Intn(d.total)
+	for i, c := range d.cumulative {
+		if x < c {
+			return d.sizes[i]
+		}
+	}
+	return d.sizes[len(d.sizes)-1]
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Filter selects the records to replay, all of the conditions that are set
+// must match
+type Filter struct {
+	// published in [Since, Until) (unix nanoseconds)
+	Since int64
+	Until int64
+
+	BodyContains []byte
+	BodyRegexp   *regexp.Regexp
+	Fields       []FieldMatch
+}
+
+// FieldMatch is a --match condition, a field of JSON bodies equal to value
+type FieldMatch struct {
+	Path  []string
+	Value string
+}
+
+// parseFieldMatch parses a --match value, <field>[.<field>...]=<value>
+func parseFieldMatch(s string) (FieldMatch, error) {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return FieldMatch{}, fmt.Errorf("invalid --match %q, should be <field>=<value>", s)
+	}
+	return FieldMatch{
+		Path:  strings.Split(s[:i], "."),
+		Value: s[i+1:],
+	}, nil
+}
+
+// Match returns true if the record satisfies all of the conditions
+func (f *Filter) Match(r *Record) bool {
+	if f.Since > 0 && r.Timestamp < f.Since {
+		return false
+	}
+	if f.Until > 0 && r.Timestamp >= f.Until {
+		return false
+	}
+	if len(f.BodyContains) > 0 && !bytes.Contains(r.Body, f.BodyContains) {
+		return false
+	}
+	if f.BodyRegexp != nil && !f.BodyRegexp.Match(r.Body) {
+		return false
+	}
+	if len(f.Fields) > 0 {
+		var body interface{}
+		dec := json.NewDecoder(bytes.NewReader(r.Body))
+		dec.UseNumber()
+		if dec.Decode(&body) != nil {
+			return false
+		}
+		for _, m := range f.Fields {
+			if !m.match(body) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// match compares the field to the value, as its string if it is a string
+// and as JSON otherwise (eg. 42, true or null)
+func (m *FieldMatch) match(body interface{}) bool {
+	v := body
+	for _, field := range m.Path {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		v, ok = obj[field]
+		if !ok {
+			return false
+		}
+	}
+	if s, ok := v.(string); ok {
+		return s == m.Value
+	}
+	b, _ := json.Marshal(v)
+	return string(b) == m.Value
+}
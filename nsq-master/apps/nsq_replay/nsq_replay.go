@@ -0,0 +1,231 @@
+// This is an NSQ client that republishes the messages of files written by
+// nsq_to_file to the specified topic.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"regexp"
+	"syscall"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+	"github.com/nsqio/nsq/internal/app"
+	"github.com/nsqio/nsq/internal/protocol"
+	"github.com/nsqio/nsq/internal/version"
+)
+
+var (
+	showVersion  = flag.Bool("version", false, "print version string")
+	topic        = flag.String("topic", "", "NSQ topic to publish to")
+	inputFormat  = flag.String("input-format", FormatRaw, "format of the files: raw (message bodies, one per line) or ndjson (JSON envelopes, see nsq_to_file --output-format)")
+	since        = flag.String("since", "", "only replay messages published at or after this RFC3339 time (requires --input-format=ndjson)")
+	until        = flag.String("until", "", "only replay messages published before this RFC3339 time (requires --input-format=ndjson)")
+	bodyContains = flag.String("body-contains", "", "only replay messages whose body contains this string")
+	bodyRegexp   = flag.String("body-regexp", "", "only replay messages whose body matches this regular expression")
+	rate         = flag.Int64("rate", 0, "throttle messages to n/second, 0 to disable")
+	envelope     = flag.Bool("envelope", false, "publish the JSON envelopes, with the original message ID, timestamp and attempts, instead of the bodies (NSQ messages have no other metadata, requires --input-format=ndjson)")
+
+	destNsqdTCPAddrs = app.StringArray{}
+	matches          = app.StringArray{}
+)
+
+func init() {
+	flag.Var(&destNsqdTCPAddrs, "nsqd-tcp-address", "destination nsqd TCP address (may be given multiple times)")
+	flag.Var(&matches, "match", "only replay JSON messages with this field value, <field>[.<field>...]=<value> (may be given multiple times)")
+}
+
+func main() {
+	cfg := nsq.NewConfig()
+	flag.Var(&nsq.ConfigFlag{cfg}, "producer-opt", "option to passthrough to nsq.Producer (may be given multiple times, http://godoc.org/github.com/nsqio/go-nsq#Config)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] <file> [<file>...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Printf("nsq_replay v%s\n", version.Binary)
+		return
+	}
+
+	if len(*topic) == 0 {
+		log.Fatal("--topic required")
+	}
+	if !protocol.IsValidTopicName(*topic) {
+		log.Fatal("--topic is invalid")
+	}
+
+	if len(destNsqdTCPAddrs) == 0 {
+		log.Fatal("--nsqd-tcp-address required")
+	}
+
+	if flag.NArg() == 0 {
+		log.Fatal("at least one file required")
+	}
+
+	if *inputFormat != FormatRaw && *inputFormat != FormatNDJSON {
+		log.Fatal("--input-format must be raw or ndjson")
+	}
+
+	filter, err := newFilter()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if (filter.Since > 0 || filter.Until > 0 || *envelope) && *inputFormat != FormatNDJSON {
+		log.Fatal("--since, --until and --envelope require --input-format=ndjson")
+	}
+
+	if *rate < 0 {
+		log.Fatal("--rate should be positive")
+	}
+
+	termChan := make(chan os.Signal, 1)
+	signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM)
+
+	cfg.UserAgent = fmt.Sprintf("nsq_replay/%s go-nsq/%s", version.Binary, nsq.VERSION)
+
+	var producers []*nsq.Producer
+	for _, addr := range destNsqdTCPAddrs {
+		producer, err := nsq.NewProducer(addr, cfg)
+		if err != nil {
+			log.Fatalf("failed to create nsq.Producer - %s", err)
+		}
+		producers = append(producers, producer)
+	}
+	defer func() {
+		for _, producer := range producers {
+			producer.Stop()
+		}
+	}()
+
+	r := &Replayer{
+		producers: producers,
+		filter:    filter,
+		termChan:  termChan,
+	}
+	if *rate > 0 {
+		log.Printf("Throttling messages rate to max:%d/second", *rate)
+		ticker := time.NewTicker(time.Second / time.Duration(*rate))
+		defer ticker.Stop()
+		r.throttle = ticker.C
+	}
+
+	start := time.Now()
+	for _, fileName := range flag.Args() {
+		err = r.replayFile(fileName)
+		if err != nil {
+			log.Printf("ERROR: %s", err)
+			break
+		}
+	}
+	log.Printf("replayed %d of %d messages in %s", r.published, r.read, time.Since(start))
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+func newFilter() (*Filter, error) {
+	filter := &Filter{BodyContains: []byte(*bodyContains)}
+	for _, t := range []struct {
+		flag  string
+		value string
+		dst   *int64
+	}{{"since", *since, &filter.Since}, {"until", *until, &filter.Until}} {
+		if t.value == "" {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339, t.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --%s value (%s), should be RFC3339", t.flag, t.value)
+		}
+		*t.dst = ts.UnixNano()
+	}
+	if *bodyRegexp != "" {
+		re, err := regexp.Compile(*bodyRegexp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --body-regexp - %s", err)
+		}
+		filter.BodyRegexp = re
+	}
+	for _, s := range matches {
+		m, err := parseFieldMatch(s)
+		if err != nil {
+			return nil, err
+		}
+		filter.Fields = append(filter.Fields, m)
+	}
+	return filter, nil
+}
+
+// Replayer publishes the records of files that match the filter, round-robin
+// to the producers
+type Replayer struct {
+	producers []*nsq.Producer
+	filter    *Filter
+	throttle  <-chan time.Time
+	termChan  chan os.Signal
+
+	read      int64
+	published int64
+}
+
+func (r *Replayer) replayFile(fileName string) error {
+	rr, err := NewRecordReader(fileName, *inputFormat)
+	if err != nil {
+		return err
+	}
+	defer rr.Close()
+
+	var read, published int64
+	defer func() {
+		log.Printf("replayed %d of %d messages from %s", published, read, fileName)
+		r.read += read
+		r.published += published
+	}()
+
+	for {
+		select {
+		case <-r.termChan:
+			return fmt.Errorf("interrupted while replaying %s", fileName)
+		default:
+		}
+
+		record, err := rr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		read++
+
+		if !r.filter.Match(record) {
+			continue
+		}
+
+		body := record.Body
+		if *envelope {
+			body, err = encodeEnvelope(record)
+			if err != nil {
+				return err
+			}
+		}
+
+		if r.throttle != nil {
+			<-r.throttle
+		}
+		producer := r.producers[(r.published+published)%int64(len(r.producers))]
+		err = producer.Publish(*topic, body)
+		if err != nil {
+			return fmt.Errorf("failed to publish message from %s - %s", fileName, err)
+		}
+		published++
+	}
+}
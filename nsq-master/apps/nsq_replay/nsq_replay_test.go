@@ -0,0 +1,185 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/itchio/lzma"
+)
+
+func readRecords(t *testing.T, fileName string, format string) []*Record {
+	rr, err := NewRecordReader(fileName, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+	var records []*Record
+	for {
+		r, err := rr.Next()
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+}
+
+func TestRecordReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nsq_replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// gzip files are written in streams ended by each sync, concatenated
+	// streams are read as one
+	streams := []string{"one\ntwo\n", "\nthree"}
+	writeFile := func(name string, compress func(io.Writer) io.WriteCloser) string {
+		fileName := filepath.Join(dir, name)
+		f, err := os.Create(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		for _, s := range streams {
+			w := compress(f)
+			io.WriteString(w, s)
+			w.Close()
+		}
+		return fileName
+	}
+	nopCloser := func(w io.Writer) io.WriteCloser { return nopWriteCloser{w} }
+	files := []string{
+		writeFile("test.log", nopCloser),
+		writeFile("test.log.gz", func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }),
+		writeFile("test.log.lzma", func(w io.Writer) io.WriteCloser { return lzma.NewWriter(w) }),
+	}
+
+	want := []*Record{{Body: []byte("one")}, {Body: []byte("two")}, {Body: []byte("three")}}
+	for _, fileName := range files {
+		records := readRecords(t, fileName, FormatRaw)
+		if !reflect.DeepEqual(records, want) {
+			t.Fatalf("%s: got %v, want %v", fileName, records, want)
+		}
+	}
+
+	_, err = NewRecordReader(filepath.Join(dir, "test.parquet"), FormatRaw)
+	if err == nil {
+		t.Fatal("parquet files should be refused")
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestRecordReaderNDJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nsq_replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lines := []string{
+		`{"id":"0123456789abcdef","timestamp":1500000000123456789,"attempts":2,"encoding":"json","body":{"a":"<b>"}}`,
+		`{"id":"0123456789abcdeg","timestamp":1500000000123456790,"attempts":1,"encoding":"json","body":"x"}`,
+		`{"id":"0123456789abcdeh","timestamp":1500000000123456791,"attempts":1,"encoding":"string","body":"not json\nline"}`,
+		`{"id":"0123456789abcdei","timestamp":1500000000123456792,"attempts":1,"encoding":"string","body":"{\"a\": 1}"}`,
+		`{"id":"0123456789abcdej","timestamp":1500000000123456793,"attempts":1,"encoding":"base64","body":"/wAi"}`,
+	}
+	fileName := filepath.Join(dir, "test.log")
+	ioutil.WriteFile(fileName, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+
+	records := readRecords(t, fileName, FormatNDJSON)
+	want := []*Record{
+		{ID: "0123456789abcdef", Timestamp: 1500000000123456789, Attempts: 2, Body: []byte(`{"a":"<b>"}`)},
+		{ID: "0123456789abcdeg", Timestamp: 1500000000123456790, Attempts: 1, Body: []byte(`"x"`)},
+		{ID: "0123456789abcdeh", Timestamp: 1500000000123456791, Attempts: 1, Body: []byte("not json\nline")},
+		{ID: "0123456789abcdei", Timestamp: 1500000000123456792, Attempts: 1, Body: []byte(`{"a": 1}`)},
+		{ID: "0123456789abcdej", Timestamp: 1500000000123456793, Attempts: 1, Body: []byte{0xff, 0x00, '"'}},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Fatalf("got %v, want %v", records, want)
+	}
+
+	// envelopes are republished as they were written
+	for i, r := range records {
+		b, err := encodeEnvelope(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != lines[i] {
+			t.Fatalf("got %s, want %s", b, lines[i])
+		}
+	}
+
+	for _, line := range []string{
+		"not json",
+		`{"id":"0123456789abcdef","encoding":"json"}`,
+		`{"id":"0123456789abcdef","encoding":"gzip","body":"x"}`,
+		`{"id":"0123456789abcdef","encoding":"base64","body":"not base64"}`,
+	} {
+		_, err := decodeEnvelope([]byte(line))
+		if err == nil {
+			t.Fatalf("%s: invalid envelope should fail", line)
+		}
+	}
+
+	ioutil.WriteFile(fileName, []byte("not json\n"), 0644)
+	rr, _ := NewRecordReader(fileName, FormatNDJSON)
+	defer rr.Close()
+	_, err = rr.Next()
+	if err == nil {
+		t.Fatal("invalid envelope should fail")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	m := func(s string) FieldMatch {
+		fm, err := parseFieldMatch(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fm
+	}
+	r := &Record{Timestamp: 100, Body: []byte(`{"user": {"id": 42, "name": "a=b"}, "ok": true}`)}
+
+	tests := []struct {
+		filter Filter
+		match  bool
+	}{
+		{Filter{}, true},
+		{Filter{Since: 100, Until: 101}, true},
+		{Filter{Since: 101}, false},
+		{Filter{Until: 100}, false},
+		{Filter{BodyContains: []byte(`"ok"`)}, true},
+		{Filter{BodyContains: []byte("nope")}, false},
+		{Filter{BodyRegexp: regexp.MustCompile(`"id": \d+`)}, true},
+		{Filter{BodyRegexp: regexp.MustCompile(`^nope`)}, false},
+		{Filter{Fields: []FieldMatch{m("user.id=42"), m("user.name=a=b"), m("ok=true")}}, true},
+		{Filter{Fields: []FieldMatch{m("user.id=43")}}, false},
+		{Filter{Fields: []FieldMatch{m("user.missing=null")}}, false},
+		{Filter{Fields: []FieldMatch{m("ok.id=true")}}, false},
+	}
+	for i, tt := range tests {
+		if got := tt.filter.Match(r); got != tt.match {
+			t.Errorf("%d: got %v, want %v", i, got, tt.match)
+		}
+	}
+
+	_, err := parseFieldMatch("=42")
+	if err == nil {
+		t.Fatal("--match without a field should fail")
+	}
+}
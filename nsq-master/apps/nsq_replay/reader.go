@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/itchio/lzma"
+)
+
+const (
+	FormatRaw    = "raw"
+	FormatNDJSON = "ndjson"
+)
+
+// Record is a message read from a file written by nsq_to_file, its ID,
+// timestamp and attempts are only known with --input-format=ndjson
+type Record struct {
+	ID        string `json:"id"`
+	Timestamp int64  `json:"timestamp"`
+	Attempts  uint16 `json:"attempts"`
+	Body      []byte `json:"-"`
+}
+
+// RecordReader reads the records of a file, one per line, decompressing
+// it according to its extension (.gz or .lzma)
+type RecordReader struct {
+	file   *os.File
+	r      *bufio.Reader
+	format string
+	line   int
+}
+
+func NewRecordReader(fileName string, format string) (*RecordReader, error) {
+	if strings.HasSuffix(fileName, ".parquet") {
+		return nil, fmt.Errorf("%s: parquet files can't be replayed, they don't contain the message bodies", fileName)
+	}
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader = bufio.NewReader(f)
+	switch {
+	case strings.HasSuffix(fileName, ".gz"):
+		// reads all of the concatenated gzip streams written by each sync
+		r, err = gzip.NewReader(r)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %s", fileName, err)
+		}
+	case strings.HasSuffix(fileName, ".lzma"):
+		r = &lzmaStreamsReader{br: r.(*bufio.Reader)}
+	}
+
+	return &RecordReader{
+		file:   f,
+		r:      bufio.NewReader(r),
+		format: format,
+	}, nil
+}
+
+// Next returns the next record, or io.EOF at the end of the file
+func (rr *RecordReader) Next() (*Record, error) {
+	for {
+		line, err := rr.r.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return nil, err
+		}
+		rr.line++
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		if len(line) == 0 {
+			continue
+		}
+
+		if rr.format == FormatRaw {
+			return &Record{Body: line}, nil
+		}
+		record, err := decodeEnvelope(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", rr.file.Name(), rr.line, err)
+		}
+		return record, nil
+	}
+}
+
+func (rr *RecordReader) Close() error {
+	return rr.file.Close()
+}
+
+// the encodings of the body of an Envelope, see nsq_to_file --output-format
+const (
+	EncodingJSON   = "json"   // the body is embedded as is
+	EncodingString = "string" // the body is a JSON string of the (UTF-8) bytes
+	EncodingBase64 = "base64" // the body is a JSON string of the base64 bytes
+)
+
+// Envelope is a line of nsq_to_file --output-format=ndjson, its encoding
+// tells how to get back the exact bytes of the body
+type Envelope struct {
+	Record
+	Encoding string          `json:"encoding"`
+	Body     json.RawMessage `json:"body"`
+}
+
+func decodeEnvelope(line []byte) (*Record, error) {
+	var e Envelope
+	err := json.Unmarshal(line, &e)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.Body) == 0 {
+		return nil, errors.New("missing body")
+	}
+	record := e.Record
+	switch e.Encoding {
+	case EncodingJSON:
+		record.Body = []byte(e.Body)
+	case EncodingString:
+		var s string
+		err = json.Unmarshal(e.Body, &s)
+		record.Body = []byte(s)
+	case EncodingBase64:
+		err = json.Unmarshal(e.Body, &record.Body)
+	default:
+		return nil, fmt.Errorf("invalid body encoding %q", e.Encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// encodeEnvelope returns the envelope of a record as written by nsq_to_file,
+// the body is embedded as is if it is compact JSON, as a string if it is
+// other UTF-8 text and as base64 otherwise
+func encodeEnvelope(r *Record) ([]byte, error) {
+	e := Envelope{Record: *r}
+	var compact bytes.Buffer
+	switch {
+	case json.Compact(&compact, r.Body) == nil && bytes.Equal(compact.Bytes(), r.Body):
+		e.Encoding = EncodingJSON
+		e.Body = r.Body
+	case utf8.Valid(r.Body):
+		e.Encoding = EncodingString
+		e.Body, _ = json.Marshal(string(r.Body))
+	default:
+		e.Encoding = EncodingBase64
+		e.Body, _ = json.Marshal(r.Body)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(e)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// lzmaStreamsReader reads all of the concatenated lzma streams of a file,
+// nsq_to_file writes a single one but files can be joined with cat
+type lzmaStreamsReader struct {
+	br *bufio.Reader
+	r  io.ReadCloser
+}
+
+func (l *lzmaStreamsReader) Read(p []byte) (int, error) {
+	for {
+		if l.r == nil {
+			_, err := l.br.Peek(1)
+			if err != nil {
+				return 0, err
+			}
+			l.r = lzma.NewReader(l.br)
+		}
+		n, err := l.r.Read(p)
+		if err == io.EOF {
+			l.r.Close()
+			l.r = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
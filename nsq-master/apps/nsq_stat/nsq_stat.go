@@ -1,5 +1,6 @@
 // This is a utility application that polls /stats for all the producers
-// of the specified topic/channel and displays aggregate stats
+// of the specified topic/channel and displays aggregate stats, or with
+// --tui those of all topics and channels full-screen
 
 package main
 
@@ -26,6 +27,7 @@ var (
 	topic              = flag.String("topic", "", "NSQ topic")
 	channel            = flag.String("channel", "", "NSQ channel")
 	interval           = flag.Duration("interval", 2*time.Second, "duration of time between polling/printing output")
+	tui                = flag.Bool("tui", false, "show all topics and channels in a full-screen terminal UI, with sorting, rate history, node and client details, and pausing")
 	httpConnectTimeout = flag.Duration("http-client-connect-timeout", 2*time.Second, "timeout for HTTP connect")
 	httpRequestTimeout = flag.Duration("http-client-request-timeout", 5*time.Second, "timeout for HTTP request")
 	countNum           = numValue{}
@@ -137,7 +139,7 @@ func main() {
 		return
 	}
 
-	if *topic == "" || *channel == "" {
+	if !*tui && (*topic == "" || *channel == "") {
 		log.Fatal("--topic and --channel are required")
 	}
 
@@ -175,6 +177,14 @@ func main() {
 		log.Fatalf("--lookupd-http-address error - %s", err)
 	}
 
+	if *tui {
+		err := tuiLoop(intvl, connectTimeout, requestTimeout, nsqdHTTPAddrs, lookupdHTTPAddrs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	termChan := make(chan os.Signal, 1)
 	signal.Notify(termChan, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
 
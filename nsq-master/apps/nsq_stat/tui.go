@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nsqio/nsq/internal/clusterinfo"
+	"github.com/nsqio/nsq/internal/http_api"
+	"golang.org/x/term"
+)
+
+const (
+	keyUp = iota + 256
+	keyDown
+	keyPageUp
+	keyPageDown
+	keyEnter
+	keyBack
+)
+
+type statsUpdate struct {
+	topicStats   []*clusterinfo.TopicStats
+	channelStats map[string]*clusterinfo.ChannelStats
+	err          error
+}
+
+// tuiLoop shows the stats of all topics and channels full-screen, polling
+// every interval, until the user quits
+func tuiLoop(interval time.Duration, connectTimeout time.Duration, requestTimeout time.Duration,
+	nsqdHTTPAddrs []string, lookupdHTTPAddrs []string) error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return fmt.Errorf("--tui requires a terminal")
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+
+	// alternate screen, hidden cursor
+	os.Stdout.WriteString("\x1b[?1049h\x1b[?25l")
+	defer os.Stdout.WriteString("\x1b[?25h\x1b[?1049l")
+
+	ci := clusterinfo.New(nil, http_api.NewClient(nil, connectTimeout, requestTimeout))
+	updateChan := make(chan statsUpdate)
+	refreshChan := make(chan bool, 1)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			var u statsUpdate
+			producers, err := ci.GetProducers(lookupdHTTPAddrs, nsqdHTTPAddrs)
+			if err == nil {
+				u.topicStats, u.channelStats, err = ci.GetNSQDStats(producers, "", "", true)
+				if _, ok := err.(clusterinfo.PartialErr); ok {
+					// show what could be queried
+					err = nil
+				}
+			}
+			u.err = err
+			updateChan <- u
+			select {
+			case <-ticker.C:
+			case <-refreshChan:
+			}
+		}
+	}()
+
+	keyChan := make(chan int)
+	go readKeys(keyChan)
+
+	v := NewView()
+	for {
+		draw(v)
+		select {
+		case u := <-updateChan:
+			if u.err != nil {
+				v.err = u.err
+				continue
+			}
+			v.Update(u.topicStats, u.channelStats, time.Now())
+		case key, ok := <-keyChan:
+			if !ok {
+				return nil
+			}
+			v.message = ""
+			switch key {
+			case 'q', 3: // ctrl-c
+				return nil
+			case keyUp, 'k':
+				v.Move(-1)
+			case keyDown, 'j':
+				v.Move(1)
+			case keyPageUp:
+				v.Move(-10)
+			case keyPageDown:
+				v.Move(10)
+			case keyEnter:
+				v.Enter()
+			case keyBack:
+				v.Back()
+			case 's':
+				v.SortBy()
+			case 'r':
+				v.Reverse()
+			case 'p':
+				r := v.Selected()
+				if v.detail != "" {
+					r = v.detailRow()
+				}
+				if r == nil {
+					continue
+				}
+				v.message = togglePause(ci, r, nsqdHTTPAddrs, lookupdHTTPAddrs)
+				select {
+				case refreshChan <- true:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// togglePause pauses (or unpauses) a topic or channel through the nsqd HTTP
+// API and returns the status message
+func togglePause(ci *clusterinfo.ClusterInfo, r *statsRow, nsqdHTTPAddrs []string, lookupdHTTPAddrs []string) string {
+	var err error
+	action := "paused"
+	if r.Paused {
+		action = "unpaused"
+	}
+	switch {
+	case r.Channel == "" && !r.Paused:
+		err = ci.PauseTopic(r.Topic, lookupdHTTPAddrs, nsqdHTTPAddrs)
+	case r.Channel == "":
+		err = ci.UnPauseTopic(r.Topic, lookupdHTTPAddrs, nsqdHTTPAddrs)
+	case !r.Paused:
+		err = ci.PauseChannel(r.Topic, r.Channel, lookupdHTTPAddrs, nsqdHTTPAddrs)
+	default:
+		err = ci.UnPauseChannel(r.Topic, r.Channel, lookupdHTTPAddrs, nsqdHTTPAddrs)
+	}
+	name := r.Topic
+	if r.Channel != "" {
+		name += "/" + r.Channel
+	}
+	if err != nil {
+		return fmt.Sprintf("ERROR: failed to %s %s - %s", strings.TrimSuffix(action, "d"), name, err)
+	}
+	return fmt.Sprintf("%s %s", action, name)
+}
+
+func draw(v *View) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	lines, highlight := v.Render(width, height)
+
+	var b bytes.Buffer
+	b.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		if i == highlight {
+			b.WriteString("\x1b[7m" + line + strings.Repeat(" ", width-len([]rune(line))) + "\x1b[0m")
+		} else {
+			b.WriteString(line + "\x1b[K")
+		}
+	}
+	b.WriteString("\x1b[J")
+	os.Stdout.Write(b.Bytes())
+}
+
+// readKeys sends the keys read from the terminal (in raw mode), escape
+// sequences of arrows and pages are sent as key* constants
+func readKeys(keyChan chan int) {
+	buf := make([]byte, 32)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			close(keyChan)
+			return
+		}
+		for _, key := range parseKeys(buf[:n]) {
+			keyChan <- key
+		}
+	}
+}
+
+func parseKeys(b []byte) []int {
+	sequences := map[string]int{
+		"\x1b[A":  keyUp,
+		"\x1b[B":  keyDown,
+		"\x1b[5~": keyPageUp,
+		"\x1b[6~": keyPageDown,
+		"\x1bOA":  keyUp,
+		"\x1bOB":  keyDown,
+	}
+	var keys []int
+	for len(b) > 0 {
+		found := false
+		for seq, key := range sequences {
+			if bytes.HasPrefix(b, []byte(seq)) {
+				keys = append(keys, key)
+				b = b[len(seq):]
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		switch b[0] {
+		case '\r', '\n':
+			keys = append(keys, keyEnter)
+		case 0x1b, 0x7f, '\b':
+			keys = append(keys, keyBack)
+		default:
+			keys = append(keys, int(b[0]))
+		}
+		b = b[1:]
+	}
+	return keys
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nsqio/nsq/internal/clusterinfo"
+)
+
+// historySize is the number of rates shown by sparklines
+const historySize = 20
+
+// sortColumns are the columns rows can be sorted by, in the order cycled
+// through by the 's' key
+var sortColumns = []string{"name", "depth", "rate", "in-flight", "requeues", "timeouts", "consumers"}
+
+// statsRow is a topic, or one of its channels, of the TUI
+type statsRow struct {
+	Topic     string
+	Channel   string
+	Depth     int64
+	Rate      float64
+	InFlight  int64
+	Requeues  int64
+	Timeouts  int64
+	Consumers int
+	Paused    bool
+	History   []float64
+
+	topicStats   *clusterinfo.TopicStats
+	channelStats *clusterinfo.ChannelStats
+}
+
+func (r *statsRow) key() string {
+	if r.Channel == "" {
+		return r.Topic
+	}
+	return r.Topic + ":" + r.Channel
+}
+
+func (r *statsRow) name() string {
+	if r.Channel == "" {
+		return r.Topic
+	}
+	return "  " + r.Channel
+}
+
+// less compares rows by a sort column, descending for numbers
+func (r *statsRow) less(o *statsRow, column string) bool {
+	switch column {
+	case "depth":
+		return r.Depth > o.Depth
+	case "rate":
+		return r.Rate > o.Rate
+	case "in-flight":
+		return r.InFlight > o.InFlight
+	case "requeues":
+		return r.Requeues > o.Requeues
+	case "timeouts":
+		return r.Timeouts > o.Timeouts
+	case "consumers":
+		return r.Consumers > o.Consumers
+	}
+	return false
+}
+
+type rateHistory struct {
+	messageCount int64
+	updated      time.Time
+	rates        []float64
+}
+
+// View is the state of the TUI, the rows of the latest stats (each topic
+// followed by its channels) and what is selected and shown
+type View struct {
+	rows      []*statsRow
+	histories map[string]*rateHistory
+
+	sortColumn int
+	reverse    bool
+	selected   string
+	detail     string
+	offset     int
+
+	message string
+	updated time.Time
+	err     error
+}
+
+func NewView() *View {
+	return &View{histories: make(map[string]*rateHistory)}
+}
+
+// Update replaces the rows by those of the stats of all topics and channels
+// (as returned by GetNSQDStats), computing rates since the previous update
+func (v *View) Update(topicStats []*clusterinfo.TopicStats, channelStats map[string]*clusterinfo.ChannelStats, now time.Time) {
+	topics := make(map[string]*clusterinfo.TopicStats)
+	for _, t := range topicStats {
+		agg, ok := topics[t.TopicName]
+		if !ok {
+			agg = &clusterinfo.TopicStats{TopicName: t.TopicName}
+			topics[t.TopicName] = agg
+		}
+		// the channels are aggregated in channelStats
+		nodeStats := *t
+		nodeStats.Channels = nil
+		agg.Add(&nodeStats)
+	}
+
+	seen := make(map[string]bool)
+	var rows []*statsRow
+	for _, t := range topics {
+		row := &statsRow{
+			Topic:      t.TopicName,
+			Depth:      t.Depth,
+			Paused:     t.Paused,
+			topicStats: t,
+		}
+		row.Rate, row.History = v.rate(row.key(), t.MessageCount, now)
+		seen[row.key()] = true
+		rows = append(rows, row)
+	}
+	for _, c := range channelStats {
+		row := &statsRow{
+			Topic:        c.TopicName,
+			Channel:      c.ChannelName,
+			Depth:        c.Depth,
+			InFlight:     c.InFlightCount,
+			Requeues:     c.RequeueCount,
+			Timeouts:     c.TimeoutCount,
+			Consumers:    c.ClientCount,
+			Paused:       c.Paused,
+			channelStats: c,
+		}
+		row.Rate, row.History = v.rate(row.key(), c.MessageCount, now)
+		seen[row.key()] = true
+		rows = append(rows, row)
+	}
+	for key := range v.histories {
+		if !seen[key] {
+			delete(v.histories, key)
+		}
+	}
+
+	v.rows = rows
+	v.sort()
+	v.updated = now
+	v.err = nil
+}
+
+// rate returns the rate of messages since the previous update, and the
+// history of rates
+func (v *View) rate(key string, messageCount int64, now time.Time) (float64, []float64) {
+	h, ok := v.histories[key]
+	if !ok {
+		v.histories[key] = &rateHistory{messageCount: messageCount, updated: now}
+		return 0, nil
+	}
+	var rate float64
+	// counts are reset when nsqd restarts
+	if elapsed := now.Sub(h.updated).Seconds(); elapsed > 0 && messageCount >= h.messageCount {
+		rate = float64(messageCount-h.messageCount) / elapsed
+	}
+	h.messageCount = messageCount
+	h.updated = now
+	h.rates = append(h.rates, rate)
+	if len(h.rates) > historySize {
+		h.rates = h.rates[len(h.rates)-historySize:]
+	}
+	return rate, h.rates
+}
+
+// sort orders topics by the sort column, each followed by its channels
+// ordered by the sort column, ties are broken by name
+func (v *View) sort() {
+	column := sortColumns[v.sortColumn]
+	topics := make(map[string]*statsRow)
+	for _, r := range v.rows {
+		if r.Channel == "" {
+			topics[r.Topic] = r
+		}
+	}
+	group := func(r *statsRow) *statsRow {
+		if t, ok := topics[r.Topic]; ok {
+			return t
+		}
+		return r
+	}
+	sort.SliceStable(v.rows, func(i, j int) bool {
+		a, b := v.rows[i], v.rows[j]
+		ga, gb := group(a), group(b)
+		if ga.Topic != gb.Topic {
+			if v.reverse {
+				ga, gb = gb, ga
+			}
+			if ga.less(gb, column) {
+				return true
+			}
+			if gb.less(ga, column) {
+				return false
+			}
+			return ga.Topic < gb.Topic
+		}
+		if (a.Channel == "") != (b.Channel == "") {
+			return a.Channel == ""
+		}
+		if v.reverse {
+			a, b = b, a
+		}
+		if a.less(b, column) {
+			return true
+		}
+		if b.less(a, column) {
+			return false
+		}
+		return a.Channel < b.Channel
+	})
+}
+
+// SortBy cycles through the sort columns
+func (v *View) SortBy() {
+	v.sortColumn = (v.sortColumn + 1) % len(sortColumns)
+	v.sort()
+}
+
+// Reverse reverses the sort order
+func (v *View) Reverse() {
+	v.reverse = !v.reverse
+	v.sort()
+}
+
+func (v *View) selectedIndex() int {
+	for i, r := range v.rows {
+		if r.key() == v.selected {
+			return i
+		}
+	}
+	return 0
+}
+
+// Selected returns the selected row, nil if there are none
+func (v *View) Selected() *statsRow {
+	if len(v.rows) == 0 {
+		return nil
+	}
+	return v.rows[v.selectedIndex()]
+}
+
+// Move moves the selection up (negative) or down
+func (v *View) Move(n int) {
+	if len(v.rows) == 0 || v.detail != "" {
+		return
+	}
+	i := v.selectedIndex() + n
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(v.rows) {
+		i = len(v.rows) - 1
+	}
+	v.selected = v.rows[i].key()
+}
+
+// Enter shows the details of the selected row
+func (v *View) Enter() {
+	if r := v.Selected(); r != nil {
+		v.selected = r.key()
+		v.detail = r.key()
+	}
+}
+
+// Back returns to the list from the details
+func (v *View) Back() {
+	v.detail = ""
+}
+
+// Render returns the lines of the screen, and the index of the selected
+// line (-1 if none) to highlight
+func (v *View) Render(width int, height int) ([]string, int) {
+	if height < 4 {
+		height = 4
+	}
+	var lines []string
+	title := "nsq_stat"
+	if !v.updated.IsZero() {
+		title += " - updated " + v.updated.Format("15:04:05")
+	}
+	lines = append(lines, title)
+
+	var body []string
+	var help string
+	highlight := -1
+	if r := v.detailRow(); r != nil {
+		body = renderDetail(r)
+		help = "esc/backspace: back  p: pause/unpause  q: quit"
+	} else {
+		body, highlight = v.renderList(height - 4)
+		if highlight >= 0 {
+			highlight += len(lines)
+		}
+		sortColumn := sortColumns[v.sortColumn]
+		if v.reverse {
+			sortColumn += ", reversed"
+		}
+		help = fmt.Sprintf("up/down: select  enter: details  s: sort (%s)  r: reverse  p: pause/unpause  q: quit",
+			sortColumn)
+	}
+	lines = append(lines, body...)
+
+	status := v.message
+	if v.err != nil {
+		status = "ERROR: " + v.err.Error()
+	}
+	for len(lines) < height-2 {
+		lines = append(lines, "")
+	}
+	lines = append(lines[:height-2], status, help)
+
+	for i, line := range lines {
+		if len([]rune(line)) > width {
+			lines[i] = string([]rune(line)[:width])
+		}
+	}
+	return lines, highlight
+}
+
+func (v *View) detailRow() *statsRow {
+	if v.detail == "" {
+		return nil
+	}
+	for _, r := range v.rows {
+		if r.key() == v.detail {
+			return r
+		}
+	}
+	return nil
+}
+
+const listFormat = "%-40s %6s %10s %9s %10s %9s %9s %9s  %s"
+
+func (v *View) renderList(height int) ([]string, int) {
+	lines := []string{fmt.Sprintf(listFormat,
+		"TOPIC/CHANNEL", "", "DEPTH", "RATE", "IN-FLIGHT", "REQUEUES", "TIMEOUTS", "CONSUMERS", "RATE HISTORY")}
+	if height < 1 || len(v.rows) == 0 {
+		return lines, -1
+	}
+
+	// scroll to keep the selection visible
+	selected := v.selectedIndex()
+	if selected < v.offset {
+		v.offset = selected
+	}
+	if selected >= v.offset+height {
+		v.offset = selected - height + 1
+	}
+	if v.offset > len(v.rows) {
+		v.offset = 0
+	}
+
+	highlight := -1
+	for i := v.offset; i < len(v.rows) && i < v.offset+height; i++ {
+		r := v.rows[i]
+		inFlight, requeues, timeouts, consumers := "", "", "", ""
+		if r.Channel != "" {
+			inFlight = fmt.Sprint(r.InFlight)
+			requeues = fmt.Sprint(r.Requeues)
+			timeouts = fmt.Sprint(r.Timeouts)
+			consumers = fmt.Sprint(r.Consumers)
+		}
+		line := fmt.Sprintf(listFormat, r.name(), pausedString(r.Paused), fmt.Sprint(r.Depth), fmt.Sprintf("%.1f/s", r.Rate),
+			inFlight, requeues, timeouts, consumers, sparkline(r.History))
+		if i == selected {
+			highlight = len(lines)
+		}
+		lines = append(lines, line)
+	}
+	return lines, highlight
+}
+
+func renderDetail(r *statsRow) []string {
+	var lines []string
+	if r.Channel == "" {
+		t := r.topicStats
+		lines = append(lines, fmt.Sprintf("topic %s  depth %d  rate %.1f/s  messages %d  paused %v",
+			r.Topic, t.Depth, r.Rate, t.MessageCount, t.Paused), "")
+		lines = append(lines, fmt.Sprintf("%-30s %10s %10s %10s %12s %7s", "NODE", "DEPTH", "MEMORY", "DISK", "MESSAGES", ""))
+		for _, n := range t.NodeStats {
+			lines = append(lines, fmt.Sprintf("%-30s %10d %10d %10d %12d %7s",
+				n.Node, n.Depth, n.MemoryDepth, n.BackendDepth, n.MessageCount, pausedString(n.Paused)))
+		}
+		return lines
+	}
+
+	c := r.channelStats
+	lines = append(lines, fmt.Sprintf("channel %s/%s  depth %d  rate %.1f/s  messages %d  paused %v",
+		r.Topic, r.Channel, c.Depth, r.Rate, c.MessageCount, c.Paused), "")
+	lines = append(lines, fmt.Sprintf("%-30s %10s %9s %9s %9s %9s %12s %9s %7s",
+		"NODE", "DEPTH", "IN-FLIGHT", "DEFERRED", "REQUEUES", "TIMEOUTS", "MESSAGES", "CONSUMERS", ""))
+	for _, n := range c.NodeStats {
+		lines = append(lines, fmt.Sprintf("%-30s %10d %9d %9d %9d %9d %12d %9d %7s",
+			n.Node, n.Depth, n.InFlightCount, n.DeferredCount, n.RequeueCount, n.TimeoutCount,
+			n.MessageCount, n.ClientCount, pausedString(n.Paused)))
+	}
+	lines = append(lines, "", fmt.Sprintf("%-30s %-30s %6s %9s %10s %9s %10s  %s",
+		"NODE", "CLIENT", "READY", "IN-FLIGHT", "FINISHED", "REQUEUES", "CONNECTED", "USER AGENT"))
+	for _, cl := range c.Clients {
+		lines = append(lines, fmt.Sprintf("%-30s %-30s %6d %9d %10d %9d %10s  %s",
+			cl.Node, cl.Hostname+" "+cl.RemoteAddress, cl.ReadyCount, cl.InFlightCount, cl.FinishCount,
+			cl.RequeueCount, cl.ConnectedDuration.Truncate(time.Second), cl.UserAgent))
+	}
+	return lines
+}
+
+func pausedString(paused bool) string {
+	if paused {
+		return "paused"
+	}
+	return ""
+}
+
+var sparkChars = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline returns a bar per rate, scaled to the highest
+func sparkline(rates []float64) string {
+	var max float64
+	for _, r := range rates {
+		if r > max {
+			max = r
+		}
+	}
+	var b strings.Builder
+	for _, r := range rates {
+		i := 0
+		if max > 0 {
+			i = int(r / max * float64(len(sparkChars)-1))
+		}
+		b.WriteRune(sparkChars[i])
+	}
+	return b.String()
+}
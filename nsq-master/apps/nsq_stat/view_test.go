@@ -0,0 +1,150 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsqio/nsq/internal/clusterinfo"
+	"github.com/nsqio/nsq/internal/quantile"
+)
+
+func testStats(messageCount int64) ([]*clusterinfo.TopicStats, map[string]*clusterinfo.ChannelStats) {
+	topicStats := []*clusterinfo.TopicStats{
+		{Node: "n1", TopicName: "a", Depth: 1, MessageCount: messageCount},
+		{Node: "n2", TopicName: "a", Depth: 2, MessageCount: messageCount},
+		{Node: "n1", TopicName: "b", Depth: 10, MessageCount: 0, Paused: true},
+	}
+	for _, t := range topicStats {
+		t.E2eProcessingLatency = &quantile.E2eProcessingLatencyAggregate{}
+	}
+	channelStats := map[string]*clusterinfo.ChannelStats{
+		"a:x": {TopicName: "a", ChannelName: "x", Depth: 5, MessageCount: messageCount},
+		"a:y": {TopicName: "a", ChannelName: "y", Depth: 7, MessageCount: 0},
+		"b:z": {TopicName: "b", ChannelName: "z", Depth: 1, MessageCount: 0},
+	}
+	return topicStats, channelStats
+}
+
+func rowKeys(v *View) []string {
+	var keys []string
+	for _, r := range v.rows {
+		keys = append(keys, r.key())
+	}
+	return keys
+}
+
+func TestViewUpdate(t *testing.T) {
+	v := NewView()
+	now := time.Unix(1500000000, 0)
+	topicStats, channelStats := testStats(100)
+	v.Update(topicStats, channelStats, now)
+	v.Update(topicStats, channelStats, now)
+	for _, count := range []int64{120, 160, 10} {
+		now = now.Add(2 * time.Second)
+		topicStats, channelStats = testStats(count)
+		v.Update(topicStats, channelStats, now)
+	}
+
+	a := v.rows[0]
+	if a.key() != "a" || a.Depth != 3 {
+		t.Fatalf("topic a should be aggregated, got %+v", a)
+	}
+	// 20 and 40 messages per node every 2s, the counts reset at the end
+	if want := []float64{0, 20, 40, 0}; !reflect.DeepEqual(a.History, want) {
+		t.Fatalf("got history %v, want %v", a.History, want)
+	}
+	if want := []float64{0, 10, 20, 0}; !reflect.DeepEqual(v.histories["a:x"].rates, want) {
+		t.Fatalf("got history %v, want %v", v.histories["a:x"].rates, want)
+	}
+
+	// histories of deleted topics and channels are dropped
+	v.Update(nil, nil, now.Add(time.Second))
+	if len(v.rows) != 0 || len(v.histories) != 0 {
+		t.Fatalf("got %d rows and %d histories", len(v.rows), len(v.histories))
+	}
+}
+
+func TestViewSort(t *testing.T) {
+	v := NewView()
+	topicStats, channelStats := testStats(0)
+	v.Update(topicStats, channelStats, time.Now())
+	if want := []string{"a", "a:x", "a:y", "b", "b:z"}; !reflect.DeepEqual(rowKeys(v), want) {
+		t.Fatalf("by name: got %v, want %v", rowKeys(v), want)
+	}
+	v.SortBy()
+	if want := []string{"b", "b:z", "a", "a:y", "a:x"}; !reflect.DeepEqual(rowKeys(v), want) {
+		t.Fatalf("by depth: got %v, want %v", rowKeys(v), want)
+	}
+	v.Reverse()
+	if want := []string{"a", "a:x", "a:y", "b", "b:z"}; !reflect.DeepEqual(rowKeys(v), want) {
+		t.Fatalf("by depth reversed: got %v, want %v", rowKeys(v), want)
+	}
+}
+
+func TestViewNavigation(t *testing.T) {
+	v := NewView()
+	if v.Selected() != nil {
+		t.Fatal("nothing should be selected without rows")
+	}
+	topicStats, channelStats := testStats(0)
+	v.Update(topicStats, channelStats, time.Now())
+
+	v.Move(-1)
+	if v.Selected().key() != "a" {
+		t.Fatalf("got %s selected", v.Selected().key())
+	}
+	v.Move(10)
+	if v.Selected().key() != "b:z" {
+		t.Fatalf("got %s selected", v.Selected().key())
+	}
+	v.Move(-2)
+	v.Enter()
+	if r := v.detailRow(); r == nil || r.key() != "a:y" {
+		t.Fatalf("got %v in details", r)
+	}
+	v.Move(1)
+	lines, highlight := v.Render(200, 24)
+	if highlight != -1 || !strings.HasPrefix(lines[1], "channel a/y ") {
+		t.Fatalf("got %q highlighted %d", lines[1], highlight)
+	}
+
+	// the selection follows the row when the order changes
+	v.Back()
+	v.SortBy()
+	lines, highlight = v.Render(200, 24)
+	if len(lines) != 24 || !strings.HasPrefix(lines[highlight], "  y ") {
+		t.Fatalf("got %d lines, %q highlighted", len(lines), lines[highlight])
+	}
+
+	// and stays visible when scrolling
+	v.Move(10)
+	lines, highlight = v.Render(200, 6)
+	if len(lines) != 6 || !strings.HasPrefix(lines[highlight], "  x ") {
+		t.Fatalf("got %d lines, %q highlighted", len(lines), lines[highlight])
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	for _, tt := range []struct {
+		rates []float64
+		want  string
+	}{
+		{nil, ""},
+		{[]float64{0, 0}, "▁▁"},
+		{[]float64{0, 5, 10}, "▁▄█"},
+	} {
+		if got := sparkline(tt.rates); got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.rates, got, tt.want)
+		}
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	got := parseKeys([]byte("j\x1b[A\x1b[6~\r\x1bq"))
+	want := []int{'j', keyUp, keyPageDown, keyEnter, keyBack, 'q'}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"compress/gzip"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -10,20 +12,32 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/itchio/lzma"
 	"github.com/nsqio/go-nsq"
 	"github.com/nsqio/nsq/internal/lg"
 )
 
+const (
+	FormatRaw     = "raw"
+	FormatNDJSON  = "ndjson"
+	FormatParquet = "parquet"
+)
+
 type FileLogger struct {
 	logf     lg.AppLogFunc
 	opts     *Options
 	topic    string
 	consumer *nsq.Consumer
+	uploader *Uploader
 
 	out            *os.File
 	writer         io.Writer
 	gzipWriter     *gzip.Writer
+	lzmaWriter     io.WriteCloser
+	parquetWriter  *ParquetWriter
+	parquetColumns []ParquetColumn
 	logChan        chan *nsq.Message
 	filenameFormat string
 
@@ -37,12 +51,17 @@ type FileLogger struct {
 	rev      uint
 }
 
-func NewFileLogger(logf lg.AppLogFunc, opts *Options, topic string, cfg *nsq.Config) (*FileLogger, error) {
+func NewFileLogger(logf lg.AppLogFunc, opts *Options, topic string, cfg *nsq.Config, uploader *Uploader) (*FileLogger, error) {
 	computedFilenameFormat, err := computeFilenameFormat(opts, topic)
 	if err != nil {
 		return nil, err
 	}
 
+	parquetColumns, err := parseParquetColumns(opts.ParquetColumns)
+	if err != nil {
+		return nil, err
+	}
+
 	consumer, err := nsq.NewConsumer(topic, opts.Channel, cfg)
 	if err != nil {
 		return nil, err
@@ -53,8 +72,10 @@ func NewFileLogger(logf lg.AppLogFunc, opts *Options, topic string, cfg *nsq.Con
 		opts:           opts,
 		topic:          topic,
 		consumer:       consumer,
+		uploader:       uploader,
 		logChan:        make(chan *nsq.Message, 1),
 		filenameFormat: computedFilenameFormat,
+		parquetColumns: parquetColumns,
 		termChan:       make(chan bool),
 		hupChan:        make(chan bool),
 	}
@@ -114,16 +135,11 @@ func (f *FileLogger) router() {
 				f.updateFile()
 				sync = true
 			}
-			_, err := f.Write(m.Body)
+			err := f.writeMessage(m)
 			if err != nil {
 				f.logf(lg.FATAL, "[%s/%s] writing message to disk: %s", f.topic, f.opts.Channel, err)
 				os.Exit(1)
 			}
-			_, err = f.Write([]byte("\n"))
-			if err != nil {
-				f.logf(lg.FATAL, "[%s/%s] writing newline to disk: %s", f.topic, f.opts.Channel, err)
-				os.Exit(1)
-			}
 			output[pos] = m
 			pos++
 			if pos == cap(output) {
@@ -164,6 +180,7 @@ func (f *FileLogger) Close() {
 	if f.out == nil {
 		return
 	}
+	defer func() { f.out = nil }()
 
 	if f.gzipWriter != nil {
 		err := f.gzipWriter.Close()
@@ -171,6 +188,23 @@ func (f *FileLogger) Close() {
 			f.logf(lg.FATAL, "[%s/%s] failed to close GZIP writer: %s", f.topic, f.opts.Channel, err)
 			os.Exit(1)
 		}
+		f.gzipWriter = nil
+	}
+	if f.lzmaWriter != nil {
+		err := f.lzmaWriter.Close()
+		if err != nil {
+			f.logf(lg.FATAL, "[%s/%s] failed to close LZMA writer: %s", f.topic, f.opts.Channel, err)
+			os.Exit(1)
+		}
+		f.lzmaWriter = nil
+	}
+	if f.parquetWriter != nil {
+		err := f.parquetWriter.Close()
+		if err != nil {
+			f.logf(lg.FATAL, "[%s/%s] failed to close parquet writer: %s", f.topic, f.opts.Channel, err)
+			os.Exit(1)
+		}
+		f.parquetWriter = nil
 	}
 	err := f.out.Sync()
 	if err != nil {
@@ -185,43 +219,118 @@ func (f *FileLogger) Close() {
 
 	// Move file from work dir to output dir if necessary, taking care not
 	// to overwrite existing files
+	finished := f.out.Name()
 	if f.opts.WorkDir != f.opts.OutputDir {
-		src := f.out.Name()
-		dst := filepath.Join(f.opts.OutputDir, strings.TrimPrefix(src, f.opts.WorkDir))
+		finished = f.moveFinishedFile(finished)
+	}
+
+	if f.uploader != nil {
+		f.uploader.Upload(finished)
+	}
+}
+
+// moveFinishedFile moves a file from the work dir to the output dir and
+// returns its new name
+func (f *FileLogger) moveFinishedFile(src string) string {
+	dst := filepath.Join(f.opts.OutputDir, strings.TrimPrefix(src, f.opts.WorkDir))
+
+	// Optimistic rename
+	f.logf(lg.INFO, "[%s/%s] moving finished file %s to %s", f.topic, f.opts.Channel, src, dst)
+	err := exclusiveRename(src, dst)
+	if err == nil {
+		return dst
+	} else if !os.IsExist(err) {
+		f.logf(lg.FATAL, "[%s/%s] unable to move file from %s to %s: %s", f.topic, f.opts.Channel, src, dst, err)
+		os.Exit(1)
+	}
 
-		// Optimistic rename
-		f.logf(lg.INFO, "[%s/%s] moving finished file %s to %s", f.topic, f.opts.Channel, src, dst)
+	// Optimistic rename failed, so we need to generate a new
+	// destination file name by bumping the revision number.
+	_, filenameTmpl := filepath.Split(f.filename)
+	dstDir, _ := filepath.Split(dst)
+	dstTmpl := filepath.Join(dstDir, filenameTmpl)
+
+	for i := f.rev + 1; ; i++ {
+		f.logf(lg.WARN, "[%s/%s] destination file already exists: %s", f.topic, f.opts.Channel, dst)
+		dst := strings.Replace(dstTmpl, "<REV>", fmt.Sprintf("-%06d", i), -1)
 		err := exclusiveRename(src, dst)
-		if err == nil {
-			return
-		} else if !os.IsExist(err) {
-			f.logf(lg.FATAL, "[%s/%s] unable to move file from %s to %s: %s", f.topic, f.opts.Channel, src, dst, err)
+		if err != nil {
+			if os.IsExist(err) {
+				continue // next rev
+			}
+			f.logf(lg.FATAL, "[%s/%s] unable to rename file from %s to %s: %s", f.topic, f.opts.Channel, src, dst, err)
 			os.Exit(1)
 		}
+		f.logf(lg.INFO, "[%s/%s] renamed finished file %s to %s to avoid overwrite", f.topic, f.opts.Channel, src, dst)
+		return dst
+	}
+}
 
-		// Optimistic rename failed, so we need to generate a new
-		// destination file name by bumping the revision number.
-		_, filenameTmpl := filepath.Split(f.filename)
-		dstDir, _ := filepath.Split(dst)
-		dstTmpl := filepath.Join(dstDir, filenameTmpl)
-
-		for i := f.rev + 1; ; i++ {
-			f.logf(lg.WARN, "[%s/%s] destination file already exists: %s", f.topic, f.opts.Channel, dst)
-			dst := strings.Replace(dstTmpl, "<REV>", fmt.Sprintf("-%06d", i), -1)
-			err := exclusiveRename(src, dst)
-			if err != nil {
-				if os.IsExist(err) {
-					continue // next rev
-				}
-				f.logf(lg.FATAL, "[%s/%s] unable to rename file from %s to %s: %s", f.topic, f.opts.Channel, src, dst, err)
-				os.Exit(1)
-			}
-			f.logf(lg.INFO, "[%s/%s] renamed finished file %s to %s to avoid overwrite", f.topic, f.opts.Channel, src, dst)
-			break
+// writeMessage writes a message in the --output-format
+func (f *FileLogger) writeMessage(m *nsq.Message) error {
+	switch f.opts.OutputFormat {
+	case FormatParquet:
+		f.filesize += int64(len(m.Body))
+		return f.parquetWriter.Write(m)
+	case FormatNDJSON:
+		// without HTML escaping a "json" body is written byte for byte
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		err := enc.Encode(newEnvelope(m))
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(buf.Bytes())
+		return err
+	default:
+		_, err := f.Write(m.Body)
+		if err != nil {
+			return err
 		}
+		_, err = f.Write([]byte("\n"))
+		return err
 	}
+}
+
+// the encodings of the body of an Envelope
+const (
+	EncodingJSON   = "json"   // the body is embedded as is
+	EncodingString = "string" // the body is a JSON string of the (UTF-8) bytes
+	EncodingBase64 = "base64" // the body is a JSON string of the base64 bytes
+)
 
-	f.out = nil
+// Envelope is a message of --output-format=ndjson, its encoding tells how
+// to get back the exact bytes of the body
+type Envelope struct {
+	ID        string          `json:"id"`
+	Timestamp int64           `json:"timestamp"`
+	Attempts  uint16          `json:"attempts"`
+	Encoding  string          `json:"encoding"`
+	Body      json.RawMessage `json:"body"`
+}
+
+// newEnvelope embeds the body as is if it is compact JSON (which is written
+// unchanged), as a string if it is other UTF-8 text and as base64 otherwise
+func newEnvelope(m *nsq.Message) *Envelope {
+	e := &Envelope{
+		ID:        string(m.ID[:]),
+		Timestamp: m.Timestamp,
+		Attempts:  m.Attempts,
+	}
+	var compact bytes.Buffer
+	switch {
+	case json.Compact(&compact, m.Body) == nil && bytes.Equal(compact.Bytes(), m.Body):
+		e.Encoding = EncodingJSON
+		e.Body = m.Body
+	case utf8.Valid(m.Body):
+		e.Encoding = EncodingString
+		e.Body, _ = json.Marshal(string(m.Body))
+	default:
+		e.Encoding = EncodingBase64
+		e.Body, _ = json.Marshal(m.Body)
+	}
+	return e
 }
 
 func (f *FileLogger) Write(p []byte) (int, error) {
@@ -232,7 +341,20 @@ func (f *FileLogger) Write(p []byte) (int, error) {
 
 func (f *FileLogger) Sync() error {
 	var err error
-	if f.gzipWriter != nil {
+	if f.parquetWriter != nil {
+		// the buffered rows are written as a row group, the file is only
+		// readable once its footer is written at rotation
+		err = f.parquetWriter.Flush()
+		if err != nil {
+			return err
+		}
+		err = f.out.Sync()
+	} else if f.lzmaWriter != nil {
+		// unlike gzip streams, concatenated lzma streams aren't readable by
+		// standard tools so the stream is only finished at rotation, what
+		// the encoder buffers isn't on disk yet
+		err = f.out.Sync()
+	} else if f.gzipWriter != nil {
 		// finish current gzip stream and start a new one (concatenated)
 		// gzip stream trailer has checksum, and can indicate which messages were ACKed
 		err = f.gzipWriter.Close()
@@ -326,7 +448,7 @@ func (f *FileLogger) updateFile() {
 		}
 
 		openFlag := os.O_WRONLY | os.O_CREATE
-		if f.opts.GZIP || f.opts.RotateInterval > 0 {
+		if f.opts.GZIP || f.opts.LZMA || f.opts.OutputFormat == FormatParquet || f.opts.S3Bucket != "" || f.opts.RotateInterval > 0 {
 			openFlag |= os.O_EXCL
 		} else {
 			openFlag |= os.O_APPEND
@@ -358,10 +480,20 @@ func (f *FileLogger) updateFile() {
 		break // good file
 	}
 
-	if f.opts.GZIP {
+	switch {
+	case f.opts.OutputFormat == FormatParquet:
+		f.parquetWriter, err = NewParquetWriter(f.out, f.parquetColumns, f.opts.ParquetCompression)
+		if err != nil {
+			f.logf(lg.FATAL, "[%s/%s] unable to create parquet writer for %s: %s", f.topic, f.opts.Channel, f.out.Name(), err)
+			os.Exit(1)
+		}
+	case f.opts.GZIP:
 		f.gzipWriter, _ = gzip.NewWriterLevel(f.out, f.opts.GZIPLevel)
 		f.writer = f.gzipWriter
-	} else {
+	case f.opts.LZMA:
+		f.lzmaWriter = lzma.NewWriterLevel(f.out, f.opts.LZMALevel)
+		f.writer = f.lzmaWriter
+	default:
 		f.writer = f.out
 	}
 }
@@ -402,9 +534,10 @@ func computeFilenameFormat(opts *Options, topic string) (string, error) {
 	}
 
 	cff := opts.FilenameFormat
-	if opts.GZIP || opts.RotateSize > 0 || opts.RotateInterval > 0 || opts.WorkDir != opts.OutputDir {
+	if opts.GZIP || opts.LZMA || opts.OutputFormat == FormatParquet || opts.S3Bucket != "" ||
+		opts.RotateSize > 0 || opts.RotateInterval > 0 || opts.WorkDir != opts.OutputDir {
 		if strings.Index(cff, "<REV>") == -1 {
-			return "", errors.New("missing <REV> in --filename-format when gzip, rotation, or work dir enabled")
+			return "", errors.New("missing <REV> in --filename-format when gzip, lzma, parquet, s3 upload, rotation, or work dir enabled")
 		}
 	} else {
 		// remove <REV> as we don't need it
@@ -416,6 +549,12 @@ func computeFilenameFormat(opts *Options, topic string) (string, error) {
 	if opts.GZIP && !strings.HasSuffix(cff, ".gz") {
 		cff = cff + ".gz"
 	}
+	if opts.LZMA && !strings.HasSuffix(cff, ".lzma") {
+		cff = cff + ".lzma"
+	}
+	if opts.OutputFormat == FormatParquet && !strings.HasSuffix(cff, ".parquet") {
+		cff = cff + ".parquet"
+	}
 
 	return cff, nil
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/lzma"
+	"github.com/nsqio/go-nsq"
+	"github.com/nsqio/nsq/internal/lg"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func newTestFileLogger(t *testing.T, opts *Options) (*FileLogger, string) {
+	dir, err := ioutil.TempDir("", "nsq_to_file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.OutputDir = dir
+	opts.WorkDir = dir
+	opts.FilenameFormat = "<TOPIC><REV>.log"
+	filenameFormat, err := computeFilenameFormat(opts, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parquetColumns, err := parseParquetColumns(opts.ParquetColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &FileLogger{
+		logf:           func(lvl lg.LogLevel, f string, args ...interface{}) {},
+		opts:           opts,
+		topic:          "test",
+		filenameFormat: filenameFormat,
+		parquetColumns: parquetColumns,
+	}
+	return f, dir
+}
+
+func newTestMessage(id string, body string) *nsq.Message {
+	var msgID nsq.MessageID
+	copy(msgID[:], id)
+	m := nsq.NewMessage(msgID, []byte(body))
+	m.Timestamp = 1500000000123456789
+	m.Attempts = 1
+	return m
+}
+
+func writeTestMessages(t *testing.T, f *FileLogger, msgs ...*nsq.Message) {
+	for _, m := range msgs {
+		if f.needsRotation() {
+			f.updateFile()
+		}
+		err := f.writeMessage(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := f.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileLoggerNDJSON(t *testing.T) {
+	opts := NewOptions()
+	opts.OutputFormat = FormatNDJSON
+	f, dir := newTestFileLogger(t, opts)
+	defer os.RemoveAll(dir)
+
+	writeTestMessages(t, f,
+		newTestMessage("0123456789abcdef", `{"a":1,"b":"<b>"}`),
+		newTestMessage("0123456789abcdeg", `{"a": 1}`),
+		newTestMessage("0123456789abcdeh", `"x"`),
+		newTestMessage("0123456789abcdei", "x"),
+		newTestMessage("0123456789abcdej", "\xff\x00"))
+	f.Close()
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "test.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":"0123456789abcdef","timestamp":1500000000123456789,"attempts":1,"encoding":"json","body":{"a":1,"b":"<b>"}}
+{"id":"0123456789abcdeg","timestamp":1500000000123456789,"attempts":1,"encoding":"string","body":"{\"a\": 1}"}
+{"id":"0123456789abcdeh","timestamp":1500000000123456789,"attempts":1,"encoding":"json","body":"x"}
+{"id":"0123456789abcdei","timestamp":1500000000123456789,"attempts":1,"encoding":"string","body":"x"}
+{"id":"0123456789abcdej","timestamp":1500000000123456789,"attempts":1,"encoding":"base64","body":"/wA="}
+`
+	if string(data) != want {
+		t.Fatalf("got %s, want %s", data, want)
+	}
+}
+
+func TestFileLoggerLZMA(t *testing.T) {
+	opts := NewOptions()
+	opts.LZMA = true
+	f, dir := newTestFileLogger(t, opts)
+	defer os.RemoveAll(dir)
+
+	// the file is a single lzma stream, finished at rotation rather than
+	// at each sync
+	writeTestMessages(t, f, newTestMessage("1", "one"), newTestMessage("2", "two"))
+	writeTestMessages(t, f, newTestMessage("3", "three"))
+	f.Close()
+
+	file, err := os.Open(filepath.Join(dir, "test-000000.log.lzma"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var out bytes.Buffer
+	br := bufio.NewReader(file)
+	r := lzma.NewReader(br)
+	_, err = io.Copy(&out, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if out.String() != "one\ntwo\nthree\n" {
+		t.Fatalf("got %q", out.String())
+	}
+	if _, err := br.Peek(1); err != io.EOF {
+		t.Fatal("got more than one lzma stream")
+	}
+}
+
+func TestFileLoggerParquet(t *testing.T) {
+	opts := NewOptions()
+	opts.OutputFormat = FormatParquet
+	opts.ParquetColumns = []string{"user.id:int64", "name:string", "score:double", "tags:string"}
+	f, dir := newTestFileLogger(t, opts)
+	defer os.RemoveAll(dir)
+
+	// a sync writes a row group, the file is completed at rotation
+	writeTestMessages(t, f,
+		newTestMessage("0123456789abcdef", `{"user": {"id": 42}, "name": "a", "score": 1.5, "tags": ["x"]}`),
+		newTestMessage("0123456789abcdeg", `{"user": {"id": "nope"}, "extra": true}`),
+		newTestMessage("0123456789abcdeh", "not json"))
+	if f.out == nil {
+		t.Fatal("parquet file completed by sync")
+	}
+	writeTestMessages(t, f, newTestMessage("0123456789abcdei", `{"name": "b"}`))
+	f.Close()
+
+	rows := readParquetRows(t, filepath.Join(dir, "test-000000.log.parquet"))
+	want := []map[string]interface{}{
+		{"Nsq_id": "0123456789abcdef", "Nsq_timestamp": 1500000000123456.0, "Nsq_attempts": 1.0,
+			"User_id": 42.0, "Name": "a", "Score": 1.5, "Tags": `["x"]`},
+		{"Nsq_id": "0123456789abcdeg", "Nsq_timestamp": 1500000000123456.0, "Nsq_attempts": 1.0,
+			"User_id": nil, "Name": nil, "Score": nil, "Tags": nil},
+		{"Nsq_id": "0123456789abcdeh", "Nsq_timestamp": 1500000000123456.0, "Nsq_attempts": 1.0,
+			"User_id": nil, "Name": nil, "Score": nil, "Tags": nil},
+	}
+	if len(rows) != 4 || rows[3]["Name"] != "b" {
+		t.Fatalf("got rows %v", rows)
+	}
+	if !jsonEqual(rows[:3], want) {
+		t.Fatalf("got rows %v, want %v", rows, want)
+	}
+}
+
+func readParquetRows(t *testing.T, fileName string) []map[string]interface{} {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf, err := buffer.NewBufferFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := reader.NewParquetReader(pf, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+	res, err := pr.ReadByNumber(int(pr.GetNumRows()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(res)
+	var rows []map[string]interface{}
+	json.Unmarshal(b, &rows)
+	return rows
+}
+
+func jsonEqual(a interface{}, b interface{}) bool {
+	ab, _ := json.Marshal(a)
+	bb, _ := json.Marshal(b)
+	return bytes.Equal(ab, bb)
+}
@@ -8,6 +8,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
@@ -45,6 +46,22 @@ func flagSet() *flag.FlagSet {
 	fs.String("host-identifier", "", "value to output in log filename in place of hostname. <SHORT_HOST> and <HOSTNAME> are valid replacement tokens")
 	fs.Int("gzip-level", 6, "gzip compression level (1-9, 1=BestSpeed, 9=BestCompression)")
 	fs.Bool("gzip", false, "gzip output files.")
+	fs.Int("lzma-level", 5, "lzma compression level (1-9, 1=BestSpeed, 9=BestCompression)")
+	fs.Bool("lzma", false, "lzma compress output files (a single lzma stream per file, finished at rotation: messages are acked once passed to the encoder, so those it buffers at a crash are lost)")
+	fs.String("output-format", FormatRaw, "format of output files: raw (message bodies, one per line), ndjson (JSON envelopes with the message id, timestamp, attempts and body, embedded as JSON, a string or base64 per its encoding, one per line) or parquet (messages are acked once their row group is written, but a file is only readable once rotated, so one left unfinished by a crash isn't)")
+	fs.String("parquet-compression", "snappy", "compression of parquet files: uncompressed, snappy or gzip")
+	fs.String("s3-endpoint", "", "S3 compatible endpoint to upload finished files to, http(s)://<host>[:<port>] (default https://s3.<s3-region>.amazonaws.com)")
+	fs.String("s3-region", "us-east-1", "S3 region")
+	fs.String("s3-bucket", "", "S3 bucket to upload finished files to (uploads are disabled if empty)")
+	fs.String("s3-prefix", "", "prefix of the S3 keys of uploaded files, followed by their path in output-dir")
+	fs.String("s3-access-key-id", "", "S3 access key ID (default $AWS_ACCESS_KEY_ID)")
+	fs.String("s3-secret-access-key", "", "S3 secret access key (default $AWS_SECRET_ACCESS_KEY)")
+	fs.Int64("s3-part-size", 16*1024*1024, "size in bytes of the parts of multipart uploads, files up to this size are uploaded in a single request (min 5MiB)")
+	fs.Int("s3-max-retries", 5, "number of times a failed S3 request is retried, files that fail to upload are kept (and retried at the next rescan)")
+	fs.Int("s3-workers", 2, "number of concurrent uploads")
+	fs.Duration("s3-rescan-interval", 5*time.Minute, "interval at which output-dir is rescanned for files to upload, ie. that failed to or were finished before a restart (it is also scanned at startup)")
+	fs.Bool("s3-verify-etags", true, "verify that the ETags of uploaded objects are their MD5 checksums (disable for SSE-KMS or SSE-C encrypted buckets, S3 verifies the Content-MD5 of each request regardless)")
+	fs.Bool("s3-delete-after-upload", false, "delete files once uploaded (objects are never overwritten, so filenames should not be reused after a restart, eg. with <PID>)")
 	fs.Bool("skip-empty-files", false, "skip writing empty files")
 	fs.Duration("topic-refresh", time.Minute, "how frequently the topic list should be refreshed")
 	fs.String("topic-pattern", "", "only log topics matching the following pattern")
@@ -63,6 +80,8 @@ func flagSet() *flag.FlagSet {
 	fs.Var(&nsqdTCPAddrs, "nsqd-tcp-address", "nsqd TCP address (may be given multiple times)")
 	fs.Var(&lookupdHTTPAddrs, "lookupd-http-address", "lookupd HTTP address (may be given multiple times)")
 	fs.Var(&topics, "topic", "nsq topic (may be given multiple times)")
+	parquetColumns := app.StringArray{}
+	fs.Var(&parquetColumns, "parquet-column", "column of parquet files, <field>[.<field>...]:<type> of the JSON message bodies, type is string, int64, double or boolean (may be given multiple times)")
 	fs.Var(&consumerOpts, "consumer-opt", "option to passthrough to nsq.Consumer (may be given multiple times, http://godoc.org/github.com/nsqio/go-nsq#Config)")
 
 	return fs
@@ -116,6 +135,65 @@ func main() {
 		log.Fatalf("invalid --gzip-level value (%d), should be 1-9", opts.GZIPLevel)
 	}
 
+	if opts.LZMALevel < 1 || opts.LZMALevel > 9 {
+		log.Fatalf("invalid --lzma-level value (%d), should be 1-9", opts.LZMALevel)
+	}
+
+	if opts.GZIP && opts.LZMA {
+		log.Fatal("use --gzip or --lzma not both")
+	}
+
+	switch opts.OutputFormat {
+	case FormatRaw, FormatNDJSON:
+		if len(opts.ParquetColumns) > 0 {
+			log.Fatal("--parquet-column only used with --output-format=parquet")
+		}
+	case FormatParquet:
+		if opts.GZIP || opts.LZMA {
+			log.Fatal("--gzip and --lzma can't be used with --output-format=parquet, see --parquet-compression")
+		}
+		if _, ok := parquetCompressionCodecs[opts.ParquetCompression]; !ok {
+			log.Fatal("--parquet-compression must be uncompressed, snappy or gzip")
+		}
+		_, err := parseParquetColumns(opts.ParquetColumns)
+		if err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatal("--output-format must be raw, ndjson or parquet")
+	}
+
+	if opts.S3Bucket != "" {
+		if opts.S3AccessKeyID == "" {
+			opts.S3AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		}
+		if opts.S3SecretAccessKey == "" {
+			opts.S3SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		}
+		if opts.S3AccessKeyID == "" || opts.S3SecretAccessKey == "" {
+			log.Fatal("--s3-access-key-id and --s3-secret-access-key (or $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY) required with --s3-bucket")
+		}
+		if opts.S3PartSize < 5*1024*1024 {
+			log.Fatalf("invalid --s3-part-size value (%d), should be at least 5MiB", opts.S3PartSize)
+		}
+		if opts.S3MaxRetries < 0 {
+			log.Fatal("--s3-max-retries should be positive")
+		}
+		if opts.S3Workers < 1 {
+			log.Fatal("--s3-workers should be at least 1")
+		}
+		if opts.S3RescanInterval <= 0 {
+			log.Fatal("--s3-rescan-interval should be positive")
+		}
+		// output-dir is rescanned for files to upload, so it must only
+		// have finished files
+		if opts.WorkDir == "" || filepath.Clean(opts.WorkDir) == filepath.Clean(opts.OutputDir) {
+			log.Fatal("--s3-bucket requires a --work-dir other than --output-dir")
+		}
+	} else if opts.S3DeleteAfterUpload {
+		log.Fatal("--s3-delete-after-upload requires --s3-bucket")
+	}
+
 	if len(opts.Topics) == 0 && len(opts.TopicPattern) == 0 {
 		log.Fatal("--topic or --topic-pattern required")
 	}
@@ -141,6 +219,24 @@ func main() {
 	signal.Notify(hupChan, syscall.SIGHUP)
 	signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM)
 
-	discoverer := newTopicDiscoverer(logf, opts, cfg, hupChan, termChan)
+	var uploader *Uploader
+	if opts.S3Bucket != "" {
+		uploader, err = NewUploader(logf, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if uploader != nil {
+		uploader.Start()
+	}
+
+	discoverer := newTopicDiscoverer(logf, opts, cfg, uploader, hupChan, termChan)
 	discoverer.run()
+
+	if uploader != nil {
+		uploader.Stop()
+		logf(lg.INFO, "waiting for uploads to complete")
+		uploader.Wait()
+	}
 }
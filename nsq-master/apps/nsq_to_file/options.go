@@ -15,15 +15,35 @@ type Options struct {
 	HTTPClientConnectTimeout time.Duration `flag:"http-client-connect-timeout"`
 	HTTPClientRequestTimeout time.Duration `flag:"http-client-request-timeout"`
 
-	LogPrefix      string        `flag:"log-prefix"`
-	LogLevel       string        `flag:"log-level"`
-	OutputDir      string        `flag:"output-dir"`
-	WorkDir        string        `flag:"work-dir"`
-	DatetimeFormat string        `flag:"datetime-format"`
-	FilenameFormat string        `flag:"filename-format"`
-	HostIdentifier string        `flag:"host-identifier"`
-	GZIPLevel      int           `flag:"gzip-level"`
-	GZIP           bool          `flag:"gzip"`
+	LogPrefix      string `flag:"log-prefix"`
+	LogLevel       string `flag:"log-level"`
+	OutputDir      string `flag:"output-dir"`
+	WorkDir        string `flag:"work-dir"`
+	DatetimeFormat string `flag:"datetime-format"`
+	FilenameFormat string `flag:"filename-format"`
+	HostIdentifier string `flag:"host-identifier"`
+	GZIPLevel      int    `flag:"gzip-level"`
+	GZIP           bool   `flag:"gzip"`
+	LZMALevel      int    `flag:"lzma-level"`
+	LZMA           bool   `flag:"lzma"`
+	OutputFormat   string `flag:"output-format"`
+
+	ParquetColumns     []string `flag:"parquet-column"`
+	ParquetCompression string   `flag:"parquet-compression"`
+
+	S3Endpoint          string        `flag:"s3-endpoint"`
+	S3Region            string        `flag:"s3-region"`
+	S3Bucket            string        `flag:"s3-bucket"`
+	S3Prefix            string        `flag:"s3-prefix"`
+	S3AccessKeyID       string        `flag:"s3-access-key-id"`
+	S3SecretAccessKey   string        `flag:"s3-secret-access-key"`
+	S3PartSize          int64         `flag:"s3-part-size"`
+	S3MaxRetries        int           `flag:"s3-max-retries"`
+	S3Workers           int           `flag:"s3-workers"`
+	S3DeleteAfterUpload bool          `flag:"s3-delete-after-upload"`
+	S3RescanInterval    time.Duration `flag:"s3-rescan-interval"`
+	S3VerifyETags       bool          `flag:"s3-verify-etags"`
+
 	SkipEmptyFiles bool          `flag:"skip-empty-files"`
 	RotateSize     int64         `flag:"rotate-size"`
 	RotateInterval time.Duration `flag:"rotate-interval"`
@@ -40,6 +60,15 @@ func NewOptions() *Options {
 		DatetimeFormat:           "%Y-%m-%d_%H",
 		FilenameFormat:           "<TOPIC>.<HOST><REV>.<DATETIME>.log",
 		GZIPLevel:                6,
+		LZMALevel:                5,
+		OutputFormat:             FormatRaw,
+		ParquetCompression:       "snappy",
+		S3Region:                 "us-east-1",
+		S3PartSize:               16 * 1024 * 1024,
+		S3MaxRetries:             5,
+		S3Workers:                2,
+		S3RescanInterval:         5 * time.Minute,
+		S3VerifyETags:            true,
 		TopicRefreshInterval:     time.Minute,
 		SyncInterval:             30 * time.Second,
 		HTTPClientConnectTimeout: 2 * time.Second,
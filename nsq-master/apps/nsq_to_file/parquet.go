@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/nsqio/go-nsq"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetTypes maps the types of --parquet-column to parquet types
+var parquetTypes = map[string]string{
+	"string":  "type=BYTE_ARRAY, convertedtype=UTF8",
+	"int64":   "type=INT64",
+	"double":  "type=DOUBLE",
+	"boolean": "type=BOOLEAN",
+}
+
+var parquetCompressionCodecs = map[string]parquet.CompressionCodec{
+	"uncompressed": parquet.CompressionCodec_UNCOMPRESSED,
+	"snappy":       parquet.CompressionCodec_SNAPPY,
+	"gzip":         parquet.CompressionCodec_GZIP,
+}
+
+// ParquetColumn is a column of the parquet files, whose values are those of
+// a field of the (JSON) message bodies
+type ParquetColumn struct {
+	Name string
+	Path []string
+	Type string
+}
+
+// parseParquetColumns parses --parquet-column values, each
+// <field>[.<field>...]:<type>, the column is named after the fields joined
+// by '_'
+func parseParquetColumns(specs []string) ([]ParquetColumn, error) {
+	var columns []ParquetColumn
+	names := map[string]bool{"nsq_id": true, "nsq_timestamp": true, "nsq_attempts": true}
+	for _, s := range specs {
+		i := strings.LastIndex(s, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid --parquet-column %q, should be <field>:<type>", s)
+		}
+		path, typ := s[:i], s[i+1:]
+		if _, ok := parquetTypes[typ]; !ok {
+			return nil, fmt.Errorf("invalid --parquet-column %q, type should be string, int64, double or boolean", s)
+		}
+		c := ParquetColumn{
+			Name: strings.Replace(path, ".", "_", -1),
+			Path: strings.Split(path, "."),
+			Type: typ,
+		}
+		if strings.ContainsAny(c.Name, ", =") {
+			return nil, fmt.Errorf("invalid --parquet-column %q, field can't contain ',', ' ' or '='", s)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate --parquet-column %q", c.Name)
+		}
+		names[c.Name] = true
+		columns = append(columns, c)
+	}
+	return columns, nil
+}
+
+// parquetSchema returns the (parquet-go JSON) schema of the files, the
+// message ID, timestamp and attempts followed by the optional columns
+func parquetSchema(columns []ParquetColumn) string {
+	fields := []string{
+		`{"Tag": "name=nsq_id, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED"}`,
+		`{"Tag": "name=nsq_timestamp, type=INT64, convertedtype=TIMESTAMP_MICROS, repetitiontype=REQUIRED"}`,
+		`{"Tag": "name=nsq_attempts, type=INT32, repetitiontype=REQUIRED"}`,
+	}
+	for _, c := range columns {
+		fields = append(fields, fmt.Sprintf(`{"Tag": "name=%s, %s, repetitiontype=OPTIONAL"}`,
+			c.Name, parquetTypes[c.Type]))
+	}
+	return `{"Tag": "name=nsq_to_file", "Fields": [` + strings.Join(fields, ", ") + `]}`
+}
+
+// ParquetWriter writes messages as the rows of a parquet file, the file is
+// complete (readable) once closed
+type ParquetWriter struct {
+	w       *writer.JSONWriter
+	columns []ParquetColumn
+}
+
+func NewParquetWriter(w io.Writer, columns []ParquetColumn, compression string) (*ParquetWriter, error) {
+	jw, err := writer.NewJSONWriterFromWriter(parquetSchema(columns), w, 1)
+	if err != nil {
+		return nil, err
+	}
+	jw.CompressionType = parquetCompressionCodecs[compression]
+	return &ParquetWriter{w: jw, columns: columns}, nil
+}
+
+// Write adds a message as a row, the columns of fields missing from (or of
+// another type in) the body are null
+func (p *ParquetWriter) Write(m *nsq.Message) error {
+	row := map[string]interface{}{
+		"nsq_id":        string(m.ID[:]),
+		"nsq_timestamp": m.Timestamp / 1000,
+		"nsq_attempts":  m.Attempts,
+	}
+
+	var body interface{}
+	dec := json.NewDecoder(bytes.NewReader(m.Body))
+	dec.UseNumber()
+	if dec.Decode(&body) == nil {
+		for _, c := range p.columns {
+			if v := columnValue(body, c); v != nil {
+				row[c.Name] = v
+			}
+		}
+	}
+
+	b, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	return p.w.Write(string(b))
+}
+
+func columnValue(body interface{}, c ParquetColumn) interface{} {
+	v := body
+	for _, field := range c.Path {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = obj[field]
+	}
+	switch c.Type {
+	case "string":
+		switch v := v.(type) {
+		case nil:
+			return nil
+		case string:
+			return v
+		default:
+			// numbers, booleans, arrays and objects as JSON
+			b, _ := json.Marshal(v)
+			return string(b)
+		}
+	case "int64":
+		if n, ok := v.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				return i
+			}
+		}
+	case "double":
+		if n, ok := v.(json.Number); ok {
+			if f, err := n.Float64(); err == nil {
+				return f
+			}
+		}
+	case "boolean":
+		if b, ok := v.(bool); ok {
+			return b
+		}
+	}
+	return nil
+}
+
+// Flush writes the buffered rows as a row group
+func (p *ParquetWriter) Flush() error {
+	return p.w.Flush(true)
+}
+
+// Close writes the buffered rows and the footer
+func (p *ParquetWriter) Close() error {
+	return p.w.WriteStop()
+}
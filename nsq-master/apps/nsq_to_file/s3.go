@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nsqio/nsq/internal/version"
+)
+
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// S3Client is a minimal client of the S3 API, enough to upload files to S3
+// compatible object storage with path-style requests signed with AWS
+// signature version 4
+type S3Client struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+
+	// the ETags of SSE-KMS (or SSE-C) encrypted objects aren't their MD5,
+	// their content is still verified by S3 per the Content-MD5 header
+	verifyETags bool
+}
+
+func NewS3Client(endpoint, region, bucket, accessKey, secretKey string, client *http.Client) (*S3Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q, should be http(s)://<host>[:<port>]", endpoint)
+	}
+	return &S3Client{
+		endpoint:    u,
+		region:      region,
+		bucket:      bucket,
+		accessKey:   accessKey,
+		secretKey:   secretKey,
+		client:      client,
+		verifyETags: true,
+	}, nil
+}
+
+// S3Error is an error response of the S3 API
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("got status code %d", e.StatusCode)
+	}
+	return fmt.Sprintf("got status code %d (%s: %s)", e.StatusCode, e.Code, e.Message)
+}
+
+// S3Part is an uploaded part of a multipart upload
+type S3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// S3Object is the metadata of an object
+type S3Object struct {
+	ETag string
+	Size int64
+}
+
+// HeadObject returns the metadata of an object, nil if it doesn't exist
+func (c *S3Client) HeadObject(key string) (*S3Object, error) {
+	resp, _, err := c.do("HEAD", key, nil, nil, nil)
+	if err != nil {
+		if s3Err, ok := err.(*S3Error); ok && s3Err.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &S3Object{ETag: resp.Header.Get("ETag"), Size: resp.ContentLength}, nil
+}
+
+// PutObject uploads an object, the upload is verified by its MD5 checksum
+func (c *S3Client) PutObject(key string, data []byte) error {
+	sum := md5.Sum(data)
+	header := http.Header{}
+	header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	resp, _, err := c.do("PUT", key, nil, header, data)
+	if err != nil {
+		return err
+	}
+	return c.checkETag(resp.Header.Get("ETag"), hex.EncodeToString(sum[:]))
+}
+
+func (c *S3Client) CreateMultipartUpload(key string) (string, error) {
+	_, body, err := c.do("POST", key, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.Unmarshal(body, &result)
+	if err != nil {
+		return "", err
+	}
+	if result.UploadID == "" {
+		return "", errors.New("missing UploadId")
+	}
+	return result.UploadID, nil
+}
+
+// UploadPart uploads a part (numbered from 1) of a multipart upload, the
+// upload is verified by its MD5 checksum
+func (c *S3Client) UploadPart(key string, uploadID string, partNumber int, data []byte) (S3Part, error) {
+	sum := md5.Sum(data)
+	query := url.Values{
+		"partNumber": {strconv.Itoa(partNumber)},
+		"uploadId":   {uploadID},
+	}
+	header := http.Header{}
+	header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	resp, _, err := c.do("PUT", key, query, header, data)
+	if err != nil {
+		return S3Part{}, err
+	}
+	etag := resp.Header.Get("ETag")
+	err = c.checkETag(etag, hex.EncodeToString(sum[:]))
+	if err != nil {
+		return S3Part{}, err
+	}
+	return S3Part{PartNumber: partNumber, ETag: etag}, nil
+}
+
+// CompleteMultipartUpload completes a multipart upload and returns the ETag
+// of the object
+func (c *S3Client) CompleteMultipartUpload(key string, uploadID string, parts []S3Part) (string, error) {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []S3Part `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return "", err
+	}
+	_, body, err = c.do("POST", key, url.Values{"uploadId": {uploadID}}, nil, body)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		XMLName xml.Name
+		ETag    string `xml:"ETag"`
+		S3Error
+	}
+	err = xml.Unmarshal(body, &result)
+	if err != nil {
+		return "", err
+	}
+	// the response status is sent before the upload is completed, so
+	// failures are in the body of a 200 response
+	if result.XMLName.Local == "Error" {
+		result.S3Error.StatusCode = http.StatusInternalServerError
+		return "", &result.S3Error
+	}
+	return result.ETag, nil
+}
+
+func (c *S3Client) AbortMultipartUpload(key string, uploadID string) error {
+	_, _, err := c.do("DELETE", key, url.Values{"uploadId": {uploadID}}, nil, nil)
+	return err
+}
+
+func (c *S3Client) do(method string, key string, query url.Values, header http.Header, body []byte) (*http.Response, []byte, error) {
+	u := *c.endpoint
+	u.Path = path.Join("/", c.endpoint.Path, c.bucket, key)
+	u.RawPath = awsURIEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("nsq_to_file/%s", version.Binary))
+	sum := sha256.Sum256(body)
+	c.sign(req, hex.EncodeToString(sum[:]), time.Now())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode >= 300 {
+		s3Err := &S3Error{}
+		xml.Unmarshal(respBody, s3Err)
+		s3Err.StatusCode = resp.StatusCode
+		return nil, nil, s3Err
+	}
+	return resp, respBody, nil
+}
+
+// sign adds the AWS signature version 4 Authorization header to a request,
+// signing the host, Content-MD5, Content-Type, Range and X-Amz-* headers
+func (c *S3Client) sign(req *http.Request, payloadHash string, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	scope := amzDate[:8] + "/" + c.region + "/s3/aws4_request"
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if k == "content-md5" || k == "content-type" || k == "range" || strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + c.secretKey)
+	for _, s := range []string{amzDate[:8], c.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(s))
+	return mac.Sum(nil)
+}
+
+// awsURIEncode escapes all but the unreserved characters of RFC 3986 (and
+// '/' unless encodeSlash), as expected in canonical requests
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			params = append(params, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// checkETag returns an error if the ETag of an object isn't the expected
+// one, unless ETags aren't verified
+func (c *S3Client) checkETag(etag string, want string) error {
+	if c.verifyETags && strings.Trim(etag, `"`) != want {
+		return fmt.Errorf("%w (ETag %s, want %q)", errChecksumMismatch, etag, want)
+	}
+	return nil
+}
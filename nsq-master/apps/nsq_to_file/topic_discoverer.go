@@ -21,9 +21,10 @@ type TopicDiscoverer struct {
 	termChan chan os.Signal
 	wg       sync.WaitGroup
 	cfg      *nsq.Config
+	uploader *Uploader
 }
 
-func newTopicDiscoverer(logf lg.AppLogFunc, opts *Options, cfg *nsq.Config, hupChan chan os.Signal, termChan chan os.Signal) *TopicDiscoverer {
+func newTopicDiscoverer(logf lg.AppLogFunc, opts *Options, cfg *nsq.Config, uploader *Uploader, hupChan chan os.Signal, termChan chan os.Signal) *TopicDiscoverer {
 	client := http_api.NewClient(nil, opts.HTTPClientConnectTimeout, opts.HTTPClientRequestTimeout)
 	return &TopicDiscoverer{
 		logf:     logf,
@@ -33,6 +34,7 @@ func newTopicDiscoverer(logf lg.AppLogFunc, opts *Options, cfg *nsq.Config, hupC
 		hupChan:  hupChan,
 		termChan: termChan,
 		cfg:      cfg,
+		uploader: uploader,
 	}
 }
 
@@ -47,7 +49,7 @@ func (t *TopicDiscoverer) updateTopics(topics []string) {
 			continue
 		}
 
-		fl, err := NewFileLogger(t.logf, t.opts, topic, t.cfg)
+		fl, err := NewFileLogger(t.logf, t.opts, topic, t.cfg, t.uploader)
 		if err != nil {
 			t.logf(lg.ERROR, "couldn't create logger for new topic %s: %s", topic, err)
 			continue
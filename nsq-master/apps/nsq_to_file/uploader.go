@@ -0,0 +1,343 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nsqio/nsq/internal/lg"
+)
+
+// Uploader uploads finished files to S3 compatible object storage, keyed by
+// --s3-prefix and their path in the output dir. The files that failed to
+// upload, or were finished before a restart, are found by rescanning the
+// output dir.
+type Uploader struct {
+	logf    lg.AppLogFunc
+	opts    *Options
+	client  *S3Client
+	backoff time.Duration
+
+	workers chan struct{}
+	wg      sync.WaitGroup
+
+	sync.Mutex
+	queued   map[string]bool
+	uploaded map[string]bool // kept without --s3-delete-after-upload
+
+	exitChan chan struct{}
+	scanDone chan struct{}
+}
+
+func NewUploader(logf lg.AppLogFunc, opts *Options) (*Uploader, error) {
+	endpoint := opts.S3Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", opts.S3Region)
+	}
+	// parts are uploaded in a single request, so the timeout is generous
+	httpClient := &http.Client{Timeout: 5 * time.Minute}
+	client, err := NewS3Client(endpoint, opts.S3Region, opts.S3Bucket,
+		opts.S3AccessKeyID, opts.S3SecretAccessKey, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	client.verifyETags = opts.S3VerifyETags
+	return &Uploader{
+		logf:     logf,
+		opts:     opts,
+		client:   client,
+		backoff:  time.Second,
+		workers:  make(chan struct{}, opts.S3Workers),
+		queued:   make(map[string]bool),
+		uploaded: make(map[string]bool),
+		exitChan: make(chan struct{}),
+		scanDone: make(chan struct{}),
+	}, nil
+}
+
+// Upload queues a file for upload, unless it already is, it is deleted once
+// uploaded with --s3-delete-after-upload and kept (with an error logged) if
+// the upload fails
+func (u *Uploader) Upload(fileName string) {
+	u.Lock()
+	if u.queued[fileName] {
+		u.Unlock()
+		return
+	}
+	u.queued[fileName] = true
+	u.Unlock()
+
+	u.wg.Add(1)
+	go func() {
+		defer u.wg.Done()
+		u.workers <- struct{}{}
+		defer func() { <-u.workers }()
+
+		key := u.key(fileName)
+		start := time.Now()
+		err := u.upload(fileName, key)
+		if os.IsNotExist(err) {
+			// eg. found by a scan as it was deleted once uploaded
+			u.Lock()
+			delete(u.queued, fileName)
+			u.Unlock()
+			return
+		}
+		if err != nil {
+			u.logf(lg.ERROR, "failed to upload %s to s3://%s/%s (retried at the next rescan): %s",
+				fileName, u.opts.S3Bucket, key, err)
+			u.Lock()
+			delete(u.queued, fileName)
+			u.Unlock()
+			return
+		}
+		u.logf(lg.INFO, "uploaded %s to s3://%s/%s in %s", fileName, u.opts.S3Bucket, key, time.Since(start))
+
+		if u.opts.S3DeleteAfterUpload {
+			err := os.Remove(fileName)
+			if err != nil {
+				u.logf(lg.ERROR, "failed to delete uploaded file %s: %s", fileName, err)
+			}
+		}
+		u.Lock()
+		delete(u.queued, fileName)
+		if !u.opts.S3DeleteAfterUpload {
+			u.uploaded[fileName] = true
+		}
+		u.Unlock()
+	}()
+}
+
+// Start scans the output dir for files to upload now and every
+// --s3-rescan-interval, until Stop
+func (u *Uploader) Start() {
+	go func() {
+		defer close(u.scanDone)
+		ticker := time.NewTicker(u.opts.S3RescanInterval)
+		defer ticker.Stop()
+		for {
+			u.scan()
+			select {
+			case <-ticker.C:
+			case <-u.exitChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the scans started by Start
+func (u *Uploader) Stop() {
+	close(u.exitChan)
+	<-u.scanDone
+}
+
+// scan queues the files of the output dir that weren't uploaded by this
+// process, those that were uploaded before a restart are skipped by the
+// HEAD request of upload
+func (u *Uploader) scan() {
+	var fileNames []string
+	err := filepath.Walk(u.opts.OutputDir, func(fileName string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // eg. deleted once uploaded
+			}
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			fileNames = append(fileNames, fileName)
+		}
+		return nil
+	})
+	if err != nil {
+		u.logf(lg.ERROR, "failed to scan %s for files to upload: %s", u.opts.OutputDir, err)
+	}
+
+	u.Lock()
+	var pending []string
+	for _, fileName := range fileNames {
+		if !u.queued[fileName] && !u.uploaded[fileName] {
+			pending = append(pending, fileName)
+		}
+	}
+	u.Unlock()
+	for _, fileName := range pending {
+		u.Upload(fileName)
+	}
+}
+
+// Wait waits for the queued uploads
+func (u *Uploader) Wait() {
+	u.wg.Wait()
+}
+
+func (u *Uploader) key(fileName string) string {
+	rel, err := filepath.Rel(u.opts.OutputDir, fileName)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(fileName)
+	}
+	return path.Join(u.opts.S3Prefix, filepath.ToSlash(rel))
+}
+
+func (u *Uploader) upload(fileName string, key string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+
+	etag, err := expectedETag(f, size, u.opts.S3PartSize)
+	if err != nil {
+		return err
+	}
+
+	// a previous upload of the file may have succeeded without being
+	// recorded (eg. not deleted), but other objects are never overwritten.
+	// Without ETags to compare, an object of the same size is taken to be
+	// the file.
+	var existing *S3Object
+	err = u.retry("head "+key, func() (err error) {
+		existing, err = u.client.HeadObject(key)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		if u.client.checkETag(existing.ETag, etag) == nil && existing.Size == size {
+			u.logf(lg.INFO, "%s already uploaded to s3://%s/%s", fileName, u.opts.S3Bucket, key)
+			return nil
+		}
+		return fmt.Errorf("object already exists with ETag %s and %d bytes (want %q and %d bytes)",
+			existing.ETag, existing.Size, etag, size)
+	}
+
+	if size <= u.opts.S3PartSize {
+		data := make([]byte, size)
+		_, err = io.ReadFull(f, data)
+		if err != nil {
+			return err
+		}
+		return u.retry("put "+key, func() error {
+			return u.client.PutObject(key, data)
+		})
+	}
+
+	var uploadID string
+	err = u.retry("create multipart upload "+key, func() (err error) {
+		uploadID, err = u.client.CreateMultipartUpload(key)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	err = u.uploadParts(f, size, key, uploadID, etag)
+	if err != nil {
+		abortErr := u.client.AbortMultipartUpload(key, uploadID)
+		if abortErr != nil {
+			u.logf(lg.WARN, "failed to abort multipart upload %s of %s: %s", uploadID, key, abortErr)
+		}
+	}
+	return err
+}
+
+func (u *Uploader) uploadParts(f *os.File, size int64, key string, uploadID string, etag string) error {
+	var parts []S3Part
+	data := make([]byte, u.opts.S3PartSize)
+	for offset := int64(0); offset < size; offset += u.opts.S3PartSize {
+		n, err := f.ReadAt(data, offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		partNumber := len(parts) + 1
+		var part S3Part
+		err = u.retry(fmt.Sprintf("upload part %d of %s", partNumber, key), func() (err error) {
+			part, err = u.client.UploadPart(key, uploadID, partNumber, data[:n])
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		parts = append(parts, part)
+	}
+
+	var completed string
+	err := u.retry("complete multipart upload "+key, func() (err error) {
+		completed, err = u.client.CompleteMultipartUpload(key, uploadID, parts)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return u.client.checkETag(completed, etag)
+}
+
+// retry calls fn until it succeeds, fails with an error that isn't
+// retryable, or --s3-max-retries retries have failed
+func (u *Uploader) retry(op string, fn func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = fn()
+		if err == nil || !isRetryable(err) || attempt >= u.opts.S3MaxRetries {
+			return err
+		}
+		backoff := u.backoff << uint(attempt)
+		if backoff > time.Minute {
+			backoff = time.Minute
+		}
+		u.logf(lg.WARN, "failed to %s (attempt %d), retrying in %s: %s", op, attempt+1, backoff, err)
+		time.Sleep(backoff)
+	}
+}
+
+// isRetryable returns true for network errors, checksum mismatches, and
+// server errors or throttling responses
+func isRetryable(err error) bool {
+	var s3Err *S3Error
+	if errors.As(err, &s3Err) {
+		return s3Err.StatusCode >= 500 ||
+			s3Err.StatusCode == http.StatusRequestTimeout ||
+			s3Err.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// expectedETag returns the ETag of the object uploaded from a file, the MD5
+// of the file if it is uploaded in a single request, the MD5 of the MD5s of
+// the parts followed by the number of parts otherwise
+func expectedETag(r io.ReaderAt, size int64, partSize int64) (string, error) {
+	if size <= partSize {
+		h := md5.New()
+		_, err := io.Copy(h, io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	sums := md5.New()
+	parts := 0
+	for offset := int64(0); offset < size; offset += partSize {
+		h := md5.New()
+		_, err := io.Copy(h, io.NewSectionReader(r, offset, partSize))
+		if err != nil {
+			return "", err
+		}
+		sums.Write(h.Sum(nil))
+		parts++
+	}
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sums.Sum(nil)), parts), nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+const (
+	BatchFormatJSON   = "json"
+	BatchFormatNDJSON = "ndjson"
+)
+
+// Batcher collects messages into batches of up to size messages, sent by
+// the PublishHandler as a single request once full, after timeout or when
+// the consumer can't receive more messages until some are responded to
+type Batcher struct {
+	handler  *PublishHandler
+	consumer *nsq.Consumer
+	size     int
+	timeout  time.Duration
+	format   string
+
+	msgChan   chan *nsq.Message
+	batchChan chan []*nsq.Message
+}
+
+func NewBatcher(handler *PublishHandler, consumer *nsq.Consumer, size int, timeout time.Duration, format string) *Batcher {
+	return &Batcher{
+		handler:   handler,
+		consumer:  consumer,
+		size:      size,
+		timeout:   timeout,
+		format:    format,
+		msgChan:   make(chan *nsq.Message),
+		batchChan: make(chan []*nsq.Message),
+	}
+}
+
+func (b *Batcher) Add(m *nsq.Message) {
+	m.DisableAutoResponse()
+	b.msgChan <- m
+}
+
+// Run starts n publishers and collects batches for them until the consumer
+// stops
+func (b *Batcher) Run(n int) {
+	for i := 0; i < n; i++ {
+		go b.publishLoop()
+	}
+
+	var batch []*nsq.Message
+	var timeoutChan <-chan time.Time
+	starvedTicker := time.NewTicker(b.timeout / 10)
+	defer starvedTicker.Stop()
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		b.batchChan <- batch
+		batch = nil
+		timeoutChan = nil
+	}
+
+	for {
+		select {
+		case m := <-b.msgChan:
+			batch = append(batch, m)
+			if len(batch) == 1 {
+				timeoutChan = time.After(b.timeout)
+			}
+			if len(batch) >= b.size {
+				flush()
+			}
+		case <-timeoutChan:
+			flush()
+		case <-starvedTicker.C:
+			if b.consumer.IsStarved() {
+				flush()
+			}
+		case <-b.consumer.StopChan:
+			flush()
+			close(b.batchChan)
+			return
+		}
+	}
+}
+
+func (b *Batcher) publishLoop() {
+	for batch := range b.batchChan {
+		body, err := b.encode(batch)
+		if err == nil {
+			err = b.handler.publish(nil, body, batch)
+		}
+		for _, m := range batch {
+			if err != nil {
+				m.Requeue(-1)
+			} else {
+				m.Finish()
+			}
+		}
+		if err != nil {
+			log.Printf("ERROR: failed to publish batch of %d messages - %s", len(batch), err)
+		}
+	}
+}
+
+// encode returns the request body of a batch, a JSON array (of the message
+// bodies that are JSON, others as strings) or newline-delimited bodies
+func (b *Batcher) encode(batch []*nsq.Message) ([]byte, error) {
+	var buf bytes.Buffer
+	if b.format == BatchFormatJSON {
+		buf.WriteByte('[')
+	}
+	for i, m := range batch {
+		body, err := b.handler.body(m)
+		if err != nil {
+			return nil, err
+		}
+		switch b.format {
+		case BatchFormatJSON:
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if !json.Valid(body) {
+				body, err = json.Marshal(string(body))
+				if err != nil {
+					return nil, err
+				}
+			}
+			buf.Write(body)
+		case BatchFormatNDJSON:
+			buf.Write(body)
+			buf.WriteByte('\n')
+		}
+	}
+	if b.format == BatchFormatJSON {
+		buf.WriteByte(']')
+	}
+	return buf.Bytes(), nil
+}
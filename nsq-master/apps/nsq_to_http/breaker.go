@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+var errBreakerOpen = errors.New("circuit breaker open")
+
+// CircuitBreaker stops requests to an endpoint after threshold consecutive
+// failures, until a single trial request succeeds after the cooldown
+type CircuitBreaker struct {
+	sync.Mutex
+	addr      string
+	threshold int
+	cooldown  time.Duration
+
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+func NewCircuitBreaker(addr string, threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		addr:      addr,
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Allow returns true if a request may be made, once the cooldown has expired
+// that is only true for one (trial) request until its result is recorded
+func (b *CircuitBreaker) Allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.trial || time.Since(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// IsOpen returns true if requests are being refused, without claiming the
+// trial request like Allow
+func (b *CircuitBreaker) IsOpen() bool {
+	if b.threshold <= 0 {
+		return false
+	}
+	b.Lock()
+	defer b.Unlock()
+	return b.failures >= b.threshold && (b.trial || time.Since(b.openedAt) < b.cooldown)
+}
+
+// Record records the result of a request allowed by Allow
+func (b *CircuitBreaker) Record(err error) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.trial = false
+	if err == nil {
+		if b.failures >= b.threshold {
+			log.Printf("circuit breaker for %s closed", b.addr)
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		if b.failures == b.threshold {
+			log.Printf("circuit breaker for %s opened after %d failures (%s)", b.addr, b.failures, err)
+		}
+		b.openedAt = time.Now()
+	}
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 
@@ -34,8 +37,18 @@ func HTTPPost(endpoint string, body *bytes.Buffer) (*http.Response, error) {
 	}
 	req.Header.Set("User-Agent", userAgent)
 	req.Header.Set("Content-Type", *contentType)
+	if *hmacSecret != "" {
+		req.Header.Set(*hmacHeader, sign(body.Bytes(), *hmacSecret))
+	}
 	for key, val := range validCustomHeaders {
 		req.Header.Set(key, val)
 	}
 	return httpclient.Do(req)
 }
+
+// sign returns the 'sha256=<hex>' HMAC signature of a request body
+func sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -18,6 +18,7 @@ import (
 	"strings"
 	"sync/atomic"
 	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/bitly/go-hostpool"
@@ -48,6 +49,21 @@ var (
 	httpRequestTimeout = flag.Duration("http-client-request-timeout", 20*time.Second, "timeout for HTTP request")
 	statusEvery        = flag.Int("status-every", 250, "the # of requests between logging status (per handler), 0 disables")
 	contentType        = flag.String("content-type", "application/octet-stream", "the Content-Type used for POST requests")
+	bodyTemplate       = flag.String("body-template", "", "template of the POST request body, eg. '{\"id\": \"{{.ID}}\", \"event\": {{.Body}}}' (message fields: .ID .Body .Timestamp .Attempts .Topic .Channel .JSON; funcs: json base64)")
+
+	batchSize    = flag.Int("batch-size", 0, "max # of messages per POST request, 0 disables batching")
+	batchTimeout = flag.Duration("batch-timeout", 1*time.Second, "max time a message waits for its batch to fill")
+	batchFormat  = flag.String("batch-format", BatchFormatJSON, "the body of a batch: json (array) or ndjson (newline-delimited)")
+
+	maxRetries      = flag.Int("max-retries", 0, "# of times a failed request is retried (with exponential backoff and jitter) before the messages are requeued")
+	retryBackoff    = flag.Duration("retry-backoff", 100*time.Millisecond, "backoff before the first retry, doubled for each further retry")
+	retryMaxBackoff = flag.Duration("retry-max-backoff", 10*time.Second, "max backoff between retries")
+
+	breakerThreshold = flag.Int("breaker-threshold", 0, "# of consecutive failures after which requests to an address are stopped for --breaker-cooldown, 0 disables")
+	breakerCooldown  = flag.Duration("breaker-cooldown", 30*time.Second, "time after which a trial request is made to an address whose circuit breaker opened")
+
+	hmacSecret = flag.String("hmac-secret", "", "secret with which POST request bodies are signed (HMAC-SHA256)")
+	hmacHeader = flag.String("hmac-header", "X-Signature", "the header of the 'sha256=<hex>' signature of POST request bodies")
 
 	getAddrs           = app.StringArray{}
 	postAddrs          = app.StringArray{}
@@ -58,9 +74,9 @@ var (
 )
 
 func init() {
-	flag.Var(&postAddrs, "post", "HTTP address to make a POST request to.  data will be in the body (may be given multiple times)")
+	flag.Var(&postAddrs, "post", "HTTP address to make a POST request to.  data will be in the body, may be a template like --body-template (may be given multiple times)")
 	flag.Var(&customHeaders, "header", "Custom header for HTTP requests (may be given multiple times)")
-	flag.Var(&getAddrs, "get", "HTTP address to make a GET request to. '%s' will be printf replaced with data (may be given multiple times)")
+	flag.Var(&getAddrs, "get", "HTTP address to make a GET request to. '%s' will be printf replaced with data, or a template like --body-template (may be given multiple times)")
 	flag.Var(&nsqdTCPAddrs, "nsqd-tcp-address", "nsqd TCP address (may be given multiple times)")
 	flag.Var(&lookupdHTTPAddrs, "lookupd-http-address", "lookupd HTTP address (may be given multiple times)")
 }
@@ -77,6 +93,12 @@ type PublishHandler struct {
 	addresses app.StringArray
 	mode      int
 	hostPool  hostpool.HostPool
+	get       bool
+
+	addrTemplates map[string]*template.Template
+	bodyTemplate  *template.Template
+	breakers      map[string]*CircuitBreaker
+	batcher       *Batcher
 
 	perAddressStatus map[string]*timer_metrics.TimerMetrics
 	timermetrics     *timer_metrics.TimerMetrics
@@ -87,12 +109,35 @@ func (ph *PublishHandler) HandleMessage(m *nsq.Message) error {
 		return nil
 	}
 
+	if ph.batcher != nil {
+		ph.batcher.Add(m)
+		return nil
+	}
+
+	body, err := ph.body(m)
+	if err != nil {
+		return err
+	}
+	return ph.publish(m, body, []*nsq.Message{m})
+}
+
+// body returns the request body of a message, per --body-template
+func (ph *PublishHandler) body(m *nsq.Message) ([]byte, error) {
+	if ph.bodyTemplate == nil {
+		return m.Body, nil
+	}
+	return executeTemplate(ph.bodyTemplate, m)
+}
+
+// publish sends the body (of m, nil for a batch) to the address(es) of the
+// mode, inFlight are the messages it carries
+func (ph *PublishHandler) publish(m *nsq.Message, body []byte, inFlight []*nsq.Message) error {
 	startTime := time.Now()
 	switch ph.mode {
 	case ModeAll:
 		for _, addr := range ph.addresses {
 			st := time.Now()
-			err := ph.Publish(addr, m.Body)
+			err := ph.send(addr, m, body, inFlight)
 			if err != nil {
 				return err
 			}
@@ -101,8 +146,12 @@ func (ph *PublishHandler) HandleMessage(m *nsq.Message) error {
 	case ModeRoundRobin:
 		counter := atomic.AddUint64(&ph.counter, 1)
 		idx := counter % uint64(len(ph.addresses))
+		// skip the addresses whose circuit breaker is open
 		addr := ph.addresses[idx]
-		err := ph.Publish(addr, m.Body)
+		for i := 1; i < len(ph.addresses) && ph.breakers[addr].IsOpen(); i++ {
+			addr = ph.addresses[(idx+uint64(i))%uint64(len(ph.addresses))]
+		}
+		err := ph.send(addr, m, body, inFlight)
 		if err != nil {
 			return err
 		}
@@ -110,7 +159,7 @@ func (ph *PublishHandler) HandleMessage(m *nsq.Message) error {
 	case ModeHostPool:
 		hostPoolResponse := ph.hostPool.Get()
 		addr := hostPoolResponse.Host()
-		err := ph.Publish(addr, m.Body)
+		err := ph.send(addr, m, body, inFlight)
 		hostPoolResponse.Mark(err)
 		if err != nil {
 			return err
@@ -122,6 +171,78 @@ func (ph *PublishHandler) HandleMessage(m *nsq.Message) error {
 	return nil
 }
 
+// send makes the request to an address, retrying with backoff up to
+// --max-retries times unless its circuit breaker is (or opens). The
+// inFlight messages are touched before each backoff, so that nsqd doesn't
+// time them out and deliver them again while they're being retried.
+func (ph *PublishHandler) send(addr string, m *nsq.Message, body []byte, inFlight []*nsq.Message) error {
+	endpoint, err := ph.endpoint(addr, m)
+	if err != nil {
+		return err
+	}
+	breaker := ph.breakers[addr]
+	for attempt := 0; ; attempt++ {
+		if !breaker.Allow() {
+			return errBreakerOpen
+		}
+		err := ph.Publish(endpoint, body)
+		if err != nil && !isRetryable(err) {
+			// the address is up, the request is at fault
+			breaker.Record(nil)
+			return err
+		}
+		breaker.Record(err)
+		if err == nil || attempt >= *maxRetries {
+			return err
+		}
+		for _, m := range inFlight {
+			m.Touch()
+		}
+		time.Sleep(backoff(attempt))
+	}
+}
+
+// endpoint returns the URL of a request to an address
+func (ph *PublishHandler) endpoint(addr string, m *nsq.Message) (string, error) {
+	if t, ok := ph.addrTemplates[addr]; ok {
+		endpoint, err := executeTemplate(t, m)
+		return string(endpoint), err
+	}
+	if ph.get {
+		return fmt.Sprintf(addr, url.QueryEscape(string(m.Body))), nil
+	}
+	return addr, nil
+}
+
+// backoff returns the delay before retry attempt+1, exponential with "equal
+// jitter" (half of it random) so that publishers don't retry in lockstep
+func backoff(attempt int) time.Duration {
+	d := *retryBackoff << uint(attempt)
+	if d > *retryMaxBackoff || d <= 0 {
+		d = *retryMaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("got status code %d", e.StatusCode)
+}
+
+// isRetryable returns false for errors a retry won't fix, ie. client errors
+// other than timeouts and rate limiting
+func isRetryable(err error) bool {
+	if se, ok := err.(*StatusError); ok {
+		return se.StatusCode >= 500 ||
+			se.StatusCode == http.StatusRequestTimeout ||
+			se.StatusCode == http.StatusTooManyRequests
+	}
+	return err != errBreakerOpen
+}
+
 type PostPublisher struct{}
 
 func (p *PostPublisher) Publish(addr string, msg []byte) error {
@@ -134,15 +255,14 @@ func (p *PostPublisher) Publish(addr string, msg []byte) error {
 	resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("got status code %d", resp.StatusCode)
+		return &StatusError{resp.StatusCode}
 	}
 	return nil
 }
 
 type GetPublisher struct{}
 
-func (p *GetPublisher) Publish(addr string, msg []byte) error {
-	endpoint := fmt.Sprintf(addr, url.QueryEscape(string(msg)))
+func (p *GetPublisher) Publish(endpoint string, msg []byte) error {
 	resp, err := HTTPGet(endpoint)
 	if err != nil {
 		return err
@@ -151,7 +271,7 @@ func (p *GetPublisher) Publish(addr string, msg []byte) error {
 	resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("got status code %d", resp.StatusCode)
+		return &StatusError{resp.StatusCode}
 	}
 	return nil
 }
@@ -217,11 +337,78 @@ func main() {
 	if len(getAddrs) > 0 && len(postAddrs) > 0 {
 		log.Fatal("use --get or --post not both")
 	}
-	if len(getAddrs) > 0 {
-		for _, get := range getAddrs {
-			if strings.Count(get, "%s") != 1 {
-				log.Fatal("invalid GET address - must be a printf string")
+	addrTemplates := make(map[string]*template.Template)
+	for _, get := range getAddrs {
+		if isTemplate(get) {
+			continue
+		}
+		if strings.Count(get, "%s") != 1 {
+			log.Fatal("invalid GET address - must be a printf string or a template")
+		}
+	}
+	for _, addr := range append(getAddrs, postAddrs...) {
+		if !isTemplate(addr) {
+			continue
+		}
+		t, err := parseTemplate(addr, addr)
+		if err != nil {
+			log.Fatalf("invalid address template %s - %s", addr, err)
+		}
+		addrTemplates[addr] = t
+	}
+
+	var bodyTmpl *template.Template
+	if *bodyTemplate != "" {
+		if len(postAddrs) == 0 {
+			log.Fatal("--body-template only used with --post")
+		}
+		var err error
+		bodyTmpl, err = parseTemplate("body", *bodyTemplate)
+		if err != nil {
+			log.Fatalf("invalid --body-template - %s", err)
+		}
+	}
+
+	if *batchSize < 0 {
+		log.Fatal("--batch-size must be >= 0")
+	}
+	if *batchSize > 0 {
+		if len(postAddrs) == 0 {
+			log.Fatal("--batch-size only used with --post")
+		}
+		if len(addrTemplates) > 0 {
+			log.Fatal("--batch-size can't be used with templated addresses")
+		}
+		if *batchTimeout <= 0 {
+			log.Fatal("--batch-timeout must be positive")
+		}
+		switch *batchFormat {
+		case BatchFormatJSON:
+			if !hasArg("content-type") {
+				*contentType = "application/json"
+			}
+		case BatchFormatNDJSON:
+			if !hasArg("content-type") {
+				*contentType = "application/x-ndjson"
 			}
+		default:
+			log.Fatal("--batch-format must be json or ndjson")
+		}
+	}
+
+	if *maxRetries < 0 {
+		log.Fatal("--max-retries must be >= 0")
+	}
+	if *retryBackoff <= 0 || *retryMaxBackoff < *retryBackoff {
+		log.Fatal("--retry-backoff must be positive and <= --retry-max-backoff")
+	}
+
+	if *hmacSecret != "" {
+		if len(postAddrs) == 0 {
+			log.Fatal("--hmac-secret only used with --post")
+		}
+		if *hmacHeader == "" {
+			log.Fatal("--hmac-header requires a value when used")
 		}
 	}
 
@@ -271,14 +458,27 @@ func main() {
 		hostPool = hostpool.NewEpsilonGreedy(addresses, 0, &hostpool.LinearEpsilonValueCalculator{})
 	}
 
+	breakers := make(map[string]*CircuitBreaker)
+	for _, a := range addresses {
+		breakers[a] = NewCircuitBreaker(a, *breakerThreshold, *breakerCooldown)
+	}
+
 	handler := &PublishHandler{
 		Publisher:        publisher,
 		addresses:        addresses,
 		mode:             selectedMode,
 		hostPool:         hostPool,
+		get:              len(getAddrs) > 0,
+		addrTemplates:    addrTemplates,
+		bodyTemplate:     bodyTmpl,
+		breakers:         breakers,
 		perAddressStatus: perAddressStatus,
 		timermetrics:     timer_metrics.NewTimerMetrics(*statusEvery, "[aggregate]:"),
 	}
+	if *batchSize > 0 {
+		handler.batcher = NewBatcher(handler, consumer, *batchSize, *batchTimeout, *batchFormat)
+		go handler.batcher.Run(*numPublishers)
+	}
 	consumer.AddConcurrentHandlers(handler, *numPublishers)
 
 	err = consumer.ConnectToNSQDs(nsqdTCPAddrs)
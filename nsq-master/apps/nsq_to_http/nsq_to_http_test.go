@@ -4,8 +4,15 @@
 package main
 
 import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/nsqio/go-nsq"
 )
 
 func TestParseCustomHeaders(t *testing.T) {
@@ -56,3 +63,193 @@ func TestParseCustomHeaders(t *testing.T) {
 		})
 	}
 }
+
+func newTestMessage(body string) *nsq.Message {
+	m := nsq.NewMessage(nsq.MessageID{'0', '6', 'a', 'b'}, []byte(body))
+	m.Timestamp = 1500000000
+	m.Attempts = 2
+	m.Delegate = &testMessageDelegate{}
+	return m
+}
+
+type testMessageDelegate struct {
+	touches int32
+}
+
+func (d *testMessageDelegate) OnFinish(m *nsq.Message)                           {}
+func (d *testMessageDelegate) OnRequeue(m *nsq.Message, t time.Duration, b bool) {}
+func (d *testMessageDelegate) OnTouch(m *nsq.Message)                            { atomic.AddInt32(&d.touches, 1) }
+
+func TestExecuteTemplate(t *testing.T) {
+	*topic = "orders"
+	defer func() { *topic = "" }()
+
+	m := newTestMessage(`{"user": {"id": 42}, "note": "a \"b\""}`)
+	for _, tt := range []struct {
+		tmpl string
+		want string
+	}{
+		{"http://x/{{.Topic}}/{{.JSON.user.id}}?ts={{.Timestamp}}&n={{.Attempts}}", "http://x/orders/42?ts=1500000000&n=2"},
+		{`{"event": {{.Body}}, "note": {{json .JSON.note}}}`, `{"event": {"user": {"id": 42}, "note": "a \"b\""}, "note": "a \"b\""}`},
+		{"{{.Body | base64}}", "eyJ1c2VyIjogeyJpZCI6IDQyfSwgIm5vdGUiOiAiYSBcImJcIiJ9"},
+		{"q={{.Body | urlquery}}", "q=%7B%22user%22%3A+%7B%22id%22%3A+42%7D%2C+%22note%22%3A+%22a+%5C%22b%5C%22%22%7D"},
+	} {
+		tmpl, err := parseTemplate("test", tt.tmpl)
+		if err != nil {
+			t.Fatalf("parseTemplate(%q) error = %v", tt.tmpl, err)
+		}
+		got, err := executeTemplate(tmpl, m)
+		if err != nil {
+			t.Fatalf("executeTemplate(%q) error = %v", tt.tmpl, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("executeTemplate(%q) = %s, want %s", tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+func TestBatcherEncode(t *testing.T) {
+	batch := []*nsq.Message{newTestMessage(`{"a":1}`), newTestMessage("plain")}
+	ph := &PublishHandler{}
+
+	b := &Batcher{handler: ph, format: BatchFormatJSON}
+	got, err := b.encode(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"a":1},"plain"]`; string(got) != want {
+		t.Errorf("encode() = %s, want %s", got, want)
+	}
+
+	b.format = BatchFormatNDJSON
+	got, err = b.encode(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\"a\":1}\nplain\n"; string(got) != want {
+		t.Errorf("encode() = %q, want %q", got, want)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	defer func(b, max time.Duration) { *retryBackoff, *retryMaxBackoff = b, max }(*retryBackoff, *retryMaxBackoff)
+	*retryBackoff = 100 * time.Millisecond
+	*retryMaxBackoff = time.Second
+
+	for attempt, d := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		d *= time.Millisecond
+		if attempt == 5 {
+			attempt = 100 // must not overflow
+		}
+		for i := 0; i < 10; i++ {
+			got := backoff(attempt)
+			if got < d/2 || got > d {
+				t.Errorf("backoff(%d) = %s, want between %s and %s", attempt, got, d/2, d)
+			}
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	b := NewCircuitBreaker("test", 2, 50*time.Millisecond)
+	fail := errors.New("fail")
+
+	b.Record(fail)
+	if !b.Allow() {
+		t.Fatal("breaker open before the threshold")
+	}
+	b.Record(fail)
+	if b.Allow() || !b.IsOpen() {
+		t.Fatal("breaker not open at the threshold")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if b.IsOpen() {
+		t.Fatal("breaker open after the cooldown")
+	}
+	if !b.Allow() {
+		t.Fatal("no trial request after the cooldown")
+	}
+	if b.Allow() {
+		t.Fatal("more than one trial request")
+	}
+	b.Record(fail)
+	if b.Allow() {
+		t.Fatal("breaker not open after a failed trial")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("no trial request after the cooldown")
+	}
+	b.Record(nil)
+	if !b.Allow() || b.IsOpen() {
+		t.Fatal("breaker not closed after a successful trial")
+	}
+}
+
+func TestPublishHandlerSend(t *testing.T) {
+	defer func(r int, b time.Duration) { *maxRetries, *retryBackoff = r, b }(*maxRetries, *retryBackoff)
+	*maxRetries = 2
+	*retryBackoff = time.Millisecond
+	httpclient = &http.Client{}
+
+	var requests int32
+	var status int32 = 503
+	var lastSignature atomic.Value
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		lastSignature.Store(r.Header.Get("X-Signature"))
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer ts.Close()
+
+	addr := ts.URL + "/hook"
+	ph := &PublishHandler{
+		Publisher: &PostPublisher{},
+		breakers:  map[string]*CircuitBreaker{addr: NewCircuitBreaker(addr, 4, time.Minute)},
+	}
+	m := newTestMessage("body")
+
+	// retried up to --max-retries
+	err := ph.send(addr, m, m.Body, []*nsq.Message{m})
+	if se, ok := err.(*StatusError); !ok || se.StatusCode != 503 {
+		t.Fatalf("send() error = %v, want status code 503", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Fatalf("%d requests, want 3", n)
+	}
+	// the message is touched before each backoff, not to time out
+	if n := atomic.LoadInt32(&m.Delegate.(*testMessageDelegate).touches); n != 2 {
+		t.Fatalf("%d touches, want 2", n)
+	}
+
+	// the breaker opens at 4 consecutive failures
+	err = ph.send(addr, m, m.Body, []*nsq.Message{m})
+	if err != errBreakerOpen {
+		t.Fatalf("send() error = %v, want %v", err, errBreakerOpen)
+	}
+	if n := atomic.LoadInt32(&requests); n != 4 {
+		t.Fatalf("%d requests, want 4", n)
+	}
+
+	// client errors aren't retried, nor count against the breaker
+	atomic.StoreInt32(&status, 400)
+	ph.breakers[addr] = NewCircuitBreaker(addr, 1, time.Minute)
+	*hmacSecret = "secret"
+	defer func() { *hmacSecret = "" }()
+	err = ph.send(addr, m, m.Body, []*nsq.Message{m})
+	if se, ok := err.(*StatusError); !ok || se.StatusCode != 400 {
+		t.Fatalf("send() error = %v, want status code 400", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 5 {
+		t.Fatalf("%d requests, want 5", n)
+	}
+	if ph.breakers[addr].IsOpen() {
+		t.Fatal("breaker opened by a client error")
+	}
+	want := "sha256=dc46983557fea127b43af721467eb9b3fde2338fe3e14f51952aa8478c13d355"
+	if got := lastSignature.Load().(string); got != want {
+		t.Fatalf("signature = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"text/template"
+
+	"github.com/nsqio/go-nsq"
+)
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"base64": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+}
+
+// isTemplate returns true if s is a --get/--post address or --body-template
+// with template actions
+func isTemplate(s string) bool {
+	return strings.Contains(s, "{{")
+}
+
+func parseTemplate(name string, s string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(s)
+}
+
+// MessageData are the fields of a message available to templates, eg.
+//
+//	{{.ID}} {{.Body}} {{.Timestamp}} {{.Attempts}} {{.Topic}} {{.Channel}}
+//	{{.JSON.user.id}} (the body parsed as JSON)
+type MessageData struct {
+	m    *nsq.Message
+	json interface{}
+}
+
+func (d *MessageData) ID() string          { return string(d.m.ID[:]) }
+func (d *MessageData) Body() string        { return string(d.m.Body) }
+func (d *MessageData) Timestamp() int64    { return d.m.Timestamp }
+func (d *MessageData) Attempts() uint16    { return d.m.Attempts }
+func (d *MessageData) NSQDAddress() string { return d.m.NSQDAddress }
+func (d *MessageData) Topic() string       { return *topic }
+func (d *MessageData) Channel() string     { return *channel }
+
+// JSON returns the body parsed as JSON, nil if it isn't
+func (d *MessageData) JSON() interface{} {
+	if d.json == nil {
+		json.Unmarshal(d.m.Body, &d.json)
+	}
+	return d.json
+}
+
+func executeTemplate(t *template.Template, m *nsq.Message) ([]byte, error) {
+	var buf bytes.Buffer
+	err := t.Execute(&buf, &MessageData{m: m})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -46,6 +46,7 @@ var (
 
 	requireJSONField = flag.String("require-json-field", "", "for JSON messages: only pass messages that contain this field")
 	requireJSONValue = flag.String("require-json-value", "", "for JSON messages: only pass messages in which the required field has this value")
+	transformConfig  = flag.String("transform-config", "", "path to a YAML file of transforms (extract, rename, project, route, fanout) applied in order to JSON object messages")
 )
 
 func init() {
@@ -65,6 +66,7 @@ type PublishHandler struct {
 	mode      int
 	hostPool  hostpool.HostPool
 	respChan  chan *nsq.ProducerTransaction
+	chain     Chain
 
 	requireJSONValueParsed   bool
 	requireJSONValueIsNumber bool
@@ -74,13 +76,33 @@ type PublishHandler struct {
 	timermetrics     *timer_metrics.TimerMetrics
 }
 
+// publishTxn tracks the publishes of a message, one per destination topic,
+// it is finished once all have succeeded and requeued if any has failed
+type publishTxn struct {
+	m         *nsq.Message
+	remaining int32
+	failed    int32
+}
+
+// done records that n publishes have completed
+func (txn *publishTxn) done(n int32) {
+	if atomic.AddInt32(&txn.remaining, -n) > 0 {
+		return
+	}
+	if atomic.LoadInt32(&txn.failed) == 1 {
+		txn.m.Requeue(-1)
+	} else {
+		txn.m.Finish()
+	}
+}
+
 type TopicHandler struct {
 	publishHandler   *PublishHandler
 	destinationTopic string
 }
 
 func (ph *PublishHandler) responder() {
-	var msg *nsq.Message
+	var txn *publishTxn
 	var startTime time.Time
 	var address string
 	var hostPoolResponse hostpool.HostPoolResponse
@@ -88,12 +110,12 @@ func (ph *PublishHandler) responder() {
 	for t := range ph.respChan {
 		switch ph.mode {
 		case ModeRoundRobin:
-			msg = t.Args[0].(*nsq.Message)
+			txn = t.Args[0].(*publishTxn)
 			startTime = t.Args[1].(time.Time)
 			hostPoolResponse = nil
 			address = t.Args[2].(string)
 		case ModeHostPool:
-			msg = t.Args[0].(*nsq.Message)
+			txn = t.Args[0].(*publishTxn)
 			startTime = t.Args[1].(time.Time)
 			hostPoolResponse = t.Args[2].(hostpool.HostPoolResponse)
 			address = hostPoolResponse.Host()
@@ -109,11 +131,10 @@ func (ph *PublishHandler) responder() {
 			}
 		}
 
-		if success {
-			msg.Finish()
-		} else {
-			msg.Requeue(-1)
+		if !success {
+			atomic.StoreInt32(&txn.failed, 1)
 		}
+		txn.done(1)
 
 		ph.perAddressStatus[address].Status(startTime)
 		ph.timermetrics.Status(startTime)
@@ -229,6 +250,35 @@ func (ph *PublishHandler) HandleMessage(m *nsq.Message, destinationTopic string)
 		}
 	}
 
+	destinationTopics := []string{destinationTopic}
+	if ph.chain != nil {
+		msgBody, destinationTopics, err = ph.chain.Apply(msgBody, destinationTopic)
+		if err != nil {
+			log.Printf("ERROR: Unable to transform message (%s): %s", err, m.Body)
+			return nil
+		}
+	}
+
+	txn := &publishTxn{m: m, remaining: int32(len(destinationTopics))}
+	for i, topic := range destinationTopics {
+		err = ph.publish(topic, msgBody, txn)
+		if err != nil {
+			if i == 0 {
+				return err
+			}
+			// the publishes so far are responded to once they complete
+			log.Printf("ERROR: failed to publish to %s: %s", topic, err)
+			atomic.StoreInt32(&txn.failed, 1)
+			txn.done(int32(len(destinationTopics) - i))
+			break
+		}
+	}
+	m.DisableAutoResponse()
+	return nil
+}
+
+func (ph *PublishHandler) publish(topic string, body []byte, txn *publishTxn) error {
+	var err error
 	startTime := time.Now()
 
 	switch ph.mode {
@@ -237,21 +287,16 @@ func (ph *PublishHandler) HandleMessage(m *nsq.Message, destinationTopic string)
 		idx := counter % uint64(len(ph.addresses))
 		addr := ph.addresses[idx]
 		p := ph.producers[addr]
-		err = p.PublishAsync(destinationTopic, msgBody, ph.respChan, m, startTime, addr)
+		err = p.PublishAsync(topic, body, ph.respChan, txn, startTime, addr)
 	case ModeHostPool:
 		hostPoolResponse := ph.hostPool.Get()
 		p := ph.producers[hostPoolResponse.Host()]
-		err = p.PublishAsync(destinationTopic, msgBody, ph.respChan, m, startTime, hostPoolResponse)
+		err = p.PublishAsync(topic, body, ph.respChan, txn, startTime, hostPoolResponse)
 		if err != nil {
 			hostPoolResponse.Mark(err)
 		}
 	}
-
-	if err != nil {
-		return err
-	}
-	m.DisableAutoResponse()
-	return nil
+	return err
 }
 
 func hasArg(s string) bool {
@@ -309,6 +354,15 @@ func main() {
 		log.Fatal("--destination-nsqd-tcp-address required")
 	}
 
+	var chain Chain
+	if *transformConfig != "" {
+		var err error
+		chain, err = LoadChain(*transformConfig)
+		if err != nil {
+			log.Fatalf("failed to load --transform-config %s - %s", *transformConfig, err)
+		}
+	}
+
 	switch *mode {
 	case "round-robin":
 		selectedMode = ModeRoundRobin
@@ -357,6 +411,7 @@ func main() {
 		producers:        producers,
 		mode:             selectedMode,
 		hostPool:         hostPool,
+		chain:            chain,
 		respChan:         make(chan *nsq.ProducerTransaction, len(destNsqdTCPAddrs)),
 		perAddressStatus: perAddressStatus,
 		timermetrics:     timer_metrics.NewTimerMetrics(*statusEvery, "[aggregate]:"),
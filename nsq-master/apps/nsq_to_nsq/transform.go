@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	gojsonpointer "awesomeProject8/gojsonpointer/pointer"
+	"github.com/nsqio/nsq/internal/protocol"
+	"gopkg.in/yaml.v2"
+)
+
+// TransformConfig is the YAML document of --transform-config, a chain of
+// transforms applied in order to JSON object messages, eg.
+//
+//	transforms:
+//	  - extract: {user_id: /user/id}      # set fields to JSON pointer values
+//	  - rename: {ts: timestamp}           # rename fields
+//	  - project: [user_id, timestamp]     # only keep these fields
+//	  - route:                            # publish to topics by field value
+//	      pointer: /type
+//	      topics: {click: clicks, view: views}
+//	      default: other                  # otherwise keep the destinations
+//	  - fanout: [audit]                   # also publish to these topics
+//
+// Each transform has exactly one of extract, rename, project, route or
+// fanout.
+type TransformConfig struct {
+	Transforms []TransformSpec `yaml:"transforms"`
+}
+
+type TransformSpec struct {
+	Extract map[string]string `yaml:"extract"`
+	Rename  map[string]string `yaml:"rename"`
+	Project []string          `yaml:"project"`
+	Route   *RouteSpec        `yaml:"route"`
+	Fanout  []string          `yaml:"fanout"`
+}
+
+type RouteSpec struct {
+	Pointer string            `yaml:"pointer"`
+	Topics  map[string]string `yaml:"topics"`
+	Default string            `yaml:"default"`
+}
+
+// Event is a message going through the transform chain, the body of a JSON
+// object message and the topics it is published to
+type Event struct {
+	Body   map[string]interface{}
+	Topics []string
+}
+
+// Transform is a step of the transform chain
+type Transform interface {
+	Apply(e *Event)
+}
+
+// Chain is the transform chain of --transform-config
+type Chain []Transform
+
+// LoadChain reads and validates a transform chain file
+func LoadChain(fileName string) (Chain, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return ParseChain(data)
+}
+
+func ParseChain(data []byte) (Chain, error) {
+	var cfg TransformConfig
+	err := yaml.UnmarshalStrict(data, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	var chain Chain
+	for i, spec := range cfg.Transforms {
+		t, err := newTransform(spec)
+		if err != nil {
+			return nil, fmt.Errorf("transform %d - %s", i+1, err)
+		}
+		chain = append(chain, t)
+	}
+	return chain, nil
+}
+
+func newTransform(spec TransformSpec) (Transform, error) {
+	var transforms []Transform
+	if spec.Extract != nil {
+		t := extractTransform{}
+		for field, s := range spec.Extract {
+			p, err := gojsonpointer.NewJsonPointer(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pointer %q of extract %s - %s", s, field, err)
+			}
+			t[field] = p
+		}
+		transforms = append(transforms, t)
+	}
+	if spec.Rename != nil {
+		transforms = append(transforms, renameTransform(spec.Rename))
+	}
+	if spec.Project != nil {
+		transforms = append(transforms, projectTransform(spec.Project))
+	}
+	if spec.Route != nil {
+		p, err := gojsonpointer.NewJsonPointer(spec.Route.Pointer)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route pointer %q - %s", spec.Route.Pointer, err)
+		}
+		for _, topic := range spec.Route.Topics {
+			if !protocol.IsValidTopicName(topic) {
+				return nil, fmt.Errorf("invalid route topic %q", topic)
+			}
+		}
+		if spec.Route.Default != "" && !protocol.IsValidTopicName(spec.Route.Default) {
+			return nil, fmt.Errorf("invalid route default topic %q", spec.Route.Default)
+		}
+		transforms = append(transforms, &routeTransform{
+			pointer:  p,
+			topics:   spec.Route.Topics,
+			fallback: spec.Route.Default,
+		})
+	}
+	if spec.Fanout != nil {
+		for _, topic := range spec.Fanout {
+			if !protocol.IsValidTopicName(topic) {
+				return nil, fmt.Errorf("invalid fanout topic %q", topic)
+			}
+		}
+		transforms = append(transforms, fanoutTransform(spec.Fanout))
+	}
+	if len(transforms) != 1 {
+		return nil, errors.New("should have exactly one of extract, rename, project, route or fanout")
+	}
+	return transforms[0], nil
+}
+
+// Apply runs a message through the chain and returns the body to publish,
+// and the topics (the destination topic unless routed or fanned out) to
+// publish it to
+func (c Chain) Apply(body []byte, destinationTopic string) ([]byte, []string, error) {
+	e := &Event{Topics: []string{destinationTopic}}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	err := dec.Decode(&e.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if e.Body == nil {
+		return nil, nil, errors.New("not a JSON object")
+	}
+	for _, t := range c {
+		t.Apply(e)
+	}
+	body, err = json.Marshal(e.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, e.Topics, nil
+}
+
+// extractTransform sets fields to the values of JSON pointers, fields of
+// pointers that don't resolve are removed
+type extractTransform map[string]gojsonpointer.JsonPointer
+
+func (t extractTransform) Apply(e *Event) {
+	values := make(map[string]interface{}, len(t))
+	for field, p := range t {
+		v, _, err := p.Get(e.Body)
+		if err == nil {
+			values[field] = v
+		}
+	}
+	for field := range t {
+		if v, ok := values[field]; ok {
+			e.Body[field] = v
+		} else {
+			delete(e.Body, field)
+		}
+	}
+}
+
+// renameTransform renames fields (old to new name)
+type renameTransform map[string]string
+
+func (t renameTransform) Apply(e *Event) {
+	values := make(map[string]interface{}, len(t))
+	for from := range t {
+		if v, ok := e.Body[from]; ok {
+			values[from] = v
+			delete(e.Body, from)
+		}
+	}
+	for from, v := range values {
+		e.Body[t[from]] = v
+	}
+}
+
+// projectTransform only keeps some fields
+type projectTransform []string
+
+func (t projectTransform) Apply(e *Event) {
+	body := make(map[string]interface{}, len(t))
+	for _, field := range t {
+		if v, ok := e.Body[field]; ok {
+			body[field] = v
+		}
+	}
+	e.Body = body
+}
+
+// routeTransform replaces the topics by the topic of the value of a JSON
+// pointer (as a string if it is one, as JSON otherwise eg. 42 or true)
+type routeTransform struct {
+	pointer  gojsonpointer.JsonPointer
+	topics   map[string]string
+	fallback string
+}
+
+func (t *routeTransform) Apply(e *Event) {
+	topic := t.fallback
+	if v, _, err := t.pointer.Get(e.Body); err == nil {
+		s, ok := v.(string)
+		if !ok {
+			b, _ := json.Marshal(v)
+			s = string(b)
+		}
+		if routed, ok := t.topics[s]; ok {
+			topic = routed
+		}
+	}
+	if topic != "" {
+		e.Topics = []string{topic}
+	}
+}
+
+// fanoutTransform adds topics
+type fanoutTransform []string
+
+func (t fanoutTransform) Apply(e *Event) {
+	for _, topic := range t {
+		found := false
+		for _, existing := range e.Topics {
+			if existing == topic {
+				found = true
+				break
+			}
+		}
+		if !found {
+			e.Topics = append(e.Topics, topic)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseChain(t *testing.T) {
+	for _, doc := range []string{
+		"transforms: [{}]",
+		"transforms: [{rename: {a: b}, project: [b]}]",
+		"transforms: [{extract: {a: nope}}]",
+		"transforms: [{route: {pointer: /type, topics: {a: 'bad topic'}}}]",
+		"transforms: [{fanout: ['']}]",
+		"transforms: [{unknown: true}]",
+	} {
+		_, err := ParseChain([]byte(doc))
+		if err == nil {
+			t.Errorf("%s: should fail", doc)
+		}
+	}
+}
+
+func TestChainApply(t *testing.T) {
+	chain, err := ParseChain([]byte(`
+transforms:
+  - extract: {user_id: /user/id, first_tag: /tags/0, missing: /user/missing}
+  - rename: {ts: timestamp, type: kind}
+  - project: [user_id, first_tag, timestamp, kind]
+  - route:
+      pointer: /kind
+      topics: {click: clicks, "42": answers}
+  - fanout: [audit, clicks]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		body   string
+		want   string
+		topics []string
+	}{
+		{
+			`{"user": {"id": 12345678901234567890}, "tags": ["a", "b"], "ts": 1, "type": "click", "other": true}`,
+			`{"first_tag":"a","kind":"click","timestamp":1,"user_id":12345678901234567890}`,
+			[]string{"clicks", "audit"},
+		},
+		{
+			`{"type": 42}`,
+			`{"kind":42}`,
+			[]string{"answers", "audit", "clicks"},
+		},
+		{
+			`{"type": "view", "missing": "dropped"}`,
+			`{"kind":"view"}`,
+			[]string{"events", "audit", "clicks"},
+		},
+	}
+	for _, tt := range tests {
+		body, topics, err := chain.Apply([]byte(tt.body), "events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != tt.want || !reflect.DeepEqual(topics, tt.topics) {
+			t.Errorf("%s: got %s %v, want %s %v", tt.body, body, topics, tt.want, tt.topics)
+		}
+	}
+
+	for _, body := range []string{"not json", "[1]", "null"} {
+		_, _, err := chain.Apply([]byte(body), "events")
+		if err == nil {
+			t.Errorf("%s: should fail", body)
+		}
+	}
+}
+
+func TestRouteDefault(t *testing.T) {
+	chain, err := ParseChain([]byte(`
+transforms:
+  - route: {pointer: /type, topics: {a: topic_a}, default: other}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for body, want := range map[string]string{
+		`{"type": "a"}`: "topic_a",
+		`{"type": "b"}`: "other",
+		`{}`:            "other",
+	} {
+		_, topics, err := chain.Apply([]byte(body), "events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(topics, []string{want}) {
+			t.Errorf("%s: got %v, want %s", body, topics, want)
+		}
+	}
+}
@@ -39,8 +39,18 @@ func nsqadminFlagSet(opts *nsqadmin.Options) *flag.FlagSet {
 	flagSet.String("statsd-prefix", opts.StatsdPrefix, "prefix used for keys sent to statsd (%s for host replacement, must match nsqd)")
 	flagSet.Duration("statsd-interval", opts.StatsdInterval, "time interval nsqd is configured to push to statsd (must match nsqd)")
 
+	flagSet.String("stats-history-file", "", "path to a (bolt) file in which to keep a history of topic and channel stats, for charts without graphite")
+	flagSet.Duration("stats-history-interval", opts.StatsHistoryInterval, "interval at which topic and channel stats are sampled into --stats-history-file")
+	flagSet.Duration("stats-history-retention", opts.StatsHistoryRetention, "how long samples are kept in --stats-history-file")
+
 	flagSet.String("notification-http-endpoint", "", "HTTP endpoint (fully qualified) to which POST notifications of admin actions will be sent")
 
+	flagSet.String("alert-rules-file", "", "path to a TOML file of alert rules ([[rule]] tables) to evaluate periodically")
+	flagSet.Duration("alert-interval", opts.AlertInterval, "interval at which alert rules are evaluated")
+	alertWebhookURLs := app.StringArray{}
+	flagSet.Var(&alertWebhookURLs, "alert-webhook-url", "HTTP endpoint (fully qualified) to which alerts are POSTed when they fire, resolve or expire (may be given multiple times)")
+	flagSet.String("alert-topic", "", "topic to which alerts are published when they fire, resolve or expire")
+
 	flagSet.Duration("http-client-connect-timeout", opts.HTTPClientConnectTimeout, "timeout for HTTP connect")
 	flagSet.Duration("http-client-request-timeout", opts.HTTPClientRequestTimeout, "timeout for HTTP request")
 
@@ -54,10 +64,18 @@ func nsqadminFlagSet(opts *nsqadmin.Options) *flag.FlagSet {
 
 	nsqlookupdHTTPAddresses := app.StringArray{}
 	flagSet.Var(&nsqlookupdHTTPAddresses, "lookupd-http-address", "lookupd HTTP address (may be given multiple times)")
+	flagSet.String("lookupd-http-auth-token", "", "token for the mutating lookupd HTTP endpoints, when lookupd requires one (sent with every request to the cluster)")
 	nsqdHTTPAddresses := app.StringArray{}
 	flagSet.Var(&nsqdHTTPAddresses, "nsqd-http-address", "nsqd HTTP address (may be given multiple times)")
+	flagSet.String("clusters-file", "", "path to a TOML file of named clusters ([[cluster]] tables) to manage besides (or instead of) the one of --lookupd-http-address/--nsqd-http-address")
 	adminUsers := app.StringArray{}
 	flagSet.Var(&adminUsers, "admin-user", "admin user (may be given multiple times; if specified, only these users will be able to perform privileged actions; acl-http-header is used to determine the authenticated user)")
+	flagSet.String("acl-policy-file", "", "path to a TOML file of roles ([[role]] tables) granting users view, pause, empty, delete and create permissions on topic patterns (admin users keep all permissions)")
+
+	flagSet.String("sync-file", "", "path to a YAML file of the desired topics and channels of a cluster: print the plan that brings the cluster to it (and apply it with --sync-apply) and exit")
+	flagSet.Bool("sync-apply", false, "apply the plan of --sync-file (requires --sync-plan)")
+	flagSet.String("sync-plan", "", "fingerprint of the plan printed by --sync-file, --sync-apply is refused if the plan changed since")
+	flagSet.String("sync-cluster", "", "name of the cluster (of --clusters-file) to which --sync-file applies (default: the first one)")
 
 	return flagSet
 }
@@ -103,6 +121,19 @@ func (p *program) Start() error {
 	}
 
 	options.Resolve(opts, flagSet, cfg)
+
+	syncFile := flagSet.Lookup("sync-file").Value.String()
+	if syncFile != "" {
+		apply := flagSet.Lookup("sync-apply").Value.(flag.Getter).Get().(bool)
+		syncCluster := flagSet.Lookup("sync-cluster").Value.String()
+		syncPlan := flagSet.Lookup("sync-plan").Value.String()
+		err := nsqadmin.Sync(opts, syncCluster, syncFile, apply, syncPlan, os.Stdout)
+		if err != nil {
+			logFatal("failed to sync %s - %s", syncFile, err)
+		}
+		os.Exit(0)
+	}
+
 	nsqadmin, err := nsqadmin.New(opts)
 	if err != nil {
 		logFatal("failed to instantiate nsqadmin - %s", err)
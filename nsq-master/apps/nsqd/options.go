@@ -114,8 +114,12 @@ func nsqdFlagSet(opts *nsqd.Options) *flag.FlagSet {
 	authHTTPAddresses := app.StringArray{}
 	flagSet.Var(&authHTTPAddresses, "auth-http-address", "<addr>:<port> to query auth server (may be given multiple times)")
 	flagSet.String("broadcast-address", opts.BroadcastAddress, "address that will be registered with lookupd (defaults to the OS hostname)")
+	flagSet.String("zone", opts.Zone, "availability zone advertised to lookupd for topology-aware lookups")
+	flagSet.String("region", opts.Region, "region advertised to lookupd for topology-aware lookups")
 	lookupdTCPAddrs := app.StringArray{}
 	flagSet.Var(&lookupdTCPAddrs, "lookupd-tcp-address", "lookupd TCP address (may be given multiple times)")
+	flagSet.Bool("lookupd-tls", opts.NSQLookupdTLS, "connect to lookupd with TLS (verified with --tls-root-ca-file, presenting --tls-cert)")
+	flagSet.String("lookupd-secret", opts.NSQLookupdSecret, "shared secret to register with lookupd (its --registration-secret)")
 	flagSet.Duration("http-client-connect-timeout", opts.HTTPClientConnectTimeout, "timeout for HTTP connect")
 	flagSet.Duration("http-client-request-timeout", opts.HTTPClientRequestTimeout, "timeout for HTTP request")
 
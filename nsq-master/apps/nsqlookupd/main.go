@@ -11,6 +11,7 @@ import (
 	"github.com/BurntSushi/toml"
 	"github.com/judwhite/go-svc/svc"
 	"github.com/mreiferson/go-options"
+	"github.com/nsqio/nsq/internal/app"
 	"github.com/nsqio/nsq/internal/lg"
 	"github.com/nsqio/nsq/internal/version"
 	"github.com/nsqio/nsq/nsqlookupd"
@@ -29,11 +30,36 @@ func nsqlookupdFlagSet(opts *nsqlookupd.Options) *flag.FlagSet {
 
 	flagSet.String("tcp-address", opts.TCPAddress, "<addr>:<port> to listen on for TCP clients")
 	flagSet.String("http-address", opts.HTTPAddress, "<addr>:<port> to listen on for HTTP clients")
+	flagSet.String("https-address", opts.HTTPSAddress, "<addr>:<port> to listen on for HTTPS clients (when --tls-cert and --tls-key are set)")
 	flagSet.String("broadcast-address", opts.BroadcastAddress, "address of this lookupd node, (default to the OS hostname)")
 
 	flagSet.Duration("inactive-producer-timeout", opts.InactiveProducerTimeout, "duration of time a producer will remain in the active list since its last ping")
 	flagSet.Duration("tombstone-lifetime", opts.TombstoneLifetime, "duration of time a producer will remain tombstoned if registration remains")
 
+	flagSet.String("data-path", "", "path to store registrations and tombstones across restarts (disabled when empty)")
+	flagSet.Duration("snapshot-interval", opts.SnapshotInterval, "duration of time between persisting registrations (when --data-path is set)")
+
+	peerHTTPAddrs := app.StringArray{}
+	flagSet.Var(&peerHTTPAddrs, "peer-http-address", "HTTP address of a peer nsqlookupd to replicate state with (may be given multiple times)")
+	flagSet.Duration("peer-sync-interval", opts.PeerSyncInterval, "duration of time between pulling the state of each peer")
+
+	flagSet.Duration("health-check-interval", opts.HealthCheckInterval, "duration of time between probing the HTTP /ping endpoint of each nsqd (disabled when 0)")
+	flagSet.Duration("health-check-timeout", opts.HealthCheckTimeout, "timeout of a health check probe")
+	flagSet.Int("health-check-failures", opts.HealthCheckFailures, "number of consecutive failed probes after which an nsqd is unhealthy (and left out of /lookup)")
+
+	flagSet.String("tls-cert", opts.TLSCert, "path to certificate file")
+	flagSet.String("tls-key", opts.TLSKey, "path to key file")
+	flagSet.String("tls-client-auth-policy", opts.TLSClientAuthPolicy, "client certificate auth policy ('require' or 'require-verify'), implies --tls-required")
+	flagSet.String("tls-root-ca-file", opts.TLSRootCAFile, "path to certificate authority file")
+	flagSet.Bool("tls-required", opts.TLSRequired, "require TLS for TCP and HTTP client connections")
+	tlsMinVersion := tlsMinVersionOption(opts.TLSMinVersion)
+	flagSet.Var(&tlsMinVersion, "tls-min-version", "minimum SSL/TLS version acceptable ('ssl3.0', 'tls1.0', 'tls1.1', or 'tls1.2')")
+
+	flagSet.String("registration-secret", opts.RegistrationSecret, "shared secret nsqd has to IDENTIFY with to register (--lookupd-secret)")
+	httpAuthTokens := app.StringArray{}
+	flagSet.Var(&httpAuthTokens, "http-auth-token", "<role>:<token> granting access to mutating HTTP endpoints, roles are write, peer and admin (may be given multiple times)")
+	flagSet.String("peer-http-auth-token", opts.PeerHTTPAuthToken, "token to authenticate with to peers (--peer-http-address)")
+
 	return flagSet
 }
 
@@ -68,7 +94,7 @@ func (p *program) Start() error {
 		os.Exit(0)
 	}
 
-	var cfg map[string]interface{}
+	var cfg config
 	configFile := flagSet.Lookup("config").Value.String()
 	if configFile != "" {
 		_, err := toml.DecodeFile(configFile, &cfg)
@@ -76,6 +102,7 @@ func (p *program) Start() error {
 			logFatal("failed to load config file %s - %s", configFile, err)
 		}
 	}
+	cfg.Validate()
 
 	options.Resolve(opts, flagSet, cfg)
 	nsqlookupd, err := nsqlookupd.New(opts)
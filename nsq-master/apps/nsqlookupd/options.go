@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tlsMinVersionOption uint16
+
+func (t *tlsMinVersionOption) Set(s string) error {
+	s = strings.ToLower(s)
+	switch s {
+	case "":
+		return nil
+	case "ssl3.0":
+		*t = tls.VersionSSL30
+	case "tls1.0":
+		*t = tls.VersionTLS10
+	case "tls1.1":
+		*t = tls.VersionTLS11
+	case "tls1.2":
+		*t = tls.VersionTLS12
+	default:
+		return fmt.Errorf("unknown tlsVersionOption %q", s)
+	}
+	return nil
+}
+
+func (t *tlsMinVersionOption) Get() interface{} { return uint16(*t) }
+
+func (t *tlsMinVersionOption) String() string {
+	return strconv.FormatInt(int64(*t), 10)
+}
+
+type config map[string]interface{}
+
+// Validate settings in the config file, and fatal on errors
+func (cfg config) Validate() {
+	// special validation/translation
+	if v, exists := cfg["tls_min_version"]; exists {
+		var t tlsMinVersionOption
+		err := t.Set(fmt.Sprintf("%v", v))
+		if err == nil {
+			newVal := fmt.Sprintf("%v", t.Get())
+			if newVal != "0" {
+				cfg["tls_min_version"] = newVal
+			} else {
+				delete(cfg, "tls_min_version")
+			}
+		} else {
+			logFatal("failed parsing tls_min_version %+v", v)
+		}
+	}
+}
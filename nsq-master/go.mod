@@ -1,19 +1,32 @@
 module github.com/nsqio/nsq
 
 require (
+	awesomeProject8/gojsonpointer v0.0.0-00010101000000-000000000000
 	github.com/BurntSushi/toml v0.3.1
 	github.com/bitly/go-hostpool v0.1.0
 	github.com/bitly/timer_metrics v1.0.0
 	github.com/blang/semver v3.5.1+incompatible
 	github.com/bmizerany/perks v0.0.0-20141205001514-d9a9656a3a4b
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/golang/snappy v0.0.1
+	github.com/golang/snappy v0.0.3
+	github.com/itchio/lzma v0.0.0-00010101000000-000000000000
 	github.com/judwhite/go-svc v1.1.2
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/mreiferson/go-options v1.0.0
 	github.com/nsqio/go-diskqueue v1.0.0
 	github.com/nsqio/go-nsq v1.0.8
-	golang.org/x/sys v0.0.0-20191224085550-c709ea063b76 // indirect
+	github.com/xeipuuv/gojsonreference v0.0.0-00010101000000-000000000000
+	github.com/xitongsys/parquet-go v1.6.2
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1
+	gopkg.in/yaml.v2 v2.2.2
 )
 
 go 1.13
+
+replace github.com/itchio/lzma => ../lzma2
+
+replace awesomeProject8/gojsonpointer => ../gojsonpointer
+
+replace github.com/xeipuuv/gojsonreference => ../gojsonreference-master
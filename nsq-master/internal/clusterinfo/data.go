@@ -1,6 +1,7 @@
 package clusterinfo
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/url"
@@ -35,17 +36,25 @@ func (l ErrList) Errors() []error {
 }
 
 type ClusterInfo struct {
-	log    lg.AppLogFunc
-	client *http_api.Client
+	log           lg.AppLogFunc
+	client        *http_api.Client
+	lookupdClient *http_api.Client
 }
 
 func New(log lg.AppLogFunc, client *http_api.Client) *ClusterInfo {
 	return &ClusterInfo{
-		log:    log,
-		client: client,
+		log:           log,
+		client:        client,
+		lookupdClient: client,
 	}
 }
 
+// SetLookupdClient sets the client used for requests to nsqlookupd, so that
+// its credentials aren't sent to every nsqd
+func (c *ClusterInfo) SetLookupdClient(client *http_api.Client) {
+	c.lookupdClient = client
+}
+
 func (c *ClusterInfo) logf(f string, args ...interface{}) {
 	if c.log != nil {
 		c.log(lg.INFO, f, args...)
@@ -89,7 +98,7 @@ func (c *ClusterInfo) GetLookupdTopics(lookupdHTTPAddrs []string) ([]string, err
 			c.logf("CI: querying nsqlookupd %s", endpoint)
 
 			var resp respType
-			err := c.client.GETV1(endpoint, &resp)
+			err := c.lookupdClient.GETV1(endpoint, &resp)
 			if err != nil {
 				lock.Lock()
 				errs = append(errs, err)
@@ -117,6 +126,55 @@ func (c *ClusterInfo) GetLookupdTopics(lookupdHTTPAddrs []string) ([]string, err
 	return topics, nil
 }
 
+// GetLookupdTopicsMetadata returns the metadata of all topics
+// from all the given nsqlookupd
+func (c *ClusterInfo) GetLookupdTopicsMetadata(lookupdHTTPAddrs []string) (map[string]Metadata, error) {
+	metadata := make(map[string]Metadata)
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	var errs []error
+
+	type respType struct {
+		Metadata map[string]Metadata `json:"metadata"`
+	}
+
+	for _, addr := range lookupdHTTPAddrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+
+			endpoint := fmt.Sprintf("http://%s/topics", addr)
+			c.logf("CI: querying nsqlookupd %s", endpoint)
+
+			var resp respType
+			err := c.lookupdClient.GETV1(endpoint, &resp)
+			if err != nil {
+				lock.Lock()
+				errs = append(errs, err)
+				lock.Unlock()
+				return
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			for topic, m := range resp.Metadata {
+				merged := metadata[topic]
+				merged.Merge(m)
+				metadata[topic] = merged
+			}
+		}(addr)
+	}
+	wg.Wait()
+
+	if len(errs) == len(lookupdHTTPAddrs) {
+		return nil, fmt.Errorf("Failed to query any nsqlookupd: %s", ErrList(errs))
+	}
+	if len(errs) > 0 {
+		return metadata, ErrList(errs)
+	}
+	return metadata, nil
+}
+
 // GetLookupdTopicChannels returns a []string containing a union of all the channels
 // from all the given lookupd for the given topic
 func (c *ClusterInfo) GetLookupdTopicChannels(topic string, lookupdHTTPAddrs []string) ([]string, error) {
@@ -138,7 +196,7 @@ func (c *ClusterInfo) GetLookupdTopicChannels(topic string, lookupdHTTPAddrs []s
 			c.logf("CI: querying nsqlookupd %s", endpoint)
 
 			var resp respType
-			err := c.client.GETV1(endpoint, &resp)
+			err := c.lookupdClient.GETV1(endpoint, &resp)
 			if err != nil {
 				lock.Lock()
 				errs = append(errs, err)
@@ -189,7 +247,7 @@ func (c *ClusterInfo) GetLookupdProducers(lookupdHTTPAddrs []string) (Producers,
 			c.logf("CI: querying nsqlookupd %s", endpoint)
 
 			var resp respType
-			err := c.client.GETV1(endpoint, &resp)
+			err := c.lookupdClient.GETV1(endpoint, &resp)
 			if err != nil {
 				lock.Lock()
 				errs = append(errs, err)
@@ -256,7 +314,7 @@ func (c *ClusterInfo) GetLookupdTopicProducers(topic string, lookupdHTTPAddrs []
 			c.logf("CI: querying nsqlookupd %s", endpoint)
 
 			var resp respType
-			err := c.client.GETV1(endpoint, &resp)
+			err := c.lookupdClient.GETV1(endpoint, &resp)
 			if err != nil {
 				lock.Lock()
 				errs = append(errs, err)
@@ -339,6 +397,57 @@ func (c *ClusterInfo) GetNSQDTopics(nsqdHTTPAddrs []string) ([]string, error) {
 	return topics, nil
 }
 
+// GetNSQDTopicsMetadata returns the metadata of all topics on the given nsqd
+func (c *ClusterInfo) GetNSQDTopicsMetadata(nsqdHTTPAddrs []string) (map[string]Metadata, error) {
+	metadata := make(map[string]Metadata)
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	var errs []error
+
+	type respType struct {
+		Topics []struct {
+			Name string `json:"topic_name"`
+			Metadata
+		} `json:"topics"`
+	}
+
+	for _, addr := range nsqdHTTPAddrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+
+			endpoint := fmt.Sprintf("http://%s/stats?format=json&include_clients=false", addr)
+			c.logf("CI: querying nsqd %s", endpoint)
+
+			var resp respType
+			err := c.client.GETV1(endpoint, &resp)
+			if err != nil {
+				lock.Lock()
+				errs = append(errs, err)
+				lock.Unlock()
+				return
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			for _, topic := range resp.Topics {
+				merged := metadata[topic.Name]
+				merged.Merge(topic.Metadata)
+				metadata[topic.Name] = merged
+			}
+		}(addr)
+	}
+	wg.Wait()
+
+	if len(errs) == len(nsqdHTTPAddrs) {
+		return nil, fmt.Errorf("Failed to query any nsqd: %s", ErrList(errs))
+	}
+	if len(errs) > 0 {
+		return metadata, ErrList(errs)
+	}
+	return metadata, nil
+}
+
 // GetNSQDProducers returns Producers of all the given nsqd
 func (c *ClusterInfo) GetNSQDProducers(nsqdHTTPAddrs []string) (Producers, error) {
 	var producers Producers
@@ -627,6 +736,92 @@ func (c *ClusterInfo) GetNSQDStats(producers Producers,
 	return topicStatsList, channelStatsMap, nil
 }
 
+// PeekMessages returns up to count messages queued on disk for the given
+// topic (or channel, if not empty) on each of the given Producers, without
+// dequeuing them, and the total number of messages queued in memory (which
+// nsqd only counts)
+func (c *ClusterInfo) PeekMessages(producers Producers, topic string, channel string, count int) ([]*PeekedMessage, int64, error) {
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	var msgs []*PeekedMessage
+	var memoryDepth int64
+	var errs []error
+
+	type respType struct {
+		MemoryDepth int64            `json:"memory_depth"`
+		Messages    []*PeekedMessage `json:"messages"`
+	}
+
+	qs := fmt.Sprintf("topic=%s&count=%d", url.QueryEscape(topic), count)
+	uri := "topic/peek"
+	if channel != "" {
+		qs += "&channel=" + url.QueryEscape(channel)
+		uri = "channel/peek"
+	}
+
+	for _, p := range producers {
+		wg.Add(1)
+		go func(p *Producer) {
+			defer wg.Done()
+
+			addr := p.HTTPAddress()
+			endpoint := fmt.Sprintf("http://%s/%s?%s", addr, uri, qs)
+			c.logf("CI: querying nsqd %s", endpoint)
+
+			var resp respType
+			err := c.client.GETV1(endpoint, &resp)
+			if err != nil {
+				lock.Lock()
+				errs = append(errs, err)
+				lock.Unlock()
+				return
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			memoryDepth += resp.MemoryDepth
+			for _, m := range resp.Messages {
+				m.Node = addr
+				msgs = append(msgs, m)
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	if len(errs) == len(producers) {
+		return nil, 0, fmt.Errorf("Failed to query any nsqd: %s", ErrList(errs))
+	}
+
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].Timestamp < msgs[j].Timestamp
+	})
+
+	if len(errs) > 0 {
+		return msgs, memoryDepth, ErrList(errs)
+	}
+	return msgs, memoryDepth, nil
+}
+
+// PublishMessage publishes body to the given topic on the first of the given
+// Producers that accepts it, returning that nsqd's HTTP address
+func (c *ClusterInfo) PublishMessage(producers Producers, topic string, body []byte) (string, error) {
+	var errs []error
+	for _, p := range producers {
+		addr := p.HTTPAddress()
+		endpoint := fmt.Sprintf("http://%s/pub?topic=%s", addr, url.QueryEscape(topic))
+		c.logf("CI: publishing to nsqd %s", endpoint)
+		err := c.client.POSTV1Body(endpoint, body)
+		if err == nil {
+			return addr, nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return "", fmt.Errorf("no nsqd to publish to")
+	}
+	return "", fmt.Errorf("Failed to publish to any nsqd: %s", ErrList(errs))
+}
+
 // TombstoneNodeForTopic tombstones the given node for the given topic on all the given nsqlookupd
 // and deletes the topic from the node
 func (c *ClusterInfo) TombstoneNodeForTopic(topic string, node string, lookupdHTTPAddrs []string) error {
@@ -832,6 +1027,53 @@ func (c *ClusterInfo) EmptyChannel(topicName string, channelName string, lookupd
 	return c.actionHelper(topicName, lookupdHTTPAddrs, nsqdHTTPAddrs, "channel/empty", qs)
 }
 
+// ChannelMessagesAction deletes, moves or requeues the messages of a channel
+// matching the filter in params on all the nsqd that produce the topic
+func (c *ClusterInfo) ChannelMessagesAction(topicName string, channelName string, action string, params url.Values, lookupdHTTPAddrs []string, nsqdHTTPAddrs []string) error {
+	qs := fmt.Sprintf("topic=%s&channel=%s&%s", url.QueryEscape(topicName), url.QueryEscape(channelName), params.Encode())
+	return c.actionHelper(topicName, lookupdHTTPAddrs, nsqdHTTPAddrs, "channel/messages/"+action, qs)
+}
+
+// SetMetadata replaces the description and labels of the given topic (or
+// channel, if not empty) on all the nsqd that produce the topic
+func (c *ClusterInfo) SetMetadata(topicName string, channelName string, m Metadata, lookupdHTTPAddrs []string, nsqdHTTPAddrs []string) error {
+	var errs []error
+
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	producers, err := c.GetTopicProducers(topicName, lookupdHTTPAddrs, nsqdHTTPAddrs)
+	if err != nil {
+		pe, ok := err.(PartialErr)
+		if !ok {
+			return err
+		}
+		errs = append(errs, pe.Errors()...)
+	}
+
+	uri := "topic/metadata"
+	qs := fmt.Sprintf("topic=%s", url.QueryEscape(topicName))
+	if channelName != "" {
+		uri = "channel/metadata"
+		qs += "&channel=" + url.QueryEscape(channelName)
+	}
+	for _, p := range producers {
+		endpoint := fmt.Sprintf("http://%s/%s?%s", p.HTTPAddress(), uri, qs)
+		c.logf("CI: querying nsqd %s", endpoint)
+		err := c.client.POSTV1Body(endpoint, body)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return ErrList(errs)
+	}
+	return nil
+}
+
 func (c *ClusterInfo) actionHelper(topicName string, lookupdHTTPAddrs []string, nsqdHTTPAddrs []string, uri string, qs string) error {
 	var errs []error
 
@@ -878,7 +1120,7 @@ func (c *ClusterInfo) nsqlookupdPOST(addrs []string, uri string, qs string) erro
 	for _, addr := range addrs {
 		endpoint := fmt.Sprintf("http://%s/%s?%s", addr, uri, qs)
 		c.logf("CI: querying nsqlookupd %s", endpoint)
-		err := c.client.POSTV1(endpoint)
+		err := c.lookupdClient.POSTV1(endpoint)
 		if err != nil {
 			errs = append(errs, err)
 		}
@@ -5,6 +5,7 @@ import (
 	"net"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/blang/semver"
@@ -91,6 +92,41 @@ func (p *Producer) IsInconsistent(numLookupd int) bool {
 	return len(p.RemoteAddresses) != numLookupd
 }
 
+// Metadata is the description and labels of a topic or channel
+type Metadata struct {
+	Description string            `json:"description"`
+	Labels      map[string]string `json:"labels"`
+}
+
+// Merge fills in the description (if unset) and labels from another node's
+// view of the same topic or channel
+func (m *Metadata) Merge(a Metadata) {
+	if m.Description == "" {
+		m.Description = a.Description
+	}
+	for k, v := range a.Labels {
+		if m.Labels == nil {
+			m.Labels = make(map[string]string)
+		}
+		if _, ok := m.Labels[k]; !ok {
+			m.Labels[k] = v
+		}
+	}
+}
+
+// HasLabels returns true if all of the given labels match, each either
+// "name" (label is set to any value) or "name:value"
+func (m Metadata) HasLabels(labels []string) bool {
+	for _, l := range labels {
+		parts := strings.SplitN(l, ":", 2)
+		v, ok := m.Labels[parts[0]]
+		if !ok || (len(parts) == 2 && v != parts[1]) {
+			return false
+		}
+	}
+	return true
+}
+
 type TopicStats struct {
 	Node         string          `json:"node"`
 	Hostname     string          `json:"hostname"`
@@ -102,6 +138,7 @@ type TopicStats struct {
 	NodeStats    []*TopicStats   `json:"nodes"`
 	Channels     []*ChannelStats `json:"channels"`
 	Paused       bool            `json:"paused"`
+	Metadata
 
 	E2eProcessingLatency *quantile.E2eProcessingLatencyAggregate `json:"e2e_processing_latency"`
 }
@@ -115,6 +152,7 @@ func (t *TopicStats) Add(a *TopicStats) {
 	if a.Paused {
 		t.Paused = a.Paused
 	}
+	t.Metadata.Merge(a.Metadata)
 	for _, aChannelStats := range a.Channels {
 		found := false
 		for _, channelStats := range t.Channels {
@@ -156,6 +194,7 @@ type ChannelStats struct {
 	NodeStats     []*ChannelStats `json:"nodes"`
 	Clients       []*ClientStats  `json:"clients"`
 	Paused        bool            `json:"paused"`
+	Metadata
 
 	E2eProcessingLatency *quantile.E2eProcessingLatencyAggregate `json:"e2e_processing_latency"`
 }
@@ -174,6 +213,7 @@ func (c *ChannelStats) Add(a *ChannelStats) {
 	if a.Paused {
 		c.Paused = a.Paused
 	}
+	c.Metadata.Merge(a.Metadata)
 	c.NodeStats = append(c.NodeStats, a)
 	sort.Sort(ChannelStatsByHost{c.NodeStats})
 	if c.E2eProcessingLatency == nil {
@@ -236,6 +276,19 @@ func (c *ClientStats) HasSampleRate() bool {
 	return c.SampleRate > 0
 }
 
+// PeekedMessage is a message queued on a node, as returned by nsqd's
+// /topic/peek and /channel/peek
+type PeekedMessage struct {
+	Node      string `json:"node"`
+	ID        string `json:"id"`
+	Timestamp int64  `json:"timestamp"`
+	Attempts  uint16 `json:"attempts"`
+	Size      int    `json:"size"`
+	Body      string `json:"body"`
+	Truncated bool   `json:"truncated"`
+	Source    string `json:"source"`
+}
+
 type ChannelStatsList []*ChannelStats
 
 func (c ChannelStatsList) Len() int      { return len(c) }
@@ -1,9 +1,12 @@
 package http_api
 
 import (
+	"bytes"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -31,7 +34,8 @@ func NewDeadlineTransport(connectTimeout time.Duration, requestTimeout time.Dura
 }
 
 type Client struct {
-	c *http.Client
+	c         *http.Client
+	authToken string
 }
 
 func NewClient(tlsConfig *tls.Config, connectTimeout time.Duration, requestTimeout time.Duration) *Client {
@@ -45,6 +49,12 @@ func NewClient(tlsConfig *tls.Config, connectTimeout time.Duration, requestTimeo
 	}
 }
 
+// SetAuthToken sets a token sent as "Authorization: Bearer <token>" with
+// every request, for servers that restrict access to (some) endpoints
+func (c *Client) SetAuthToken(token string) {
+	c.authToken = token
+}
+
 // GETV1 is a helper function to perform a V1 HTTP request
 // and parse our NSQ daemon's expected response format, with deadlines.
 func (c *Client) GETV1(endpoint string, v interface{}) error {
@@ -55,6 +65,9 @@ retry:
 	}
 
 	req.Header.Add("Accept", "application/vnd.nsq; version=1.0")
+	if c.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.authToken)
+	}
 
 	resp, err := c.c.Do(req)
 	if err != nil {
@@ -68,11 +81,11 @@ retry:
 	}
 	if resp.StatusCode != 200 {
 		if resp.StatusCode == 403 && !strings.HasPrefix(endpoint, "https") {
-			endpoint, err = httpsEndpoint(endpoint, body)
-			if err != nil {
-				return err
+			u, err := httpsEndpoint(endpoint, body)
+			if err == nil {
+				endpoint = u
+				goto retry
 			}
-			goto retry
 		}
 		return fmt.Errorf("got response %s %q", resp.Status, body)
 	}
@@ -87,13 +100,25 @@ retry:
 // PostV1 is a helper function to perform a V1 HTTP request
 // and parse our NSQ daemon's expected response format, with deadlines.
 func (c *Client) POSTV1(endpoint string) error {
+	return c.POSTV1Body(endpoint, nil)
+}
+
+// POSTV1Body is POSTV1 with a request body (ie. a message to /pub)
+func (c *Client) POSTV1Body(endpoint string, data []byte) error {
 retry:
-	req, err := http.NewRequest("POST", endpoint, nil)
+	var r io.Reader
+	if data != nil {
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest("POST", endpoint, r)
 	if err != nil {
 		return err
 	}
 
 	req.Header.Add("Accept", "application/vnd.nsq; version=1.0")
+	if c.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.authToken)
+	}
 
 	resp, err := c.c.Do(req)
 	if err != nil {
@@ -107,11 +132,11 @@ retry:
 	}
 	if resp.StatusCode != 200 {
 		if resp.StatusCode == 403 && !strings.HasPrefix(endpoint, "https") {
-			endpoint, err = httpsEndpoint(endpoint, body)
-			if err != nil {
-				return err
+			u, err := httpsEndpoint(endpoint, body)
+			if err == nil {
+				endpoint = u
+				goto retry
 			}
-			goto retry
 		}
 		return fmt.Errorf("got response %s %q", resp.Status, body)
 	}
@@ -127,6 +152,10 @@ func httpsEndpoint(endpoint string, body []byte) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if forbiddenResp.HTTPSPort == 0 {
+		// forbidden for another reason than TLS being required
+		return "", errors.New("missing https_port")
+	}
 
 	u, err := url.Parse(endpoint)
 	if err != nil {
@@ -0,0 +1,173 @@
+package nsqadmin
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/BurntSushi/toml"
+)
+
+// actions that can be permitted by a policy, unpause is part of pause and
+// deleting, moving and requeueing selected messages is part of empty
+const (
+	ActionView    = "view" // includes peeking at and sampling messages
+	ActionPause   = "pause"
+	ActionEmpty   = "empty"
+	ActionDelete  = "delete" // delete topics and channels, tombstone producers
+	ActionCreate  = "create"
+	ActionPublish = "publish" // publish messages from the console
+	ActionAll     = "*"
+)
+
+// everyone is the user name that matches all users, including requests
+// without --acl-http-header
+const everyone = "*"
+
+// Permission grants actions on the topics (and channels) matching any of
+// the glob patterns, no Channels means all channels of the topics and the
+// topics themselves. With Channels the only action on the topics themselves
+// is view, so that the channels can be found (they get all of the topic's
+// messages anyway).
+type Permission struct {
+	Topics   []string `toml:"topics" json:"topics"`
+	Channels []string `toml:"channels" json:"channels,omitempty"`
+	Actions  []string `toml:"actions" json:"actions"`
+}
+
+func (p *Permission) allows(action string, topic string, channel string) bool {
+	if !matchAny(p.Topics, topic) {
+		return false
+	}
+	if len(p.Channels) > 0 {
+		if channel == "" && action != ActionView {
+			return false
+		}
+		if channel != "" && !matchAny(p.Channels, channel) {
+			return false
+		}
+	}
+	for _, a := range p.Actions {
+		if a == action || a == ActionAll {
+			return true
+		}
+	}
+	return false
+}
+
+// Role is a set of permissions granted to users
+type Role struct {
+	Name        string       `toml:"name" json:"name"`
+	Users       []string     `toml:"users" json:"users"`
+	Permissions []Permission `toml:"permission" json:"permissions"`
+}
+
+func (r *Role) hasUser(user string) bool {
+	for _, u := range r.Users {
+		if u == everyone || (user != "" && u == user) {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy is the role based access control configured by --acl-policy-file
+type Policy struct {
+	Roles []*Role `toml:"role" json:"roles"`
+}
+
+// LoadPolicy reads and validates the [[role]] tables of a TOML file
+func LoadPolicy(fileName string) (*Policy, error) {
+	var p Policy
+	_, err := toml.DecodeFile(fileName, &p)
+	if err != nil {
+		return nil, err
+	}
+	for i, r := range p.Roles {
+		err := r.validate()
+		if err != nil {
+			return nil, fmt.Errorf("role %d (%s) - %s", i+1, r.Name, err)
+		}
+	}
+	return &p, nil
+}
+
+func (r *Role) validate() error {
+	if r.Name == "" {
+		return errors.New("name required")
+	}
+	for _, p := range r.Permissions {
+		if len(p.Topics) == 0 {
+			return errors.New("permission without topics")
+		}
+		for _, patterns := range [][]string{p.Topics, p.Channels} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return fmt.Errorf("invalid pattern %q", pattern)
+				}
+			}
+		}
+		for _, a := range p.Actions {
+			switch a {
+			case ActionView, ActionPause, ActionEmpty, ActionDelete, ActionCreate, ActionPublish, ActionAll:
+			default:
+				return fmt.Errorf("invalid action %q", a)
+			}
+		}
+	}
+	return nil
+}
+
+// Allowed returns true if any role of the user permits the action on the
+// topic (and channel, if not empty)
+func (p *Policy) Allowed(user string, action string, topic string, channel string) bool {
+	for _, r := range p.Roles {
+		if !r.hasUser(user) {
+			continue
+		}
+		for _, perm := range r.Permissions {
+			if perm.allows(action, topic, channel) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Actions returns the actions the user is permitted on the topic (and
+// channel, if not empty)
+func (p *Policy) Actions(user string, topic string, channel string) []string {
+	actions := []string{}
+	for _, a := range []string{ActionView, ActionPause, ActionEmpty, ActionDelete, ActionCreate, ActionPublish} {
+		if p.Allowed(user, a, topic, channel) {
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
+
+// HasAction returns true if the user is permitted the action on any topic
+func (p *Policy) HasAction(user string, action string) bool {
+	for _, r := range p.Roles {
+		if !r.hasUser(user) {
+			continue
+		}
+		for _, perm := range r.Permissions {
+			for _, a := range perm.Actions {
+				if a == action || a == ActionAll {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package nsqadmin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nsqio/nsq/internal/test"
+)
+
+func writePolicy(t *testing.T, dir string, data string) string {
+	fileName := filepath.Join(dir, "policy.toml")
+	err := ioutil.WriteFile(fileName, []byte(data), 0600)
+	test.Nil(t, err)
+	return fileName
+}
+
+func TestLoadPolicy(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "nsqadmin-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	p, err := LoadPolicy(writePolicy(t, tmpDir, `
+[[role]]
+name = "orders-ops"
+users = ["alice", "bob"]
+  [[role.permission]]
+  topics = ["orders*"]
+  actions = ["view", "pause", "empty"]
+  [[role.permission]]
+  topics = ["billing"]
+  channels = ["archive"]
+  actions = ["*"]
+
+[[role]]
+name = "readers"
+users = ["*"]
+  [[role.permission]]
+  topics = ["*"]
+  actions = ["view"]
+`))
+	test.Nil(t, err)
+	test.Equal(t, 2, len(p.Roles))
+
+	test.Equal(t, true, p.Allowed("alice", ActionPause, "orders_us", ""))
+	test.Equal(t, true, p.Allowed("bob", ActionEmpty, "orders_us", "ch"))
+	test.Equal(t, false, p.Allowed("alice", ActionDelete, "orders_us", ""))
+	test.Equal(t, false, p.Allowed("carol", ActionPause, "orders_us", ""))
+	test.Equal(t, true, p.Allowed("carol", ActionView, "orders_us", ""))
+	test.Equal(t, true, p.Allowed("", ActionView, "billing", ""))
+
+	// channels restrict the permission to those channels of the topic
+	test.Equal(t, true, p.Allowed("alice", ActionDelete, "billing", "archive"))
+	test.Equal(t, false, p.Allowed("alice", ActionDelete, "billing", "invoices"))
+	// and don't grant any action on the topic itself but view
+	test.Equal(t, false, p.Allowed("alice", ActionDelete, "billing", ""))
+	test.Equal(t, false, p.Allowed("alice", ActionPause, "billing", ""))
+	test.Equal(t, true, p.Allowed("alice", ActionView, "billing", ""))
+
+	test.Equal(t, []string{"view", "pause", "empty"}, p.Actions("alice", "orders_us", ""))
+	test.Equal(t, []string{"view"}, p.Actions("carol", "orders_us", ""))
+	test.Equal(t, true, p.HasAction("alice", ActionCreate))
+	test.Equal(t, false, p.HasAction("carol", ActionCreate))
+
+	for _, tc := range []struct {
+		policy string
+		err    string
+	}{
+		{"[[role]]\nusers = [\"a\"]", "name required"},
+		{"[[role]]\nname = \"r\"\n[[role.permission]]\nactions = [\"view\"]", "permission without topics"},
+		{"[[role]]\nname = \"r\"\n[[role.permission]]\ntopics = [\"[\"]\nactions = [\"view\"]", "invalid pattern"},
+		{"[[role]]\nname = \"r\"\n[[role.permission]]\ntopics = [\"a\"]\nactions = [\"consume\"]", "invalid action"},
+	} {
+		_, err := LoadPolicy(writePolicy(t, tmpDir, tc.policy))
+		test.NotNil(t, err)
+		test.Equal(t, true, strings.Contains(err.Error(), tc.err))
+	}
+}
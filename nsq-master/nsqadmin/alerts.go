@@ -0,0 +1,510 @@
+package nsqadmin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/nsqio/nsq/internal/clusterinfo"
+	"github.com/nsqio/nsq/internal/http_api"
+)
+
+// alert rule types
+const (
+	AlertChannelDepth    = "channel_depth"    // channel depth above threshold
+	AlertNoConsumers     = "no_consumers"     // channel without any clients
+	AlertE2ELatency      = "e2e_latency"      // end to end processing latency above latency
+	AlertNSQDUnreachable = "nsqd_unreachable" // nsqd not responding to HTTP requests
+)
+
+// alert states
+const (
+	AlertPending  = "pending"  // condition is met, but not yet for long enough
+	AlertFiring   = "firing"   // condition has been met for the duration of the rule
+	AlertResolved = "resolved" // condition no longer met (only sent as a notification)
+	AlertExpired  = "expired"  // the nsqd of a firing nsqd_unreachable alert is no longer probed, it may still be down (only sent as a notification)
+)
+
+// how long an nsqd that disappeared from nsqlookupd is still probed for
+// nsqd_unreachable rules (unless it's in the rule's nodes)
+const forgetNodeAfter = time.Hour
+
+// alertDuration is a time.Duration that is written as a string (eg. "5m")
+// in the rules file
+type alertDuration struct {
+	time.Duration
+}
+
+func (d *alertDuration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d alertDuration) MarshalText() ([]byte, error) {
+	return []byte(d.Duration.String()), nil
+}
+
+// AlertRule is a condition evaluated periodically over the stats of all
+// nsqd. Topic, Channel and Node are glob patterns, empty matches all.
+type AlertRule struct {
+	Name      string        `toml:"name" json:"name"`
+	Type      string        `toml:"type" json:"type"`
+	Topic     string        `toml:"topic" json:"topic,omitempty"`
+	Channel   string        `toml:"channel" json:"channel,omitempty"`
+	Node      string        `toml:"node" json:"node,omitempty"`
+	Nodes     []string      `toml:"nodes" json:"nodes,omitempty"` // nsqd_unreachable: HTTP addresses always probed
+	Threshold int64         `toml:"threshold" json:"threshold,omitempty"`
+	Latency   alertDuration `toml:"latency" json:"latency"`
+	Quantile  float64       `toml:"quantile" json:"quantile,omitempty"`
+	For       alertDuration `toml:"for" json:"for"`
+}
+
+func (r *AlertRule) validate() error {
+	if r.Name == "" {
+		return errors.New("name required")
+	}
+	for _, pattern := range []string{r.Topic, r.Channel, r.Node} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	switch r.Type {
+	case AlertChannelDepth:
+		if r.Threshold <= 0 {
+			return errors.New("threshold required")
+		}
+	case AlertE2ELatency:
+		if r.Latency.Duration <= 0 {
+			return errors.New("latency required")
+		}
+		if r.Quantile == 0 {
+			r.Quantile = 0.99
+		}
+		if r.Quantile < 0 || r.Quantile > 1 {
+			return errors.New("quantile must be between 0 and 1")
+		}
+	case AlertNSQDUnreachable:
+		for _, addr := range r.Nodes {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				return fmt.Errorf("invalid node address %q", addr)
+			}
+		}
+	case AlertNoConsumers:
+	default:
+		return fmt.Errorf("invalid type %q", r.Type)
+	}
+	if len(r.Nodes) != 0 && r.Type != AlertNSQDUnreachable {
+		return errors.New("nodes only applies to nsqd_unreachable")
+	}
+	if r.For.Duration < 0 {
+		return errors.New("for must not be negative")
+	}
+	return nil
+}
+
+func matchPattern(pattern string, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, s)
+	return ok
+}
+
+// LoadAlertRules reads and validates the [[rule]] tables of a TOML file
+func LoadAlertRules(fileName string) ([]*AlertRule, error) {
+	var f struct {
+		Rules []*AlertRule `toml:"rule"`
+	}
+	_, err := toml.DecodeFile(fileName, &f)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for i, r := range f.Rules {
+		err := r.validate()
+		if err != nil {
+			return nil, fmt.Errorf("rule %d (%s) - %s", i+1, r.Name, err)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("rule %d - duplicate name %q", i+1, r.Name)
+		}
+		names[r.Name] = true
+	}
+	return f.Rules, nil
+}
+
+// Alert is a rule whose condition is met for a topic, channel or node. It's
+// also the notification sent to webhooks and the alert topic.
+type Alert struct {
+	Cluster   string  `json:"cluster"`
+	Rule      string  `json:"rule"`
+	Type      string  `json:"type"`
+	State     string  `json:"state"`
+	Topic     string  `json:"topic,omitempty"`
+	Channel   string  `json:"channel,omitempty"`
+	Node      string  `json:"node,omitempty"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	Message   string  `json:"message"`
+	Since     int64   `json:"since"` // when the condition was first met
+	Timestamp int64   `json:"timestamp"`
+	Via       string  `json:"via,omitempty"` // the Hostname of the nsqadmin sending the notification
+}
+
+func (a *Alert) key() string {
+	return fmt.Sprintf("%s/%s/%s/%s", a.Rule, a.Topic, a.Channel, a.Node)
+}
+
+// evaluateRules returns an Alert for every rule whose condition is met,
+// given the stats of all channels and the nsqd that couldn't be reached
+func evaluateRules(rules []*AlertRule, channelStats map[string]*clusterinfo.ChannelStats,
+	unreachable []string) []*Alert {
+	var alerts []*Alert
+	for _, r := range rules {
+		if r.Type == AlertNSQDUnreachable {
+			for _, node := range unreachable {
+				if !matchPattern(r.Node, node) {
+					continue
+				}
+				alerts = append(alerts, &Alert{
+					Rule:    r.Name,
+					Type:    r.Type,
+					Node:    node,
+					Message: fmt.Sprintf("nsqd %s is unreachable", node),
+				})
+			}
+			continue
+		}
+
+		for _, c := range channelStats {
+			if !matchPattern(r.Topic, c.TopicName) || !matchPattern(r.Channel, c.ChannelName) {
+				continue
+			}
+			a := &Alert{
+				Rule:    r.Name,
+				Type:    r.Type,
+				Topic:   c.TopicName,
+				Channel: c.ChannelName,
+			}
+			switch r.Type {
+			case AlertChannelDepth:
+				if c.Depth <= r.Threshold {
+					continue
+				}
+				a.Value = float64(c.Depth)
+				a.Threshold = float64(r.Threshold)
+				a.Message = fmt.Sprintf("channel %s/%s depth %d is above %d",
+					c.TopicName, c.ChannelName, c.Depth, r.Threshold)
+			case AlertNoConsumers:
+				if c.ClientCount > 0 {
+					continue
+				}
+				a.Message = fmt.Sprintf("channel %s/%s has no consumers", c.TopicName, c.ChannelName)
+			case AlertE2ELatency:
+				latency, ok := e2eLatency(c, r.Quantile)
+				if !ok || latency <= float64(r.Latency.Nanoseconds()) {
+					continue
+				}
+				a.Value = latency
+				a.Threshold = float64(r.Latency.Nanoseconds())
+				a.Message = fmt.Sprintf("channel %s/%s p%g end to end latency %s is above %s",
+					c.TopicName, c.ChannelName, r.Quantile*100, time.Duration(latency), r.Latency)
+			}
+			alerts = append(alerts, a)
+		}
+	}
+	return alerts
+}
+
+// e2eLatency returns the highest latency (in ns) of any node at the given
+// quantile, nsqd only reports it when --e2e-processing-latency-percentile
+// includes the quantile
+func e2eLatency(c *clusterinfo.ChannelStats, quantile float64) (float64, bool) {
+	if c.E2eProcessingLatency == nil {
+		return 0, false
+	}
+	for _, p := range c.E2eProcessingLatency.Percentiles {
+		if p["quantile"] == quantile {
+			return p["max"], true
+		}
+	}
+	return 0, false
+}
+
+// alerter periodically evaluates the alert rules against one cluster and
+// notifies when an alert starts or stops firing
+type alerter struct {
+	nsqadmin *NSQAdmin
+	rules    []*AlertRule
+	cluster  *cluster
+	client   *http.Client
+
+	// nsqd that have been seen, by HTTP address, to notice the ones that
+	// have gone away, and those that were forgotten at the last evaluation
+	knownNodes map[string]time.Time
+	forgotten  map[string]bool
+	producers  clusterinfo.Producers
+
+	sync.RWMutex
+	alerts        map[string]*Alert
+	lastEvaluated time.Time
+	lastErr       error
+}
+
+func newAlerter(n *NSQAdmin, rules []*AlertRule, c *cluster) *alerter {
+	opts := n.getOpts()
+	return &alerter{
+		nsqadmin: n,
+		rules:    rules,
+		cluster:  c,
+		client: &http.Client{
+			Transport: http_api.NewDeadlineTransport(opts.HTTPClientConnectTimeout, opts.HTTPClientRequestTimeout),
+		},
+		knownNodes: make(map[string]time.Time),
+		alerts:     make(map[string]*Alert),
+	}
+}
+
+func (a *alerter) loop() {
+	ticker := time.NewTicker(a.nsqadmin.getOpts().AlertInterval)
+	for {
+		select {
+		case <-ticker.C:
+			a.evaluate()
+		case <-a.nsqadmin.exitChan:
+			goto exit
+		}
+	}
+
+exit:
+	ticker.Stop()
+}
+
+func (a *alerter) evaluate() {
+	now := time.Now()
+
+	// when no stats could be fetched at all the channel alerts are kept as
+	// they are, rather than resolved
+	statsOK := true
+	var channelStats map[string]*clusterinfo.ChannelStats
+	producers, err := a.cluster.ci.GetProducers(a.cluster.lookupdHTTPAddrs(), a.cluster.nsqdHTTPAddrs())
+	if err != nil {
+		a.nsqadmin.logf(LOG_WARN, "ALERTS: failed to get producers - %s", err)
+		if _, ok := err.(clusterinfo.PartialErr); !ok {
+			statsOK = false
+		}
+	}
+	if statsOK && len(producers) > 0 {
+		_, channelStats, err = a.cluster.ci.GetNSQDStats(producers, "", "", false)
+		if err != nil {
+			a.nsqadmin.logf(LOG_WARN, "ALERTS: failed to get nsqd stats - %s", err)
+			if _, ok := err.(clusterinfo.PartialErr); !ok {
+				statsOK = false
+			}
+		}
+	}
+	a.producers = producers
+
+	var unreachable []string
+	for _, r := range a.rules {
+		if r.Type == AlertNSQDUnreachable {
+			unreachable = a.unreachableNodes(now, producers)
+			break
+		}
+	}
+
+	current := evaluateRules(a.rules, channelStats, unreachable)
+	for _, c := range current {
+		c.Cluster = a.cluster.name
+	}
+	for _, n := range a.update(now, current, statsOK, err) {
+		a.notify(n)
+	}
+}
+
+// unreachableNodes probes all current and recently seen nsqd, as well as
+// those listed in the rules (which may never have been seen, eg. when down
+// since nsqadmin started)
+func (a *alerter) unreachableNodes(now time.Time, producers clusterinfo.Producers) []string {
+	for _, p := range producers {
+		a.knownNodes[p.HTTPAddress()] = now
+	}
+	for _, addr := range a.cluster.nsqdHTTPAddrs() {
+		a.knownNodes[addr] = now
+	}
+	for _, r := range a.rules {
+		for _, addr := range r.Nodes {
+			a.knownNodes[addr] = now
+		}
+	}
+	a.forgotten = make(map[string]bool)
+	for addr, lastSeen := range a.knownNodes {
+		if now.Sub(lastSeen) > forgetNodeAfter {
+			delete(a.knownNodes, addr)
+			a.forgotten[addr] = true
+		}
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	var unreachable []string
+	for addr := range a.knownNodes {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			_, err := a.cluster.ci.GetVersion(addr)
+			if err != nil {
+				lock.Lock()
+				unreachable = append(unreachable, addr)
+				lock.Unlock()
+			}
+		}(addr)
+	}
+	wg.Wait()
+	sort.Strings(unreachable)
+	return unreachable
+}
+
+// update merges the alerts of an evaluation into the current ones, returning
+// those that started firing or were resolved (or expired, for nodes that
+// were forgotten rather than found reachable). If statsOK is false only the
+// nsqd_unreachable alerts are updated.
+func (a *alerter) update(now time.Time, current []*Alert, statsOK bool, evalErr error) []*Alert {
+	forDurations := make(map[string]time.Duration)
+	for _, r := range a.rules {
+		forDurations[r.Name] = r.For.Duration
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	a.lastEvaluated = now
+	a.lastErr = evalErr
+
+	var notifications []*Alert
+	seen := make(map[string]bool)
+	for _, c := range current {
+		k := c.key()
+		seen[k] = true
+		existing, ok := a.alerts[k]
+		if !ok {
+			c.State = AlertPending
+			c.Since = now.Unix()
+			existing = c
+			a.alerts[k] = c
+		} else {
+			existing.Value = c.Value
+			existing.Message = c.Message
+		}
+		existing.Timestamp = now.Unix()
+		if existing.State == AlertPending && now.Sub(time.Unix(existing.Since, 0)) >= forDurations[c.Rule] {
+			existing.State = AlertFiring
+			n := *existing
+			notifications = append(notifications, &n)
+		}
+	}
+	for k, existing := range a.alerts {
+		if seen[k] || (!statsOK && existing.Type != AlertNSQDUnreachable) {
+			continue
+		}
+		delete(a.alerts, k)
+		if existing.State == AlertFiring {
+			n := *existing
+			n.State = AlertResolved
+			if existing.Type == AlertNSQDUnreachable && a.forgotten[existing.Node] {
+				n.State = AlertExpired
+				n.Message = fmt.Sprintf("nsqd %s is no longer probed (not seen for %s), it may still be down",
+					existing.Node, forgetNodeAfter)
+			}
+			n.Timestamp = now.Unix()
+			notifications = append(notifications, &n)
+		}
+	}
+	return notifications
+}
+
+// Alerts returns the pending and firing alerts, oldest first
+func (a *alerter) Alerts() []*Alert {
+	a.RLock()
+	defer a.RUnlock()
+	alerts := make([]*Alert, 0, len(a.alerts))
+	for _, v := range a.alerts {
+		n := *v
+		alerts = append(alerts, &n)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Since != alerts[j].Since {
+			return alerts[i].Since < alerts[j].Since
+		}
+		return alerts[i].key() < alerts[j].key()
+	})
+	return alerts
+}
+
+// notify POSTs the alert to all webhooks and publishes it to the alert topic
+func (a *alerter) notify(alert *Alert) {
+	opts := a.nsqadmin.getOpts()
+	alert.Via, _ = os.Hostname()
+	a.nsqadmin.logf(LOG_INFO, "ALERTS: %s %s", alert.State, alert.Message)
+
+	content, err := json.Marshal(alert)
+	if err != nil {
+		a.nsqadmin.logf(LOG_ERROR, "failed to serialize alert - %s", err)
+		return
+	}
+
+	for _, endpoint := range opts.AlertWebhookURLs {
+		err := a.post(endpoint, content)
+		if err != nil {
+			a.nsqadmin.logf(LOG_ERROR, "ALERTS: failed to POST alert to %s - %s", endpoint, err)
+		}
+	}
+
+	if opts.AlertTopic != "" {
+		err := a.publish(opts.AlertTopic, content)
+		if err != nil {
+			a.nsqadmin.logf(LOG_ERROR, "ALERTS: failed to publish alert to topic %s - %s", opts.AlertTopic, err)
+		}
+	}
+}
+
+// publish sends the alert to the first nsqd that accepts it
+func (a *alerter) publish(topic string, content []byte) error {
+	if len(a.producers) == 0 {
+		return errors.New("no nsqd available")
+	}
+	var err error
+	for _, p := range a.producers {
+		endpoint := fmt.Sprintf("http://%s/pub?topic=%s", p.HTTPAddress(), url.QueryEscape(topic))
+		err = a.post(endpoint, content)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (a *alerter) post(endpoint string, content []byte) error {
+	resp, err := a.client.Post(endpoint, "application/json", bytes.NewBuffer(content))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("got response %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package nsqadmin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nsqio/nsq/internal/clusterinfo"
+	"github.com/nsqio/nsq/internal/quantile"
+	"github.com/nsqio/nsq/internal/test"
+	"github.com/nsqio/nsq/nsqd"
+)
+
+func TestLoadAlertRules(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	fileName := filepath.Join(tmpDir, "rules.toml")
+	ioutil.WriteFile(fileName, []byte(`
+[[rule]]
+name = "backlog"
+type = "channel_depth"
+topic = "orders*"
+threshold = 100
+for = "5m"
+
+[[rule]]
+name = "slow"
+type = "e2e_latency"
+latency = "250ms"
+`), 0644)
+	rules, err := LoadAlertRules(fileName)
+	test.Nil(t, err)
+	test.Equal(t, 2, len(rules))
+	test.Equal(t, int64(100), rules[0].Threshold)
+	test.Equal(t, 5*time.Minute, rules[0].For.Duration)
+	test.Equal(t, 250*time.Millisecond, rules[1].Latency.Duration)
+	test.Equal(t, 0.99, rules[1].Quantile)
+
+	ioutil.WriteFile(fileName, []byte(`
+[[rule]]
+name = "backlog"
+type = "channel_depth"
+`), 0644)
+	_, err = LoadAlertRules(fileName)
+	test.Equal(t, "rule 1 (backlog) - threshold required", err.Error())
+
+	ioutil.WriteFile(fileName, []byte(`
+[[rule]]
+name = "x"
+type = "unknown"
+`), 0644)
+	_, err = LoadAlertRules(fileName)
+	test.NotNil(t, err)
+
+	ioutil.WriteFile(fileName, []byte(`
+[[rule]]
+name = "down"
+type = "nsqd_unreachable"
+nodes = ["10.0.0.1"]
+`), 0644)
+	_, err = LoadAlertRules(fileName)
+	test.Equal(t, `rule 1 (down) - invalid node address "10.0.0.1"`, err.Error())
+}
+
+func TestEvaluateAlertRules(t *testing.T) {
+	rules := []*AlertRule{
+		{Name: "depth", Type: AlertChannelDepth, Topic: "orders*", Threshold: 100},
+		{Name: "consumers", Type: AlertNoConsumers, Channel: "billing"},
+		{Name: "latency", Type: AlertE2ELatency, Latency: alertDuration{time.Second}, Quantile: 0.99},
+		{Name: "down", Type: AlertNSQDUnreachable, Node: "10.0.0.*"},
+	}
+	channelStats := map[string]*clusterinfo.ChannelStats{
+		"orders:billing": {TopicName: "orders", ChannelName: "billing", Depth: 500, ClientCount: 1,
+			E2eProcessingLatency: &quantile.E2eProcessingLatencyAggregate{
+				Percentiles: []map[string]float64{{"quantile": 0.99, "max": float64(2 * time.Second)}},
+			}},
+		"orders:archive": {TopicName: "orders", ChannelName: "archive", Depth: 50, ClientCount: 1},
+		"users:billing":  {TopicName: "users", ChannelName: "billing", Depth: 500},
+	}
+
+	alerts := evaluateRules(rules, channelStats, []string{"10.0.0.1:4151", "10.1.0.1:4151"})
+	keys := make(map[string]bool)
+	for _, a := range alerts {
+		keys[a.key()] = true
+	}
+	test.Equal(t, map[string]bool{
+		"depth/orders/billing/":    true,
+		"consumers/users/billing/": true,
+		"latency/orders/billing/":  true,
+		"down///10.0.0.1:4151":     true,
+	}, keys)
+}
+
+func TestAlertStates(t *testing.T) {
+	a := &alerter{
+		rules:  []*AlertRule{{Name: "depth", Type: AlertChannelDepth, Threshold: 1, For: alertDuration{time.Minute}}},
+		alerts: make(map[string]*Alert),
+	}
+	now := time.Now()
+	cond := func() []*Alert {
+		return []*Alert{{Rule: "depth", Type: AlertChannelDepth, Topic: "t", Channel: "c"}}
+	}
+
+	test.Equal(t, 0, len(a.update(now, cond(), true, nil)))
+	test.Equal(t, AlertPending, a.Alerts()[0].State)
+	test.Equal(t, 0, len(a.update(now.Add(30*time.Second), cond(), true, nil)))
+
+	notifications := a.update(now.Add(time.Minute), cond(), true, nil)
+	test.Equal(t, 1, len(notifications))
+	test.Equal(t, AlertFiring, notifications[0].State)
+	test.Equal(t, now.Unix(), notifications[0].Since)
+	test.Equal(t, 0, len(a.update(now.Add(2*time.Minute), cond(), true, nil)))
+
+	// the stats couldn't be fetched, the alert is kept
+	test.Equal(t, 0, len(a.update(now.Add(3*time.Minute), nil, false, nil)))
+	test.Equal(t, 1, len(a.Alerts()))
+
+	notifications = a.update(now.Add(4*time.Minute), nil, true, nil)
+	test.Equal(t, 1, len(notifications))
+	test.Equal(t, AlertResolved, notifications[0].State)
+	test.Equal(t, 0, len(a.Alerts()))
+
+	// a pending alert that goes away isn't notified
+	a.update(now, cond(), true, nil)
+	test.Equal(t, 0, len(a.update(now.Add(time.Second), nil, true, nil)))
+}
+
+func TestAlertUnreachableNodes(t *testing.T) {
+	// nothing listens here
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	test.Nil(t, err)
+	downAddr := l.Addr().String()
+	l.Close()
+
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.HTTPAddress = "127.0.0.1:0"
+	opts.NSQLookupdHTTPAddresses = []string{"127.0.0.1:4161"}
+	nsqadmin, err := New(opts)
+	test.Nil(t, err)
+	defer nsqadmin.Exit()
+
+	// down since before nsqadmin started, so only known from the rule
+	rules := []*AlertRule{{Name: "down", Type: AlertNSQDUnreachable, Nodes: []string{downAddr}}}
+	a := newAlerter(nsqadmin, rules, nsqadmin.newClusters()[0])
+	now := time.Now()
+	test.Equal(t, []string{downAddr}, a.unreachableNodes(now, nil))
+
+	notifications := a.update(now, evaluateRules(rules, nil, []string{downAddr}), true, nil)
+	test.Equal(t, 1, len(notifications))
+	test.Equal(t, AlertFiring, notifications[0].State)
+
+	// a node that was forgotten while unreachable expires, it isn't resolved
+	a.knownNodes["127.0.0.1:1"] = now
+	a.alerts["down///127.0.0.1:1"] = &Alert{Rule: "down", Type: AlertNSQDUnreachable,
+		Node: "127.0.0.1:1", State: AlertFiring}
+	later := now.Add(forgetNodeAfter + time.Minute)
+	unreachable := a.unreachableNodes(later, nil)
+	test.Equal(t, []string{downAddr}, unreachable)
+	notifications = a.update(later, evaluateRules(rules, nil, unreachable), true, nil)
+	test.Equal(t, 1, len(notifications))
+	test.Equal(t, AlertExpired, notifications[0].State)
+	test.Equal(t, "127.0.0.1:1", notifications[0].Node)
+	test.Equal(t, 1, len(a.Alerts()))
+}
+
+func TestAlertWebhook(t *testing.T) {
+	lgr := test.NewTestLogger(t)
+
+	var lock sync.Mutex
+	var received []*Alert
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var a Alert
+		json.NewDecoder(req.Body).Decode(&a)
+		lock.Lock()
+		received = append(received, &a)
+		lock.Unlock()
+	}))
+	defer hook.Close()
+
+	nsqdOpts := nsqd.NewOptions()
+	nsqdOpts.Logger = lgr
+	_, nsqdHTTPAddr, nsqd1 := mustStartNSQD(nsqdOpts)
+	defer os.RemoveAll(nsqdOpts.DataPath)
+	defer nsqd1.Exit()
+	topic := nsqd1.GetTopic("alerts_test")
+	topic.GetChannel("ch")
+
+	fileName := filepath.Join(nsqdOpts.DataPath, "rules.toml")
+	ioutil.WriteFile(fileName, []byte(`
+[[rule]]
+name = "unconsumed"
+type = "no_consumers"
+topic = "alerts_test"
+`), 0644)
+
+	opts := NewOptions()
+	opts.HTTPAddress = "127.0.0.1:0"
+	opts.NSQDHTTPAddresses = []string{nsqdHTTPAddr.String()}
+	opts.AlertRulesFile = fileName
+	opts.AlertInterval = 50 * time.Millisecond
+	opts.AlertWebhookURLs = []string{hook.URL}
+	opts.Logger = lgr
+	nsqadmin1, err := New(opts)
+	test.Nil(t, err)
+	go nsqadmin1.Main()
+	defer nsqadmin1.Exit()
+
+	waitFor := func(n int) []*Alert {
+		for i := 0; i < 100; i++ {
+			lock.Lock()
+			if len(received) >= n {
+				r := received
+				lock.Unlock()
+				return r
+			}
+			lock.Unlock()
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("didn't receive %d alerts", n)
+		return nil
+	}
+
+	alerts := waitFor(1)
+	test.Equal(t, AlertFiring, alerts[0].State)
+	test.Equal(t, "unconsumed", alerts[0].Rule)
+	test.Equal(t, "ch", alerts[0].Channel)
+
+	resp, err := http.Get("http://" + nsqadmin1.RealHTTPAddr().String() + "/api/alerts")
+	test.Nil(t, err)
+	var doc struct {
+		Rules  []*AlertRule `json:"rules"`
+		Alerts []*Alert     `json:"alerts"`
+	}
+	json.NewDecoder(resp.Body).Decode(&doc)
+	resp.Body.Close()
+	test.Equal(t, 1, len(doc.Rules))
+	test.Equal(t, 1, len(doc.Alerts))
+	test.Equal(t, AlertFiring, doc.Alerts[0].State)
+
+	topic.DeleteExistingChannel("ch")
+	alerts = waitFor(2)
+	test.Equal(t, AlertResolved, alerts[1].State)
+}
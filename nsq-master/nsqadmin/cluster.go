@@ -0,0 +1,245 @@
+package nsqadmin
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/nsqio/nsq/internal/clusterinfo"
+	"github.com/nsqio/nsq/internal/http_api"
+)
+
+// defaultClusterName is the name of the cluster configured by
+// --lookupd-http-address or --nsqd-http-address
+const defaultClusterName = "default"
+
+// ClusterConfig is a named cluster of --clusters-file
+type ClusterConfig struct {
+	Name                    string   `toml:"name"`
+	NSQLookupdHTTPAddresses []string `toml:"nsqlookupd_http_addresses"`
+	NSQDHTTPAddresses       []string `toml:"nsqd_http_addresses"`
+	NSQLookupdHTTPAuthToken string   `toml:"lookupd_http_auth_token"`
+}
+
+// LoadClusters reads and validates the [[cluster]] tables of a TOML file
+func LoadClusters(fileName string) ([]*ClusterConfig, error) {
+	var f struct {
+		Clusters []*ClusterConfig `toml:"cluster"`
+	}
+	_, err := toml.DecodeFile(fileName, &f)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for i, c := range f.Clusters {
+		err := c.validate()
+		if err != nil {
+			return nil, fmt.Errorf("cluster %d (%s) - %s", i+1, c.Name, err)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("cluster %d (%s) - duplicate name", i+1, c.Name)
+		}
+		names[c.Name] = true
+	}
+	return f.Clusters, nil
+}
+
+func (c *ClusterConfig) validate() error {
+	if c.Name == "" {
+		return errors.New("name required")
+	}
+	if c.Name == defaultClusterName {
+		return fmt.Errorf("name %q is reserved for --lookupd-http-address/--nsqd-http-address", c.Name)
+	}
+	if len(c.NSQLookupdHTTPAddresses) == 0 && len(c.NSQDHTTPAddresses) == 0 {
+		return errors.New("nsqlookupd_http_addresses or nsqd_http_addresses required")
+	}
+	if len(c.NSQLookupdHTTPAddresses) != 0 && len(c.NSQDHTTPAddresses) != 0 {
+		return errors.New("use nsqlookupd_http_addresses or nsqd_http_addresses not both")
+	}
+	for _, addrs := range [][]string{c.NSQLookupdHTTPAddresses, c.NSQDHTTPAddresses} {
+		for _, address := range addrs {
+			_, err := net.ResolveTCPAddr("tcp", address)
+			if err != nil {
+				return fmt.Errorf("failed to resolve %s - %s", address, err)
+			}
+		}
+	}
+	return nil
+}
+
+// cluster routes ClusterInfo queries and actions to one cluster
+type cluster struct {
+	name     string
+	ci       *clusterinfo.ClusterInfo
+	config   *ClusterConfig // nil for the default cluster, which follows the options
+	nsqadmin *NSQAdmin
+	alerter  *alerter // nil without --alert-rules-file
+}
+
+func (c *cluster) lookupdHTTPAddrs() []string {
+	if c.config == nil {
+		return c.nsqadmin.getOpts().NSQLookupdHTTPAddresses
+	}
+	return c.config.NSQLookupdHTTPAddresses
+}
+
+func (c *cluster) nsqdHTTPAddrs() []string {
+	if c.config == nil {
+		return c.nsqadmin.getOpts().NSQDHTTPAddresses
+	}
+	return c.config.NSQDHTTPAddresses
+}
+
+// newClusters returns the default cluster (if configured) followed by those
+// of --clusters-file, each with its own lookupd client for its auth token
+func (n *NSQAdmin) newClusters() []*cluster {
+	opts := n.getOpts()
+	newCI := func(authToken string) *clusterinfo.ClusterInfo {
+		client := http_api.NewClient(n.httpClientTLSConfig, opts.HTTPClientConnectTimeout,
+			opts.HTTPClientRequestTimeout)
+		ci := clusterinfo.New(n.logf, client)
+		if authToken != "" {
+			lookupdClient := http_api.NewClient(n.httpClientTLSConfig, opts.HTTPClientConnectTimeout,
+				opts.HTTPClientRequestTimeout)
+			lookupdClient.SetAuthToken(authToken)
+			ci.SetLookupdClient(lookupdClient)
+		}
+		return ci
+	}
+
+	var clusters []*cluster
+	if len(opts.NSQLookupdHTTPAddresses) != 0 || len(opts.NSQDHTTPAddresses) != 0 {
+		clusters = append(clusters, &cluster{
+			name:     defaultClusterName,
+			ci:       newCI(opts.NSQLookupdHTTPAuthToken),
+			nsqadmin: n,
+		})
+	}
+	for _, cfg := range n.clusterConfigs {
+		clusters = append(clusters, &cluster{
+			name:     cfg.Name,
+			ci:       newCI(cfg.NSQLookupdHTTPAuthToken),
+			config:   cfg,
+			nsqadmin: n,
+		})
+	}
+	return clusters
+}
+
+// cluster returns the cluster a request is for, per the "cluster" query
+// parameter or cookie (set by the UI's cluster switcher), the first one by
+// default. It returns nil for an unknown cluster. With several clusters
+// ServeHTTP requires the query parameter for mutating API requests.
+func (s *httpServer) cluster(req *http.Request) *cluster {
+	name := req.URL.Query().Get("cluster")
+	if name == "" {
+		if cookie, err := req.Cookie("cluster"); err == nil {
+			name = cookie.Value
+			// a stale cookie (of a cluster since removed) doesn't lock the UI out
+			if s.clusterByName(name) == nil {
+				name = ""
+			}
+		}
+	}
+	if name == "" {
+		return s.clusters[0]
+	}
+	return s.clusterByName(name)
+}
+
+func (s *httpServer) clusterByName(name string) *cluster {
+	for _, c := range s.clusters {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// ClusterSummary is a cluster's line of the aggregate overview
+type ClusterSummary struct {
+	Name                    string   `json:"name"`
+	NSQLookupdHTTPAddresses []string `json:"nsqlookupd_http_addresses"`
+	NSQDHTTPAddresses       []string `json:"nsqd_http_addresses"`
+	NodeCount               int      `json:"node_count"`
+	TopicCount              int      `json:"topic_count"`
+	ChannelCount            int      `json:"channel_count"`
+	Depth                   int64    `json:"depth"`
+	MessageCount            int64    `json:"message_count"`
+	ClientCount             int      `json:"client_count"`
+	Message                 string   `json:"message"`
+}
+
+// summarize queries every cluster concurrently, a cluster that can't be
+// queried at all is summarized by its error
+func summarize(clusters []*cluster) []*ClusterSummary {
+	summaries := make([]*ClusterSummary, len(clusters))
+	var wg sync.WaitGroup
+	for i, c := range clusters {
+		wg.Add(1)
+		go func(i int, c *cluster) {
+			defer wg.Done()
+			summaries[i] = c.summary()
+		}(i, c)
+	}
+	wg.Wait()
+	return summaries
+}
+
+func (c *cluster) summary() *ClusterSummary {
+	var messages []string
+	cs := &ClusterSummary{
+		Name:                    c.name,
+		NSQLookupdHTTPAddresses: c.lookupdHTTPAddrs(),
+		NSQDHTTPAddresses:       c.nsqdHTTPAddrs(),
+	}
+
+	producers, err := c.ci.GetProducers(cs.NSQLookupdHTTPAddresses, cs.NSQDHTTPAddresses)
+	if err != nil {
+		if _, ok := err.(clusterinfo.PartialErr); !ok {
+			cs.Message = fmt.Sprintf("ERROR: %s", err)
+			return cs
+		}
+		messages = append(messages, err.Error())
+	}
+	cs.NodeCount = len(producers)
+	if len(producers) == 0 {
+		cs.Message = maybeWarnMsg(messages)
+		return cs
+	}
+
+	topicStats, channelStats, err := c.ci.GetNSQDStats(producers, "", "", false)
+	if err != nil {
+		if _, ok := err.(clusterinfo.PartialErr); !ok {
+			cs.Message = fmt.Sprintf("ERROR: %s", err)
+			return cs
+		}
+		messages = append(messages, err.Error())
+	}
+	topics := make(map[string]bool)
+	for _, ts := range topicStats {
+		topics[ts.TopicName] = true
+		cs.Depth += ts.Depth
+		cs.MessageCount += ts.MessageCount
+	}
+	for _, c := range channelStats {
+		cs.ClientCount += c.ClientCount
+	}
+	cs.TopicCount = len(topics)
+	cs.ChannelCount = len(channelStats)
+	cs.Message = maybeWarnMsg(messages)
+	return cs
+}
+
+// clusterNames returns the names of the clusters, for the UI's switcher
+func clusterNames(clusters []*cluster) []string {
+	names := make([]string, 0, len(clusters))
+	for _, c := range clusters {
+		names = append(names, c.name)
+	}
+	return names
+}
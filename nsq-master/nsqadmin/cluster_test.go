@@ -0,0 +1,93 @@
+package nsqadmin
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nsqio/nsq/internal/test"
+)
+
+func TestLoadClusters(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "nsqadmin-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	write := func(data string) string {
+		fileName := filepath.Join(tmpDir, "clusters.toml")
+		err := ioutil.WriteFile(fileName, []byte(data), 0600)
+		test.Nil(t, err)
+		return fileName
+	}
+
+	clusters, err := LoadClusters(write(`
+[[cluster]]
+name = "us-east"
+nsqlookupd_http_addresses = ["127.0.0.1:4161", "127.0.0.1:4261"]
+lookupd_http_auth_token = "secret"
+
+[[cluster]]
+name = "eu-west"
+nsqd_http_addresses = ["127.0.0.1:4151"]
+`))
+	test.Nil(t, err)
+	test.Equal(t, 2, len(clusters))
+	test.Equal(t, "us-east", clusters[0].Name)
+	test.Equal(t, []string{"127.0.0.1:4161", "127.0.0.1:4261"}, clusters[0].NSQLookupdHTTPAddresses)
+	test.Equal(t, "secret", clusters[0].NSQLookupdHTTPAuthToken)
+	test.Equal(t, []string{"127.0.0.1:4151"}, clusters[1].NSQDHTTPAddresses)
+
+	for _, tc := range []struct {
+		clusters string
+		err      string
+	}{
+		{"[[cluster]]\nnsqd_http_addresses = [\"127.0.0.1:4151\"]", "name required"},
+		{"[[cluster]]\nname = \"default\"\nnsqd_http_addresses = [\"127.0.0.1:4151\"]", "reserved"},
+		{"[[cluster]]\nname = \"a\"", "required"},
+		{"[[cluster]]\nname = \"a\"\nnsqd_http_addresses = [\"127.0.0.1:4151\"]\nnsqlookupd_http_addresses = [\"127.0.0.1:4161\"]", "not both"},
+		{"[[cluster]]\nname = \"a\"\nnsqd_http_addresses = [\"127.0.0.1\"]", "failed to resolve"},
+		{"[[cluster]]\nname = \"a\"\nnsqd_http_addresses = [\"127.0.0.1:4151\"]\n[[cluster]]\nname = \"a\"\nnsqd_http_addresses = [\"127.0.0.1:4152\"]", "duplicate"},
+	} {
+		_, err := LoadClusters(write(tc.clusters))
+		test.NotNil(t, err)
+		test.Equal(t, true, strings.Contains(err.Error(), tc.err))
+	}
+}
+
+func TestLookupdAuthTokenScope(t *testing.T) {
+	var lookupdAuth, nsqdAuth atomic.Value
+	lookupd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lookupdAuth.Store(req.Header.Get("Authorization"))
+		w.Write([]byte(`{"topics":["a"]}`))
+	}))
+	defer lookupd.Close()
+	nsqd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		nsqdAuth.Store(req.Header.Get("Authorization"))
+		w.Write([]byte(`{"topics":[]}`))
+	}))
+	defer nsqd.Close()
+
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.HTTPAddress = "127.0.0.1:0"
+	opts.NSQLookupdHTTPAddresses = []string{lookupd.Listener.Addr().String()}
+	opts.NSQLookupdHTTPAuthToken = "secret"
+	nsqadmin, err := New(opts)
+	test.Nil(t, err)
+	defer nsqadmin.Exit()
+
+	ci := nsqadmin.newClusters()[0].ci
+	_, err = ci.GetLookupdTopics(opts.NSQLookupdHTTPAddresses)
+	test.Nil(t, err)
+	test.Equal(t, "Bearer secret", lookupdAuth.Load())
+
+	// the lookupd token is never sent to nsqd
+	_, err = ci.GetNSQDTopics([]string{nsqd.Listener.Addr().String()})
+	test.Nil(t, err)
+	test.Equal(t, "", nsqdAuth.Load())
+}
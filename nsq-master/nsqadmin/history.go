@@ -0,0 +1,248 @@
+package nsqadmin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nsqio/nsq/internal/clusterinfo"
+	// the maintained fork of boltdb/bolt, as used by nsqlookupd/store.go
+	bolt "go.etcd.io/bbolt"
+)
+
+// historyBucket holds a bucket per cluster, with a bucket per series (a
+// topic or channel) in which samples are keyed by their big endian unix
+// timestamp
+var historyBucket = []byte("history")
+
+func topicSeries(topic string) []byte {
+	return []byte("topic:" + topic)
+}
+
+func channelSeries(topic string, channel string) []byte {
+	return []byte("channel:" + topic + ":" + channel)
+}
+
+// HistorySample is the state of a topic or channel (aggregated over all
+// nsqd) at a point in time
+type HistorySample struct {
+	Timestamp     int64   `json:"timestamp"`
+	Depth         int64   `json:"depth"`
+	InFlightCount int64   `json:"in_flight_count,omitempty"`
+	MessageCount  int64   `json:"message_count"`
+	MessageRate   float64 `json:"message_rate"` // per second, since the previous sample
+	ClientCount   int64   `json:"client_count"`
+}
+
+// statsHistory periodically samples the stats of all topics and channels of
+// every cluster into a bolt file, keeping --stats-history-retention worth of
+// samples
+type statsHistory struct {
+	nsqadmin *NSQAdmin
+	db       *bolt.DB
+}
+
+func newStatsHistory(n *NSQAdmin, fileName string) (*statsHistory, error) {
+	db, err := bolt.Open(fileName, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(historyBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &statsHistory{
+		nsqadmin: n,
+		db:       db,
+	}, nil
+}
+
+func (h *statsHistory) loop(c *cluster) {
+	ticker := time.NewTicker(h.nsqadmin.getOpts().StatsHistoryInterval)
+	for {
+		select {
+		case <-ticker.C:
+			err := h.sample(c, time.Now())
+			if err != nil {
+				h.nsqadmin.logf(LOG_ERROR, "HISTORY: failed to sample stats - %s", err)
+			}
+		case <-h.nsqadmin.exitChan:
+			goto exit
+		}
+	}
+
+exit:
+	ticker.Stop()
+}
+
+func (h *statsHistory) sample(c *cluster, now time.Time) error {
+	producers, err := c.ci.GetProducers(c.lookupdHTTPAddrs(), c.nsqdHTTPAddrs())
+	if err != nil {
+		if _, ok := err.(clusterinfo.PartialErr); !ok {
+			return err
+		}
+		h.nsqadmin.logf(LOG_WARN, "HISTORY: %s", err)
+	}
+	if len(producers) == 0 {
+		return h.prune(c.name, now)
+	}
+	topicStats, channelStats, err := c.ci.GetNSQDStats(producers, "", "", false)
+	if err != nil {
+		if _, ok := err.(clusterinfo.PartialErr); !ok {
+			return err
+		}
+		h.nsqadmin.logf(LOG_WARN, "HISTORY: %s", err)
+	}
+	err = h.record(c.name, now, topicStats, channelStats)
+	if err != nil {
+		return err
+	}
+	return h.prune(c.name, now)
+}
+
+// record stores a sample of every topic and channel of a cluster,
+// topicStats has an entry per topic per nsqd and channelStats is aggregated
+// already
+func (h *statsHistory) record(cluster string, now time.Time, topicStats []*clusterinfo.TopicStats,
+	channelStats map[string]*clusterinfo.ChannelStats) error {
+	samples := make(map[string]*HistorySample)
+	for _, t := range topicStats {
+		k := string(topicSeries(t.TopicName))
+		s, ok := samples[k]
+		if !ok {
+			s = &HistorySample{Timestamp: now.Unix()}
+			samples[k] = s
+		}
+		s.Depth += t.Depth
+		s.MessageCount += t.MessageCount
+		for _, c := range t.Channels {
+			s.ClientCount += int64(c.ClientCount)
+		}
+	}
+	for _, c := range channelStats {
+		samples[string(channelSeries(c.TopicName, c.ChannelName))] = &HistorySample{
+			Timestamp:     now.Unix(),
+			Depth:         c.Depth,
+			InFlightCount: c.InFlightCount,
+			MessageCount:  c.MessageCount,
+			ClientCount:   int64(c.ClientCount),
+		}
+	}
+
+	return h.db.Update(func(tx *bolt.Tx) error {
+		root, err := tx.Bucket(historyBucket).CreateBucketIfNotExists([]byte(cluster))
+		if err != nil {
+			return err
+		}
+		for k, s := range samples {
+			b, err := root.CreateBucketIfNotExists([]byte(k))
+			if err != nil {
+				return err
+			}
+			data, err := json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			err = b.Put(timestampKey(s.Timestamp), data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// prune removes the samples of a cluster older than the retention, and the
+// series without any samples left (ie. of deleted topics and channels)
+func (h *statsHistory) prune(cluster string, now time.Time) error {
+	cutoff := timestampKey(now.Add(-h.nsqadmin.getOpts().StatsHistoryRetention).Unix())
+	return h.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(historyBucket).Bucket([]byte(cluster))
+		if root == nil {
+			return nil
+		}
+		var empty [][]byte
+		err := root.ForEach(func(name []byte, _ []byte) error {
+			b := root.Bucket(name)
+			c := b.Cursor()
+			for k, _ := c.First(); k != nil && bytes.Compare(k, cutoff) < 0; k, _ = c.First() {
+				err := c.Delete()
+				if err != nil {
+					return err
+				}
+			}
+			if k, _ := c.First(); k == nil {
+				empty = append(empty, append([]byte{}, name...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range empty {
+			err := root.DeleteBucket(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Series returns the samples of a series of a cluster since the given time,
+// oldest first
+func (h *statsHistory) Series(cluster string, series []byte, since time.Time) ([]*HistorySample, error) {
+	samples := []*HistorySample{}
+	err := h.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(historyBucket).Bucket([]byte(cluster))
+		if root == nil {
+			return nil
+		}
+		b := root.Bucket(series)
+		if b == nil {
+			return nil
+		}
+		var prev *HistorySample
+		start := timestampKey(since.Unix())
+		c := b.Cursor()
+		// the sample before the range is needed for the first rate
+		if k, _ := c.Seek(start); k != nil {
+			if pk, pv := c.Prev(); pk != nil {
+				prev = &HistorySample{}
+				if err := json.Unmarshal(pv, prev); err != nil {
+					return fmt.Errorf("invalid sample %x - %s", pk, err)
+				}
+			}
+		}
+		for k, v := c.Seek(start); k != nil; k, v = c.Next() {
+			s := &HistorySample{}
+			if err := json.Unmarshal(v, s); err != nil {
+				return fmt.Errorf("invalid sample %x - %s", k, err)
+			}
+			// counters reset when nsqd restarts
+			if prev != nil && s.Timestamp > prev.Timestamp && s.MessageCount >= prev.MessageCount {
+				s.MessageRate = float64(s.MessageCount-prev.MessageCount) / float64(s.Timestamp-prev.Timestamp)
+			}
+			samples = append(samples, s)
+			prev = s
+		}
+		return nil
+	})
+	return samples, err
+}
+
+func (h *statsHistory) Close() error {
+	return h.db.Close()
+}
+
+func timestampKey(ts int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(ts))
+	return b
+}
@@ -0,0 +1,125 @@
+package nsqadmin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nsqio/nsq/internal/clusterinfo"
+	"github.com/nsqio/nsq/internal/test"
+	"github.com/nsqio/nsq/nsqd"
+)
+
+func TestStatsHistory(t *testing.T) {
+	opts := NewOptions()
+	opts.StatsHistoryRetention = time.Hour
+	n := &NSQAdmin{}
+	n.swapOpts(opts)
+
+	tmpDir, err := ioutil.TempDir("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+	h, err := newStatsHistory(n, filepath.Join(tmpDir, "history.db"))
+	test.Nil(t, err)
+	defer h.Close()
+
+	now := time.Now()
+	for i, count := range []int64{100, 700, 50} {
+		ts := now.Add(time.Duration(i-2) * time.Minute)
+		err := h.record("a", ts, []*clusterinfo.TopicStats{
+			{TopicName: "t", Depth: 1, MessageCount: count},
+			{TopicName: "t", Depth: 2, MessageCount: count, Channels: []*clusterinfo.ChannelStats{{ClientCount: 3}}},
+		}, map[string]*clusterinfo.ChannelStats{
+			"t:c": {TopicName: "t", ChannelName: "c", Depth: 5, ClientCount: 3},
+		})
+		test.Nil(t, err)
+	}
+
+	samples, err := h.Series("a", topicSeries("t"), now.Add(-time.Hour))
+	test.Nil(t, err)
+	test.Equal(t, 3, len(samples))
+	test.Equal(t, int64(3), samples[0].Depth)
+	test.Equal(t, int64(3), samples[0].ClientCount)
+	test.Equal(t, 0.0, samples[0].MessageRate)
+	test.Equal(t, 20.0, samples[1].MessageRate)
+	// the counters were reset
+	test.Equal(t, 0.0, samples[2].MessageRate)
+
+	// the sample before the range is used for the rate
+	samples, err = h.Series("a", topicSeries("t"), now.Add(-90*time.Second))
+	test.Nil(t, err)
+	test.Equal(t, 2, len(samples))
+	test.Equal(t, 20.0, samples[0].MessageRate)
+
+	samples, err = h.Series("a", channelSeries("t", "c"), now.Add(-time.Hour))
+	test.Nil(t, err)
+	test.Equal(t, 3, len(samples))
+	test.Equal(t, int64(5), samples[2].Depth)
+
+	// each cluster has its own series
+	samples, err = h.Series("b", topicSeries("t"), now.Add(-time.Hour))
+	test.Nil(t, err)
+	test.Equal(t, 0, len(samples))
+	test.Nil(t, h.prune("b", now.Add(2*time.Hour)))
+	samples, err = h.Series("a", topicSeries("t"), now.Add(-time.Hour))
+	test.Nil(t, err)
+	test.Equal(t, 3, len(samples))
+
+	test.Nil(t, h.prune("a", now.Add(time.Hour-30*time.Second)))
+	samples, err = h.Series("a", topicSeries("t"), now.Add(-time.Hour))
+	test.Nil(t, err)
+	test.Equal(t, 1, len(samples))
+
+	test.Nil(t, h.prune("a", now.Add(2*time.Hour)))
+	samples, err = h.Series("a", channelSeries("t", "c"), now.Add(-time.Hour))
+	test.Nil(t, err)
+	test.Equal(t, 0, len(samples))
+}
+
+func TestHistoryAPI(t *testing.T) {
+	lgr := test.NewTestLogger(t)
+
+	nsqdOpts := nsqd.NewOptions()
+	nsqdOpts.Logger = lgr
+	_, nsqdHTTPAddr, nsqd1 := mustStartNSQD(nsqdOpts)
+	defer os.RemoveAll(nsqdOpts.DataPath)
+	defer nsqd1.Exit()
+	topic := nsqd1.GetTopic("history_test")
+	topic.GetChannel("ch")
+
+	opts := NewOptions()
+	opts.HTTPAddress = "127.0.0.1:0"
+	opts.NSQDHTTPAddresses = []string{nsqdHTTPAddr.String()}
+	opts.StatsHistoryFile = filepath.Join(nsqdOpts.DataPath, "history.db")
+	opts.Logger = lgr
+	nsqadmin1, err := New(opts)
+	test.Nil(t, err)
+	go nsqadmin1.Main()
+	defer nsqadmin1.Exit()
+
+	c := nsqadmin1.newClusters()[0]
+	now := time.Now()
+	test.Nil(t, nsqadmin1.history.sample(c, now.Add(-time.Minute)))
+	test.Nil(t, nsqadmin1.history.sample(c, now))
+
+	var doc struct {
+		Channel string           `json:"channel"`
+		Samples []*HistorySample `json:"samples"`
+	}
+	resp, err := http.Get("http://" + nsqadmin1.RealHTTPAddr().String() + "/api/history/history_test/ch?period=10m")
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	json.NewDecoder(resp.Body).Decode(&doc)
+	resp.Body.Close()
+	test.Equal(t, "ch", doc.Channel)
+	test.Equal(t, 2, len(doc.Samples))
+
+	resp, err = http.Get("http://" + nsqadmin1.RealHTTPAddr().String() + "/api/history/history_test?period=x")
+	test.Nil(t, err)
+	resp.Body.Close()
+	test.Equal(t, 400, resp.StatusCode)
+}
@@ -13,6 +13,7 @@ import (
 	"net/url"
 	"path"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
@@ -31,6 +32,22 @@ func maybeWarnMsg(msgs []string) string {
 	return ""
 }
 
+// streamV1 responds to errors like http_api.V1, successful responses are
+// streamed by the handler itself
+func streamV1(f http_api.APIHandler) http_api.APIHandler {
+	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+		_, err := f(w, req, ps)
+		if err != nil {
+			code := 500
+			if apiErr, ok := err.(http_api.Err); ok {
+				code = apiErr.Code
+			}
+			http_api.RespondV1(w, code, err)
+		}
+		return nil, nil
+	}
+}
+
 // this is similar to httputil.NewSingleHostReverseProxy except it passes along basic auth
 func NewSingleHostReverseProxy(target *url.URL, connectTimeout time.Duration, requestTimeout time.Duration) *httputil.ReverseProxy {
 	director := func(req *http.Request) {
@@ -51,7 +68,7 @@ type httpServer struct {
 	ctx      *Context
 	router   http.Handler
 	client   *http_api.Client
-	ci       *clusterinfo.ClusterInfo
+	clusters []*cluster
 	basePath string
 }
 
@@ -70,7 +87,7 @@ func NewHTTPServer(ctx *Context) *httpServer {
 		ctx:      ctx,
 		router:   router,
 		client:   client,
-		ci:       clusterinfo.New(ctx.nsqadmin.logf, client),
+		clusters: ctx.nsqadmin.newClusters(),
 		basePath: ctx.nsqadmin.getOpts().BasePath,
 	}
 
@@ -88,6 +105,8 @@ func NewHTTPServer(ctx *Context) *httpServer {
 	router.Handle("GET", bp("/nodes/:node"), http_api.Decorate(s.indexHandler, log))
 	router.Handle("GET", bp("/counter"), http_api.Decorate(s.indexHandler, log))
 	router.Handle("GET", bp("/lookup"), http_api.Decorate(s.indexHandler, log))
+	router.Handle("GET", bp("/alerts"), http_api.Decorate(s.indexHandler, log))
+	router.Handle("GET", bp("/clusters"), http_api.Decorate(s.indexHandler, log))
 
 	router.Handle("GET", bp("/static/:asset"), http_api.Decorate(s.staticAssetHandler, log, http_api.PlainText))
 	router.Handle("GET", bp("/fonts/:asset"), http_api.Decorate(s.staticAssetHandler, log, http_api.PlainText))
@@ -101,6 +120,10 @@ func NewHTTPServer(ctx *Context) *httpServer {
 	router.Handle("GET", bp("/api/topics"), http_api.Decorate(s.topicsHandler, log, http_api.V1))
 	router.Handle("GET", bp("/api/topics/:topic"), http_api.Decorate(s.topicHandler, log, http_api.V1))
 	router.Handle("GET", bp("/api/topics/:topic/:channel"), http_api.Decorate(s.channelHandler, log, http_api.V1))
+	router.Handle("GET", bp("/api/peek/:topic"), http_api.Decorate(s.peekHandler, log, http_api.V1))
+	router.Handle("GET", bp("/api/peek/:topic/:channel"), http_api.Decorate(s.peekHandler, log, http_api.V1))
+	router.Handle("POST", bp("/api/publish/:topic"), http_api.Decorate(s.publishHandler, log, http_api.V1))
+	router.Handle("GET", bp("/api/sample/:topic"), http_api.Decorate(s.sampleHandler, log, streamV1))
 	router.Handle("GET", bp("/api/nodes"), http_api.Decorate(s.nodesHandler, log, http_api.V1))
 	router.Handle("GET", bp("/api/nodes/:node"), http_api.Decorate(s.nodeHandler, log, http_api.V1))
 	router.Handle("POST", bp("/api/topics"), http_api.Decorate(s.createTopicChannelHandler, log, http_api.V1))
@@ -109,7 +132,12 @@ func NewHTTPServer(ctx *Context) *httpServer {
 	router.Handle("DELETE", bp("/api/nodes/:node"), http_api.Decorate(s.tombstoneNodeForTopicHandler, log, http_api.V1))
 	router.Handle("DELETE", bp("/api/topics/:topic"), http_api.Decorate(s.deleteTopicHandler, log, http_api.V1))
 	router.Handle("DELETE", bp("/api/topics/:topic/:channel"), http_api.Decorate(s.deleteChannelHandler, log, http_api.V1))
+	router.Handle("POST", bp("/api/sync"), http_api.Decorate(s.syncHandler, log, http_api.V1))
 	router.Handle("GET", bp("/api/counter"), http_api.Decorate(s.counterHandler, log, http_api.V1))
+	router.Handle("GET", bp("/api/history/:topic"), http_api.Decorate(s.historyHandler, log, http_api.V1))
+	router.Handle("GET", bp("/api/history/:topic/:channel"), http_api.Decorate(s.historyHandler, log, http_api.V1))
+	router.Handle("GET", bp("/api/alerts"), http_api.Decorate(s.alertsHandler, log, http_api.V1))
+	router.Handle("GET", bp("/api/clusters"), http_api.Decorate(s.clustersHandler, log, http_api.V1))
 	router.Handle("GET", bp("/api/graphite"), http_api.Decorate(s.graphiteHandler, log, http_api.V1))
 	router.Handle("GET", bp("/config/:opt"), http_api.Decorate(s.doConfig, log, http_api.V1))
 	router.Handle("PUT", bp("/config/:opt"), http_api.Decorate(s.doConfig, log, http_api.V1))
@@ -118,6 +146,14 @@ func NewHTTPServer(ctx *Context) *httpServer {
 }
 
 func (s *httpServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// with several clusters an action must name the one it's for, rather
+	// than fall back to the cookie of whichever tab switched cluster last
+	if len(s.clusters) > 1 && req.Method != "GET" && req.Method != "HEAD" &&
+		strings.HasPrefix(req.URL.Path, path.Join(s.basePath, "/api")+"/") &&
+		req.URL.Query().Get("cluster") == "" {
+		http_api.RespondV1(w, 400, http_api.Err{400, "MISSING_ARG_CLUSTER"})
+		return
+	}
 	s.router.ServeHTTP(w, req)
 }
 
@@ -133,6 +169,12 @@ func (s *httpServer) indexHandler(w http.ResponseWriter, req *http.Request, ps h
 		},
 	}).Parse(string(asset))
 
+	// the page of an unknown ?cluster= shows the first cluster
+	c := s.cluster(req)
+	if c == nil {
+		c = s.clusters[0]
+	}
+
 	w.Header().Set("Content-Type", "text/html")
 	t.Execute(w, struct {
 		Version             string
@@ -144,7 +186,10 @@ func (s *httpServer) indexHandler(w http.ResponseWriter, req *http.Request, ps h
 		StatsdGaugeFormat   string
 		StatsdPrefix        string
 		NSQLookupd          []string
+		Cluster             string
+		Clusters            []string
 		IsAdmin             bool
+		HistoryEnabled      bool
 	}{
 		Version:             version.Binary,
 		ProxyGraphite:       s.ctx.nsqadmin.getOpts().ProxyGraphite,
@@ -154,8 +199,11 @@ func (s *httpServer) indexHandler(w http.ResponseWriter, req *http.Request, ps h
 		StatsdCounterFormat: s.ctx.nsqadmin.getOpts().StatsdCounterFormat,
 		StatsdGaugeFormat:   s.ctx.nsqadmin.getOpts().StatsdGaugeFormat,
 		StatsdPrefix:        s.ctx.nsqadmin.getOpts().StatsdPrefix,
-		NSQLookupd:          s.ctx.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
-		IsAdmin:             s.isAuthorizedAdminRequest(req),
+		NSQLookupd:          c.lookupdHTTPAddrs(),
+		Cluster:             c.name,
+		Clusters:            clusterNames(s.clusters),
+		IsAdmin:             s.canCreate(req),
+		HistoryEnabled:      s.ctx.nsqadmin.history != nil,
 	})
 
 	return nil, nil
@@ -195,6 +243,10 @@ func (s *httpServer) staticAssetHandler(w http.ResponseWriter, req *http.Request
 }
 
 func (s *httpServer) topicsHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	c := s.cluster(req)
+	if c == nil {
+		return nil, http_api.Err{400, "INVALID_CLUSTER"}
+	}
 	var messages []string
 
 	reqParams, err := http_api.NewReqParams(req)
@@ -203,10 +255,10 @@ func (s *httpServer) topicsHandler(w http.ResponseWriter, req *http.Request, ps
 	}
 
 	var topics []string
-	if len(s.ctx.nsqadmin.getOpts().NSQLookupdHTTPAddresses) != 0 {
-		topics, err = s.ci.GetLookupdTopics(s.ctx.nsqadmin.getOpts().NSQLookupdHTTPAddresses)
+	if len(c.lookupdHTTPAddrs()) != 0 {
+		topics, err = c.ci.GetLookupdTopics(c.lookupdHTTPAddrs())
 	} else {
-		topics, err = s.ci.GetNSQDTopics(s.ctx.nsqadmin.getOpts().NSQDHTTPAddresses)
+		topics, err = c.ci.GetNSQDTopics(c.nsqdHTTPAddrs())
 	}
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
@@ -221,15 +273,18 @@ func (s *httpServer) topicsHandler(w http.ResponseWriter, req *http.Request, ps
 	inactive, _ := reqParams.Get("inactive")
 	if inactive == "true" {
 		topicChannelMap := make(map[string][]string)
-		if len(s.ctx.nsqadmin.getOpts().NSQLookupdHTTPAddresses) == 0 {
+		if len(c.lookupdHTTPAddrs()) == 0 {
 			goto respond
 		}
 		for _, topicName := range topics {
-			producers, _ := s.ci.GetLookupdTopicProducers(
-				topicName, s.ctx.nsqadmin.getOpts().NSQLookupdHTTPAddresses)
+			if !s.isAllowed(req, ActionView, topicName, "") {
+				continue
+			}
+			producers, _ := c.ci.GetLookupdTopicProducers(
+				topicName, c.lookupdHTTPAddrs())
 			if len(producers) == 0 {
-				topicChannels, _ := s.ci.GetLookupdTopicChannels(
-					topicName, s.ctx.nsqadmin.getOpts().NSQLookupdHTTPAddresses)
+				topicChannels, _ := c.ci.GetLookupdTopicChannels(
+					topicName, c.lookupdHTTPAddrs())
 				topicChannelMap[topicName] = topicChannels
 			}
 		}
@@ -240,20 +295,55 @@ func (s *httpServer) topicsHandler(w http.ResponseWriter, req *http.Request, ps
 		}{topicChannelMap, maybeWarnMsg(messages)}, nil
 	}
 
+	// metadata is only fetched to filter by label or when asked for (eg. by
+	// the UI to show descriptions), failing to get it isn't fatal
+	labels := reqParams.Values["label"]
+	withMetadata, _ := reqParams.Get("metadata")
+	var metadata map[string]clusterinfo.Metadata
+	if len(labels) != 0 || withMetadata == "true" {
+		if len(c.lookupdHTTPAddrs()) != 0 {
+			metadata, err = c.ci.GetLookupdTopicsMetadata(c.lookupdHTTPAddrs())
+		} else {
+			metadata, err = c.ci.GetNSQDTopicsMetadata(c.nsqdHTTPAddrs())
+		}
+		if err != nil {
+			s.ctx.nsqadmin.logf(LOG_WARN, "failed to get topics metadata - %s", err)
+			messages = append(messages, fmt.Sprintf("failed to get topics metadata - %s", err))
+		}
+	}
+
+	filtered := []string{}
+	for _, topicName := range topics {
+		if !metadata[topicName].HasLabels(labels) || !s.isAllowed(req, ActionView, topicName, "") {
+			continue
+		}
+		filtered = append(filtered, topicName)
+	}
+	topics = filtered
+
 	return struct {
-		Topics  []string `json:"topics"`
-		Message string   `json:"message"`
-	}{topics, maybeWarnMsg(messages)}, nil
+		Topics   []string                        `json:"topics"`
+		Metadata map[string]clusterinfo.Metadata `json:"metadata"`
+		Message  string                          `json:"message"`
+	}{topics, metadata, maybeWarnMsg(messages)}, nil
 }
 
 func (s *httpServer) topicHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	c := s.cluster(req)
+	if c == nil {
+		return nil, http_api.Err{400, "INVALID_CLUSTER"}
+	}
 	var messages []string
 
 	topicName := ps.ByName("topic")
 
-	producers, err := s.ci.GetTopicProducers(topicName,
-		s.ctx.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
-		s.ctx.nsqadmin.getOpts().NSQDHTTPAddresses)
+	if !s.isAllowed(req, ActionView, topicName, "") {
+		return nil, http_api.Err{403, "FORBIDDEN"}
+	}
+
+	producers, err := c.ci.GetTopicProducers(topicName,
+		c.lookupdHTTPAddrs(),
+		c.nsqdHTTPAddrs())
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -263,7 +353,7 @@ func (s *httpServer) topicHandler(w http.ResponseWriter, req *http.Request, ps h
 		s.ctx.nsqadmin.logf(LOG_WARN, "%s", err)
 		messages = append(messages, pe.Error())
 	}
-	topicStats, _, err := s.ci.GetNSQDStats(producers, topicName, "", false)
+	topicStats, _, err := c.ci.GetNSQDStats(producers, topicName, "", false)
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -281,19 +371,28 @@ func (s *httpServer) topicHandler(w http.ResponseWriter, req *http.Request, ps h
 
 	return struct {
 		*clusterinfo.TopicStats
-		Message string `json:"message"`
-	}{allNodesTopicStats, maybeWarnMsg(messages)}, nil
+		Permissions []string `json:"permissions"`
+		Message     string   `json:"message"`
+	}{allNodesTopicStats, s.permittedActions(req, topicName, ""), maybeWarnMsg(messages)}, nil
 }
 
 func (s *httpServer) channelHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	c := s.cluster(req)
+	if c == nil {
+		return nil, http_api.Err{400, "INVALID_CLUSTER"}
+	}
 	var messages []string
 
 	topicName := ps.ByName("topic")
 	channelName := ps.ByName("channel")
 
-	producers, err := s.ci.GetTopicProducers(topicName,
-		s.ctx.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
-		s.ctx.nsqadmin.getOpts().NSQDHTTPAddresses)
+	if !s.isAllowed(req, ActionView, topicName, channelName) {
+		return nil, http_api.Err{403, "FORBIDDEN"}
+	}
+
+	producers, err := c.ci.GetTopicProducers(topicName,
+		c.lookupdHTTPAddrs(),
+		c.nsqdHTTPAddrs())
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -303,7 +402,7 @@ func (s *httpServer) channelHandler(w http.ResponseWriter, req *http.Request, ps
 		s.ctx.nsqadmin.logf(LOG_WARN, "%s", err)
 		messages = append(messages, pe.Error())
 	}
-	_, channelStats, err := s.ci.GetNSQDStats(producers, topicName, channelName, true)
+	_, channelStats, err := c.ci.GetNSQDStats(producers, topicName, channelName, true)
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -316,14 +415,269 @@ func (s *httpServer) channelHandler(w http.ResponseWriter, req *http.Request, ps
 
 	return struct {
 		*clusterinfo.ChannelStats
+		Permissions []string `json:"permissions"`
+		Message     string   `json:"message"`
+	}{channelStats[channelName], s.permittedActions(req, topicName, channelName), maybeWarnMsg(messages)}, nil
+}
+
+func (s *httpServer) peekHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	c := s.cluster(req)
+	if c == nil {
+		return nil, http_api.Err{400, "INVALID_CLUSTER"}
+	}
+	var messages []string
+
+	topicName := ps.ByName("topic")
+	channelName := ps.ByName("channel")
+
+	if !s.isAllowed(req, ActionView, topicName, channelName) {
+		return nil, http_api.Err{403, "FORBIDDEN"}
+	}
+
+	reqParams, err := http_api.NewReqParams(req)
+	if err != nil {
+		return nil, http_api.Err{400, err.Error()}
+	}
+
+	count := 10
+	if v, err := reqParams.Get("count"); err == nil {
+		count, err = strconv.Atoi(v)
+		if err != nil || count <= 0 {
+			return nil, http_api.Err{400, "INVALID_COUNT"}
+		}
+	}
+
+	producers, err := c.ci.GetTopicProducers(topicName,
+		c.lookupdHTTPAddrs(),
+		c.nsqdHTTPAddrs())
+	if err != nil {
+		pe, ok := err.(clusterinfo.PartialErr)
+		if !ok {
+			s.ctx.nsqadmin.logf(LOG_ERROR, "failed to get topic producers - %s", err)
+			return nil, http_api.Err{502, fmt.Sprintf("UPSTREAM_ERROR: %s", err)}
+		}
+		s.ctx.nsqadmin.logf(LOG_WARN, "%s", err)
+		messages = append(messages, pe.Error())
+	}
+
+	peeked, memoryDepth, err := c.ci.PeekMessages(producers, topicName, channelName, count)
+	if err != nil {
+		pe, ok := err.(clusterinfo.PartialErr)
+		if !ok {
+			s.ctx.nsqadmin.logf(LOG_ERROR, "failed to peek messages - %s", err)
+			return nil, http_api.Err{502, fmt.Sprintf("UPSTREAM_ERROR: %s", err)}
+		}
+		s.ctx.nsqadmin.logf(LOG_WARN, "%s", err)
+		messages = append(messages, pe.Error())
+	}
+
+	return struct {
+		MemoryDepth int64                        `json:"memory_depth"`
+		Messages    []*clusterinfo.PeekedMessage `json:"messages"`
+		Message     string                       `json:"message"`
+	}{memoryDepth, peeked, maybeWarnMsg(messages)}, nil
+}
+
+func (s *httpServer) publishHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	c := s.cluster(req)
+	if c == nil {
+		return nil, http_api.Err{400, "INVALID_CLUSTER"}
+	}
+	var messages []string
+
+	topicName := ps.ByName("topic")
+	if !protocol.IsValidTopicName(topicName) {
+		return nil, http_api.Err{400, "INVALID_TOPIC"}
+	}
+
+	var body struct {
 		Message string `json:"message"`
-	}{channelStats[channelName], maybeWarnMsg(messages)}, nil
+	}
+	err := json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		return nil, http_api.Err{400, err.Error()}
+	}
+	if body.Message == "" {
+		return nil, http_api.Err{400, "MSG_EMPTY"}
+	}
+
+	if !s.isAllowed(req, ActionPublish, topicName, "") {
+		return nil, http_api.Err{403, "FORBIDDEN"}
+	}
+
+	producers, err := c.ci.GetTopicProducers(topicName,
+		c.lookupdHTTPAddrs(),
+		c.nsqdHTTPAddrs())
+	if err != nil {
+		pe, ok := err.(clusterinfo.PartialErr)
+		if !ok {
+			s.ctx.nsqadmin.logf(LOG_ERROR, "failed to get topic producers - %s", err)
+			return nil, http_api.Err{502, fmt.Sprintf("UPSTREAM_ERROR: %s", err)}
+		}
+		s.ctx.nsqadmin.logf(LOG_WARN, "%s", err)
+		messages = append(messages, pe.Error())
+	}
+	if len(producers) == 0 {
+		// the topic doesn't exist (yet), publishing creates it on any nsqd
+		producers, err = c.ci.GetProducers(c.lookupdHTTPAddrs(),
+			c.nsqdHTTPAddrs())
+		if err != nil {
+			pe, ok := err.(clusterinfo.PartialErr)
+			if !ok {
+				s.ctx.nsqadmin.logf(LOG_ERROR, "failed to get nodes - %s", err)
+				return nil, http_api.Err{502, fmt.Sprintf("UPSTREAM_ERROR: %s", err)}
+			}
+			s.ctx.nsqadmin.logf(LOG_WARN, "%s", err)
+			messages = append(messages, pe.Error())
+		}
+	}
+
+	node, err := c.ci.PublishMessage(producers, topicName, []byte(body.Message))
+	if err != nil {
+		s.ctx.nsqadmin.logf(LOG_ERROR, "failed to publish message - %s", err)
+		return nil, http_api.Err{502, fmt.Sprintf("UPSTREAM_ERROR: %s", err)}
+	}
+
+	s.notifyAdminAction("publish_message", topicName, "", node, req)
+
+	return struct {
+		Node    string `json:"node"`
+		Message string `json:"message"`
+	}{node, maybeWarnMsg(messages)}, nil
+}
+
+// sampleHandler streams up to count messages published to the topic from
+// now on as server-sent events, followed by a "done" event
+func (s *httpServer) sampleHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	c := s.cluster(req)
+	if c == nil {
+		return nil, http_api.Err{400, "INVALID_CLUSTER"}
+	}
+	topicName := ps.ByName("topic")
+	if !protocol.IsValidTopicName(topicName) {
+		return nil, http_api.Err{400, "INVALID_TOPIC"}
+	}
+	if !s.isAllowed(req, ActionView, topicName, "") {
+		return nil, http_api.Err{403, "FORBIDDEN"}
+	}
+
+	reqParams, err := http_api.NewReqParams(req)
+	if err != nil {
+		return nil, http_api.Err{400, "INVALID_REQUEST"}
+	}
+
+	count := sampleDefaultCount
+	if v, _ := reqParams.Get("count"); v != "" {
+		count, err = strconv.Atoi(v)
+		if err != nil || count <= 0 || count > sampleMaxCount {
+			return nil, http_api.Err{400, "INVALID_COUNT"}
+		}
+	}
+	timeout := sampleDefaultTimeout
+	if v, _ := reqParams.Get("timeout"); v != "" {
+		timeout, err = time.ParseDuration(v)
+		if err != nil || timeout <= 0 || timeout > sampleMaxTimeout {
+			return nil, http_api.Err{400, "INVALID_TIMEOUT"}
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, http_api.Err{500, "STREAMING_UNSUPPORTED"}
+	}
+
+	producers, err := c.ci.GetTopicProducers(topicName,
+		c.lookupdHTTPAddrs(),
+		c.nsqdHTTPAddrs())
+	if err != nil {
+		if _, ok := err.(clusterinfo.PartialErr); !ok {
+			s.ctx.nsqadmin.logf(LOG_ERROR, "failed to get topic producers - %s", err)
+			return nil, http_api.Err{502, fmt.Sprintf("UPSTREAM_ERROR: %s", err)}
+		}
+		s.ctx.nsqadmin.logf(LOG_WARN, "%s", err)
+	}
+	if len(producers) == 0 {
+		return nil, http_api.Err{404, "TOPIC_NOT_FOUND"}
+	}
+
+	// nsqd hands the messages queued for a topic without channels to the
+	// first channel, the sample would take (and lose) them
+	topicStats, _, err := c.ci.GetNSQDStats(producers, topicName, "", false)
+	if err != nil {
+		if _, ok := err.(clusterinfo.PartialErr); !ok {
+			s.ctx.nsqadmin.logf(LOG_ERROR, "failed to get nsqd stats - %s", err)
+			return nil, http_api.Err{502, fmt.Sprintf("UPSTREAM_ERROR: %s", err)}
+		}
+		s.ctx.nsqadmin.logf(LOG_WARN, "%s", err)
+	}
+	withChannels := make(map[string]bool)
+	for _, ts := range topicStats {
+		if len(ts.Channels) > 0 {
+			withChannels[ts.Node] = true
+		}
+	}
+	var sampleProducers clusterinfo.Producers
+	for _, p := range producers {
+		if withChannels[p.HTTPAddress()] {
+			sampleProducers = append(sampleProducers, p)
+		}
+	}
+	if len(sampleProducers) == 0 {
+		return nil, http_api.Err{400, "TOPIC_HAS_NO_CHANNELS"}
+	}
+
+	smp, err := s.ctx.nsqadmin.newSampler(sampleProducers, topicName, count)
+	if err != nil {
+		s.ctx.nsqadmin.logf(LOG_ERROR, "failed to sample messages - %s", err)
+		return nil, http_api.Err{502, fmt.Sprintf("UPSTREAM_ERROR: %s", err)}
+	}
+	defer smp.Stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	sampled := 0
+	err = smp.run(count, timeout, req.Context().Done(),
+		func(m *clusterinfo.PeekedMessage) error {
+			data, err := json.Marshal(m)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+			if err != nil {
+				return err
+			}
+			flusher.Flush()
+			sampled++
+			return nil
+		})
+
+	// the response is committed, errors can only be reported in the stream
+	var done struct {
+		Count   int    `json:"count"`
+		Message string `json:"message,omitempty"`
+	}
+	done.Count = sampled
+	if err != nil {
+		s.ctx.nsqadmin.logf(LOG_ERROR, "failed to sample messages - %s", err)
+		done.Message = fmt.Sprintf("ERROR: failed to sample messages - %s", err)
+	}
+	data, _ := json.Marshal(done)
+	fmt.Fprintf(w, "event: done\ndata: %s\n\n", data)
+	flusher.Flush()
+	return nil, nil
 }
 
 func (s *httpServer) nodesHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	c := s.cluster(req)
+	if c == nil {
+		return nil, http_api.Err{400, "INVALID_CLUSTER"}
+	}
 	var messages []string
 
-	producers, err := s.ci.GetProducers(s.ctx.nsqadmin.getOpts().NSQLookupdHTTPAddresses, s.ctx.nsqadmin.getOpts().NSQDHTTPAddresses)
+	producers, err := c.ci.GetProducers(c.lookupdHTTPAddrs(), c.nsqdHTTPAddrs())
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -341,11 +695,15 @@ func (s *httpServer) nodesHandler(w http.ResponseWriter, req *http.Request, ps h
 }
 
 func (s *httpServer) nodeHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	c := s.cluster(req)
+	if c == nil {
+		return nil, http_api.Err{400, "INVALID_CLUSTER"}
+	}
 	var messages []string
 
 	node := ps.ByName("node")
 
-	producers, err := s.ci.GetProducers(s.ctx.nsqadmin.getOpts().NSQLookupdHTTPAddresses, s.ctx.nsqadmin.getOpts().NSQDHTTPAddresses)
+	producers, err := c.ci.GetProducers(c.lookupdHTTPAddrs(), c.nsqdHTTPAddrs())
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -361,7 +719,7 @@ func (s *httpServer) nodeHandler(w http.ResponseWriter, req *http.Request, ps ht
 		return nil, http_api.Err{404, "NODE_NOT_FOUND"}
 	}
 
-	topicStats, _, err := s.ci.GetNSQDStats(clusterinfo.Producers{producer}, "", "", true)
+	topicStats, _, err := c.ci.GetNSQDStats(clusterinfo.Producers{producer}, "", "", true)
 	if err != nil {
 		s.ctx.nsqadmin.logf(LOG_ERROR, "failed to get nsqd stats - %s", err)
 		return nil, http_api.Err{502, fmt.Sprintf("UPSTREAM_ERROR: %s", err)}
@@ -392,6 +750,10 @@ func (s *httpServer) nodeHandler(w http.ResponseWriter, req *http.Request, ps ht
 }
 
 func (s *httpServer) tombstoneNodeForTopicHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	c := s.cluster(req)
+	if c == nil {
+		return nil, http_api.Err{400, "INVALID_CLUSTER"}
+	}
 	var messages []string
 
 	node := ps.ByName("node")
@@ -408,8 +770,12 @@ func (s *httpServer) tombstoneNodeForTopicHandler(w http.ResponseWriter, req *ht
 		return nil, http_api.Err{400, "INVALID_TOPIC"}
 	}
 
-	err = s.ci.TombstoneNodeForTopic(body.Topic, node,
-		s.ctx.nsqadmin.getOpts().NSQLookupdHTTPAddresses)
+	if !s.isAllowed(req, ActionDelete, body.Topic, "") {
+		return nil, http_api.Err{403, "FORBIDDEN"}
+	}
+
+	err = c.ci.TombstoneNodeForTopic(body.Topic, node,
+		c.lookupdHTTPAddrs())
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -428,6 +794,10 @@ func (s *httpServer) tombstoneNodeForTopicHandler(w http.ResponseWriter, req *ht
 }
 
 func (s *httpServer) createTopicChannelHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	c := s.cluster(req)
+	if c == nil {
+		return nil, http_api.Err{400, "INVALID_CLUSTER"}
+	}
 	var messages []string
 
 	var body struct {
@@ -435,7 +805,7 @@ func (s *httpServer) createTopicChannelHandler(w http.ResponseWriter, req *http.
 		Channel string `json:"channel"`
 	}
 
-	if !s.isAuthorizedAdminRequest(req) {
+	if !s.canCreate(req) {
 		return nil, http_api.Err{403, "FORBIDDEN"}
 	}
 
@@ -452,8 +822,12 @@ func (s *httpServer) createTopicChannelHandler(w http.ResponseWriter, req *http.
 		return nil, http_api.Err{400, "INVALID_CHANNEL"}
 	}
 
-	err = s.ci.CreateTopicChannel(body.Topic, body.Channel,
-		s.ctx.nsqadmin.getOpts().NSQLookupdHTTPAddresses)
+	if !s.isAllowed(req, ActionCreate, body.Topic, body.Channel) {
+		return nil, http_api.Err{403, "FORBIDDEN"}
+	}
+
+	err = c.ci.CreateTopicChannel(body.Topic, body.Channel,
+		c.lookupdHTTPAddrs())
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -475,17 +849,21 @@ func (s *httpServer) createTopicChannelHandler(w http.ResponseWriter, req *http.
 }
 
 func (s *httpServer) deleteTopicHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	c := s.cluster(req)
+	if c == nil {
+		return nil, http_api.Err{400, "INVALID_CLUSTER"}
+	}
 	var messages []string
 
-	if !s.isAuthorizedAdminRequest(req) {
+	topicName := ps.ByName("topic")
+
+	if !s.isAllowed(req, ActionDelete, topicName, "") {
 		return nil, http_api.Err{403, "FORBIDDEN"}
 	}
 
-	topicName := ps.ByName("topic")
-
-	err := s.ci.DeleteTopic(topicName,
-		s.ctx.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
-		s.ctx.nsqadmin.getOpts().NSQDHTTPAddresses)
+	err := c.ci.DeleteTopic(topicName,
+		c.lookupdHTTPAddrs(),
+		c.nsqdHTTPAddrs())
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -504,18 +882,22 @@ func (s *httpServer) deleteTopicHandler(w http.ResponseWriter, req *http.Request
 }
 
 func (s *httpServer) deleteChannelHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
-	var messages []string
-
-	if !s.isAuthorizedAdminRequest(req) {
-		return nil, http_api.Err{403, "FORBIDDEN"}
+	c := s.cluster(req)
+	if c == nil {
+		return nil, http_api.Err{400, "INVALID_CLUSTER"}
 	}
+	var messages []string
 
 	topicName := ps.ByName("topic")
 	channelName := ps.ByName("channel")
 
-	err := s.ci.DeleteChannel(topicName, channelName,
-		s.ctx.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
-		s.ctx.nsqadmin.getOpts().NSQDHTTPAddresses)
+	if !s.isAllowed(req, ActionDelete, topicName, channelName) {
+		return nil, http_api.Err{403, "FORBIDDEN"}
+	}
+
+	err := c.ci.DeleteChannel(topicName, channelName,
+		c.lookupdHTTPAddrs(),
+		c.nsqdHTTPAddrs())
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -545,14 +927,18 @@ func (s *httpServer) channelActionHandler(w http.ResponseWriter, req *http.Reque
 }
 
 func (s *httpServer) topicChannelAction(req *http.Request, topicName string, channelName string) (interface{}, error) {
+	c := s.cluster(req)
+	if c == nil {
+		return nil, http_api.Err{400, "INVALID_CLUSTER"}
+	}
 	var messages []string
 
 	var body struct {
-		Action string `json:"action"`
-	}
-
-	if !s.isAuthorizedAdminRequest(req) {
-		return nil, http_api.Err{403, "FORBIDDEN"}
+		Action       string   `json:"action"`
+		IDs          []string `json:"ids"`
+		BodyContains string   `json:"body_contains"`
+		BodyRegexp   string   `json:"body_regexp"`
+		ToTopic      string   `json:"to_topic"`
 	}
 
 	err := json.NewDecoder(req.Body).Decode(&body)
@@ -560,49 +946,89 @@ func (s *httpServer) topicChannelAction(req *http.Request, topicName string, cha
 		return nil, http_api.Err{400, err.Error()}
 	}
 
+	if !s.isAllowed(req, actionPermission(body.Action), topicName, channelName) {
+		return nil, http_api.Err{403, "FORBIDDEN"}
+	}
+
 	switch body.Action {
 	case "pause":
 		if channelName != "" {
-			err = s.ci.PauseChannel(topicName, channelName,
-				s.ctx.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
-				s.ctx.nsqadmin.getOpts().NSQDHTTPAddresses)
+			err = c.ci.PauseChannel(topicName, channelName,
+				c.lookupdHTTPAddrs(),
+				c.nsqdHTTPAddrs())
 
 			s.notifyAdminAction("pause_channel", topicName, channelName, "", req)
 		} else {
-			err = s.ci.PauseTopic(topicName,
-				s.ctx.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
-				s.ctx.nsqadmin.getOpts().NSQDHTTPAddresses)
+			err = c.ci.PauseTopic(topicName,
+				c.lookupdHTTPAddrs(),
+				c.nsqdHTTPAddrs())
 
 			s.notifyAdminAction("pause_topic", topicName, "", "", req)
 		}
 	case "unpause":
 		if channelName != "" {
-			err = s.ci.UnPauseChannel(topicName, channelName,
-				s.ctx.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
-				s.ctx.nsqadmin.getOpts().NSQDHTTPAddresses)
+			err = c.ci.UnPauseChannel(topicName, channelName,
+				c.lookupdHTTPAddrs(),
+				c.nsqdHTTPAddrs())
 
 			s.notifyAdminAction("unpause_channel", topicName, channelName, "", req)
 		} else {
-			err = s.ci.UnPauseTopic(topicName,
-				s.ctx.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
-				s.ctx.nsqadmin.getOpts().NSQDHTTPAddresses)
+			err = c.ci.UnPauseTopic(topicName,
+				c.lookupdHTTPAddrs(),
+				c.nsqdHTTPAddrs())
 
 			s.notifyAdminAction("unpause_topic", topicName, "", "", req)
 		}
 	case "empty":
 		if channelName != "" {
-			err = s.ci.EmptyChannel(topicName, channelName,
-				s.ctx.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
-				s.ctx.nsqadmin.getOpts().NSQDHTTPAddresses)
+			err = c.ci.EmptyChannel(topicName, channelName,
+				c.lookupdHTTPAddrs(),
+				c.nsqdHTTPAddrs())
 
 			s.notifyAdminAction("empty_channel", topicName, channelName, "", req)
 		} else {
-			err = s.ci.EmptyTopic(topicName,
-				s.ctx.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
-				s.ctx.nsqadmin.getOpts().NSQDHTTPAddresses)
+			err = c.ci.EmptyTopic(topicName,
+				c.lookupdHTTPAddrs(),
+				c.nsqdHTTPAddrs())
 
 			s.notifyAdminAction("empty_topic", topicName, "", "", req)
 		}
+	case "delete_messages", "move_messages", "requeue_messages":
+		if channelName == "" {
+			return nil, http_api.Err{400, "INVALID_ACTION"}
+		}
+		params := url.Values{}
+		for _, id := range body.IDs {
+			params.Add("id", id)
+		}
+		if body.BodyContains != "" {
+			params.Set("body_contains", body.BodyContains)
+		}
+		if body.BodyRegexp != "" {
+			params.Set("body_regexp", body.BodyRegexp)
+		}
+		if len(params) == 0 {
+			return nil, http_api.Err{400, "MISSING_FILTER"}
+		}
+		action := strings.TrimSuffix(body.Action, "_messages")
+		if action == "move" {
+			if !protocol.IsValidTopicName(body.ToTopic) {
+				return nil, http_api.Err{400, "INVALID_TO_TOPIC"}
+			}
+			if !s.isAllowed(req, ActionPublish, body.ToTopic, "") {
+				return nil, http_api.Err{403, "FORBIDDEN"}
+			}
+			params.Set("to_topic", body.ToTopic)
+		}
+		if action == "delete" || action == "move" {
+			params.Set("scan_backend", "true")
+		}
+
+		err = c.ci.ChannelMessagesAction(topicName, channelName, action, params,
+			c.lookupdHTTPAddrs(),
+			c.nsqdHTTPAddrs())
+
+		s.notifyAdminAction(body.Action, topicName, channelName, "", req)
 	default:
 		return nil, http_api.Err{400, "INVALID_ACTION"}
 	}
@@ -622,6 +1048,78 @@ func (s *httpServer) topicChannelAction(req *http.Request, topicName string, cha
 	}{maybeWarnMsg(messages)}, nil
 }
 
+// syncHandler plans (and with apply=true applies) the changes that bring the
+// cluster to the desired state (see DesiredState) of the YAML body. Applying
+// requires the plan fingerprint of a previous dry run, and is refused with a
+// 409 if the plan changed since.
+func (s *httpServer) syncHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	c := s.cluster(req)
+	if c == nil {
+		return nil, http_api.Err{400, "INVALID_CLUSTER"}
+	}
+	apply := req.URL.Query().Get("apply") == "true"
+	plan := req.URL.Query().Get("plan")
+	if apply && plan == "" {
+		return nil, http_api.Err{400, "MISSING_ARG_PLAN"}
+	}
+
+	// add 1 so that it's greater than our max when we test for it
+	// (LimitReader returns a "fake" EOF)
+	data, err := ioutil.ReadAll(io.LimitReader(req.Body, syncMaxBodySize+1))
+	if err != nil {
+		return nil, http_api.Err{500, "INTERNAL_ERROR"}
+	}
+	if int64(len(data)) == syncMaxBodySize+1 {
+		return nil, http_api.Err{413, "BODY_TOO_LARGE"}
+	}
+	ds, err := ParseDesiredState(data)
+	if err != nil {
+		return nil, http_api.Err{400, fmt.Sprintf("INVALID_BODY: %s", err)}
+	}
+
+	changes, messages, err := c.planSync(ds)
+	if err == errSyncNeedsLookupd {
+		return nil, http_api.Err{400, "SYNC_REQUIRES_LOOKUPD"}
+	}
+	if err != nil {
+		s.ctx.nsqadmin.logf(LOG_ERROR, "failed to plan sync - %s", err)
+		return nil, http_api.Err{502, fmt.Sprintf("UPSTREAM_ERROR: %s", err)}
+	}
+
+	if changes == nil {
+		changes = []*SyncChange{}
+	}
+	fingerprint := planFingerprint(changes)
+	if apply && plan != fingerprint {
+		return nil, http_api.Err{409, "PLAN_CHANGED"}
+	}
+
+	// the whole plan is refused if any change of it is
+	for _, sc := range changes {
+		permission := ActionView
+		if apply {
+			permission = sc.permission()
+		}
+		if !s.isAllowed(req, permission, sc.Topic, sc.Channel) {
+			return nil, http_api.Err{403, "FORBIDDEN"}
+		}
+	}
+
+	if apply {
+		applyMessages, _ := c.applySync(changes, func(sc *SyncChange) {
+			s.notifyAdminAction(sc.Action, sc.Topic, sc.Channel, "", req)
+		})
+		messages = append(messages, applyMessages...)
+	}
+
+	return struct {
+		Changes []*SyncChange `json:"changes"`
+		Plan    string        `json:"plan"`
+		Applied bool          `json:"applied"`
+		Message string        `json:"message"`
+	}{changes, fingerprint, apply, maybeWarnMsg(messages)}, nil
+}
+
 type counterStats struct {
 	Node         string `json:"node"`
 	TopicName    string `json:"topic_name"`
@@ -629,11 +1127,100 @@ type counterStats struct {
 	MessageCount int64  `json:"message_count"`
 }
 
+func (s *httpServer) historyHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	c := s.cluster(req)
+	if c == nil {
+		return nil, http_api.Err{400, "INVALID_CLUSTER"}
+	}
+	if s.ctx.nsqadmin.history == nil {
+		return nil, http_api.Err{404, "HISTORY_NOT_ENABLED"}
+	}
+
+	reqParams, err := http_api.NewReqParams(req)
+	if err != nil {
+		return nil, http_api.Err{400, "INVALID_REQUEST"}
+	}
+
+	period := time.Hour
+	if periodStr, _ := reqParams.Get("period"); periodStr != "" {
+		period, err = time.ParseDuration(periodStr)
+		if err != nil || period <= 0 {
+			return nil, http_api.Err{400, "INVALID_ARG_PERIOD"}
+		}
+	}
+
+	topicName := ps.ByName("topic")
+	channelName := ps.ByName("channel")
+	if !s.isAllowed(req, ActionView, topicName, channelName) {
+		return nil, http_api.Err{403, "FORBIDDEN"}
+	}
+	series := topicSeries(topicName)
+	if channelName != "" {
+		series = channelSeries(topicName, channelName)
+	}
+
+	samples, err := s.ctx.nsqadmin.history.Series(c.name, series, time.Now().Add(-period))
+	if err != nil {
+		s.ctx.nsqadmin.logf(LOG_ERROR, "failed to read stats history - %s", err)
+		return nil, http_api.Err{500, "INTERNAL_ERROR"}
+	}
+
+	return struct {
+		Topic    string           `json:"topic"`
+		Channel  string           `json:"channel,omitempty"`
+		Interval int64            `json:"interval"`
+		Samples  []*HistorySample `json:"samples"`
+	}{topicName, channelName, int64(s.ctx.nsqadmin.getOpts().StatsHistoryInterval / time.Second), samples}, nil
+}
+
+func (s *httpServer) alertsHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	c := s.cluster(req)
+	if c == nil {
+		return nil, http_api.Err{400, "INVALID_CLUSTER"}
+	}
+	var messages []string
+	rules := []*AlertRule{}
+	alerts := []*Alert{}
+	var lastEvaluated int64
+
+	a := c.alerter
+	if a != nil {
+		rules = a.rules
+		alerts = a.Alerts()
+		a.RLock()
+		if !a.lastEvaluated.IsZero() {
+			lastEvaluated = a.lastEvaluated.Unix()
+		}
+		if a.lastErr != nil {
+			messages = append(messages, a.lastErr.Error())
+		}
+		a.RUnlock()
+	}
+
+	return struct {
+		Rules         []*AlertRule `json:"rules"`
+		Alerts        []*Alert     `json:"alerts"`
+		LastEvaluated int64        `json:"last_evaluated"`
+		Message       string       `json:"message"`
+	}{rules, alerts, lastEvaluated, maybeWarnMsg(messages)}, nil
+}
+
+// clustersHandler returns the aggregate overview of all clusters
+func (s *httpServer) clustersHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	return struct {
+		Clusters []*ClusterSummary `json:"clusters"`
+	}{summarize(s.clusters)}, nil
+}
+
 func (s *httpServer) counterHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	c := s.cluster(req)
+	if c == nil {
+		return nil, http_api.Err{400, "INVALID_CLUSTER"}
+	}
 	var messages []string
 	stats := make(map[string]*counterStats)
 
-	producers, err := s.ci.GetProducers(s.ctx.nsqadmin.getOpts().NSQLookupdHTTPAddresses, s.ctx.nsqadmin.getOpts().NSQDHTTPAddresses)
+	producers, err := c.ci.GetProducers(c.lookupdHTTPAddrs(), c.nsqdHTTPAddrs())
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -643,7 +1230,7 @@ func (s *httpServer) counterHandler(w http.ResponseWriter, req *http.Request, ps
 		s.ctx.nsqadmin.logf(LOG_WARN, "%s", err)
 		messages = append(messages, pe.Error())
 	}
-	_, channelStats, err := s.ci.GetNSQDStats(producers, "", "", false)
+	_, channelStats, err := c.ci.GetNSQDStats(producers, "", "", false)
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -801,6 +1388,69 @@ func (s *httpServer) isAuthorizedAdminRequest(req *http.Request) bool {
 	return false
 }
 
+// aclUser is the user a request was made by, per --acl-http-header
+func (s *httpServer) aclUser(req *http.Request) string {
+	return req.Header.Get(s.ctx.nsqadmin.getOpts().AclHttpHeader)
+}
+
+// isAllowed returns true if the request may perform the action on the topic
+// (and channel, if not empty). Without --acl-policy-file anyone may view
+// and --admin-user may do everything, with a policy --admin-user may still
+// do everything and other users what their roles permit.
+func (s *httpServer) isAllowed(req *http.Request, action string, topic string, channel string) bool {
+	policy := s.ctx.nsqadmin.policy
+	if policy == nil {
+		return action == ActionView || s.isAuthorizedAdminRequest(req)
+	}
+	if s.isAdminUser(req) {
+		return true
+	}
+	return policy.Allowed(s.aclUser(req), action, topic, channel)
+}
+
+// permittedActions returns the actions the request may perform on the topic
+// (and channel, if not empty), for the UI to show only those
+func (s *httpServer) permittedActions(req *http.Request, topic string, channel string) []string {
+	actions := []string{}
+	for _, a := range []string{ActionView, ActionPause, ActionEmpty, ActionDelete, ActionCreate, ActionPublish} {
+		if s.isAllowed(req, a, topic, channel) {
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
+
+// canCreate returns true if the request may create any topic
+func (s *httpServer) canCreate(req *http.Request) bool {
+	policy := s.ctx.nsqadmin.policy
+	if policy == nil {
+		return s.isAuthorizedAdminRequest(req)
+	}
+	return s.isAdminUser(req) || policy.HasAction(s.aclUser(req), ActionCreate)
+}
+
+// isAdminUser returns true if the request was made by one of --admin-user
+func (s *httpServer) isAdminUser(req *http.Request) bool {
+	user := s.aclUser(req)
+	for _, v := range s.ctx.nsqadmin.getOpts().AdminUsers {
+		if v == user {
+			return true
+		}
+	}
+	return false
+}
+
+// actionPermission is the permission needed for an action of
+// topicChannelAction
+func actionPermission(action string) string {
+	switch action {
+	case "pause", "unpause":
+		return ActionPause
+	default:
+		return ActionEmpty
+	}
+}
+
 func getOptByCfgName(opts interface{}, name string) (interface{}, bool) {
 	val := reflect.ValueOf(opts).Elem()
 	typ := val.Type()
@@ -8,7 +8,9 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -67,6 +69,14 @@ func bootstrapNSQCluster(t *testing.T) (string, []*nsqd.NSQD, []*nsqlookupd.NSQL
 }
 
 func bootstrapNSQClusterWithAuth(t *testing.T, withAuth bool) (string, []*nsqd.NSQD, []*nsqlookupd.NSQLookupd, *NSQAdmin) {
+	return bootstrapNSQClusterWithOpts(t, func(opts *Options) {
+		if withAuth {
+			opts.AdminUsers = []string{"matt"}
+		}
+	})
+}
+
+func bootstrapNSQClusterWithOpts(t *testing.T, setOpts func(*Options)) (string, []*nsqd.NSQD, []*nsqlookupd.NSQLookupd, *NSQAdmin) {
 	lgr := test.NewTestLogger(t)
 
 	nsqlookupdOpts := nsqlookupd.NewOptions()
@@ -113,9 +123,7 @@ func bootstrapNSQClusterWithAuth(t *testing.T, withAuth bool) (string, []*nsqd.N
 	nsqadminOpts.HTTPAddress = "127.0.0.1:0"
 	nsqadminOpts.NSQLookupdHTTPAddresses = []string{nsqlookupd1.RealHTTPAddr().String()}
 	nsqadminOpts.Logger = lgr
-	if withAuth {
-		nsqadminOpts.AdminUsers = []string{"matt"}
-	}
+	setOpts(nsqadminOpts)
 	nsqadmin1, err := New(nsqadminOpts)
 	if err != nil {
 		panic(err)
@@ -179,6 +187,46 @@ func TestHTTPTopicsGET(t *testing.T) {
 	test.Equal(t, topicName, tr.Topics[0])
 }
 
+func TestHTTPTopicsMetadataGET(t *testing.T) {
+	dataPath, nsqds, nsqlookupds, nsqadmin1 := bootstrapNSQCluster(t)
+	defer os.RemoveAll(dataPath)
+	defer nsqds[0].Exit()
+	defer nsqlookupds[0].Exit()
+	defer nsqadmin1.Exit()
+
+	topicName := "test_topics_metadata" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqds[0].GetTopic(topicName)
+	err := topic.SetMetadata(nsqd.Metadata{Labels: map[string]string{"team": "ops"}})
+	test.Nil(t, err)
+	nsqds[0].GetTopic("other_" + topicName)
+	time.Sleep(100 * time.Millisecond)
+
+	get := func(query string) map[string]json.RawMessage {
+		url := fmt.Sprintf("http://%s/api/topics%s", nsqadmin1.RealHTTPAddr(), query)
+		resp, err := http.Get(url)
+		test.Nil(t, err)
+		defer resp.Body.Close()
+		test.Equal(t, 200, resp.StatusCode)
+		var doc map[string]json.RawMessage
+		err = json.NewDecoder(resp.Body).Decode(&doc)
+		test.Nil(t, err)
+		return doc
+	}
+
+	doc := get("")
+	test.Equal(t, "null", string(doc["metadata"]))
+
+	doc = get("?metadata=true")
+	var metadata map[string]clusterinfo.Metadata
+	json.Unmarshal(doc["metadata"], &metadata)
+	test.Equal(t, "ops", metadata[topicName].Labels["team"])
+
+	doc = get("?label=team:ops")
+	var topics []string
+	json.Unmarshal(doc["topics"], &topics)
+	test.Equal(t, []string{topicName}, topics)
+}
+
 func TestHTTPTopicGET(t *testing.T) {
 	dataPath, nsqds, nsqlookupds, nsqadmin1 := bootstrapNSQCluster(t)
 	defer os.RemoveAll(dataPath)
@@ -284,6 +332,50 @@ func TestHTTPChannelGET(t *testing.T) {
 	test.Equal(t, 0, len(cs.Clients))
 }
 
+func TestHTTPPeekChannelGET(t *testing.T) {
+	dataPath, nsqds, nsqlookupds, nsqadmin1 := bootstrapNSQCluster(t)
+	defer os.RemoveAll(dataPath)
+	defer nsqds[0].Exit()
+	defer nsqlookupds[0].Exit()
+	defer nsqadmin1.Exit()
+
+	topicName := "test_peek_get" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqds[0].GetTopic(topicName)
+	// only messages queued on disk can be peeked at
+	topic.SetDurability(nsqd.DurabilityDisk)
+	channel := topic.GetChannel("ch")
+	for i := 0; i < 3; i++ {
+		topic.PutMessage(nsqd.NewMessage(topic.GenerateID(), []byte("test body")))
+	}
+	for channel.Depth() != 3 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	client := http.Client{}
+	url := fmt.Sprintf("http://%s/api/peek/%s/ch?count=2", nsqadmin1.RealHTTPAddr(), topicName)
+	req, _ := http.NewRequest("GET", url, nil)
+	resp, err := client.Do(req)
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	t.Logf("%s", body)
+	var pr struct {
+		MemoryDepth int64                        `json:"memory_depth"`
+		Messages    []*clusterinfo.PeekedMessage `json:"messages"`
+		Message     string                       `json:"message"`
+	}
+	err = json.Unmarshal(body, &pr)
+	test.Nil(t, err)
+	test.Equal(t, int64(0), pr.MemoryDepth)
+	test.Equal(t, 2, len(pr.Messages))
+	test.Equal(t, "test body", pr.Messages[0].Body)
+	test.Equal(t, nsqds[0].RealHTTPAddr().String(), pr.Messages[0].Node)
+	test.Equal(t, int64(3), channel.Depth())
+}
+
 func TestHTTPNodesSingleGET(t *testing.T) {
 	dataPath, nsqds, nsqlookupds, nsqadmin1 := bootstrapNSQCluster(t)
 	defer os.RemoveAll(dataPath)
@@ -559,6 +651,46 @@ func TestHTTPEmptyChannelPOST(t *testing.T) {
 	test.Equal(t, int64(0), channel.Depth())
 }
 
+func TestHTTPChannelMessagesPOST(t *testing.T) {
+	dataPath, nsqds, nsqlookupds, nsqadmin1 := bootstrapNSQCluster(t)
+	defer os.RemoveAll(dataPath)
+	defer nsqds[0].Exit()
+	defer nsqlookupds[0].Exit()
+	defer nsqadmin1.Exit()
+
+	topicName := "test_channel_messages_post" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqds[0].GetTopic(topicName)
+	channel := topic.GetChannel("ch")
+	channel.PutMessage(nsqd.NewMessage(topic.GenerateID(), []byte("keep")))
+	channel.PutMessage(nsqd.NewMessage(topic.GenerateID(), []byte("drop")))
+
+	time.Sleep(100 * time.Millisecond)
+	test.Equal(t, int64(2), channel.Depth())
+
+	client := http.Client{}
+	url := fmt.Sprintf("http://%s/api/topics/%s/ch", nsqadmin1.RealHTTPAddr(), topicName)
+	body, _ := json.Marshal(map[string]interface{}{
+		"action": "delete_messages",
+	})
+	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	resp, err := client.Do(req)
+	test.Nil(t, err)
+	test.Equal(t, 400, resp.StatusCode)
+	resp.Body.Close()
+
+	body, _ = json.Marshal(map[string]interface{}{
+		"action":        "delete_messages",
+		"body_contains": "drop",
+	})
+	req, _ = http.NewRequest("POST", url, bytes.NewBuffer(body))
+	resp, err = client.Do(req)
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	resp.Body.Close()
+
+	test.Equal(t, int64(1), channel.Depth())
+}
+
 func TestHTTPconfig(t *testing.T) {
 	dataPath, nsqds, nsqlookupds, nsqadmin1 := bootstrapNSQCluster(t)
 	defer os.RemoveAll(dataPath)
@@ -642,3 +774,455 @@ func TestHTTPconfigCIDR(t *testing.T) {
 	_, _ = ioutil.ReadAll(resp.Body)
 	test.Equal(t, 403, resp.StatusCode)
 }
+
+func TestHTTPAclPolicy(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "nsqadmin-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+	policyFile := filepath.Join(tmpDir, "policy.toml")
+	err = ioutil.WriteFile(policyFile, []byte(`
+[[role]]
+name = "orders-ops"
+users = ["alice"]
+  [[role.permission]]
+  topics = ["orders*"]
+  actions = ["view", "pause"]
+  [[role.permission]]
+  topics = ["orders*"]
+  channels = ["billing"]
+  actions = ["empty"]
+`), 0600)
+	test.Nil(t, err)
+
+	dataPath, nsqds, nsqlookupds, nsqadmin1 := bootstrapNSQClusterWithOpts(t, func(opts *Options) {
+		opts.AclPolicyFile = policyFile
+	})
+	defer os.RemoveAll(dataPath)
+	defer nsqds[0].Exit()
+	defer nsqlookupds[0].Exit()
+	defer nsqadmin1.Exit()
+
+	nsqds[0].GetTopic("orders_acl").GetChannel("billing")
+	nsqds[0].GetTopic("billing_acl")
+	time.Sleep(100 * time.Millisecond)
+
+	client := http.Client{}
+	do := func(method string, path string, body []byte) (int, []byte) {
+		url := fmt.Sprintf("http://%s%s", nsqadmin1.RealHTTPAddr(), path)
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(body))
+		req.Header.Set("X-Forwarded-User", "alice")
+		resp, err := client.Do(req)
+		test.Nil(t, err)
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, data
+	}
+
+	code, body := do("GET", "/api/topics", nil)
+	test.Equal(t, 200, code)
+	tr := TopicsDoc{}
+	err = json.Unmarshal(body, &tr)
+	test.Nil(t, err)
+	test.Equal(t, []interface{}{"orders_acl"}, tr.Topics)
+
+	code, body = do("GET", "/api/topics/orders_acl", nil)
+	test.Equal(t, 200, code)
+	var permissions struct {
+		Permissions []string `json:"permissions"`
+	}
+	err = json.Unmarshal(body, &permissions)
+	test.Nil(t, err)
+	test.Equal(t, []string{"view", "pause"}, permissions.Permissions)
+
+	code, _ = do("GET", "/api/topics/billing_acl", nil)
+	test.Equal(t, 403, code)
+
+	pause, _ := json.Marshal(map[string]interface{}{"action": "pause"})
+	code, _ = do("POST", "/api/topics/orders_acl", pause)
+	test.Equal(t, 200, code)
+	code, _ = do("POST", "/api/topics/billing_acl", pause)
+	test.Equal(t, 403, code)
+
+	// the channel permission doesn't extend to the topic
+	empty, _ := json.Marshal(map[string]interface{}{"action": "empty"})
+	code, _ = do("POST", "/api/topics/orders_acl", empty)
+	test.Equal(t, 403, code)
+	code, _ = do("POST", "/api/topics/orders_acl/billing", empty)
+	test.Equal(t, 200, code)
+
+	// moving messages also publishes them to the destination
+	move, _ := json.Marshal(map[string]interface{}{
+		"action":        "move_messages",
+		"body_contains": "x",
+		"to_topic":      "billing_acl",
+	})
+	code, _ = do("POST", "/api/topics/orders_acl/billing", move)
+	test.Equal(t, 403, code)
+
+	code, _ = do("DELETE", "/api/topics/orders_acl", nil)
+	test.Equal(t, 403, code)
+}
+
+func TestHTTPPublishPOST(t *testing.T) {
+	dataPath, nsqds, nsqlookupds, nsqadmin1 := bootstrapNSQCluster(t)
+	defer os.RemoveAll(dataPath)
+	defer nsqds[0].Exit()
+	defer nsqlookupds[0].Exit()
+	defer nsqadmin1.Exit()
+
+	topicName := "test_publish_post" + strconv.Itoa(int(time.Now().Unix()))
+	nsqds[0].GetTopic(topicName)
+	time.Sleep(100 * time.Millisecond)
+
+	client := http.Client{}
+	url := fmt.Sprintf("http://%s/api/publish/%s", nsqadmin1.RealHTTPAddr(), topicName)
+	body, _ := json.Marshal(map[string]interface{}{
+		"message": "hello",
+	})
+	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	resp, err := client.Do(req)
+	test.Nil(t, err)
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	test.Equal(t, 200, resp.StatusCode)
+
+	var pr struct {
+		Node string `json:"node"`
+	}
+	err = json.Unmarshal(body, &pr)
+	test.Nil(t, err)
+	test.Equal(t, nsqds[0].RealHTTPAddr().String(), pr.Node)
+	test.Equal(t, uint64(1), nsqds[0].GetStats(topicName, "", false)[0].MessageCount)
+
+	body, _ = json.Marshal(map[string]interface{}{
+		"message": "",
+	})
+	req, _ = http.NewRequest("POST", url, bytes.NewBuffer(body))
+	resp, err = client.Do(req)
+	test.Nil(t, err)
+	resp.Body.Close()
+	test.Equal(t, 400, resp.StatusCode)
+}
+
+func TestSampleTLS(t *testing.T) {
+	lgr := test.NewTestLogger(t)
+
+	nsqdOpts := nsqd.NewOptions()
+	nsqdOpts.Logger = lgr
+	nsqdOpts.TCPAddress = "127.0.0.1:0"
+	nsqdOpts.HTTPAddress = "127.0.0.1:0"
+	nsqdOpts.BroadcastAddress = "127.0.0.1"
+	nsqdOpts.TLSCert = "../nsqd/test/certs/server.pem"
+	nsqdOpts.TLSKey = "../nsqd/test/certs/server.key"
+	nsqdOpts.TLSRequired = nsqd.TLSRequiredExceptHTTP
+	tmpDir, err := ioutil.TempDir("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+	nsqdOpts.DataPath = tmpDir
+	nsqd1, err := nsqd.New(nsqdOpts)
+	test.Nil(t, err)
+	go nsqd1.Main()
+	defer nsqd1.Exit()
+
+	opts := NewOptions()
+	opts.Logger = lgr
+	opts.HTTPAddress = "127.0.0.1:0"
+	opts.NSQDHTTPAddresses = []string{nsqd1.RealHTTPAddr().String()}
+	opts.HTTPClientTLSRootCAFile = "../nsqd/test/certs/ca.pem"
+	nsqadmin1, err := New(opts)
+	test.Nil(t, err)
+	defer nsqadmin1.Exit()
+
+	topicName := "test_sample_tls" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd1.GetTopic(topicName)
+	topic.GetChannel("ch")
+	producers, err := nsqadmin1.newClusters()[0].ci.GetTopicProducers(topicName, nil, opts.NSQDHTTPAddresses)
+	test.Nil(t, err)
+
+	smp, err := nsqadmin1.newSampler(producers, topicName, 1)
+	test.Nil(t, err)
+	defer smp.Stop()
+	for i := 0; i < 100 && len(nsqd1.GetStats(topicName, "", false)[0].Channels) == 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	topic.PutMessage(nsqd.NewMessage(topic.GenerateID(), []byte("secret")))
+	var bodies []string
+	err = smp.run(1, 5*time.Second, nil, func(m *clusterinfo.PeekedMessage) error {
+		bodies = append(bodies, m.Body)
+		return nil
+	})
+	test.Nil(t, err)
+	test.Equal(t, []string{"secret"}, bodies)
+}
+
+func TestHTTPSampleGET(t *testing.T) {
+	dataPath, nsqds, nsqlookupds, nsqadmin1 := bootstrapNSQCluster(t)
+	defer os.RemoveAll(dataPath)
+	defer nsqds[0].Exit()
+	defer nsqlookupds[0].Exit()
+	defer nsqadmin1.Exit()
+
+	topicName := "test_sample_get" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqds[0].GetTopic(topicName)
+	topic.PutMessage(nsqd.NewMessage(topic.GenerateID(), []byte("before")))
+	time.Sleep(100 * time.Millisecond)
+
+	channelCount := func() int {
+		return len(nsqds[0].GetStats(topicName, "", false)[0].Channels)
+	}
+
+	// without channels the queued messages would go to the sample
+	client := http.Client{}
+	url := fmt.Sprintf("http://%s/api/sample/%s?count=2&timeout=10s", nsqadmin1.RealHTTPAddr(), topicName)
+	resp, err := client.Get(url)
+	test.Nil(t, err)
+	resp.Body.Close()
+	test.Equal(t, 400, resp.StatusCode)
+
+	topic.GetChannel("ch")
+	resp, err = client.Get(url)
+	test.Nil(t, err)
+	defer resp.Body.Close()
+	test.Equal(t, 200, resp.StatusCode)
+	test.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// the ephemeral channel is created when nsqd handles the SUB
+	for i := 0; i < 100 && channelCount() == 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	test.Equal(t, 2, channelCount())
+	for _, b := range []string{"1", "2", "3"} {
+		topic.PutMessage(nsqd.NewMessage(topic.GenerateID(), []byte(b)))
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	test.Nil(t, err)
+	t.Logf("%s", body)
+	var bodies []string
+	var done struct {
+		Count int `json:"count"`
+	}
+	for _, event := range strings.Split(strings.TrimSpace(string(body)), "\n\n") {
+		if strings.HasPrefix(event, "event: done\n") {
+			err := json.Unmarshal([]byte(strings.TrimPrefix(event, "event: done\ndata: ")), &done)
+			test.Nil(t, err)
+			continue
+		}
+		var m clusterinfo.PeekedMessage
+		err := json.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), &m)
+		test.Nil(t, err)
+		test.Equal(t, nsqds[0].RealHTTPAddr().String(), m.Node)
+		bodies = append(bodies, m.Body)
+	}
+	test.Equal(t, []string{"1", "2"}, bodies)
+	test.Equal(t, 2, done.Count)
+
+	// the ephemeral channel goes away with the sample
+	for i := 0; i < 100 && channelCount() != 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	test.Equal(t, 1, channelCount())
+}
+
+func TestHTTPClusters(t *testing.T) {
+	lgr := test.NewTestLogger(t)
+
+	nsqdOpts := nsqd.NewOptions()
+	nsqdOpts.Logger = lgr
+	_, nsqdHTTPAddr, nsqd2 := mustStartNSQD(nsqdOpts)
+	defer os.RemoveAll(nsqdOpts.DataPath)
+	defer nsqd2.Exit()
+	nsqd2.GetTopic("only_in_b").GetChannel("ch")
+
+	clustersFile := filepath.Join(nsqdOpts.DataPath, "clusters.toml")
+	err := ioutil.WriteFile(clustersFile, []byte(fmt.Sprintf(`
+[[cluster]]
+name = "b"
+nsqd_http_addresses = ["%s"]
+`, nsqdHTTPAddr)), 0600)
+	test.Nil(t, err)
+
+	dataPath, nsqds, nsqlookupds, nsqadmin1 := bootstrapNSQClusterWithOpts(t, func(opts *Options) {
+		opts.ClustersFile = clustersFile
+	})
+	defer os.RemoveAll(dataPath)
+	defer nsqds[0].Exit()
+	defer nsqlookupds[0].Exit()
+	defer nsqadmin1.Exit()
+
+	nsqds[0].GetTopic("only_in_default")
+	time.Sleep(100 * time.Millisecond)
+
+	client := http.Client{}
+	get := func(path string, cookie string) (int, []byte) {
+		url := fmt.Sprintf("http://%s%s", nsqadmin1.RealHTTPAddr(), path)
+		req, _ := http.NewRequest("GET", url, nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "cluster", Value: cookie})
+		}
+		resp, err := client.Do(req)
+		test.Nil(t, err)
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, body
+	}
+	topics := func(body []byte) []interface{} {
+		tr := TopicsDoc{}
+		err := json.Unmarshal(body, &tr)
+		test.Nil(t, err)
+		return tr.Topics
+	}
+
+	code, body := get("/api/topics", "")
+	test.Equal(t, 200, code)
+	test.Equal(t, []interface{}{"only_in_default"}, topics(body))
+
+	code, body = get("/api/topics?cluster=b", "")
+	test.Equal(t, 200, code)
+	test.Equal(t, []interface{}{"only_in_b"}, topics(body))
+
+	code, body = get("/api/topics", "b")
+	test.Equal(t, 200, code)
+	test.Equal(t, []interface{}{"only_in_b"}, topics(body))
+
+	code, _ = get("/api/topics?cluster=nope", "")
+	test.Equal(t, 400, code)
+
+	// a stale cookie falls back to the first cluster
+	code, body = get("/api/topics", "nope")
+	test.Equal(t, 200, code)
+	test.Equal(t, []interface{}{"only_in_default"}, topics(body))
+
+	// actions go to the cluster of the request
+	url := fmt.Sprintf("http://%s/api/topics/only_in_b?cluster=b", nsqadmin1.RealHTTPAddr())
+	pause, _ := json.Marshal(map[string]interface{}{"action": "pause"})
+	resp, err := client.Post(url, "application/json", bytes.NewBuffer(pause))
+	test.Nil(t, err)
+	resp.Body.Close()
+	test.Equal(t, 200, resp.StatusCode)
+	test.Equal(t, true, nsqd2.GetTopic("only_in_b").IsPaused())
+
+	// with several clusters an action without ?cluster= is refused, even
+	// with the cookie set
+	unpause, _ := json.Marshal(map[string]interface{}{"action": "unpause"})
+	req, _ := http.NewRequest("POST", fmt.Sprintf("http://%s/api/topics/only_in_b", nsqadmin1.RealHTTPAddr()),
+		bytes.NewBuffer(unpause))
+	req.AddCookie(&http.Cookie{Name: "cluster", Value: "b"})
+	resp, err = client.Do(req)
+	test.Nil(t, err)
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	test.Equal(t, 400, resp.StatusCode)
+	test.Equal(t, `{"message":"MISSING_ARG_CLUSTER"}`, string(body))
+	test.Equal(t, true, nsqd2.GetTopic("only_in_b").IsPaused())
+
+	code, body = get("/api/clusters", "")
+	test.Equal(t, 200, code)
+	var cr struct {
+		Clusters []*ClusterSummary `json:"clusters"`
+	}
+	err = json.Unmarshal(body, &cr)
+	test.Nil(t, err)
+	test.Equal(t, 2, len(cr.Clusters))
+	test.Equal(t, "default", cr.Clusters[0].Name)
+	test.Equal(t, 1, cr.Clusters[0].TopicCount)
+	test.Equal(t, "b", cr.Clusters[1].Name)
+	test.Equal(t, 1, cr.Clusters[1].NodeCount)
+	test.Equal(t, 1, cr.Clusters[1].TopicCount)
+	test.Equal(t, 1, cr.Clusters[1].ChannelCount)
+}
+
+func TestHTTPSync(t *testing.T) {
+	dataPath, nsqds, nsqlookupds, nsqadmin1 := bootstrapNSQCluster(t)
+	defer os.RemoveAll(dataPath)
+	defer nsqds[0].Exit()
+	defer nsqlookupds[0].Exit()
+	defer nsqadmin1.Exit()
+
+	topicName := "test_sync" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqds[0].GetTopic(topicName)
+	topic.GetChannel("old")
+	time.Sleep(100 * time.Millisecond)
+
+	state := fmt.Sprintf(`
+prune: true
+topics:
+  - name: %s
+    paused: true
+    channels:
+      - name: new
+        labels: {team: ops}
+`, topicName)
+
+	type syncResponse struct {
+		Changes []*SyncChange `json:"changes"`
+		Plan    string        `json:"plan"`
+		Applied bool          `json:"applied"`
+	}
+	post := func(query string, body string) (int, *syncResponse) {
+		url := fmt.Sprintf("http://%s/api/sync%s", nsqadmin1.RealHTTPAddr(), query)
+		resp, err := http.Post(url, "application/x-yaml", strings.NewReader(body))
+		test.Nil(t, err)
+		defer resp.Body.Close()
+		var sr syncResponse
+		data, _ := ioutil.ReadAll(resp.Body)
+		json.Unmarshal(data, &sr)
+		return resp.StatusCode, &sr
+	}
+	actions := func(sr *syncResponse) []string {
+		var s []string
+		for _, sc := range sr.Changes {
+			s = append(s, sc.String())
+		}
+		return s
+	}
+
+	code, _ := post("", "topics: [{name: \"a b\"}]")
+	test.Equal(t, 400, code)
+
+	// a truncated state could prune whatever came after the cut
+	code, _ = post("", "prune: true\n"+strings.Repeat("#", syncMaxBodySize))
+	test.Equal(t, 413, code)
+
+	plan := []string{
+		"~ pause_topic " + topicName,
+		"+ create_channel " + topicName + "/new",
+		"~ set_channel_labels " + topicName + "/new {team=ops}",
+		"- delete_channel " + topicName + "/old",
+	}
+	code, sr := post("", state)
+	test.Equal(t, 200, code)
+	test.Equal(t, false, sr.Applied)
+	test.Equal(t, plan, actions(sr))
+	test.Equal(t, false, topic.IsPaused())
+	reviewed := sr.Plan
+
+	// applying requires the reviewed plan, and is refused once it changed
+	code, _ = post("?apply=true", state)
+	test.Equal(t, 400, code)
+	topic.Pause()
+	code, _ = post("?apply=true&plan="+reviewed, state)
+	test.Equal(t, 409, code)
+	_, err := topic.GetExistingChannel("old")
+	test.Nil(t, err)
+	topic.UnPause()
+
+	code, sr = post("?apply=true&plan="+reviewed, state)
+	test.Equal(t, 200, code)
+	test.Equal(t, true, sr.Applied)
+	test.Equal(t, plan, actions(sr))
+	for _, sc := range sr.Changes {
+		test.Equal(t, "", sc.Error)
+	}
+	test.Equal(t, true, topic.IsPaused())
+	channel, err := topic.GetExistingChannel("new")
+	test.Nil(t, err)
+	test.Equal(t, "ops", channel.GetMetadata().Labels["team"])
+	_, err = topic.GetExistingChannel("old")
+	test.NotNil(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+	code, sr = post("", state)
+	test.Equal(t, 200, code)
+	test.Equal(t, 0, len(sr.Changes))
+}
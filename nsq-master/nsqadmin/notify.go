@@ -11,6 +11,7 @@ import (
 
 type AdminAction struct {
 	Action    string `json:"action"`
+	Cluster   string `json:"cluster,omitempty"` // the --clusters-file cluster the action was performed on
 	Topic     string `json:"topic"`
 	Channel   string `json:"channel,omitempty"`
 	Node      string `json:"node,omitempty"`
@@ -44,6 +45,11 @@ func (s *httpServer) notifyAdminAction(action, topic, channel, node string, req
 	}
 	via, _ := os.Hostname()
 
+	var clusterName string
+	if c := s.cluster(req); c != nil && c.config != nil {
+		clusterName = c.name
+	}
+
 	u := url.URL{
 		Scheme:   "http",
 		Host:     req.Host,
@@ -56,6 +62,7 @@ func (s *httpServer) notifyAdminAction(action, topic, channel, node string, req
 
 	a := &AdminAction{
 		Action:    action,
+		Cluster:   clusterName,
 		Topic:     topic,
 		Channel:   channel,
 		Node:      node,
@@ -28,21 +28,64 @@ type NSQAdmin struct {
 	httpListener        net.Listener
 	waitGroup           util.WaitGroupWrapper
 	notifications       chan *AdminAction
+	exitChan            chan int
+	clusterConfigs      []*ClusterConfig
+	alertRules          []*AlertRule
+	history             *statsHistory
+	policy              *Policy
 	graphiteURL         *url.URL
 	httpClientTLSConfig *tls.Config
 }
 
 func New(opts *Options) (*NSQAdmin, error) {
+	n, err := newNSQAdmin(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	n.logf(LOG_INFO, version.String("nsqadmin"))
+
+	n.httpListener, err = net.Listen("tcp", n.getOpts().HTTPAddress)
+	if err != nil {
+		return nil, fmt.Errorf("listen (%s) failed - %s", n.getOpts().HTTPAddress, err)
+	}
+
+	if opts.StatsHistoryFile != "" {
+		n.history, err = newStatsHistory(n, opts.StatsHistoryFile)
+		if err != nil {
+			n.httpListener.Close()
+			return nil, fmt.Errorf("failed to open --stats-history-file (%s) - %s", opts.StatsHistoryFile, err)
+		}
+	}
+
+	return n, nil
+}
+
+// newNSQAdmin validates the options and loads the files they refer to,
+// without listening
+func newNSQAdmin(opts *Options) (*NSQAdmin, error) {
 	if opts.Logger == nil {
 		opts.Logger = log.New(os.Stderr, opts.LogPrefix, log.Ldate|log.Ltime|log.Lmicroseconds)
 	}
 
 	n := &NSQAdmin{
 		notifications: make(chan *AdminAction),
+		exitChan:      make(chan int),
 	}
 	n.swapOpts(opts)
 
-	if len(opts.NSQDHTTPAddresses) == 0 && len(opts.NSQLookupdHTTPAddresses) == 0 {
+	if opts.ClustersFile != "" {
+		clusters, err := LoadClusters(opts.ClustersFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load --clusters-file (%s) - %s", opts.ClustersFile, err)
+		}
+		if len(clusters) == 0 {
+			return nil, fmt.Errorf("--clusters-file (%s) has no [[cluster]]", opts.ClustersFile)
+		}
+		n.clusterConfigs = clusters
+	}
+
+	if len(opts.NSQDHTTPAddresses) == 0 && len(opts.NSQLookupdHTTPAddresses) == 0 && len(n.clusterConfigs) == 0 {
 		return nil, errors.New("--nsqd-http-address or --lookupd-http-address required")
 	}
 
@@ -112,16 +155,31 @@ func New(opts *Options) (*NSQAdmin, error) {
 		}
 	}
 
-	opts.BasePath = normalizeBasePath(opts.BasePath)
+	if opts.AclPolicyFile != "" {
+		policy, err := LoadPolicy(opts.AclPolicyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load --acl-policy-file (%s) - %s", opts.AclPolicyFile, err)
+		}
+		n.policy = policy
+	}
 
-	n.logf(LOG_INFO, version.String("nsqadmin"))
+	if opts.AlertRulesFile != "" {
+		if opts.AlertInterval <= 0 {
+			return nil, errors.New("--alert-interval must be positive")
+		}
+		rules, err := LoadAlertRules(opts.AlertRulesFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load --alert-rules-file (%s) - %s", opts.AlertRulesFile, err)
+		}
+		n.alertRules = rules
+	}
 
-	var err error
-	n.httpListener, err = net.Listen("tcp", n.getOpts().HTTPAddress)
-	if err != nil {
-		return nil, fmt.Errorf("listen (%s) failed - %s", n.getOpts().HTTPAddress, err)
+	if opts.StatsHistoryFile != "" && (opts.StatsHistoryInterval <= 0 || opts.StatsHistoryRetention <= 0) {
+		return nil, errors.New("--stats-history-interval and --stats-history-retention must be positive")
 	}
 
+	opts.BasePath = normalizeBasePath(opts.BasePath)
+
 	return n, nil
 }
 
@@ -180,6 +238,17 @@ func (n *NSQAdmin) Main() error {
 	}
 
 	httpServer := NewHTTPServer(&Context{n})
+	// alerts and the stats history are kept for every cluster
+	for _, c := range httpServer.clusters {
+		c := c
+		if len(n.alertRules) > 0 {
+			c.alerter = newAlerter(n, n.alertRules, c)
+			n.waitGroup.Wrap(c.alerter.loop)
+		}
+		if n.history != nil {
+			n.waitGroup.Wrap(func() { n.history.loop(c) })
+		}
+	}
 	n.waitGroup.Wrap(func() {
 		exitFunc(http_api.Serve(n.httpListener, http_api.CompressHandler(httpServer), "HTTP", n.logf))
 	})
@@ -194,5 +263,9 @@ func (n *NSQAdmin) Exit() {
 		n.httpListener.Close()
 	}
 	close(n.notifications)
+	close(n.exitChan)
 	n.waitGroup.Wait()
+	if n.history != nil {
+		n.history.Close()
+	}
 }
@@ -23,8 +23,14 @@ type Options struct {
 
 	StatsdInterval time.Duration `flag:"statsd-interval"`
 
+	StatsHistoryFile      string        `flag:"stats-history-file"`
+	StatsHistoryInterval  time.Duration `flag:"stats-history-interval"`
+	StatsHistoryRetention time.Duration `flag:"stats-history-retention"`
+
 	NSQLookupdHTTPAddresses []string `flag:"lookupd-http-address" cfg:"nsqlookupd_http_addresses"`
 	NSQDHTTPAddresses       []string `flag:"nsqd-http-address" cfg:"nsqd_http_addresses"`
+	NSQLookupdHTTPAuthToken string   `flag:"lookupd-http-auth-token"`
+	ClustersFile            string   `flag:"clusters-file"`
 
 	HTTPClientConnectTimeout time.Duration `flag:"http-client-connect-timeout"`
 	HTTPClientRequestTimeout time.Duration `flag:"http-client-request-timeout"`
@@ -38,8 +44,14 @@ type Options struct {
 
 	NotificationHTTPEndpoint string `flag:"notification-http-endpoint"`
 
+	AlertRulesFile   string        `flag:"alert-rules-file"`
+	AlertInterval    time.Duration `flag:"alert-interval"`
+	AlertWebhookURLs []string      `flag:"alert-webhook-url" cfg:"alert_webhook_urls"`
+	AlertTopic       string        `flag:"alert-topic"`
+
 	AclHttpHeader string   `flag:"acl-http-header"`
 	AdminUsers    []string `flag:"admin-user" cfg:"admin_users"`
+	AclPolicyFile string   `flag:"acl-policy-file"`
 }
 
 func NewOptions() *Options {
@@ -52,9 +64,12 @@ func NewOptions() *Options {
 		StatsdCounterFormat:      "stats.counters.%s.count",
 		StatsdGaugeFormat:        "stats.gauges.%s",
 		StatsdInterval:           60 * time.Second,
+		StatsHistoryInterval:     60 * time.Second,
+		StatsHistoryRetention:    24 * time.Hour,
 		HTTPClientConnectTimeout: 2 * time.Second,
 		HTTPClientRequestTimeout: 5 * time.Second,
 		AllowConfigFromCIDR:      "127.0.0.1/8",
+		AlertInterval:            30 * time.Second,
 		AclHttpHeader:            "X-Forwarded-User",
 		AdminUsers:               []string{},
 	}
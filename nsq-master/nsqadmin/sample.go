@@ -0,0 +1,148 @@
+package nsqadmin
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+	"github.com/nsqio/nsq/internal/clusterinfo"
+)
+
+// limits of /api/sample, which ties up a connection to every nsqd of the
+// topic for its duration
+const (
+	sampleDefaultCount   = 10
+	sampleMaxCount       = 100
+	sampleDefaultTimeout = 30 * time.Second
+	sampleMaxTimeout     = 5 * time.Minute
+	sampleMaxBodySize    = 4096
+)
+
+// sampleChannelName returns a random #ephemeral channel name, so that
+// sampling neither steals messages from nor leaves a backlog behind for
+// existing consumers
+func sampleChannelName() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return "nsqadmin_sample_" + hex.EncodeToString(b) + "#ephemeral"
+}
+
+// sampler consumes the messages of a topic through an ephemeral channel on
+// each of its nsqd
+type sampler struct {
+	nsqadmin  *NSQAdmin
+	consumer  *nsq.Consumer
+	httpAddrs map[string]string
+	msgChan   chan *nsq.Message
+	stopChan  chan struct{}
+	stopOnce  sync.Once
+}
+
+// newSampler subscribes to the topic on each of the producers, messages
+// published from then on are received by run
+func (n *NSQAdmin) newSampler(producers clusterinfo.Producers, topic string, count int) (*sampler, error) {
+	if len(producers) == 0 {
+		return nil, errors.New("no nsqd to sample from")
+	}
+
+	cfg := nsq.NewConfig()
+	cfg.MaxInFlight = count
+	// nsqd that use TLS for HTTP likely do for TCP too, maybe requiring it
+	// (nsqd without TLS just don't upgrade the connection)
+	opts := n.getOpts()
+	if opts.HTTPClientTLSCert != "" || opts.HTTPClientTLSRootCAFile != "" || opts.HTTPClientTLSInsecureSkipVerify {
+		cfg.TlsV1 = true
+		cfg.TlsConfig = n.httpClientTLSConfig
+	}
+	consumer, err := nsq.NewConsumer(topic, sampleChannelName(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	consumer.SetLogger(opts.Logger, nsq.LogLevelWarning)
+
+	s := &sampler{
+		nsqadmin:  n,
+		consumer:  consumer,
+		httpAddrs: make(map[string]string, len(producers)),
+		msgChan:   make(chan *nsq.Message),
+		stopChan:  make(chan struct{}),
+	}
+	consumer.AddHandler(nsq.HandlerFunc(func(m *nsq.Message) error {
+		select {
+		case s.msgChan <- m:
+		case <-s.stopChan:
+		}
+		return nil
+	}))
+
+	// messages carry the TCP address of the nsqd they came from, the UI
+	// links to nodes by HTTP address
+	for _, p := range producers {
+		s.httpAddrs[p.TCPAddress()] = p.HTTPAddress()
+	}
+
+	var errs []error
+	for addr := range s.httpAddrs {
+		err := consumer.ConnectToNSQD(addr)
+		if err != nil {
+			n.logf(LOG_WARN, "SAMPLE: failed to connect to nsqd %s - %s", addr, err)
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == len(s.httpAddrs) {
+		s.Stop()
+		return nil, fmt.Errorf("failed to connect to any nsqd: %s", clusterinfo.ErrList(errs))
+	}
+	return s, nil
+}
+
+// run calls fn with every message received, until fn has been called count
+// times, the timeout expires or done is closed
+func (s *sampler) run(count int, timeout time.Duration, done <-chan struct{},
+	fn func(*clusterinfo.PeekedMessage) error) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for i := 0; i < count; i++ {
+		select {
+		case m := <-s.msgChan:
+			body := m.Body
+			truncated := false
+			if len(body) > sampleMaxBodySize {
+				body = body[:sampleMaxBodySize]
+				truncated = true
+			}
+			err := fn(&clusterinfo.PeekedMessage{
+				Node:      s.httpAddrs[m.NSQDAddress],
+				ID:        string(m.ID[:]),
+				Timestamp: m.Timestamp,
+				Attempts:  m.Attempts,
+				Size:      len(m.Body),
+				Body:      string(body),
+				Truncated: truncated,
+			})
+			if err != nil {
+				return err
+			}
+		case <-timer.C:
+			return nil
+		case <-done:
+			return nil
+		case <-s.nsqadmin.exitChan:
+			return nil
+		}
+	}
+	return nil
+}
+
+// Stop closes the connections, which deletes the ephemeral channels
+func (s *sampler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopChan)
+		s.consumer.Stop()
+		<-s.consumer.StopChan
+	})
+}
@@ -7,11 +7,14 @@ var AppState = Backbone.Model.extend({
             'VERSION': VERSION,
             'GRAPHITE_URL': GRAPHITE_URL,
             'GRAPH_ENABLED': GRAPH_ENABLED,
+            'HISTORY_ENABLED': HISTORY_ENABLED,
             'STATSD_INTERVAL': STATSD_INTERVAL,
             'STATSD_COUNTER_FORMAT': STATSD_COUNTER_FORMAT,
             'STATSD_GAUGE_FORMAT': STATSD_GAUGE_FORMAT,
             'STATSD_PREFIX': STATSD_PREFIX,
             'NSQLOOKUPD': NSQLOOKUPD,
+            'CLUSTER': CLUSTER,
+            'CLUSTERS': CLUSTERS,
             'graph_interval': '2h',
             'IS_ADMIN': IS_ADMIN,
             'BASE_PATH': BASE_PATH
@@ -29,6 +32,11 @@ var AppState = Backbone.Model.extend({
         var def = this.get('GRAPH_ENABLED') ? '2h' : 'off';
         var interval = qp['t'] || localStorage.getItem('graph_interval') || def;
         this.set('graph_interval', interval);
+
+        // a link to ?cluster= switches to that cluster for the API requests too
+        if (qp['cluster']) {
+            this.setCluster(this.get('CLUSTER'));
+        }
     },
 
     basePath: function(p) {
@@ -39,7 +47,21 @@ var AppState = Backbone.Model.extend({
     },
 
     apiPath: function(p) {
-        return this.basePath('/api' + p);
+        // every API request names its cluster, the cookie is shared by all
+        // tabs and nsqadmin refuses actions without it
+        var url = this.basePath('/api' + p);
+        if (!this.get('CLUSTER')) {
+            return url;
+        }
+        return url + (url.indexOf('?') === -1 ? '?' : '&') +
+            'cluster=' + encodeURIComponent(this.get('CLUSTER'));
+    },
+
+    setCluster: function(name) {
+        // nsqadmin routes requests to the cluster of this cookie
+        document.cookie = 'cluster=' + encodeURIComponent(name) +
+            '; path=' + this.basePath('/') + '; max-age=31536000';
+        this.set('CLUSTER', name);
     }
 });
 
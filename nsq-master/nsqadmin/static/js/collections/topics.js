@@ -15,12 +15,22 @@ var Topics = Backbone.Collection.extend({
     },
 
     url: function() {
-        return AppState.apiPath('/topics');
+        var p = '/topics?metadata=true';
+        if (this.label) {
+            p += '&label=' + encodeURIComponent(this.label);
+        }
+        return AppState.apiPath(p);
     },
 
     parse: function(resp) {
+        var metadata = resp['metadata'] || {};
         var topics = _.map(resp['topics'], function(name) {
-            return {'name': name};
+            var m = metadata[name] || {};
+            return {
+                'name': name,
+                'description': m['description'],
+                'labels': m['labels']
+            };
         });
         return topics;
     }
@@ -120,6 +120,11 @@ Handlebars.registerHelper('iflteq', function(a, b, options) {
     return (a <= b) ? options.fn(this) : options.inverse(this);
 });
 
+// ifcan renders the block if the action is one of the permitted ones
+Handlebars.registerHelper('ifcan', function(permissions, action, options) {
+    return _.contains(permissions || [], action) ? options.fn(this) : options.inverse(this);
+});
+
 Handlebars.registerHelper('length', function(xs) {
     return xs.length;
 });
@@ -224,6 +229,10 @@ Handlebars.registerHelper('nanotohuman', function(n) {
     return s;
 });
 
+Handlebars.registerHelper('nanotodate', function(n) {
+    return new Date(n / 1000000).toISOString();
+});
+
 Handlebars.registerHelper('sparkline', function(typ, node, ns1, ns2, key) {
     var q = {
         'colorList': genColorList(typ, key),
@@ -8,8 +8,8 @@ var Node = Backbone.Model.extend({ //eslint-disable-line no-undef
         Backbone.Model.prototype.constructor.apply(this, arguments);
     },
 
-    urlRoot: function() {
-        return AppState.apiPath('/nodes');
+    url: function() {
+        return AppState.apiPath('/nodes/' + encodeURIComponent(this.get('name')));
     },
 
     tombstoneTopic: function(topic) {
@@ -0,0 +1,46 @@
+var _ = require('underscore');
+var $ = require('jquery');
+
+var AppState = require('../app_state');
+var Pubsub = require('../lib/pubsub');
+var BaseView = require('./base');
+
+var AlertsView = BaseView.extend({
+    className: 'alerts container-fluid',
+
+    template: require('./spinner.hbs'),
+
+    initialize: function() {
+        BaseView.prototype.initialize.apply(this, arguments);
+        this.poll();
+    },
+
+    remove: function() {
+        clearTimeout(this.poller);
+        BaseView.prototype.remove.apply(this, arguments);
+    },
+
+    poll: function() {
+        $.ajax(AppState.apiPath('/alerts'))
+            .done(function(data) {
+                if (this.removed) {
+                    return;
+                }
+                this.template = require('./alerts.hbs');
+                this.render({
+                    'rules': data['rules'],
+                    'alerts': _.map(data['alerts'], function(a) {
+                        // nanotodate takes nanoseconds
+                        return _.extend(a, {'since_ns': a['since'] * 1000000000});
+                    }),
+                    'last_evaluated_ns': data['last_evaluated'] * 1000000000,
+                    'message': data['message']
+                });
+                this.poller = setTimeout(this.poll.bind(this), 10000);
+            }.bind(this))
+            .fail(this.handleViewError.bind(this))
+            .always(Pubsub.trigger.bind(Pubsub, 'view:ready'));
+    }
+});
+
+module.exports = AlertsView;
@@ -50,6 +50,8 @@ var BaseView = Backbone.View.extend({
             'graph_interval': AppState.get('graph_interval'),
             'graph_active': AppState.get('GRAPH_ENABLED') &&
                 AppState.get('graph_interval') !== 'off',
+            'history_active': AppState.get('HISTORY_ENABLED') &&
+                !(AppState.get('GRAPH_ENABLED') && AppState.get('graph_interval') !== 'off'),
             'nsqlookupd': AppState.get('NSQLOOKUPD'),
             'version': AppState.get('VERSION')
         };
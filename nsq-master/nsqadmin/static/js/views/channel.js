@@ -8,6 +8,7 @@ var Pubsub = require('../lib/pubsub');
 var AppState = require('../app_state');
 
 var BaseView = require('./base');
+var HistoryView = require('./history');
 
 var ChannelView = BaseView.extend({
     className: 'channel container-fluid',
@@ -15,7 +16,9 @@ var ChannelView = BaseView.extend({
     template: require('./spinner.hbs'),
 
     events: {
-        'click .channel-actions button': 'channelAction'
+        'click .channel-actions button': 'channelAction',
+        'click .peek-messages button': 'peekMessages',
+        'click .message-actions button': 'messageAction'
     },
 
     initialize: function() {
@@ -31,6 +34,15 @@ var ChannelView = BaseView.extend({
             .always(Pubsub.trigger.bind(Pubsub, 'view:ready'));
     },
 
+    postRender: function(ctx) {
+        if (ctx['history_active'] && this.$('.history-charts').length) {
+            this.appendSubview(new HistoryView({
+                'topic': this.model.get('topic'),
+                'channel': this.model.get('name')
+            }), '.history-charts');
+        }
+    },
+
     channelAction: function(e) {
         e.preventDefault();
         e.stopPropagation();
@@ -56,6 +68,46 @@ var ChannelView = BaseView.extend({
                     .fail(this.handleAJAXError.bind(this));
             }
         }.bind(this));
+    },
+
+    messageAction: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        var action = $(e.currentTarget).data('action');
+        var form = this.$('.message-actions');
+        var ids = form.find('[name=ids]').val().split(',')
+            .map(function(id) { return id.trim(); })
+            .filter(function(id) { return id !== ''; });
+        var body = {
+            'action': action,
+            'ids': ids,
+            'body_contains': form.find('[name=body_contains]').val(),
+            'body_regexp': form.find('[name=body_regexp]').val(),
+            'to_topic': form.find('[name=to_topic]').val()
+        };
+        var txt = 'Are you sure you want to <strong>' +
+            action.replace('_', ' ') + '</strong> matching in <em>' +
+            this.model.get('topic') + '/' + this.model.get('name') + '</em>?';
+        bootbox.confirm(txt, function(result) {
+            if (result !== true) {
+                return;
+            }
+            $.post(this.model.url(), JSON.stringify(body))
+                .done(function() { window.location.reload(true); })
+                .fail(this.handleAJAXError.bind(this));
+        }.bind(this));
+    },
+
+    peekMessages: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        var url = AppState.apiPath('/peek/' + encodeURIComponent(this.model.get('topic')) +
+            '/' + encodeURIComponent(this.model.get('name')));
+        $.get(url)
+            .done(function(data) {
+                this.$('.peek-results').html(require('./peek.hbs')(data));
+            }.bind(this))
+            .fail(this.handleAJAXError.bind(this));
     }
 });
 
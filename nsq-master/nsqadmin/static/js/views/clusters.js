@@ -0,0 +1,38 @@
+var $ = require('jquery');
+
+var AppState = require('../app_state');
+var Pubsub = require('../lib/pubsub');
+var BaseView = require('./base');
+
+var ClustersView = BaseView.extend({
+    className: 'clusters container-fluid',
+
+    template: require('./spinner.hbs'),
+
+    events: {
+        'click .clusters-table a[data-cluster]': 'onClusterClick'
+    },
+
+    initialize: function() {
+        BaseView.prototype.initialize.apply(this, arguments);
+        $.ajax(AppState.apiPath('/clusters'))
+            .done(function(data) {
+                this.template = require('./clusters.hbs');
+                this.render({
+                    'clusters': data['clusters'],
+                    'current': AppState.get('CLUSTER')
+                });
+            }.bind(this))
+            .fail(this.handleViewError.bind(this))
+            .always(Pubsub.trigger.bind(Pubsub, 'view:ready'));
+    },
+
+    onClusterClick: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        AppState.setCluster($(e.currentTarget).data('cluster'));
+        window.location = AppState.basePath('/');
+    }
+});
+
+module.exports = ClustersView;
@@ -11,7 +11,8 @@ var HeaderView = BaseView.extend({
     template: require('./header.hbs'),
 
     events: {
-        'click .dropdown-menu li': 'onGraphIntervalClick'
+        'click .graph-intervals li': 'onGraphIntervalClick',
+        'click .cluster-switcher li a': 'onClusterClick'
     },
 
     initialize: function() {
@@ -22,7 +23,10 @@ var HeaderView = BaseView.extend({
     getRenderCtx: function() {
         return _.extend(BaseView.prototype.getRenderCtx.apply(this, arguments), {
             'graph_intervals': ['1h', '2h', '12h', '24h', '48h', '168h', 'off'],
-            'graph_interval': AppState.get('graph_interval')
+            'graph_interval': AppState.get('graph_interval'),
+            'cluster': AppState.get('CLUSTER'),
+            'clusters': AppState.get('CLUSTERS'),
+            'multi_cluster': AppState.get('CLUSTERS').length > 1
         });
     },
 
@@ -34,6 +38,13 @@ var HeaderView = BaseView.extend({
     onGraphIntervalClick: function(e) {
         e.stopPropagation();
         AppState.set('graph_interval', $(e.target).text());
+    },
+
+    onClusterClick: function(e) {
+        e.preventDefault();
+        AppState.setCluster($(e.target).data('cluster'));
+        // every view shows the data of the previous cluster
+        window.location = AppState.basePath('/');
     }
 });
 
@@ -0,0 +1,83 @@
+var _ = require('underscore');
+var $ = require('jquery');
+
+var AppState = require('../app_state');
+var BaseView = require('./base');
+
+var WIDTH = 300;
+var HEIGHT = 60;
+
+// chart draws the values as an SVG polyline scaled to the chart's size
+var chart = function(samples, key) {
+    var values = _.pluck(samples, key);
+    var max = _.max(values.concat([0]));
+    var first = samples.length ? samples[0]['timestamp'] : 0;
+    var last = samples.length ? samples[samples.length - 1]['timestamp'] : 0;
+    var span = Math.max(last - first, 1);
+    var points = _.map(samples, function(s) {
+        var x = (s['timestamp'] - first) / span * WIDTH;
+        var y = HEIGHT - (max > 0 ? s[key] / max * HEIGHT : 0);
+        return x.toFixed(1) + ',' + y.toFixed(1);
+    });
+    return {
+        'key': key,
+        'points': points.join(' '),
+        'max': max,
+        'current': values.length ? values[values.length - 1] : 0
+    };
+};
+
+var HistoryView = BaseView.extend({
+    className: 'history',
+
+    template: require('./spinner.hbs'),
+
+    events: {
+        'click .history-period button': 'onPeriodClick'
+    },
+
+    initialize: function() {
+        BaseView.prototype.initialize.apply(this, arguments);
+        this.period = '1h';
+        this.keys = ['depth', 'message_rate', 'client_count'];
+        if (this.options['channel']) {
+            this.keys.splice(1, 0, 'in_flight_count');
+        }
+        this.fetch();
+    },
+
+    url: function() {
+        var p = '/history/' + encodeURIComponent(this.options['topic']);
+        if (this.options['channel']) {
+            p += '/' + encodeURIComponent(this.options['channel']);
+        }
+        return AppState.apiPath(p + '?period=' + this.period);
+    },
+
+    fetch: function() {
+        $.get(this.url())
+            .done(function(data) {
+                var samples = data['samples'];
+                this.template = require('./history.hbs');
+                this.render({
+                    'period': this.period,
+                    'periods': ['1h', '6h', '24h'],
+                    'width': WIDTH,
+                    'height': HEIGHT,
+                    'samples': samples.length,
+                    'charts': _.map(this.keys, function(key) {
+                        return chart(samples, key);
+                    })
+                });
+            }.bind(this))
+            .fail(this.handleAJAXError.bind(this));
+    },
+
+    onPeriodClick: function(e) {
+        e.preventDefault();
+        this.period = $(e.currentTarget).data('period');
+        this.fetch();
+    }
+});
+
+module.exports = HistoryView;
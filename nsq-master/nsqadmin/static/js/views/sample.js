@@ -0,0 +1,85 @@
+var $ = require('jquery');
+
+var AppState = require('../app_state');
+var BaseView = require('./base');
+
+// SampleView streams messages published to a topic, through an ephemeral
+// channel that nsqadmin attaches for the duration of the sample
+var SampleView = BaseView.extend({
+    className: 'sample',
+
+    template: require('./sample.hbs'),
+
+    events: {
+        'submit .sample-form': 'onSubmit',
+        'click .sample-form [data-action=stop]': 'finish'
+    },
+
+    initialize: function() {
+        BaseView.prototype.initialize.apply(this, arguments);
+        this.count = 10;
+        this.messages = [];
+    },
+
+    getRenderCtx: function(data) {
+        return BaseView.prototype.getRenderCtx.call(this, $.extend({
+            'count': this.count,
+            'running': !!this.source,
+            'done': this.done,
+            'message': this.message,
+            'messages': this.messages
+        }, data));
+    },
+
+    onSubmit: function(e) {
+        e.preventDefault();
+        this.count = parseInt(this.$('input[name=count]').val(), 10) || 10;
+        this.start();
+    },
+
+    start: function() {
+        this.stop();
+        this.messages = [];
+        this.message = '';
+        this.done = false;
+        var url = AppState.apiPath('/sample/' + encodeURIComponent(this.options['topic']) +
+            '?count=' + this.count);
+        this.source = new window.EventSource(url);
+        this.source.onmessage = function(e) {
+            this.messages.push(JSON.parse(e.data));
+            this.render();
+        }.bind(this);
+        this.source.addEventListener('done', function(e) {
+            this.message = JSON.parse(e.data)['message'];
+            this.finish();
+        }.bind(this));
+        this.source.onerror = function() {
+            // the stream ended without a done event (eg. an error response)
+            if (this.source && this.source.readyState !== window.EventSource.CONNECTING) {
+                this.message = 'ERROR: failed to sample messages';
+            }
+            this.finish();
+        }.bind(this);
+        this.render();
+    },
+
+    finish: function() {
+        this.stop();
+        this.done = true;
+        this.render();
+    },
+
+    stop: function() {
+        if (this.source) {
+            this.source.close();
+            this.source = null;
+        }
+    },
+
+    remove: function() {
+        this.stop();
+        BaseView.prototype.remove.apply(this, arguments);
+    }
+});
+
+module.exports = SampleView;
@@ -8,6 +8,8 @@ var Pubsub = require('../lib/pubsub');
 var AppState = require('../app_state');
 
 var BaseView = require('./base');
+var HistoryView = require('./history');
+var SampleView = require('./sample');
 
 var TopicView = BaseView.extend({
     className: 'topic container-fluid',
@@ -15,7 +17,9 @@ var TopicView = BaseView.extend({
     template: require('./spinner.hbs'),
 
     events: {
-        'click .topic-actions button': 'topicAction'
+        'click .topic-actions button': 'topicAction',
+        'click .peek-messages button': 'peekMessages',
+        'submit .publish-message form': 'publishMessage'
     },
 
     initialize: function() {
@@ -31,6 +35,15 @@ var TopicView = BaseView.extend({
             .always(Pubsub.trigger.bind(Pubsub, 'view:ready'));
     },
 
+    postRender: function(ctx) {
+        if (ctx['history_active'] && this.$('.history-charts').length) {
+            this.appendSubview(new HistoryView({'topic': this.model.get('name')}), '.history-charts');
+        }
+        if (this.$('.sample-messages').length) {
+            this.appendSubview(new SampleView({'topic': this.model.get('name')}), '.sample-messages');
+        }
+    },
+
     topicAction: function(e) {
         e.preventDefault();
         e.stopPropagation();
@@ -50,6 +63,33 @@ var TopicView = BaseView.extend({
                     .fail(this.handleAJAXError.bind(this));
             }
         }.bind(this));
+    },
+
+    peekMessages: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        var url = AppState.apiPath('/peek/' + encodeURIComponent(this.model.get('name')));
+        $.get(url)
+            .done(function(data) {
+                this.$('.peek-results').html(require('./peek.hbs')(data));
+            }.bind(this))
+            .fail(this.handleAJAXError.bind(this));
+    },
+
+    publishMessage: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        var $form = $(e.currentTarget);
+        var message = $form.find('textarea[name=message]').val();
+        if (!message) {
+            return;
+        }
+        var url = AppState.apiPath('/publish/' + encodeURIComponent(this.model.get('name')));
+        $.post(url, JSON.stringify({'message': message}))
+            .done(function(data) {
+                $form.find('.publish-result').text('published to ' + data['node']);
+            })
+            .fail(this.handleAJAXError.bind(this));
     }
 });
 
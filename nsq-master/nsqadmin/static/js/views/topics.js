@@ -1,3 +1,5 @@
+var $ = require('jquery');
+
 var Pubsub = require('../lib/pubsub');
 var AppState = require('../app_state');
 
@@ -9,17 +11,32 @@ var TopicsView = BaseView.extend({
 
     template: require('./spinner.hbs'),
 
+    events: {
+        'submit .label-filter form': 'filterByLabel'
+    },
+
     initialize: function() {
         BaseView.prototype.initialize.apply(this, arguments);
         this.listenTo(AppState, 'change:graph_interval', this.render);
         this.collection = new Topics();
-        this.collection.fetch()
+        this.fetch();
+    },
+
+    fetch: function() {
+        this.collection.fetch({'reset': true})
             .done(function(data) {
                 this.template = require('./topics.hbs');
-                this.render({'message': data['message']});
+                this.render({'message': data['message'], 'label': this.collection.label});
             }.bind(this))
             .fail(this.handleViewError.bind(this))
             .always(Pubsub.trigger.bind(Pubsub, 'view:ready'));
+    },
+
+    filterByLabel: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        this.collection.label = $.trim($(e.target.elements['label']).val());
+        this.fetch();
     }
 });
 
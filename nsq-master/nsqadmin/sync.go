@@ -0,0 +1,475 @@
+package nsqadmin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/nsqio/nsq/internal/clusterinfo"
+	"github.com/nsqio/nsq/internal/protocol"
+	"gopkg.in/yaml.v2"
+)
+
+// syncMaxBodySize limits the desired state POSTed to /api/sync
+const syncMaxBodySize = 1024 * 1024
+
+// DesiredState is the YAML document applied by /api/sync and --sync-file, eg.
+//
+//	prune: true             # delete the topics and channels not listed
+//	topics:
+//	  - name: orders
+//	    paused: false       # omitted paused and labels are left alone
+//	    labels: {team: checkout}
+//	    channels:
+//	      - name: billing
+//	        paused: true
+//
+// Pauses and labels are applied on the nsqd that have the topic, so those of
+// a topic not yet on any nsqd are applied by a later sync.
+type DesiredState struct {
+	Prune  bool           `yaml:"prune"`
+	Topics []DesiredTopic `yaml:"topics"`
+}
+
+type DesiredTopic struct {
+	Name     string            `yaml:"name"`
+	Paused   *bool             `yaml:"paused"`
+	Labels   map[string]string `yaml:"labels"`
+	Channels []DesiredChannel  `yaml:"channels"`
+}
+
+type DesiredChannel struct {
+	Name   string            `yaml:"name"`
+	Paused *bool             `yaml:"paused"`
+	Labels map[string]string `yaml:"labels"`
+}
+
+// ParseDesiredState parses and validates a desired state document
+func ParseDesiredState(data []byte) (*DesiredState, error) {
+	var ds DesiredState
+	err := yaml.UnmarshalStrict(data, &ds)
+	if err != nil {
+		return nil, err
+	}
+	topics := make(map[string]bool)
+	for i, t := range ds.Topics {
+		if !protocol.IsValidTopicName(t.Name) {
+			return nil, fmt.Errorf("topic %d - invalid name %q", i+1, t.Name)
+		}
+		if topics[t.Name] {
+			return nil, fmt.Errorf("topic %d (%s) - duplicate name", i+1, t.Name)
+		}
+		topics[t.Name] = true
+		channels := make(map[string]bool)
+		for j, c := range t.Channels {
+			if !protocol.IsValidChannelName(c.Name) {
+				return nil, fmt.Errorf("topic %d (%s) channel %d - invalid name %q", i+1, t.Name, j+1, c.Name)
+			}
+			if channels[c.Name] {
+				return nil, fmt.Errorf("topic %d (%s) channel %d (%s) - duplicate name", i+1, t.Name, j+1, c.Name)
+			}
+			channels[c.Name] = true
+		}
+	}
+	return &ds, nil
+}
+
+// SyncChange is a step of the plan that brings a cluster to a desired state
+type SyncChange struct {
+	Action  string            `json:"action"`
+	Topic   string            `json:"topic"`
+	Channel string            `json:"channel,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Error   string            `json:"error,omitempty"`
+
+	description string // kept when labels are set
+}
+
+func (sc *SyncChange) String() string {
+	sign := "~"
+	switch {
+	case strings.HasPrefix(sc.Action, "create_"):
+		sign = "+"
+	case strings.HasPrefix(sc.Action, "delete_"):
+		sign = "-"
+	}
+	name := sc.Topic
+	if sc.Channel != "" {
+		name += "/" + sc.Channel
+	}
+	s := fmt.Sprintf("%s %s %s", sign, sc.Action, name)
+	if strings.HasSuffix(sc.Action, "_labels") {
+		keys := make([]string, 0, len(sc.Labels))
+		for k := range sc.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, 0, len(keys))
+		for _, k := range keys {
+			pairs = append(pairs, k+"="+sc.Labels[k])
+		}
+		s += " {" + strings.Join(pairs, ", ") + "}"
+	}
+	return s
+}
+
+// planFingerprint identifies a plan, so that what's applied is the plan that
+// was reviewed rather than whatever a new diff against the cluster finds
+func planFingerprint(changes []*SyncChange) string {
+	h := sha256.New()
+	for _, sc := range changes {
+		fmt.Fprintln(h, sc)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// permission is the ACL action needed to apply the change
+func (sc *SyncChange) permission() string {
+	switch sc.Action {
+	case "create_topic", "create_channel", "set_topic_labels", "set_channel_labels":
+		return ActionCreate
+	case "delete_topic", "delete_channel":
+		return ActionDelete
+	default:
+		return ActionPause
+	}
+}
+
+// syncObject is the current state of a topic or channel, with an entry in
+// nodes for each nsqd that has it
+type syncObject struct {
+	nodes    []syncNode
+	metadata clusterinfo.Metadata
+}
+
+type syncNode struct {
+	paused bool
+	labels map[string]string
+}
+
+type syncTopic struct {
+	syncObject
+	channels map[string]*syncObject
+}
+
+var errSyncNeedsLookupd = errors.New("sync requires a cluster with nsqlookupd")
+
+// syncState returns the topics and channels registered with the cluster's
+// nsqlookupd, along with their paused flags and labels on each nsqd
+func (c *cluster) syncState() (map[string]*syncTopic, []string, error) {
+	var messages []string
+	lookupdHTTPAddrs := c.lookupdHTTPAddrs()
+	if len(lookupdHTTPAddrs) == 0 {
+		return nil, nil, errSyncNeedsLookupd
+	}
+
+	// a partial error is a warning, what an unreachable node alone knows
+	// about is at worst planned to be created again
+	warn := func(err error) error {
+		if _, ok := err.(clusterinfo.PartialErr); !ok {
+			return err
+		}
+		messages = append(messages, err.Error())
+		return nil
+	}
+
+	topics, err := c.ci.GetLookupdTopics(lookupdHTTPAddrs)
+	if err != nil {
+		if err := warn(err); err != nil {
+			return nil, nil, err
+		}
+	}
+	state := make(map[string]*syncTopic, len(topics))
+	for _, t := range topics {
+		channels, err := c.ci.GetLookupdTopicChannels(t, lookupdHTTPAddrs)
+		if err != nil {
+			if err := warn(err); err != nil {
+				return nil, nil, err
+			}
+		}
+		st := &syncTopic{channels: make(map[string]*syncObject, len(channels))}
+		for _, ch := range channels {
+			st.channels[ch] = &syncObject{}
+		}
+		state[t] = st
+	}
+
+	producers, err := c.ci.GetProducers(lookupdHTTPAddrs, nil)
+	if err != nil {
+		if err := warn(err); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(producers) == 0 {
+		return state, messages, nil
+	}
+	topicStats, _, err := c.ci.GetNSQDStats(producers, "", "", false)
+	if err != nil {
+		if err := warn(err); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, ts := range topicStats {
+		st, ok := state[ts.TopicName]
+		if !ok {
+			st = &syncTopic{channels: make(map[string]*syncObject)}
+			state[ts.TopicName] = st
+		}
+		st.add(ts.Paused, ts.Metadata)
+		for _, cs := range ts.Channels {
+			sc, ok := st.channels[cs.ChannelName]
+			if !ok {
+				sc = &syncObject{}
+				st.channels[cs.ChannelName] = sc
+			}
+			sc.add(cs.Paused, cs.Metadata)
+		}
+	}
+	return state, messages, nil
+}
+
+func (o *syncObject) add(paused bool, m clusterinfo.Metadata) {
+	o.nodes = append(o.nodes, syncNode{paused: paused, labels: m.Labels})
+	o.metadata.Merge(m)
+}
+
+// planSync returns the changes that bring the state to the desired one, in
+// the order they are to be applied
+func planSync(ds *DesiredState, state map[string]*syncTopic) []*SyncChange {
+	var changes []*SyncChange
+	desired := make(map[string]bool, len(ds.Topics))
+	for _, dt := range ds.Topics {
+		desired[dt.Name] = true
+		st, ok := state[dt.Name]
+		if !ok {
+			changes = append(changes, &SyncChange{Action: "create_topic", Topic: dt.Name})
+			st = &syncTopic{channels: make(map[string]*syncObject)}
+		}
+		changes = append(changes, planSyncObject(dt.Name, "", dt.Paused, dt.Labels, &st.syncObject)...)
+
+		desiredChannels := make(map[string]bool, len(dt.Channels))
+		for _, dc := range dt.Channels {
+			desiredChannels[dc.Name] = true
+			sc, ok := st.channels[dc.Name]
+			if !ok {
+				changes = append(changes, &SyncChange{Action: "create_channel", Topic: dt.Name, Channel: dc.Name})
+				// the channel is created (unpaused, unlabelled) on every
+				// nsqd that has the topic
+				sc = &syncObject{nodes: make([]syncNode, len(st.nodes))}
+			}
+			changes = append(changes, planSyncObject(dt.Name, dc.Name, dc.Paused, dc.Labels, sc)...)
+		}
+
+		if ds.Prune {
+			for _, name := range sortedKeys(st.channels) {
+				if !desiredChannels[name] && !isEphemeral(name) {
+					changes = append(changes, &SyncChange{Action: "delete_channel", Topic: dt.Name, Channel: name})
+				}
+			}
+		}
+	}
+
+	if ds.Prune {
+		names := make([]string, 0, len(state))
+		for name := range state {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !desired[name] && !isEphemeral(name) {
+				changes = append(changes, &SyncChange{Action: "delete_topic", Topic: name})
+			}
+		}
+	}
+	return changes
+}
+
+func planSyncObject(topic string, channel string, paused *bool, labels map[string]string,
+	o *syncObject) []*SyncChange {
+	var changes []*SyncChange
+	kind := "topic"
+	if channel != "" {
+		kind = "channel"
+	}
+	if labels != nil {
+		for _, n := range o.nodes {
+			if !labelsEqual(n.labels, labels) {
+				changes = append(changes, &SyncChange{
+					Action:      fmt.Sprintf("set_%s_labels", kind),
+					Topic:       topic,
+					Channel:     channel,
+					Labels:      labels,
+					description: o.metadata.Description,
+				})
+				break
+			}
+		}
+	}
+	if paused != nil {
+		for _, n := range o.nodes {
+			if n.paused != *paused {
+				action := "unpause_" + kind
+				if *paused {
+					action = "pause_" + kind
+				}
+				changes = append(changes, &SyncChange{Action: action, Topic: topic, Channel: channel})
+				break
+			}
+		}
+	}
+	return changes
+}
+
+func labelsEqual(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(m map[string]*syncObject) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func isEphemeral(name string) bool {
+	return strings.HasSuffix(name, "#ephemeral")
+}
+
+// planSync diffs the desired state against the cluster
+func (c *cluster) planSync(ds *DesiredState) ([]*SyncChange, []string, error) {
+	state, messages, err := c.syncState()
+	if err != nil {
+		return nil, nil, err
+	}
+	return planSync(ds, state), messages, nil
+}
+
+// applySync applies the changes in order, recording the error of those that
+// failed (a change that partially failed is a warning) and calling done
+// after each
+func (c *cluster) applySync(changes []*SyncChange, done func(*SyncChange)) ([]string, int) {
+	var messages []string
+	var failed int
+	for _, sc := range changes {
+		err := c.applySyncChange(sc)
+		if err != nil {
+			if _, ok := err.(clusterinfo.PartialErr); ok {
+				c.nsqadmin.logf(LOG_WARN, "SYNC: %s - %s", sc, err)
+				messages = append(messages, err.Error())
+			} else {
+				c.nsqadmin.logf(LOG_ERROR, "SYNC: failed to %s - %s", sc, err)
+				sc.Error = err.Error()
+				failed++
+			}
+		}
+		done(sc)
+	}
+	return messages, failed
+}
+
+func (c *cluster) applySyncChange(sc *SyncChange) error {
+	lookupdHTTPAddrs := c.lookupdHTTPAddrs()
+	nsqdHTTPAddrs := c.nsqdHTTPAddrs()
+	switch sc.Action {
+	case "create_topic", "create_channel":
+		return c.ci.CreateTopicChannel(sc.Topic, sc.Channel, lookupdHTTPAddrs)
+	case "set_topic_labels", "set_channel_labels":
+		m := clusterinfo.Metadata{Description: sc.description, Labels: sc.Labels}
+		return c.ci.SetMetadata(sc.Topic, sc.Channel, m, lookupdHTTPAddrs, nsqdHTTPAddrs)
+	case "pause_topic":
+		return c.ci.PauseTopic(sc.Topic, lookupdHTTPAddrs, nsqdHTTPAddrs)
+	case "unpause_topic":
+		return c.ci.UnPauseTopic(sc.Topic, lookupdHTTPAddrs, nsqdHTTPAddrs)
+	case "pause_channel":
+		return c.ci.PauseChannel(sc.Topic, sc.Channel, lookupdHTTPAddrs, nsqdHTTPAddrs)
+	case "unpause_channel":
+		return c.ci.UnPauseChannel(sc.Topic, sc.Channel, lookupdHTTPAddrs, nsqdHTTPAddrs)
+	case "delete_channel":
+		return c.ci.DeleteChannel(sc.Topic, sc.Channel, lookupdHTTPAddrs, nsqdHTTPAddrs)
+	case "delete_topic":
+		return c.ci.DeleteTopic(sc.Topic, lookupdHTTPAddrs, nsqdHTTPAddrs)
+	}
+	return fmt.Errorf("unknown action %s", sc.Action)
+}
+
+// Sync writes the plan that brings the named cluster (the first one, if
+// empty) to the desired state of fileName to w, along with its fingerprint.
+// If apply, the plan is applied when it still has the given fingerprint.
+func Sync(opts *Options, clusterName string, fileName string, apply bool, plan string, w io.Writer) error {
+	n, err := newNSQAdmin(opts)
+	if err != nil {
+		return err
+	}
+	clusters := n.newClusters()
+	c := clusters[0]
+	if clusterName != "" {
+		c = nil
+		for _, cl := range clusters {
+			if cl.name == clusterName {
+				c = cl
+			}
+		}
+		if c == nil {
+			return fmt.Errorf("unknown cluster %s", clusterName)
+		}
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	ds, err := ParseDesiredState(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s - %s", fileName, err)
+	}
+
+	changes, messages, err := c.planSync(ds)
+	if err != nil {
+		return err
+	}
+	for _, m := range messages {
+		n.logf(LOG_WARN, "SYNC: %s", m)
+	}
+	if len(changes) == 0 {
+		fmt.Fprintf(w, "cluster %s is in sync\n", c.name)
+		return nil
+	}
+	for _, sc := range changes {
+		fmt.Fprintln(w, sc)
+	}
+	fingerprint := planFingerprint(changes)
+	if !apply {
+		fmt.Fprintf(w, "%d change(s) to cluster %s, run with --sync-apply --sync-plan=%s to apply them\n",
+			len(changes), c.name, fingerprint)
+		return nil
+	}
+	if plan == "" {
+		return errors.New("--sync-apply requires --sync-plan, the fingerprint printed by a run without --sync-apply")
+	}
+	if plan != fingerprint {
+		return fmt.Errorf("the plan of cluster %s changed since it was printed (now %s), review it and run again",
+			c.name, fingerprint)
+	}
+
+	_, failed := c.applySync(changes, func(*SyncChange) {})
+	if failed > 0 {
+		return fmt.Errorf("%d of %d change(s) to cluster %s failed", failed, len(changes), c.name)
+	}
+	fmt.Fprintf(w, "applied %d change(s) to cluster %s\n", len(changes), c.name)
+	return nil
+}
@@ -0,0 +1,117 @@
+package nsqadmin
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nsqio/nsq/internal/test"
+)
+
+func TestParseDesiredState(t *testing.T) {
+	ds, err := ParseDesiredState([]byte(`
+prune: true
+topics:
+  - name: orders
+    paused: true
+    labels: {team: checkout}
+    channels:
+      - name: billing
+      - name: audit
+        paused: false
+  - name: events
+`))
+	test.Nil(t, err)
+	test.Equal(t, true, ds.Prune)
+	test.Equal(t, 2, len(ds.Topics))
+	test.Equal(t, true, *ds.Topics[0].Paused)
+	test.Equal(t, map[string]string{"team": "checkout"}, ds.Topics[0].Labels)
+	test.Equal(t, 2, len(ds.Topics[0].Channels))
+	test.Nil(t, ds.Topics[0].Channels[0].Paused)
+	test.Equal(t, false, *ds.Topics[0].Channels[1].Paused)
+	test.Nil(t, ds.Topics[1].Paused)
+	test.Nil(t, ds.Topics[1].Labels)
+
+	for _, tc := range []struct {
+		state string
+		err   string
+	}{
+		{"topics:\n  - name: a b", "invalid name"},
+		{"topics:\n  - name: a\n  - name: a", "duplicate name"},
+		{"topics:\n  - name: a\n    channels:\n      - name: \"\"", "invalid name"},
+		{"topics:\n  - name: a\n    channels:\n      - name: c\n      - name: c", "duplicate name"},
+		{"topics:\n  - name: a\n    pasued: true", "not found"},
+	} {
+		_, err := ParseDesiredState([]byte(tc.state))
+		test.NotNil(t, err)
+		test.Equal(t, true, strings.Contains(err.Error(), tc.err))
+	}
+}
+
+func TestPlanSync(t *testing.T) {
+	yes, no := true, false
+	state := map[string]*syncTopic{
+		"orders": {
+			syncObject: syncObject{nodes: []syncNode{{paused: false}, {paused: true}}},
+			channels: map[string]*syncObject{
+				"billing": {nodes: []syncNode{
+					{labels: map[string]string{"team": "billing"}},
+					{labels: map[string]string{"team": "billing"}},
+				}},
+				"old":            {nodes: []syncNode{{}, {}}},
+				"tail#ephemeral": {nodes: []syncNode{{}}},
+			},
+		},
+		"legacy":           {channels: map[string]*syncObject{}},
+		"stream#ephemeral": {channels: map[string]*syncObject{}},
+	}
+
+	plan := func(ds *DesiredState) []string {
+		var changes []string
+		for _, sc := range planSync(ds, state) {
+			changes = append(changes, sc.String())
+		}
+		return changes
+	}
+
+	ds := &DesiredState{
+		Topics: []DesiredTopic{
+			{
+				Name:   "orders",
+				Paused: &no,
+				Channels: []DesiredChannel{
+					{Name: "billing", Labels: map[string]string{"team": "billing"}},
+					{Name: "audit", Paused: &yes, Labels: map[string]string{"team": "sec"}},
+				},
+			},
+			{Name: "events", Paused: &yes, Channels: []DesiredChannel{{Name: "archive"}}},
+		},
+	}
+	test.Equal(t, []string{
+		"~ unpause_topic orders",
+		"+ create_channel orders/audit",
+		"~ set_channel_labels orders/audit {team=sec}",
+		"~ pause_channel orders/audit",
+		"+ create_topic events",
+		"+ create_channel events/archive",
+	}, plan(ds))
+
+	ds.Prune = true
+	test.Equal(t, []string{
+		"~ unpause_topic orders",
+		"+ create_channel orders/audit",
+		"~ set_channel_labels orders/audit {team=sec}",
+		"~ pause_channel orders/audit",
+		"- delete_channel orders/old",
+		"+ create_topic events",
+		"+ create_channel events/archive",
+		"- delete_topic legacy",
+	}, plan(ds))
+
+	// labels are compared as a whole, an empty map clears them
+	ds = &DesiredState{
+		Topics: []DesiredTopic{
+			{Name: "orders", Channels: []DesiredChannel{{Name: "billing", Labels: map[string]string{}}}},
+		},
+	}
+	test.Equal(t, []string{"~ set_channel_labels orders/billing {}"}, plan(ds))
+}
@@ -54,10 +54,13 @@ type Channel struct {
 	// state tracking
 	clients        map[int64]Consumer
 	paused         int32
+	memoryBypass   int32
 	ephemeral      bool
 	deleteCallback func(*Channel)
 	deleter        sync.Once
 
+	metadataHolder
+
 	// Stats tracking
 	e2eProcessingLatencyStream *quantile.Quantile
 
@@ -288,6 +291,18 @@ func (c *Channel) IsPaused() bool {
 	return atomic.LoadInt32(&c.paused) == 1
 }
 
+// SetMetadata replaces the description and labels of this channel,
+// persists them and propagates them to nsqlookupd
+func (c *Channel) SetMetadata(m Metadata) error {
+	err := m.Validate()
+	if err != nil {
+		return err
+	}
+	c.setMetadata(m)
+	c.ctx.nsqd.Notify(c)
+	return nil
+}
+
 // PutMessage writes a Message to the queue
 func (c *Channel) PutMessage(m *Message) error {
 	c.RLock()
@@ -304,22 +319,66 @@ func (c *Channel) PutMessage(m *Message) error {
 }
 
 func (c *Channel) put(m *Message) error {
-	select {
-	case c.memoryMsgChan <- m:
-	default:
-		b := bufferPoolGet()
-		err := writeMessageToBackend(b, m, c.backend)
-		bufferPoolPut(b)
-		c.ctx.nsqd.SetHealth(err)
-		if err != nil {
-			c.ctx.nsqd.logf(LOG_ERROR, "CHANNEL(%s): failed to write message to backend - %s",
-				c.name, err)
-			return err
+	if atomic.LoadInt32(&c.memoryBypass) == 0 {
+		select {
+		case c.memoryMsgChan <- m:
+			return nil
+		default:
 		}
 	}
+
+	b := bufferPoolGet()
+	err := writeMessageToBackend(b, m, c.backend)
+	bufferPoolPut(b)
+	c.ctx.nsqd.SetHealth(err)
+	if err != nil {
+		c.ctx.nsqd.logf(LOG_ERROR, "CHANNEL(%s): failed to write message to backend - %s",
+			c.name, err)
+		return err
+	}
 	return nil
 }
 
+// setMemoryBypass makes the channel queue messages on disk only (except for
+// ephemeral channels, which don't have a disk queue)
+func (c *Channel) setMemoryBypass(bypass bool) {
+	if c.ephemeral {
+		return
+	}
+	if bypass {
+		atomic.StoreInt32(&c.memoryBypass, 1)
+	} else {
+		atomic.StoreInt32(&c.memoryBypass, 0)
+	}
+}
+
+// Peek returns up to count messages queued in the disk backend of this
+// channel without removing them, bodies are truncated to previewSize, and the
+// number of messages queued in memory
+func (c *Channel) Peek(count int, previewSize int) (*PeekResult, error) {
+	c.RLock()
+	defer c.RUnlock()
+	if c.Exiting() {
+		return nil, errors.New("exiting")
+	}
+
+	result := &PeekResult{
+		MemoryDepth: int64(len(c.memoryMsgChan)),
+		Messages:    []PeekedMessage{},
+	}
+	if c.ephemeral {
+		return result, nil
+	}
+
+	opts := c.ctx.nsqd.getOpts()
+	msgs, err := peekDiskQueue(opts.DataPath, getBackendName(c.topicName, c.name), count,
+		int32(opts.MaxMsgSize)+minValidMsgLength)
+	for _, msg := range msgs {
+		result.Messages = append(result.Messages, newPeekedMessage(msg, peekSourceDisk, previewSize))
+	}
+	return result, err
+}
+
 func (c *Channel) PutMessageDeferred(msg *Message, timeout time.Duration) {
 	atomic.AddUint64(&c.messageCount, 1)
 	c.StartDeferredTimeout(msg, timeout)
@@ -0,0 +1,256 @@
+package nsqd
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+// backendScanTimeout is how long to wait for the backend to deliver the
+// next message while scanning it, it's normally immediate
+const backendScanTimeout = 100 * time.Millisecond
+
+// MessageFilter selects individual messages of a channel,
+// all of the conditions that are set must match
+type MessageFilter struct {
+	IDs          []MessageID
+	BodyContains []byte
+	BodyRegexp   *regexp.Regexp
+	MinAttempts  uint16
+	// messages published before this time (unix nanoseconds)
+	Before int64
+}
+
+// IsEmpty returns true if no conditions are set (ie. it would match everything)
+func (f *MessageFilter) IsEmpty() bool {
+	return len(f.IDs) == 0 && len(f.BodyContains) == 0 && f.BodyRegexp == nil &&
+		f.MinAttempts == 0 && f.Before == 0
+}
+
+// Match returns true if the message satisfies all of the conditions
+func (f *MessageFilter) Match(msg *Message) bool {
+	if len(f.IDs) > 0 {
+		found := false
+		for _, id := range f.IDs {
+			if id == msg.ID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.BodyContains) > 0 && !bytes.Contains(msg.Body, f.BodyContains) {
+		return false
+	}
+	if f.BodyRegexp != nil && !f.BodyRegexp.Match(msg.Body) {
+		return false
+	}
+	if msg.Attempts < f.MinAttempts {
+		return false
+	}
+	if f.Before > 0 && msg.Timestamp >= f.Before {
+		return false
+	}
+	return true
+}
+
+// MessageOpResult is the number of messages affected by a selective
+// operation, by where they were found
+type MessageOpResult struct {
+	InFlight int `json:"in_flight"`
+	Deferred int `json:"deferred"`
+	Memory   int `json:"memory"`
+	Backend  int `json:"backend"`
+}
+
+// Total returns the number of messages affected
+func (r MessageOpResult) Total() int {
+	return r.InFlight + r.Deferred + r.Memory + r.Backend
+}
+
+// RemoveMessages removes the in-flight, deferred and queued messages matching
+// the filter and returns them.
+//
+// Messages that are queued on disk are only considered when scanBackend is
+// true, this reads (and re-writes the non-matching messages of) the entire
+// backend so they end up behind anything that is published concurrently.
+func (c *Channel) RemoveMessages(f *MessageFilter, scanBackend bool) ([]*Message, MessageOpResult, error) {
+	var result MessageOpResult
+
+	c.exitMutex.RLock()
+	defer c.exitMutex.RUnlock()
+	if c.Exiting() {
+		return nil, result, errors.New("exiting")
+	}
+
+	removed := c.removeInFlightMessages(f)
+	result.InFlight = len(removed)
+
+	deferred := c.removeDeferredMessages(f)
+	result.Deferred = len(deferred)
+	removed = append(removed, deferred...)
+
+	// go channels can't be filtered in place, receive everything that's
+	// queued right now and hand back what doesn't match
+	for i := len(c.memoryMsgChan); i > 0; i-- {
+		var msg *Message
+		select {
+		case msg = <-c.memoryMsgChan:
+		default:
+		}
+		if msg == nil {
+			break
+		}
+		if f.Match(msg) {
+			removed = append(removed, msg)
+			result.Memory++
+			continue
+		}
+		err := c.put(msg)
+		if err != nil {
+			return removed, result, err
+		}
+	}
+
+	if !scanBackend {
+		return removed, result, nil
+	}
+
+	for i := c.backend.Depth(); i > 0; i-- {
+		var data []byte
+		select {
+		case data = <-c.backend.ReadChan():
+		case <-time.After(backendScanTimeout):
+		}
+		if data == nil {
+			break
+		}
+		msg, err := decodeMessage(data)
+		if err != nil {
+			c.ctx.nsqd.logf(LOG_ERROR, "failed to decode message - %s", err)
+			continue
+		}
+		if f.Match(msg) {
+			removed = append(removed, msg)
+			result.Backend++
+			continue
+		}
+		b := bufferPoolGet()
+		err = writeMessageToBackend(b, msg, c.backend)
+		bufferPoolPut(b)
+		if err != nil {
+			c.ctx.nsqd.logf(LOG_ERROR, "CHANNEL(%s): failed to write message to backend - %s",
+				c.name, err)
+			return removed, result, err
+		}
+	}
+
+	return removed, result, nil
+}
+
+// RequeueMessages immediately requeues the in-flight and deferred messages
+// matching the filter, regardless of their timeouts
+func (c *Channel) RequeueMessages(f *MessageFilter) (MessageOpResult, error) {
+	var result MessageOpResult
+
+	c.exitMutex.RLock()
+	defer c.exitMutex.RUnlock()
+	if c.Exiting() {
+		return result, errors.New("exiting")
+	}
+
+	// keep going on errors, the messages are no longer in flight or
+	// deferred either way, but only count those actually requeued
+	var firstErr error
+	for _, msg := range c.removeInFlightMessages(f) {
+		atomic.AddUint64(&c.requeueCount, 1)
+		err := c.put(msg)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		result.InFlight++
+	}
+	for _, msg := range c.removeDeferredMessages(f) {
+		err := c.put(msg)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		result.Deferred++
+	}
+	return result, firstErr
+}
+
+// PutBackMessages queues again messages returned by RemoveMessages, eg.
+// those that couldn't be moved to another topic, and returns how many were
+func (c *Channel) PutBackMessages(msgs []*Message) (int, error) {
+	c.exitMutex.RLock()
+	defer c.exitMutex.RUnlock()
+	if c.Exiting() {
+		return 0, errors.New("exiting")
+	}
+
+	for i, msg := range msgs {
+		err := c.put(msg)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), nil
+}
+
+func (c *Channel) removeInFlightMessages(f *MessageFilter) []*Message {
+	var msgs []*Message
+	c.inFlightMutex.Lock()
+	for id, msg := range c.inFlightMessages {
+		if !f.Match(msg) {
+			continue
+		}
+		delete(c.inFlightMessages, id)
+		if msg.index != -1 {
+			c.inFlightPQ.Remove(msg.index)
+		}
+		msgs = append(msgs, msg)
+	}
+	c.inFlightMutex.Unlock()
+
+	// the owning clients no longer have these in flight, a later FIN
+	// or REQ for them will fail just as if they had timed out
+	for _, msg := range msgs {
+		c.RLock()
+		client, ok := c.clients[msg.clientID]
+		c.RUnlock()
+		if ok {
+			client.TimedOutMessage()
+		}
+	}
+	return msgs
+}
+
+func (c *Channel) removeDeferredMessages(f *MessageFilter) []*Message {
+	var msgs []*Message
+	c.deferredMutex.Lock()
+	for id, item := range c.deferredMessages {
+		msg := item.Value.(*Message)
+		if !f.Match(msg) {
+			continue
+		}
+		delete(c.deferredMessages, id)
+		if item.Index != -1 {
+			heap.Remove(&c.deferredPQ, item.Index)
+		}
+		msgs = append(msgs, msg)
+	}
+	c.deferredMutex.Unlock()
+	return msgs
+}
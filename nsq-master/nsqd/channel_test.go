@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"regexp"
 	"strconv"
 	"testing"
 	"time"
@@ -137,6 +138,72 @@ func TestChannelEmpty(t *testing.T) {
 	test.Equal(t, int64(0), channel.Depth())
 }
 
+func TestChannelRemoveMessages(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.MemQueueSize = 5
+	_, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	topicName := "test_channel_remove" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	channel := topic.GetChannel("channel")
+
+	var inFlight []*Message
+	for i := 0; i < 4; i++ {
+		msg := NewMessage(topic.GenerateID(), []byte(fmt.Sprintf("inflight %d", i)))
+		channel.StartInFlightTimeout(msg, 0, opts.MsgTimeout)
+		inFlight = append(inFlight, msg)
+	}
+	channel.RequeueMessage(0, inFlight[3].ID, time.Hour)
+
+	// 5 fit in memory, the rest overflow to the backend
+	for i := 0; i < 10; i++ {
+		body := "keep"
+		if i%2 == 0 {
+			body = "drop"
+		}
+		channel.PutMessage(NewMessage(topic.GenerateID(), []byte(body)))
+	}
+
+	// by id, in-flight and deferred
+	msgs, result, err := channel.RemoveMessages(&MessageFilter{
+		IDs: []MessageID{inFlight[0].ID, inFlight[3].ID},
+	}, false)
+	test.Nil(t, err)
+	test.Equal(t, 2, len(msgs))
+	test.Equal(t, MessageOpResult{InFlight: 1, Deferred: 1}, result)
+	test.Equal(t, 2, len(channel.inFlightMessages))
+	test.Equal(t, 2, len(channel.inFlightPQ))
+	test.Equal(t, 0, len(channel.deferredMessages))
+	test.Equal(t, 0, len(channel.deferredPQ))
+
+	// by body, without and with the backend
+	_, result, err = channel.RemoveMessages(&MessageFilter{BodyContains: []byte("drop")}, false)
+	test.Nil(t, err)
+	test.Equal(t, MessageOpResult{Memory: 3}, result)
+	test.Equal(t, int64(7), channel.Depth())
+
+	msgs, result, err = channel.RemoveMessages(&MessageFilter{
+		BodyRegexp: regexp.MustCompile("^dr"),
+	}, true)
+	test.Nil(t, err)
+	test.Equal(t, MessageOpResult{Backend: 2}, result)
+	test.Equal(t, int64(5), channel.Depth())
+	for _, msg := range msgs {
+		test.Equal(t, []byte("drop"), msg.Body)
+	}
+
+	// requeue what's left in flight
+	result, err = channel.RequeueMessages(&MessageFilter{BodyContains: []byte("inflight")})
+	test.Nil(t, err)
+	test.Equal(t, MessageOpResult{InFlight: 2}, result)
+	test.Equal(t, 0, len(channel.inFlightMessages))
+	test.Equal(t, int64(7), channel.Depth())
+	test.Equal(t, uint64(3), channel.requeueCount)
+}
+
 func TestChannelEmptyConsumer(t *testing.T) {
 	opts := NewOptions()
 	opts.Logger = test.NewTestLogger(t)
@@ -0,0 +1,476 @@
+package nsqd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nsqio/nsq/internal/lg"
+	"github.com/nsqio/nsq/internal/util"
+)
+
+// Durability is the point a message published to a topic has to reach
+// before the publish is acknowledged
+type Durability int32
+
+const (
+	// DurabilityMemory acknowledges once the message is queued, in memory
+	// or (when the memory queue is full) on disk
+	DurabilityMemory Durability = iota
+	// DurabilityDisk bypasses the in-memory queues of the topic and its
+	// channels, acknowledging once the message is written to disk, this
+	// survives nsqd crashing but not the OS
+	DurabilityDisk
+	// DurabilityFsync is DurabilityDisk that also waits for the message to
+	// be fsynced to the topic's journal
+	DurabilityFsync
+)
+
+var durabilityNames = []string{"memory", "disk", "fsync"}
+
+func (d Durability) String() string {
+	if d < 0 || int(d) >= len(durabilityNames) {
+		return fmt.Sprintf("Durability(%d)", d)
+	}
+	return durabilityNames[d]
+}
+
+// ParseDurability returns the Durability with the given name
+func ParseDurability(s string) (Durability, error) {
+	for i, name := range durabilityNames {
+		if s == name {
+			return Durability(i), nil
+		}
+	}
+	return DurabilityMemory, fmt.Errorf("invalid durability %q", s)
+}
+
+// maxJournalBatch limits how many writes are committed by a single fsync
+const maxJournalBatch = 1024
+
+// errDeferredNotDurable is returned when publishing a deferred message to a
+// topic with DurabilityDisk or DurabilityFsync, deferred messages are only
+// held in memory (by the channels) until their timeout
+var errDeferredNotDurable = errors.New("deferred messages are not supported with durability disk or fsync")
+
+type journalWrite struct {
+	data    []byte
+	fileNum int64
+	errChan chan error
+}
+
+type journalSegment struct {
+	fileNum int64
+	// checkpointedAt is when all of the messages in the segment had reached
+	// the backends of the topic's channels
+	checkpointedAt time.Time
+}
+
+// journal is an append-only log of the messages published to a topic with
+// DurabilityFsync. The topic's diskqueue only fsyncs every --sync-every
+// messages or --sync-timeout, the journal covers the window in between.
+//
+// Writes that queue up while an fsync is in progress are committed together
+// by the next one (group commit). The current segment is rotated every
+// syncTimeout, closed segments are removed once the messages they contain
+// are safe in the diskqueues of the topic's channels: the topic reports the
+// position of its backend the messages were written at (Queued), and the
+// checkpoint func how many messages its messagePump read from the backend
+// and put in the channels, whose diskqueues sync within two syncTimeouts.
+// The segments of a topic without channels (or paused) are kept until its
+// messages are.
+//
+// After a crash the remaining segments are replayed into the topic, which
+// can duplicate messages (as with any nsqd failure, delivery is at least once)
+type journal struct {
+	name        string
+	dataPath    string
+	syncTimeout time.Duration
+	checkpoint  func() int64
+	logf        lg.AppLogFunc
+
+	sync.RWMutex
+	exitFlag bool
+
+	file     *os.File
+	fileNum  int64
+	segments []journalSegment
+
+	// by segment, the number of committed writes not Queued yet and the
+	// highest backend position they were queued at
+	positionMtx sync.Mutex
+	pending     map[int64]int
+	positions   map[int64]int64
+
+	writeChan chan *journalWrite
+	emptyChan chan chan error
+	exitChan  chan int
+	waitGroup util.WaitGroupWrapper
+}
+
+// newJournal opens the journal of the named topic and returns any messages
+// left over in it by a previous process, which the caller should re-queue
+// and then report with Replayed
+func newJournal(name string, dataPath string, syncTimeout time.Duration,
+	maxMsgSize int32, checkpoint func() int64, logf lg.AppLogFunc) (*journal, []*Message, error) {
+	j := &journal{
+		name:        name,
+		dataPath:    dataPath,
+		syncTimeout: syncTimeout,
+		checkpoint:  checkpoint,
+		logf:        logf,
+		pending:     make(map[int64]int),
+		positions:   make(map[int64]int64),
+		writeChan:   make(chan *journalWrite),
+		emptyChan:   make(chan chan error),
+		exitChan:    make(chan int),
+	}
+
+	fileNums, err := j.segmentFileNums()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var msgs []*Message
+	for _, fileNum := range fileNums {
+		msgs, err = j.readSegment(fileNum, maxMsgSize, msgs)
+		if err != nil {
+			// a torn write at the tail of the last segment is expected
+			j.logf(LOG_WARN, "JOURNAL(%s): failed to read %s - %s",
+				j.name, j.fileName(fileNum), err)
+		}
+		// the replayed messages have to make it through the topic again,
+		// keep the segment until Replayed says where they were queued
+		j.segments = append(j.segments, journalSegment{fileNum: fileNum})
+		j.pending[fileNum] = 1
+		j.fileNum = fileNum + 1
+	}
+
+	j.waitGroup.Wrap(j.ioLoop)
+
+	return j, msgs, nil
+}
+
+// hasJournal returns true if there are journal segments of the named topic
+func hasJournal(name string, dataPath string) bool {
+	j := &journal{name: name, dataPath: dataPath}
+	fileNums, _ := j.segmentFileNums()
+	return len(fileNums) > 0
+}
+
+// Write appends the encoded messages to the journal and returns once
+// they are fsynced, with the segment they were written to which has to be
+// passed to Queued once the messages are written to the topic's backend
+func (j *journal) Write(msgs []*Message) (int64, error) {
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		// reserve the length prefix, it's filled in once the size is known
+		start := buf.Len()
+		buf.Write([]byte{0, 0, 0, 0})
+		_, err := msg.WriteTo(&buf)
+		if err != nil {
+			return 0, err
+		}
+		binary.BigEndian.PutUint32(buf.Bytes()[start:], uint32(buf.Len()-start-4))
+	}
+
+	j.RLock()
+	defer j.RUnlock()
+	if j.exitFlag {
+		return 0, errors.New("exiting")
+	}
+
+	w := &journalWrite{data: buf.Bytes(), errChan: make(chan error, 1)}
+	j.writeChan <- w
+	err := <-w.errChan
+	return w.fileNum, err
+}
+
+// Queued records that the messages of a successful Write to the segment
+// fileNum were written to the topic's backend (or failed to be), up to
+// position, the number of messages written to it so far
+func (j *journal) Queued(fileNum int64, position int64) {
+	j.positionMtx.Lock()
+	defer j.positionMtx.Unlock()
+	if _, ok := j.pending[fileNum]; !ok {
+		// emptied in the meantime
+		return
+	}
+	j.pending[fileNum]--
+	if position > j.positions[fileNum] {
+		j.positions[fileNum] = position
+	}
+}
+
+// Replayed records that the messages returned by newJournal were written
+// to the topic's backend, up to position
+func (j *journal) Replayed(position int64) {
+	j.positionMtx.Lock()
+	fileNums := make([]int64, 0, len(j.pending))
+	for fileNum := range j.pending {
+		fileNums = append(fileNums, fileNum)
+	}
+	j.positionMtx.Unlock()
+	for _, fileNum := range fileNums {
+		j.Queued(fileNum, position)
+	}
+}
+
+// Empty removes all of the segments, the topic's messages were dropped
+func (j *journal) Empty() error {
+	j.RLock()
+	defer j.RUnlock()
+	if j.exitFlag {
+		return errors.New("exiting")
+	}
+
+	errChan := make(chan error, 1)
+	j.emptyChan <- errChan
+	return <-errChan
+}
+
+// Close stops the journal, if remove is true all segments are deleted (the
+// caller has to make sure the messages they contain are synced elsewhere)
+func (j *journal) Close(remove bool) error {
+	j.Lock()
+	if j.exitFlag {
+		j.Unlock()
+		return errors.New("exiting")
+	}
+	j.exitFlag = true
+	j.Unlock()
+
+	close(j.exitChan)
+	j.waitGroup.Wait()
+
+	if j.file != nil {
+		j.file.Close()
+		j.segments = append(j.segments, journalSegment{fileNum: j.fileNum})
+		j.file = nil
+	}
+
+	if !remove {
+		return nil
+	}
+
+	var err error
+	for _, s := range j.segments {
+		innerErr := os.Remove(j.fileName(s.fileNum))
+		if innerErr != nil && !os.IsNotExist(innerErr) {
+			err = innerErr
+		}
+	}
+	j.segments = nil
+	return err
+}
+
+func (j *journal) ioLoop() {
+	var batch []*journalWrite
+
+	ticker := time.NewTicker(j.syncTimeout)
+	for {
+		select {
+		case w := <-j.writeChan:
+			batch = append(batch[:0], w)
+			// everything that queued up during the last fsync goes into this one
+		drain:
+			for len(batch) < maxJournalBatch {
+				select {
+				case w := <-j.writeChan:
+					batch = append(batch, w)
+				default:
+					break drain
+				}
+			}
+			err := j.commit(batch)
+			if err != nil {
+				j.logf(LOG_ERROR, "JOURNAL(%s): failed to commit %d writes - %s",
+					j.name, len(batch), err)
+			}
+			for _, w := range batch {
+				w.errChan <- err
+			}
+		case <-ticker.C:
+			j.rotate()
+		case errChan := <-j.emptyChan:
+			errChan <- j.empty()
+		case <-j.exitChan:
+			goto exit
+		}
+	}
+
+exit:
+	ticker.Stop()
+}
+
+func (j *journal) commit(batch []*journalWrite) error {
+	if j.file == nil {
+		f, err := os.OpenFile(j.fileName(j.fileNum), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		j.file = f
+	}
+
+	var err error
+	if len(batch) == 1 {
+		_, err = j.file.Write(batch[0].data)
+	} else {
+		var buf bytes.Buffer
+		for _, w := range batch {
+			buf.Write(w.data)
+		}
+		_, err = j.file.Write(buf.Bytes())
+	}
+	if err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		// start over with a new segment, the data in this one is suspect
+		j.file.Close()
+		j.file = nil
+		j.segments = append(j.segments, journalSegment{fileNum: j.fileNum})
+		j.fileNum++
+		return err
+	}
+
+	j.positionMtx.Lock()
+	j.pending[j.fileNum] += len(batch)
+	j.positionMtx.Unlock()
+	for _, w := range batch {
+		w.fileNum = j.fileNum
+	}
+	return nil
+}
+
+// rotate closes the current segment and removes the segments whose messages
+// the channels' diskqueues have certainly synced, ie. that reached the
+// checkpoint at least two of their --sync-timeout intervals ago
+func (j *journal) rotate() {
+	now := time.Now()
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+		j.segments = append(j.segments, journalSegment{fileNum: j.fileNum})
+		j.fileNum++
+	}
+
+	checkpoint := j.checkpoint()
+	j.positionMtx.Lock()
+	for i, s := range j.segments {
+		if s.checkpointedAt.IsZero() && j.pending[s.fileNum] <= 0 && checkpoint >= j.positions[s.fileNum] {
+			j.segments[i].checkpointedAt = now
+		}
+	}
+	j.positionMtx.Unlock()
+
+	for len(j.segments) > 0 {
+		s := j.segments[0]
+		if s.checkpointedAt.IsZero() || now.Sub(s.checkpointedAt) < 2*j.syncTimeout {
+			break
+		}
+		fn := j.fileName(s.fileNum)
+		err := os.Remove(fn)
+		if err != nil && !os.IsNotExist(err) {
+			j.logf(LOG_ERROR, "JOURNAL(%s): failed to remove %s - %s", j.name, fn, err)
+			return
+		}
+		j.positionMtx.Lock()
+		delete(j.pending, s.fileNum)
+		delete(j.positions, s.fileNum)
+		j.positionMtx.Unlock()
+		j.segments = j.segments[1:]
+	}
+}
+
+func (j *journal) empty() error {
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+		j.segments = append(j.segments, journalSegment{fileNum: j.fileNum})
+		j.fileNum++
+	}
+
+	var err error
+	for _, s := range j.segments {
+		innerErr := os.Remove(j.fileName(s.fileNum))
+		if innerErr != nil && !os.IsNotExist(innerErr) {
+			err = innerErr
+		}
+	}
+	j.segments = nil
+	j.positionMtx.Lock()
+	j.pending = make(map[int64]int)
+	j.positions = make(map[int64]int64)
+	j.positionMtx.Unlock()
+	return err
+}
+
+func (j *journal) readSegment(fileNum int64, maxMsgSize int32, msgs []*Message) ([]*Message, error) {
+	f, err := os.Open(j.fileName(fileNum))
+	if err != nil {
+		return msgs, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		var msgSize int32
+		err := binary.Read(r, binary.BigEndian, &msgSize)
+		if err != nil {
+			if err == io.EOF {
+				return msgs, nil
+			}
+			return msgs, err
+		}
+
+		if msgSize < minValidMsgLength || msgSize > maxMsgSize {
+			return msgs, fmt.Errorf("invalid message read size (%d)", msgSize)
+		}
+
+		buf := make([]byte, msgSize)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return msgs, err
+		}
+
+		msg, err := decodeMessage(buf)
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func (j *journal) segmentFileNums() ([]int64, error) {
+	pattern := fmt.Sprintf(path.Join(j.dataPath, "%s.journal.*.dat"), j.name)
+	fileNames, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileNums []int64
+	for _, fn := range fileNames {
+		var fileNum int64
+		_, err := fmt.Sscanf(path.Base(fn), j.name+".journal.%d.dat", &fileNum)
+		if err != nil {
+			continue
+		}
+		fileNums = append(fileNums, fileNum)
+	}
+	sort.Slice(fileNums, func(i, k int) bool { return fileNums[i] < fileNums[k] })
+	return fileNums, nil
+}
+
+func (j *journal) fileName(fileNum int64) string {
+	return fmt.Sprintf(path.Join(j.dataPath, "%s.journal.%06d.dat"), j.name, fileNum)
+}
@@ -0,0 +1,189 @@
+package nsqd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nsqio/nsq/internal/lg"
+	"github.com/nsqio/nsq/internal/test"
+)
+
+func TestJournal(t *testing.T) {
+	dataPath, err := ioutil.TempDir("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(dataPath)
+
+	logf := func(lvl lg.LogLevel, f string, args ...interface{}) {}
+	checkpoint := func() int64 { return 0 }
+	j, msgs, err := newJournal("test_journal", dataPath, time.Hour, 1024, checkpoint, logf)
+	test.Nil(t, err)
+	test.Equal(t, 0, len(msgs))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			msg := NewMessage(MessageID{}, []byte(fmt.Sprintf("message %d", i)))
+			_, err := j.Write([]*Message{msg, msg})
+			test.Nil(t, err)
+		}(i)
+	}
+	wg.Wait()
+	test.Equal(t, true, hasJournal("test_journal", dataPath))
+
+	// simulate a crash by leaving the segments behind
+	test.Nil(t, j.Close(false))
+
+	j, msgs, err = newJournal("test_journal", dataPath, time.Hour, 1024, checkpoint, logf)
+	test.Nil(t, err)
+	test.Equal(t, 100, len(msgs))
+	test.Equal(t, int64(1), j.fileNum)
+
+	test.Nil(t, j.Close(true))
+	test.Equal(t, false, hasJournal("test_journal", dataPath))
+}
+
+func TestJournalRotate(t *testing.T) {
+	dataPath, err := ioutil.TempDir("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(dataPath)
+
+	logf := func(lvl lg.LogLevel, f string, args ...interface{}) {}
+	var reads int64
+	checkpoint := func() int64 { return atomic.LoadInt64(&reads) }
+	j, _, err := newJournal("test_journal", dataPath, 10*time.Millisecond, 1024, checkpoint, logf)
+	test.Nil(t, err)
+	defer j.Close(true)
+
+	msg := NewMessage(MessageID{}, []byte("test"))
+	fileNum, err := j.Write([]*Message{msg})
+	test.Nil(t, err)
+	test.Equal(t, true, hasJournal("test_journal", dataPath))
+
+	// kept while the message isn't queued in the topic's backend...
+	time.Sleep(50 * time.Millisecond)
+	test.Equal(t, true, hasJournal("test_journal", dataPath))
+	j.Queued(fileNum, 3)
+
+	// ...nor read from it and put in the channels
+	time.Sleep(50 * time.Millisecond)
+	test.Equal(t, true, hasJournal("test_journal", dataPath))
+	atomic.StoreInt64(&reads, 3)
+
+	// removed once the checkpoint is older than two sync timeouts
+	for i := 0; hasJournal("test_journal", dataPath); i++ {
+		if i > 100 {
+			t.Fatal("journal segment was not removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTopicDurability(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	_, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	topicName := "test_topic_durability" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	channel := topic.GetChannel("ch")
+	test.Equal(t, DurabilityMemory, topic.GetDurability())
+
+	test.Nil(t, topic.SetDurability(DurabilityDisk))
+	test.Equal(t, int32(1), channel.memoryBypass)
+	test.Nil(t, topic.PutMessage(NewMessage(topic.GenerateID(), []byte("disk"))))
+	for channel.Depth() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	test.Equal(t, int64(1), channel.backend.Depth())
+	test.Equal(t, false, hasJournal(topicName, opts.DataPath))
+
+	test.Nil(t, topic.SetDurability(DurabilityFsync))
+	test.Nil(t, topic.PutMessages([]*Message{
+		NewMessage(topic.GenerateID(), []byte("fsync")),
+		NewMessage(topic.GenerateID(), []byte("fsync")),
+	}))
+	test.Equal(t, true, hasJournal(topicName, opts.DataPath))
+	for channel.Depth() != 3 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	test.Equal(t, int64(3), channel.backend.Depth())
+
+	ephemeral := nsqd.GetTopic(topicName + "#ephemeral")
+	test.NotNil(t, ephemeral.SetDurability(DurabilityDisk))
+
+	// deleting the topic removes the journal
+	nsqd.DeleteExistingTopic(topicName)
+	test.Equal(t, false, hasJournal(topicName, opts.DataPath))
+}
+
+func TestTopicJournalReplay(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	dataPath, err := ioutil.TempDir("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(dataPath)
+	opts.DataPath = dataPath
+
+	// left behind by a crashed nsqd
+	topicName := "test_topic_journal_replay" + strconv.Itoa(int(time.Now().Unix()))
+	logf := func(lvl lg.LogLevel, f string, args ...interface{}) {}
+	checkpoint := func() int64 { return 0 }
+	j, _, err := newJournal(topicName, dataPath, time.Hour, 1024, checkpoint, logf)
+	test.Nil(t, err)
+	_, err = j.Write([]*Message{
+		NewMessage(MessageID{}, []byte("a")),
+		NewMessage(MessageID{}, []byte("b")),
+	})
+	test.Nil(t, err)
+	test.Nil(t, j.Close(false))
+
+	_, _, nsqd := mustStartNSQD(opts)
+	defer nsqd.Exit()
+
+	topic := nsqd.GetTopic(topicName)
+	test.Equal(t, int64(2), topic.Depth())
+	test.NotNil(t, topic.journal)
+
+	// emptying the topic drops the replayed messages for good
+	test.Nil(t, topic.Empty())
+	test.Equal(t, int64(0), topic.Depth())
+	test.Equal(t, false, hasJournal(topicName, dataPath))
+}
+
+func TestTopicJournalCheckpoint(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.SyncTimeout = 10 * time.Millisecond
+	_, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	topicName := "test_topic_journal_checkpoint" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	test.Nil(t, topic.SetDurability(DurabilityFsync))
+	test.Nil(t, topic.PutMessage(NewMessage(topic.GenerateID(), []byte("fsync"))))
+
+	// without channels the message stays in the topic's backend, so the
+	// journal has to as well
+	time.Sleep(100 * time.Millisecond)
+	test.Equal(t, true, hasJournal(topicName, opts.DataPath))
+
+	channel := topic.GetChannel("ch")
+	for i := 0; hasJournal(topicName, opts.DataPath); i++ {
+		if i > 100 {
+			t.Fatal("journal segment was not removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	test.Equal(t, int64(1), channel.backend.Depth())
+}
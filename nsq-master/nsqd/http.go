@@ -11,7 +11,9 @@ import (
 	"net/http/pprof"
 	"net/url"
 	"os"
+	"path"
 	"reflect"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
@@ -67,11 +69,25 @@ func newHTTPServer(ctx *context, tlsEnabled bool, tlsRequired bool) *httpServer
 	router.Handle("POST", "/topic/empty", http_api.Decorate(s.doEmptyTopic, log, http_api.V1))
 	router.Handle("POST", "/topic/pause", http_api.Decorate(s.doPauseTopic, log, http_api.V1))
 	router.Handle("POST", "/topic/unpause", http_api.Decorate(s.doPauseTopic, log, http_api.V1))
+	router.Handle("GET", "/topic/metadata", http_api.Decorate(s.doTopicMetadata, log, http_api.V1))
+	router.Handle("POST", "/topic/metadata", http_api.Decorate(s.doTopicMetadata, log, http_api.V1))
+	router.Handle("GET", "/topic/peek", http_api.Decorate(s.doPeekTopic, log, http_api.V1))
+	router.Handle("GET", "/topic/schema", http_api.Decorate(s.doTopicSchema, log, http_api.V1))
+	router.Handle("POST", "/topic/schema", http_api.Decorate(s.doTopicSchema, log, http_api.V1))
+	router.Handle("DELETE", "/topic/schema", http_api.Decorate(s.doTopicSchema, log, http_api.V1))
+	router.Handle("GET", "/topic/durability", http_api.Decorate(s.doTopicDurability, log, http_api.V1))
+	router.Handle("POST", "/topic/durability", http_api.Decorate(s.doTopicDurability, log, http_api.V1))
 	router.Handle("POST", "/channel/create", http_api.Decorate(s.doCreateChannel, log, http_api.V1))
 	router.Handle("POST", "/channel/delete", http_api.Decorate(s.doDeleteChannel, log, http_api.V1))
 	router.Handle("POST", "/channel/empty", http_api.Decorate(s.doEmptyChannel, log, http_api.V1))
 	router.Handle("POST", "/channel/pause", http_api.Decorate(s.doPauseChannel, log, http_api.V1))
 	router.Handle("POST", "/channel/unpause", http_api.Decorate(s.doPauseChannel, log, http_api.V1))
+	router.Handle("POST", "/channel/messages/delete", http_api.Decorate(s.doChannelMessages, log, http_api.V1))
+	router.Handle("POST", "/channel/messages/move", http_api.Decorate(s.doChannelMessages, log, http_api.V1))
+	router.Handle("POST", "/channel/messages/requeue", http_api.Decorate(s.doChannelMessages, log, http_api.V1))
+	router.Handle("GET", "/channel/peek", http_api.Decorate(s.doPeekChannel, log, http_api.V1))
+	router.Handle("GET", "/channel/metadata", http_api.Decorate(s.doChannelMetadata, log, http_api.V1))
+	router.Handle("POST", "/channel/metadata", http_api.Decorate(s.doChannelMetadata, log, http_api.V1))
 	router.Handle("GET", "/config/:opt", http_api.Decorate(s.doConfig, log, http_api.V1))
 	router.Handle("PUT", "/config/:opt", http_api.Decorate(s.doConfig, log, http_api.V1))
 
@@ -222,6 +238,15 @@ func (s *httpServer) doPUB(w http.ResponseWriter, req *http.Request, ps httprout
 		}
 	}
 
+	if deferred > 0 && topic.GetDurability() != DurabilityMemory {
+		return nil, http_api.Err{400, "INVALID_DEFER: " + errDeferredNotDurable.Error()}
+	}
+
+	err = topic.ValidateMessage(body)
+	if err != nil {
+		return nil, http_api.Err{400, fmt.Sprintf("INVALID_MESSAGE: %s", err)}
+	}
+
 	msg := NewMessage(topic.GenerateID(), body)
 	msg.deferred = deferred
 	err = topic.PutMessage(msg)
@@ -302,6 +327,13 @@ func (s *httpServer) doMPUB(w http.ResponseWriter, req *http.Request, ps httprou
 		}
 	}
 
+	for i, msg := range msgs {
+		err = topic.ValidateMessage(msg.Body)
+		if err != nil {
+			return nil, http_api.Err{400, fmt.Sprintf("INVALID_MESSAGE: message(%d) %s", i, err)}
+		}
+	}
+
 	err = topic.PutMessages(msgs)
 	if err != nil {
 		return nil, http_api.Err{503, "EXITING"}
@@ -399,6 +431,367 @@ func (s *httpServer) doPauseTopic(w http.ResponseWriter, req *http.Request, ps h
 	return nil, nil
 }
 
+func (s *httpServer) doTopicMetadata(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	reqParams, err := http_api.NewReqParams(req)
+	if err != nil {
+		s.ctx.nsqd.logf(LOG_ERROR, "failed to parse request params - %s", err)
+		return nil, http_api.Err{400, "INVALID_REQUEST"}
+	}
+
+	topicName, err := reqParams.Get("topic")
+	if err != nil {
+		return nil, http_api.Err{400, "MISSING_ARG_TOPIC"}
+	}
+
+	topic, err := s.ctx.nsqd.GetExistingTopic(topicName)
+	if err != nil {
+		return nil, http_api.Err{404, "TOPIC_NOT_FOUND"}
+	}
+
+	if req.Method == "POST" {
+		m, err := parseMetadataBody(reqParams.Body)
+		if err != nil {
+			return nil, err
+		}
+		err = topic.SetMetadata(m)
+		if err != nil {
+			return nil, http_api.Err{400, fmt.Sprintf("INVALID_METADATA: %s", err)}
+		}
+	}
+
+	return topic.GetMetadata(), nil
+}
+
+func (s *httpServer) doPeekTopic(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	reqParams, err := http_api.NewReqParams(req)
+	if err != nil {
+		s.ctx.nsqd.logf(LOG_ERROR, "failed to parse request params - %s", err)
+		return nil, http_api.Err{400, "INVALID_REQUEST"}
+	}
+
+	topicName, err := reqParams.Get("topic")
+	if err != nil {
+		return nil, http_api.Err{400, "MISSING_ARG_TOPIC"}
+	}
+
+	count, previewSize, err := s.getPeekParams(reqParams)
+	if err != nil {
+		return nil, err
+	}
+
+	topic, err := s.ctx.nsqd.GetExistingTopic(topicName)
+	if err != nil {
+		return nil, http_api.Err{404, "TOPIC_NOT_FOUND"}
+	}
+
+	result, err := topic.Peek(count, previewSize)
+	if err != nil {
+		s.ctx.nsqd.logf(LOG_ERROR, "failed to peek topic %s - %s", topicName, err)
+		return nil, http_api.Err{500, "INTERNAL_ERROR"}
+	}
+
+	return result, nil
+}
+
+func (s *httpServer) doPeekChannel(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	reqParams, topic, channelName, err := s.getExistingTopicFromQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	count, previewSize, err := s.getPeekParams(reqParams)
+	if err != nil {
+		return nil, err
+	}
+
+	channel, err := topic.GetExistingChannel(channelName)
+	if err != nil {
+		return nil, http_api.Err{404, "CHANNEL_NOT_FOUND"}
+	}
+
+	result, err := channel.Peek(count, previewSize)
+	if err != nil {
+		s.ctx.nsqd.logf(LOG_ERROR, "failed to peek channel %s:%s - %s", topic.name, channelName, err)
+		return nil, http_api.Err{500, "INTERNAL_ERROR"}
+	}
+
+	return result, nil
+}
+
+func (s *httpServer) doChannelMessages(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	reqParams, topic, channelName, err := s.getExistingTopicFromQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	channel, err := topic.GetExistingChannel(channelName)
+	if err != nil {
+		return nil, http_api.Err{404, "CHANNEL_NOT_FOUND"}
+	}
+
+	filter, err := getMessageFilter(reqParams)
+	if err != nil {
+		return nil, err
+	}
+
+	var result MessageOpResult
+	switch action := path.Base(req.URL.Path); action {
+	case "requeue":
+		result, err = channel.RequeueMessages(filter)
+		if err != nil {
+			return nil, http_api.Err{500, "INTERNAL_ERROR"}
+		}
+	case "delete", "move":
+		var toTopic *Topic
+		if action == "move" {
+			toTopicName, err := reqParams.Get("to_topic")
+			if err != nil {
+				return nil, http_api.Err{400, "MISSING_ARG_TO_TOPIC"}
+			}
+			// moving to the same topic would also duplicate the messages
+			// into all of its other channels
+			if !protocol.IsValidTopicName(toTopicName) || toTopicName == topic.name {
+				return nil, http_api.Err{400, "INVALID_ARG_TO_TOPIC"}
+			}
+			toTopic = s.ctx.nsqd.GetTopic(toTopicName)
+		}
+
+		scanBackend := false
+		if v, err := reqParams.Get("scan_backend"); err == nil {
+			scanBackend = boolParams[v]
+		}
+
+		var msgs []*Message
+		msgs, result, err = channel.RemoveMessages(filter, scanBackend)
+		if err != nil {
+			s.ctx.nsqd.logf(LOG_ERROR, "failed to remove messages from %s:%s - %s",
+				topic.name, channelName, err)
+			if toTopic != nil {
+				s.putBackMessages(channel, msgs)
+			}
+			return nil, http_api.Err{500, "INTERNAL_ERROR"}
+		}
+
+		if toTopic != nil {
+			for i, msg := range msgs {
+				err := toTopic.PutMessage(NewMessage(toTopic.GenerateID(), msg.Body))
+				if err != nil {
+					s.ctx.nsqd.logf(LOG_ERROR, "failed to move message %s to %s - %s",
+						msg.ID, toTopic.name, err)
+					// the messages that weren't moved go back where they were
+					s.putBackMessages(channel, msgs[i:])
+					return nil, http_api.Err{500, fmt.Sprintf("MOVE_FAILED: moved %d of %d messages", i, len(msgs))}
+				}
+			}
+		}
+	}
+
+	s.ctx.nsqd.logf(LOG_INFO, "CHANNEL(%s:%s): %s %d messages (%+v)",
+		topic.name, channelName, path.Base(req.URL.Path), result.Total(), result)
+
+	return result, nil
+}
+
+func (s *httpServer) putBackMessages(channel *Channel, msgs []*Message) {
+	n, err := channel.PutBackMessages(msgs)
+	if err != nil {
+		s.ctx.nsqd.logf(LOG_ERROR, "CHANNEL(%s:%s): lost %d messages, failed to put them back - %s",
+			channel.topicName, channel.name, len(msgs)-n, err)
+	}
+}
+
+// getMessageFilter builds a MessageFilter from the id (repeatable),
+// body_contains, body_regexp, min_attempts and before (unix ms) params
+func getMessageFilter(reqParams *http_api.ReqParams) (*MessageFilter, error) {
+	filter := &MessageFilter{}
+
+	for _, v := range reqParams.Values["id"] {
+		if len(v) != MsgIDLength {
+			return nil, http_api.Err{400, "INVALID_ID"}
+		}
+		var id MessageID
+		copy(id[:], v)
+		filter.IDs = append(filter.IDs, id)
+	}
+
+	if v, err := reqParams.Get("body_contains"); err == nil {
+		filter.BodyContains = []byte(v)
+	}
+
+	if v, err := reqParams.Get("body_regexp"); err == nil {
+		filter.BodyRegexp, err = regexp.Compile(v)
+		if err != nil {
+			return nil, http_api.Err{400, "INVALID_BODY_REGEXP"}
+		}
+	}
+
+	if v, err := reqParams.Get("min_attempts"); err == nil {
+		attempts, err := strconv.ParseUint(v, 10, 16)
+		if err != nil {
+			return nil, http_api.Err{400, "INVALID_MIN_ATTEMPTS"}
+		}
+		filter.MinAttempts = uint16(attempts)
+	}
+
+	if v, err := reqParams.Get("before"); err == nil {
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || ms <= 0 {
+			return nil, http_api.Err{400, "INVALID_BEFORE"}
+		}
+		filter.Before = int64(time.Duration(ms) * time.Millisecond)
+	}
+
+	// matching everything is what /channel/empty is for
+	if filter.IsEmpty() {
+		return nil, http_api.Err{400, "MISSING_FILTER"}
+	}
+
+	return filter, nil
+}
+
+// getPeekParams returns the number of messages and the size of the body
+// preview requested, both optional
+func (s *httpServer) getPeekParams(reqParams *http_api.ReqParams) (int, int, error) {
+	count := defaultPeekCount
+	if v, err := reqParams.Get("count"); err == nil {
+		count, err = strconv.Atoi(v)
+		if err != nil || count <= 0 || count > maxPeekCount {
+			return 0, 0, http_api.Err{400, "INVALID_COUNT"}
+		}
+	}
+
+	previewSize := defaultPeekPreviewSize
+	if v, err := reqParams.Get("preview"); err == nil {
+		previewSize, err = strconv.Atoi(v)
+		if err != nil || previewSize < 0 {
+			return 0, 0, http_api.Err{400, "INVALID_PREVIEW"}
+		}
+	}
+
+	return count, previewSize, nil
+}
+
+func (s *httpServer) doTopicSchema(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	reqParams, err := http_api.NewReqParams(req)
+	if err != nil {
+		s.ctx.nsqd.logf(LOG_ERROR, "failed to parse request params - %s", err)
+		return nil, http_api.Err{400, "INVALID_REQUEST"}
+	}
+
+	topicName, err := reqParams.Get("topic")
+	if err != nil {
+		return nil, http_api.Err{400, "MISSING_ARG_TOPIC"}
+	}
+
+	topic, err := s.ctx.nsqd.GetExistingTopic(topicName)
+	if err != nil {
+		return nil, http_api.Err{404, "TOPIC_NOT_FOUND"}
+	}
+
+	switch req.Method {
+	case "POST":
+		if len(reqParams.Body) == 0 {
+			return nil, http_api.Err{400, "MISSING_BODY"}
+		}
+		schema, err := NewSchema(reqParams.Body)
+		if err != nil {
+			return nil, http_api.Err{400, fmt.Sprintf("INVALID_SCHEMA: %s", err)}
+		}
+		s.ctx.nsqd.logf(LOG_INFO, "TOPIC(%s): setting schema", topic.name)
+		topic.SetSchema(schema)
+		return schema, nil
+	case "DELETE":
+		s.ctx.nsqd.logf(LOG_INFO, "TOPIC(%s): removing schema", topic.name)
+		topic.SetSchema(nil)
+		return nil, nil
+	}
+
+	schema := topic.GetSchema()
+	if schema == nil {
+		return nil, http_api.Err{404, "SCHEMA_NOT_FOUND"}
+	}
+	return schema, nil
+}
+
+func (s *httpServer) doTopicDurability(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	reqParams, err := http_api.NewReqParams(req)
+	if err != nil {
+		s.ctx.nsqd.logf(LOG_ERROR, "failed to parse request params - %s", err)
+		return nil, http_api.Err{400, "INVALID_REQUEST"}
+	}
+
+	topicName, err := reqParams.Get("topic")
+	if err != nil {
+		return nil, http_api.Err{400, "MISSING_ARG_TOPIC"}
+	}
+
+	topic, err := s.ctx.nsqd.GetExistingTopic(topicName)
+	if err != nil {
+		return nil, http_api.Err{404, "TOPIC_NOT_FOUND"}
+	}
+
+	if req.Method == "POST" {
+		level, err := reqParams.Get("level")
+		if err != nil {
+			return nil, http_api.Err{400, "MISSING_ARG_LEVEL"}
+		}
+		d, err := ParseDurability(level)
+		if err != nil {
+			return nil, http_api.Err{400, "INVALID_ARG_LEVEL"}
+		}
+		if d != DurabilityMemory && topic.ephemeral {
+			return nil, http_api.Err{400, "INVALID_DURABILITY: ephemeral topics are memory only"}
+		}
+		s.ctx.nsqd.logf(LOG_INFO, "TOPIC(%s): setting durability %s", topic.name, d)
+		err = topic.SetDurability(d)
+		if err != nil {
+			s.ctx.nsqd.logf(LOG_ERROR, "TOPIC(%s): failed to set durability - %s", topic.name, err)
+			return nil, http_api.Err{500, "INTERNAL_ERROR"}
+		}
+	}
+
+	return struct {
+		Durability string `json:"durability"`
+	}{topic.GetDurability().String()}, nil
+}
+
+func (s *httpServer) doChannelMetadata(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	reqParams, topic, channelName, err := s.getExistingTopicFromQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	channel, err := topic.GetExistingChannel(channelName)
+	if err != nil {
+		return nil, http_api.Err{404, "CHANNEL_NOT_FOUND"}
+	}
+
+	if req.Method == "POST" {
+		m, err := parseMetadataBody(reqParams.Body)
+		if err != nil {
+			return nil, err
+		}
+		err = channel.SetMetadata(m)
+		if err != nil {
+			return nil, http_api.Err{400, fmt.Sprintf("INVALID_METADATA: %s", err)}
+		}
+	}
+
+	return channel.GetMetadata(), nil
+}
+
+func parseMetadataBody(body []byte) (Metadata, error) {
+	var m Metadata
+	if len(body) == 0 {
+		return m, http_api.Err{400, "MISSING_BODY"}
+	}
+	err := json.Unmarshal(body, &m)
+	if err != nil {
+		return m, http_api.Err{400, "INVALID_BODY"}
+	}
+	return m, nil
+}
+
 func (s *httpServer) doCreateChannel(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
 	_, topic, channelName, err := s.getExistingTopicFromQuery(req)
 	if err != nil {
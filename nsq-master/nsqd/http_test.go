@@ -12,6 +12,7 @@ import (
 	"runtime"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -227,6 +228,17 @@ func TestHTTPpubDefer(t *testing.T) {
 	numDef := len(ch.deferredMessages)
 	ch.deferredMutex.Unlock()
 	test.Equal(t, 1, numDef)
+
+	for _, d := range []Durability{DurabilityDisk, DurabilityFsync} {
+		test.Nil(t, topic.SetDurability(d))
+		buf := bytes.NewBuffer([]byte("test message"))
+		resp, err := http.Post(url, "application/octet-stream", buf)
+		test.Nil(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		test.Equal(t, 400, resp.StatusCode)
+		test.Equal(t, `{"message":"INVALID_DEFER: `+errDeferredNotDurable.Error()+`"}`, string(body))
+	}
 }
 
 func TestHTTPSRequire(t *testing.T) {
@@ -795,6 +807,248 @@ func TestEmptyTopic(t *testing.T) {
 	test.Equal(t, []byte(""), body)
 }
 
+func TestHTTPTopicChannelMetadata(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	_, httpAddr, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	topicName := "test_http_metadata" + strconv.Itoa(int(time.Now().Unix()))
+	nsqd.GetTopic(topicName).GetChannel("ch")
+
+	em := ErrMessage{}
+
+	url := fmt.Sprintf("http://%s/topic/metadata?topic=%s", httpAddr, topicName)
+	resp, err := http.Post(url, "application/json", bytes.NewBufferString(`{"labels":{"a b":"c"}}`))
+	test.Nil(t, err)
+	test.Equal(t, 400, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	err = json.Unmarshal(body, &em)
+	test.Nil(t, err)
+	test.Equal(t, true, strings.HasPrefix(em.Message, "INVALID_METADATA"))
+
+	resp, err = http.Post(url, "application/json",
+		bytes.NewBufferString(`{"description":"orders","labels":{"owner":"team-a"}}`))
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Get(url)
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	var m Metadata
+	err = json.Unmarshal(body, &m)
+	test.Nil(t, err)
+	test.Equal(t, "orders", m.Description)
+	test.Equal(t, "team-a", m.Labels["owner"])
+
+	url = fmt.Sprintf("http://%s/channel/metadata?topic=%s&channel=nope", httpAddr, topicName)
+	resp, err = http.Post(url, "application/json", bytes.NewBufferString(`{}`))
+	test.Nil(t, err)
+	test.Equal(t, 404, resp.StatusCode)
+	resp.Body.Close()
+
+	url = fmt.Sprintf("http://%s/channel/metadata?topic=%s&channel=ch", httpAddr, topicName)
+	resp, err = http.Post(url, "application/json", bytes.NewBufferString(`{"labels":{"sla":"1m"}}`))
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	resp.Body.Close()
+
+	topic, _ := nsqd.GetExistingTopic(topicName)
+	channel, _ := topic.GetExistingChannel("ch")
+	test.Equal(t, "1m", channel.GetMetadata().Labels["sla"])
+}
+
+func TestHTTPTopicSchema(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	_, httpAddr, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	topicName := "test_http_schema" + strconv.Itoa(int(time.Now().Unix()))
+	nsqd.GetTopic(topicName)
+
+	em := ErrMessage{}
+
+	url := fmt.Sprintf("http://%s/topic/schema?topic=%s", httpAddr, topicName)
+	resp, err := http.Get(url)
+	test.Nil(t, err)
+	test.Equal(t, 404, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Post(url, "application/json", bytes.NewBufferString(`{"$ref": "#/nope"}`))
+	test.Nil(t, err)
+	test.Equal(t, 400, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	err = json.Unmarshal(body, &em)
+	test.Nil(t, err)
+	test.Equal(t, true, strings.HasPrefix(em.Message, "INVALID_SCHEMA"))
+
+	schema := `{"type":"object","properties":{"n":{"type":"integer"}}}`
+	resp, err = http.Post(url, "application/json", bytes.NewBufferString(schema))
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Get(url)
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	test.Equal(t, schema, string(body))
+
+	pubURL := fmt.Sprintf("http://%s/pub?topic=%s", httpAddr, topicName)
+	resp, err = http.Post(pubURL, "application/octet-stream", bytes.NewBufferString(`{"n": "1"}`))
+	test.Nil(t, err)
+	test.Equal(t, 400, resp.StatusCode)
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	err = json.Unmarshal(body, &em)
+	test.Nil(t, err)
+	test.Equal(t, "INVALID_MESSAGE: /n: expected integer, got string", em.Message)
+
+	mpubURL := fmt.Sprintf("http://%s/mpub?topic=%s", httpAddr, topicName)
+	resp, err = http.Post(mpubURL, "application/octet-stream", bytes.NewBufferString("{\"n\": 1}\n{\"n\": 2}"))
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	resp.Body.Close()
+
+	req, _ := http.NewRequest("DELETE", url, nil)
+	resp, err = http.DefaultClient.Do(req)
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Post(pubURL, "application/octet-stream", bytes.NewBufferString(`{"n": "1"}`))
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestHTTPPeek(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.MemQueueSize = 2
+	_, httpAddr, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	topicName := "test_http_peek" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	for i := 0; i < 5; i++ {
+		msg := NewMessage(topic.GenerateID(), []byte(fmt.Sprintf("message %d", i)))
+		topic.PutMessage(msg)
+	}
+
+	var pr PeekResult
+
+	url := fmt.Sprintf("http://%s/topic/peek?topic=%s&count=2&preview=7", httpAddr, topicName)
+	resp, err := http.Get(url)
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	err = json.Unmarshal(body, &pr)
+	test.Nil(t, err)
+	// messages queued in memory are only counted
+	test.Equal(t, int64(2), pr.MemoryDepth)
+	test.Equal(t, 2, len(pr.Messages))
+	test.Equal(t, "disk", pr.Messages[0].Source)
+	test.Equal(t, "message", pr.Messages[0].Body)
+	test.Equal(t, 9, pr.Messages[0].Size)
+	test.Equal(t, true, pr.Messages[0].Truncated)
+
+	// nothing was dequeued
+	test.Equal(t, int64(5), topic.Depth())
+
+	url = fmt.Sprintf("http://%s/topic/peek?topic=%s&count=0", httpAddr, topicName)
+	resp, err = http.Get(url)
+	test.Nil(t, err)
+	test.Equal(t, 400, resp.StatusCode)
+	resp.Body.Close()
+
+	channel := topic.GetChannel("ch")
+	for channel.Depth() != 5 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	url = fmt.Sprintf("http://%s/channel/peek?topic=%s&channel=ch", httpAddr, topicName)
+	resp, err = http.Get(url)
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	pr = PeekResult{}
+	err = json.Unmarshal(body, &pr)
+	test.Nil(t, err)
+	test.Equal(t, int64(2), pr.MemoryDepth)
+	test.Equal(t, 3, len(pr.Messages))
+	test.Equal(t, int64(5), channel.Depth())
+
+	bodies := make(map[string]bool)
+	for _, m := range pr.Messages {
+		bodies[m.Body] = true
+	}
+	test.Equal(t, 3, len(bodies))
+}
+
+func TestPeekEphemeralChannelWhilePublishing(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	_, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	topicName := "test_peek_ephemeral" + strconv.Itoa(int(time.Now().Unix())) + "#ephemeral"
+	topic := nsqd.GetTopic(topicName)
+	channel := topic.GetChannel("ch#ephemeral")
+
+	const n = 2000
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			msg := NewMessage(topic.GenerateID(), []byte(strconv.Itoa(i)))
+			err := topic.PutMessage(msg)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	peeks := 0
+	for {
+		select {
+		case <-done:
+		default:
+			result, err := channel.Peek(10, -1)
+			test.Nil(t, err)
+			test.Equal(t, 0, len(result.Messages))
+			_, err = topic.Peek(10, -1)
+			test.Nil(t, err)
+			peeks++
+			continue
+		}
+		break
+	}
+	t.Logf("%d peeks while publishing", peeks)
+
+	for i := 0; i < n; i++ {
+		select {
+		case msg := <-channel.memoryMsgChan:
+			test.Equal(t, strconv.Itoa(i), string(msg.Body))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message %d was not delivered", i)
+		}
+	}
+}
+
 func TestEmptyChannel(t *testing.T) {
 	opts := NewOptions()
 	opts.Logger = test.NewTestLogger(t)
@@ -937,3 +1191,161 @@ func BenchmarkHTTPpub(b *testing.B) {
 	b.StopTimer()
 	nsqd.Exit()
 }
+
+func TestHTTPChannelMessages(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	_, httpAddr, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	topicName := "test_http_channel_messages" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	channel := topic.GetChannel("ch")
+
+	var msgs []*Message
+	for i := 0; i < 6; i++ {
+		msg := NewMessage(topic.GenerateID(), []byte(fmt.Sprintf("message %d", i)))
+		msgs = append(msgs, msg)
+	}
+	channel.StartInFlightTimeout(msgs[0], 0, opts.MsgTimeout)
+	channel.StartInFlightTimeout(msgs[1], 0, opts.MsgTimeout)
+	for _, msg := range msgs[2:] {
+		channel.PutMessage(msg)
+	}
+
+	var result MessageOpResult
+
+	url := fmt.Sprintf("http://%s/channel/messages/delete?topic=%s&channel=ch", httpAddr, topicName)
+	resp, err := http.Post(url, "application/json", nil)
+	test.Nil(t, err)
+	test.Equal(t, 400, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	test.Equal(t, `{"message":"MISSING_FILTER"}`, string(body))
+
+	url = fmt.Sprintf("http://%s/channel/messages/delete?topic=%s&channel=ch&id=%s&id=%s",
+		httpAddr, topicName, msgs[0].ID, msgs[2].ID)
+	resp, err = http.Post(url, "application/json", nil)
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	err = json.Unmarshal(body, &result)
+	test.Nil(t, err)
+	test.Equal(t, MessageOpResult{InFlight: 1, Memory: 1}, result)
+
+	url = fmt.Sprintf("http://%s/channel/messages/move?topic=%s&channel=ch&body_regexp=[45]$",
+		httpAddr, topicName)
+	resp, err = http.Post(url, "application/json", nil)
+	test.Nil(t, err)
+	test.Equal(t, 400, resp.StatusCode)
+	resp.Body.Close()
+
+	url = fmt.Sprintf("http://%s/channel/messages/move?topic=%s&channel=ch&body_regexp=[45]$&to_topic=%s_dead",
+		httpAddr, topicName, topicName)
+	resp, err = http.Post(url, "application/json", nil)
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	err = json.Unmarshal(body, &result)
+	test.Nil(t, err)
+	test.Equal(t, MessageOpResult{Memory: 2}, result)
+	test.Equal(t, int64(1), channel.Depth())
+
+	deadTopic, err := nsqd.GetExistingTopic(topicName + "_dead")
+	test.Nil(t, err)
+	test.Equal(t, int64(2), deadTopic.Depth())
+
+	url = fmt.Sprintf("http://%s/channel/messages/move?topic=%s&channel=ch&body_regexp=3$&to_topic=%s",
+		httpAddr, topicName, topicName)
+	resp, err = http.Post(url, "application/json", nil)
+	test.Nil(t, err)
+	test.Equal(t, 400, resp.StatusCode)
+	resp.Body.Close()
+
+	// messages that can't be moved are put back in the channel
+	atomic.StoreInt32(&deadTopic.exitFlag, 1)
+	url = fmt.Sprintf("http://%s/channel/messages/move?topic=%s&channel=ch&body_regexp=3$&to_topic=%s_dead",
+		httpAddr, topicName, topicName)
+	resp, err = http.Post(url, "application/json", nil)
+	test.Nil(t, err)
+	test.Equal(t, 500, resp.StatusCode)
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	test.Equal(t, `{"message":"MOVE_FAILED: moved 0 of 1 messages"}`, string(body))
+	atomic.StoreInt32(&deadTopic.exitFlag, 0)
+	test.Equal(t, int64(1), channel.Depth())
+	test.Equal(t, int64(2), deadTopic.Depth())
+
+	url = fmt.Sprintf("http://%s/channel/messages/requeue?topic=%s&channel=ch&body_contains=message",
+		httpAddr, topicName)
+	resp, err = http.Post(url, "application/json", nil)
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	err = json.Unmarshal(body, &result)
+	test.Nil(t, err)
+	test.Equal(t, MessageOpResult{InFlight: 1}, result)
+	test.Equal(t, int64(2), channel.Depth())
+}
+
+func TestHTTPTopicDurability(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	_, httpAddr, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	topicName := "test_http_topic_durability" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+
+	type durabilityResp struct {
+		Durability string `json:"durability"`
+	}
+	var dr durabilityResp
+
+	url := fmt.Sprintf("http://%s/topic/durability?topic=%s", httpAddr, topicName)
+	resp, err := http.Get(url)
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	test.Nil(t, json.Unmarshal(body, &dr))
+	test.Equal(t, "memory", dr.Durability)
+
+	resp, err = http.Post(url+"&level=paper", "application/json", nil)
+	test.Nil(t, err)
+	test.Equal(t, 400, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = http.Post(url+"&level=fsync", "application/json", nil)
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	test.Nil(t, json.Unmarshal(body, &dr))
+	test.Equal(t, "fsync", dr.Durability)
+	test.Equal(t, DurabilityFsync, topic.GetDurability())
+
+	url = fmt.Sprintf("http://%s/pub?topic=%s", httpAddr, topicName)
+	resp, err = http.Post(url, "application/octet-stream", bytes.NewBufferString("payment"))
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	resp.Body.Close()
+	test.Equal(t, int64(1), topic.backend.Depth())
+
+	// persisted (asynchronously) to the metadata file
+	var m *meta
+	for i := 0; i < 100; i++ {
+		m, err = getMetadata(nsqd)
+		if err == nil && len(m.Topics) > 0 && m.Topics[0].Durability != "" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	test.Nil(t, err)
+	test.Equal(t, "fsync", m.Topics[0].Durability)
+}
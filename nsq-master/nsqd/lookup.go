@@ -2,7 +2,11 @@ package nsqd
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"net"
 	"os"
 	"strconv"
@@ -20,6 +24,15 @@ func connectCallback(n *NSQD, hostname string) func(*lookupPeer) {
 		ci["http_port"] = n.RealHTTPAddr().Port
 		ci["hostname"] = hostname
 		ci["broadcast_address"] = n.getOpts().BroadcastAddress
+		if n.getOpts().NSQLookupdSecret != "" {
+			ci["secret"] = n.getOpts().NSQLookupdSecret
+		}
+		if n.getOpts().Zone != "" {
+			ci["zone"] = n.getOpts().Zone
+		}
+		if n.getOpts().Region != "" {
+			ci["region"] = n.getOpts().Region
+		}
 
 		cmd, err := nsq.Identify(ci)
 		if err != nil {
@@ -34,6 +47,10 @@ func connectCallback(n *NSQD, hostname string) func(*lookupPeer) {
 			n.logf(LOG_INFO, "LOOKUPD(%s): lookupd returned %s", lp, resp)
 			lp.Close()
 			return
+		} else if bytes.HasPrefix(resp, []byte("E_UNAUTHORIZED")) || bytes.HasPrefix(resp, []byte("E_TLS_REQUIRED")) {
+			n.logf(LOG_ERROR, "LOOKUPD(%s): lookupd returned %s", lp, resp)
+			lp.Close()
+			return
 		} else {
 			err = json.Unmarshal(resp, &lp.Info)
 			if err != nil {
@@ -60,6 +77,16 @@ func connectCallback(n *NSQD, hostname string) func(*lookupPeer) {
 					commands = append(commands, nsq.Register(channel.topicName, channel.name))
 				}
 			}
+			if lp.Info.Metadata {
+				if m := topic.GetMetadata(); !m.IsEmpty() {
+					commands = append(commands, metadataCommand(topic.name, "", m))
+				}
+				for _, channel := range topic.channelMap {
+					if m := channel.GetMetadata(); !m.IsEmpty() {
+						commands = append(commands, metadataCommand(channel.topicName, channel.name, m))
+					}
+				}
+			}
 			topic.RUnlock()
 		}
 		n.RUnlock()
@@ -95,8 +122,8 @@ func (n *NSQD) lookupLoop() {
 					continue
 				}
 				n.logf(LOG_INFO, "LOOKUP(%s): adding peer", host)
-				lookupPeer := newLookupPeer(host, n.getOpts().MaxBodySize, n.logf,
-					connectCallback(n, hostname))
+				lookupPeer := newLookupPeer(host, n.getOpts().MaxBodySize, n.lookupdTLSConfig,
+					n.logf, connectCallback(n, hostname))
 				lookupPeer.Command(nil) // start the connection
 				lookupPeers = append(lookupPeers, lookupPeer)
 				lookupAddrs = append(lookupAddrs, host)
@@ -118,6 +145,7 @@ func (n *NSQD) lookupLoop() {
 			}
 		case val := <-n.notifyChan:
 			var cmd *nsq.Command
+			var metaCmd *nsq.Command
 			var branch string
 
 			switch val.(type) {
@@ -129,6 +157,7 @@ func (n *NSQD) lookupLoop() {
 					cmd = nsq.UnRegister(channel.topicName, channel.name)
 				} else {
 					cmd = nsq.Register(channel.topicName, channel.name)
+					metaCmd = metadataCommand(channel.topicName, channel.name, channel.GetMetadata())
 				}
 			case *Topic:
 				// notify all nsqlookupds that a new topic exists, or that it's removed
@@ -138,6 +167,7 @@ func (n *NSQD) lookupLoop() {
 					cmd = nsq.UnRegister(topic.name, "")
 				} else {
 					cmd = nsq.Register(topic.name, "")
+					metaCmd = metadataCommand(topic.name, "", topic.GetMetadata())
 				}
 			}
 
@@ -146,6 +176,16 @@ func (n *NSQD) lookupLoop() {
 				_, err := lookupPeer.Command(cmd)
 				if err != nil {
 					n.logf(LOG_ERROR, "LOOKUPD(%s): %s - %s", lookupPeer, cmd, err)
+					continue
+				}
+				// older nsqlookupd treat unknown commands as fatal, only send
+				// metadata to those that advertised support for it
+				if metaCmd == nil || !lookupPeer.Info.Metadata {
+					continue
+				}
+				_, err = lookupPeer.Command(metaCmd)
+				if err != nil {
+					n.logf(LOG_ERROR, "LOOKUPD(%s): %s - %s", lookupPeer, metaCmd, err)
 				}
 			}
 		case <-n.optsNotificationChan:
@@ -172,6 +212,18 @@ exit:
 	n.logf(LOG_INFO, "LOOKUP: closing")
 }
 
+// metadataCommand builds a METADATA command that replaces the description
+// and labels nsqlookupd has stored for the given topic (and optional channel)
+func metadataCommand(topic string, channel string, m Metadata) *nsq.Command {
+	params := [][]byte{[]byte(topic)}
+	if len(channel) > 0 {
+		params = append(params, []byte(channel))
+	}
+	// Metadata only contains strings so this can't fail
+	body, _ := json.Marshal(m)
+	return &nsq.Command{Name: []byte("METADATA"), Params: params, Body: body}
+}
+
 func in(s string, lst []string) bool {
 	for _, v := range lst {
 		if s == v {
@@ -196,3 +248,33 @@ func (n *NSQD) lookupdHTTPAddrs() []string {
 	}
 	return lookupHTTPAddrs
 }
+
+// buildLookupdTLSConfig returns the config to connect to nsqlookupd with,
+// the nsqd TLS certificate (if any) is presented for mutual TLS
+func buildLookupdTLSConfig(opts *Options) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: opts.TLSMinVersion,
+	}
+
+	if opts.TLSCert != "" || opts.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(opts.TLSCert, opts.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if opts.TLSRootCAFile != "" {
+		tlsCertPool := x509.NewCertPool()
+		caCertFile, err := ioutil.ReadFile(opts.TLSRootCAFile)
+		if err != nil {
+			return nil, err
+		}
+		if !tlsCertPool.AppendCertsFromPEM(caCertFile) {
+			return nil, errors.New("failed to append certificate to pool")
+		}
+		tlsConfig.RootCAs = tlsCertPool
+	}
+
+	return tlsConfig, nil
+}
@@ -1,6 +1,7 @@
 package nsqd
 
 import (
+	"crypto/tls"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -19,6 +20,7 @@ import (
 type lookupPeer struct {
 	logf            lg.AppLogFunc
 	addr            string
+	tlsConfig       *tls.Config
 	conn            net.Conn
 	state           int32
 	connectCallback func(*lookupPeer)
@@ -32,15 +34,18 @@ type peerInfo struct {
 	HTTPPort         int    `json:"http_port"`
 	Version          string `json:"version"`
 	BroadcastAddress string `json:"broadcast_address"`
+	Metadata         bool   `json:"metadata"`
 }
 
 // newLookupPeer creates a new lookupPeer instance connecting to the supplied address.
 //
 // The supplied connectCallback will be called *every* time the instance connects.
-func newLookupPeer(addr string, maxBodySize int64, l lg.AppLogFunc, connectCallback func(*lookupPeer)) *lookupPeer {
+func newLookupPeer(addr string, maxBodySize int64, tlsConfig *tls.Config, l lg.AppLogFunc,
+	connectCallback func(*lookupPeer)) *lookupPeer {
 	return &lookupPeer{
 		logf:            l,
 		addr:            addr,
+		tlsConfig:       tlsConfig,
 		state:           stateDisconnected,
 		maxBodySize:     maxBodySize,
 		connectCallback: connectCallback,
@@ -50,7 +55,22 @@ func newLookupPeer(addr string, maxBodySize int64, l lg.AppLogFunc, connectCallb
 // Connect will Dial the specified address, with timeouts
 func (lp *lookupPeer) Connect() error {
 	lp.logf(lg.INFO, "LOOKUP connecting to %s", lp.addr)
-	conn, err := net.DialTimeout("tcp", lp.addr, time.Second)
+	dialer := &net.Dialer{Timeout: time.Second}
+	if lp.tlsConfig != nil {
+		host, _, err := net.SplitHostPort(lp.addr)
+		if err != nil {
+			return err
+		}
+		tlsConfig := lp.tlsConfig.Clone()
+		tlsConfig.ServerName = host
+		conn, err := tls.DialWithDialer(dialer, "tcp", lp.addr, tlsConfig)
+		if err != nil {
+			return err
+		}
+		lp.conn = conn
+		return nil
+	}
+	conn, err := dialer.Dial("tcp", lp.addr)
 	if err != nil {
 		return err
 	}
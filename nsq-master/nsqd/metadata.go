@@ -0,0 +1,80 @@
+package nsqd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	maxMetadataLabels        = 64
+	maxMetadataLabelLength   = 256
+	maxMetadataDescriptionSz = 4096
+)
+
+// Metadata is the operator supplied description and set of labels
+// (ie. owner team, schema URL, SLA) attached to a topic or channel
+type Metadata struct {
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// IsEmpty returns true if neither a description nor any labels are set
+func (m Metadata) IsEmpty() bool {
+	return m.Description == "" && len(m.Labels) == 0
+}
+
+// Copy returns a deep copy so that callers can't mutate shared state
+func (m Metadata) Copy() Metadata {
+	c := Metadata{Description: m.Description}
+	if len(m.Labels) > 0 {
+		c.Labels = make(map[string]string, len(m.Labels))
+		for k, v := range m.Labels {
+			c.Labels[k] = v
+		}
+	}
+	return c
+}
+
+// Validate checks the size and naming constraints of the description and labels
+func (m Metadata) Validate() error {
+	if len(m.Description) > maxMetadataDescriptionSz {
+		return fmt.Errorf("description longer than %d bytes", maxMetadataDescriptionSz)
+	}
+	if len(m.Labels) > maxMetadataLabels {
+		return fmt.Errorf("more than %d labels", maxMetadataLabels)
+	}
+	for k, v := range m.Labels {
+		if k == "" {
+			return errors.New("empty label name")
+		}
+		if len(k) > maxMetadataLabelLength || len(v) > maxMetadataLabelLength {
+			return fmt.Errorf("label %q name or value longer than %d bytes", k, maxMetadataLabelLength)
+		}
+		if strings.ContainsAny(k, " \t\r\n:=,") {
+			return fmt.Errorf("label name %q contains invalid characters", k)
+		}
+	}
+	return nil
+}
+
+// metadataHolder provides thread safe access to Metadata and is embedded
+// in both Topic and Channel
+type metadataHolder struct {
+	metadataMutex sync.RWMutex
+	metadata      Metadata
+}
+
+// GetMetadata returns a copy of the current metadata
+func (h *metadataHolder) GetMetadata() Metadata {
+	h.metadataMutex.RLock()
+	defer h.metadataMutex.RUnlock()
+	return h.metadata.Copy()
+}
+
+func (h *metadataHolder) setMetadata(m Metadata) {
+	h.metadataMutex.Lock()
+	h.metadata = m.Copy()
+	h.metadataMutex.Unlock()
+}
@@ -67,6 +67,8 @@ type NSQD struct {
 	httpsListener net.Listener
 	tlsConfig     *tls.Config
 
+	lookupdTLSConfig *tls.Config
+
 	poolSize int
 
 	notifyChan           chan interface{}
@@ -146,6 +148,13 @@ func New(opts *Options) (*NSQD, error) {
 	}
 	n.tlsConfig = tlsConfig
 
+	if opts.NSQLookupdTLS {
+		n.lookupdTLSConfig, err = buildLookupdTLSConfig(opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build lookupd TLS config - %s", err)
+		}
+	}
+
 	for _, v := range opts.E2EProcessingLatencyPercentiles {
 		if v <= 0 || v > 1 {
 			return nil, fmt.Errorf("invalid E2E processing latency percentile: %v", v)
@@ -286,11 +295,17 @@ func (n *NSQD) Main() error {
 
 type meta struct {
 	Topics []struct {
-		Name     string `json:"name"`
-		Paused   bool   `json:"paused"`
-		Channels []struct {
-			Name   string `json:"name"`
-			Paused bool   `json:"paused"`
+		Name        string            `json:"name"`
+		Paused      bool              `json:"paused"`
+		Description string            `json:"description"`
+		Labels      map[string]string `json:"labels"`
+		Schema      json.RawMessage   `json:"schema"`
+		Durability  string            `json:"durability"`
+		Channels    []struct {
+			Name        string            `json:"name"`
+			Paused      bool              `json:"paused"`
+			Description string            `json:"description"`
+			Labels      map[string]string `json:"labels"`
 		} `json:"channels"`
 	} `json:"topics"`
 }
@@ -352,6 +367,24 @@ func (n *NSQD) LoadMetadata() error {
 		if t.Paused {
 			topic.Pause()
 		}
+		topic.setMetadata(Metadata{Description: t.Description, Labels: t.Labels})
+		if len(t.Schema) > 0 {
+			schema, err := NewSchema(t.Schema)
+			if err != nil {
+				n.logf(LOG_WARN, "skipping invalid schema of topic %s - %s", t.Name, err)
+			} else {
+				topic.setSchema(schema)
+			}
+		}
+		if t.Durability != "" {
+			d, err := ParseDurability(t.Durability)
+			if err == nil {
+				err = topic.SetDurability(d)
+			}
+			if err != nil {
+				n.logf(LOG_WARN, "skipping durability of topic %s - %s", t.Name, err)
+			}
+		}
 		for _, c := range t.Channels {
 			if !protocol.IsValidChannelName(c.Name) {
 				n.logf(LOG_WARN, "skipping creation of invalid channel %s", c.Name)
@@ -361,6 +394,7 @@ func (n *NSQD) LoadMetadata() error {
 			if c.Paused {
 				channel.Pause()
 			}
+			channel.setMetadata(Metadata{Description: c.Description, Labels: c.Labels})
 		}
 		topic.Start()
 	}
@@ -382,6 +416,13 @@ func (n *NSQD) PersistMetadata() error {
 		topicData := make(map[string]interface{})
 		topicData["name"] = topic.name
 		topicData["paused"] = topic.IsPaused()
+		addMetadata(topicData, topic.GetMetadata())
+		if schema := topic.GetSchema(); schema != nil {
+			topicData["schema"] = schema
+		}
+		if d := topic.GetDurability(); d != DurabilityMemory {
+			topicData["durability"] = d.String()
+		}
 		channels := []interface{}{}
 		topic.Lock()
 		for _, channel := range topic.channelMap {
@@ -393,6 +434,7 @@ func (n *NSQD) PersistMetadata() error {
 			channelData := make(map[string]interface{})
 			channelData["name"] = channel.name
 			channelData["paused"] = channel.IsPaused()
+			addMetadata(channelData, channel.GetMetadata())
 			channels = append(channels, channelData)
 			channel.Unlock()
 		}
@@ -423,6 +465,15 @@ func (n *NSQD) PersistMetadata() error {
 	return nil
 }
 
+func addMetadata(data map[string]interface{}, m Metadata) {
+	if m.Description != "" {
+		data["description"] = m.Description
+	}
+	if len(m.Labels) > 0 {
+		data["labels"] = m.Labels
+	}
+}
+
 func (n *NSQD) Exit() {
 	if n.tcpListener != nil {
 		n.tcpListener.Close()
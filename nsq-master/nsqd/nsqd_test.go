@@ -240,6 +240,55 @@ func TestPauseMetadata(t *testing.T) {
 	test.Equal(t, false, isPaused(nsqd, 0, 0))
 }
 
+func TestTopicChannelMetadata(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	_, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+
+	// avoid concurrency issue of async PersistMetadata() calls
+	atomic.StoreInt32(&nsqd.isLoading, 1)
+	topicName := "labels_metadata" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	channel := topic.GetChannel("ch")
+	err := topic.SetMetadata(Metadata{
+		Description: "billing events",
+		Labels:      map[string]string{"owner": "payments"},
+	})
+	test.Nil(t, err)
+	err = channel.SetMetadata(Metadata{Labels: map[string]string{"sla": "5m"}})
+	test.Nil(t, err)
+	err = topic.SetMetadata(Metadata{Labels: map[string]string{"bad label": "x"}})
+	test.NotNil(t, err)
+	schema, err := NewSchema([]byte(`{"type":"object"}`))
+	test.Nil(t, err)
+	topic.SetSchema(schema)
+	atomic.StoreInt32(&nsqd.isLoading, 0)
+	nsqd.PersistMetadata()
+
+	m, err := getMetadata(nsqd)
+	test.Nil(t, err)
+	test.Equal(t, "billing events", m.Topics[0].Description)
+	test.Equal(t, "payments", m.Topics[0].Labels["owner"])
+	test.Equal(t, "5m", m.Topics[0].Channels[0].Labels["sla"])
+	nsqd.Exit()
+
+	// start up a new nsqd w/ the same folder
+	_, _, nsqd = mustStartNSQD(opts)
+	defer nsqd.Exit()
+	err = nsqd.LoadMetadata()
+	test.Nil(t, err)
+
+	topic, err = nsqd.GetExistingTopic(topicName)
+	test.Nil(t, err)
+	test.Equal(t, "billing events", topic.GetMetadata().Description)
+	test.NotNil(t, topic.GetSchema())
+	test.NotNil(t, topic.ValidateMessage([]byte(`[]`)))
+	channel, err = topic.GetExistingChannel("ch")
+	test.Nil(t, err)
+	test.Equal(t, "5m", channel.GetMetadata().Labels["sla"])
+}
+
 func mustStartNSQLookupd(opts *nsqlookupd.Options) (*net.TCPAddr, *net.TCPAddr, *nsqlookupd.NSQLookupd) {
 	opts.TCPAddress = "127.0.0.1:0"
 	opts.HTTPAddress = "127.0.0.1:0"
@@ -316,6 +365,53 @@ func TestReconfigure(t *testing.T) {
 	test.Equal(t, newOpts.NSQLookupdTCPAddresses, lookupPeers)
 }
 
+func TestClusterMetadata(t *testing.T) {
+	lopts := nsqlookupd.NewOptions()
+	lopts.Logger = test.NewTestLogger(t)
+	lopts.BroadcastAddress = "127.0.0.1"
+	_, _, lookupd := mustStartNSQLookupd(lopts)
+	defer lookupd.Exit()
+
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.NSQLookupdTCPAddresses = []string{lookupd.RealTCPAddr().String()}
+	opts.BroadcastAddress = "127.0.0.1"
+	_, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	topicName := "cluster_metadata_test" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	topic.GetChannel("ch")
+	err := topic.SetMetadata(Metadata{
+		Description: "orders",
+		Labels:      map[string]string{"owner": "team-a"},
+	})
+	test.Nil(t, err)
+
+	// allow some time for nsqd to push info to nsqlookupd
+	time.Sleep(350 * time.Millisecond)
+
+	var tr struct {
+		Topics   []string `json:"topics"`
+		Metadata map[string]struct {
+			Description string            `json:"description"`
+			Labels      map[string]string `json:"labels"`
+		} `json:"metadata"`
+	}
+
+	endpoint := fmt.Sprintf("http://%s/topics?label=owner:team-a", lookupd.RealHTTPAddr())
+	err = http_api.NewClient(nil, ConnectTimeout, RequestTimeout).GETV1(endpoint, &tr)
+	test.Nil(t, err)
+	test.Equal(t, []string{topicName}, tr.Topics)
+	test.Equal(t, "orders", tr.Metadata[topicName].Description)
+
+	endpoint = fmt.Sprintf("http://%s/topics?label=owner:team-b", lookupd.RealHTTPAddr())
+	err = http_api.NewClient(nil, ConnectTimeout, RequestTimeout).GETV1(endpoint, &tr)
+	test.Nil(t, err)
+	test.Equal(t, 0, len(tr.Topics))
+}
+
 func TestCluster(t *testing.T) {
 	lopts := nsqlookupd.NewOptions()
 	lopts.Logger = test.NewTestLogger(t)
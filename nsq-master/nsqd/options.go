@@ -23,7 +23,11 @@ type Options struct {
 	HTTPAddress              string        `flag:"http-address"`
 	HTTPSAddress             string        `flag:"https-address"`
 	BroadcastAddress         string        `flag:"broadcast-address"`
+	Zone                     string        `flag:"zone"`
+	Region                   string        `flag:"region"`
 	NSQLookupdTCPAddresses   []string      `flag:"lookupd-tcp-address" cfg:"nsqlookupd_tcp_addresses"`
+	NSQLookupdTLS            bool          `flag:"lookupd-tls" cfg:"nsqlookupd_tls"`
+	NSQLookupdSecret         string        `flag:"lookupd-secret" cfg:"nsqlookupd_secret"`
 	AuthHTTPAddresses        []string      `flag:"auth-http-address" cfg:"auth_http_addresses"`
 	HTTPClientConnectTimeout time.Duration `flag:"http-client-connect-timeout" cfg:"http_client_connect_timeout"`
 	HTTPClientRequestTimeout time.Duration `flag:"http-client-request-timeout" cfg:"http_client_request_timeout"`
@@ -0,0 +1,142 @@
+package nsqd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+const (
+	peekSourceDisk = "disk"
+
+	defaultPeekCount       = 10
+	maxPeekCount           = 1000
+	defaultPeekPreviewSize = 256
+)
+
+// PeekedMessage is a read-only view of a queued message
+type PeekedMessage struct {
+	ID        string `json:"id"`
+	Timestamp int64  `json:"timestamp"`
+	Attempts  uint16 `json:"attempts"`
+	Size      int    `json:"size"`
+	Body      string `json:"body"`
+	Truncated bool   `json:"truncated"`
+	Source    string `json:"source"`
+}
+
+// PeekResult is the result of a peek, the messages read from the disk
+// backend and the number of messages queued in memory. Those can't be looked
+// at without dequeuing them (there is no way to look into a go channel
+// without receiving) so they are only counted.
+type PeekResult struct {
+	MemoryDepth int64           `json:"memory_depth"`
+	Messages    []PeekedMessage `json:"messages"`
+}
+
+func newPeekedMessage(msg *Message, source string, previewSize int) PeekedMessage {
+	body := msg.Body
+	truncated := false
+	if previewSize >= 0 && len(body) > previewSize {
+		body = body[:previewSize]
+		truncated = true
+	}
+	return PeekedMessage{
+		ID:        string(msg.ID[:]),
+		Timestamp: msg.Timestamp,
+		Attempts:  msg.Attempts,
+		Size:      len(msg.Body),
+		Body:      string(body),
+		Truncated: truncated,
+		Source:    source,
+	}
+}
+
+// peekDiskQueue reads up to count messages from the files of the named
+// diskqueue, starting at the last read position it synced to disk.
+//
+// The read position is only synced periodically (see --sync-every and
+// --sync-timeout) so the result may include a few recently consumed messages.
+func peekDiskQueue(dataPath string, name string, count int, maxMsgSize int32) ([]*Message, error) {
+	var depth, readFileNum, readPos, writeFileNum, writePos int64
+
+	fn := fmt.Sprintf(path.Join(dataPath, "%s.diskqueue.meta.dat"), name)
+	f, err := os.Open(fn)
+	if err == nil {
+		_, err = fmt.Fscanf(f, "%d\n%d,%d\n%d,%d\n",
+			&depth, &readFileNum, &readPos, &writeFileNum, &writePos)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s - %s", fn, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var msgs []*Message
+	for fileNum, pos := readFileNum, readPos; len(msgs) < count; fileNum, pos = fileNum+1, 0 {
+		fn := fmt.Sprintf(path.Join(dataPath, "%s.diskqueue.%06d.dat"), name, fileNum)
+		f, err := os.Open(fn)
+		if err != nil {
+			if os.IsNotExist(err) {
+				if fileNum < writeFileNum {
+					// consumed and removed since the read position was synced
+					continue
+				}
+				break
+			}
+			return msgs, err
+		}
+		msgs, err = peekDiskQueueFile(f, pos, count, maxMsgSize, msgs)
+		f.Close()
+		if err != nil {
+			return msgs, fmt.Errorf("failed to read %s - %s", fn, err)
+		}
+	}
+	return msgs, nil
+}
+
+func peekDiskQueueFile(f *os.File, pos int64, count int, maxMsgSize int32, msgs []*Message) ([]*Message, error) {
+	if pos > 0 {
+		_, err := f.Seek(pos, 0)
+		if err != nil {
+			return msgs, err
+		}
+	}
+
+	r := bufio.NewReader(f)
+	for len(msgs) < count {
+		var msgSize int32
+		err := binary.Read(r, binary.BigEndian, &msgSize)
+		if err != nil {
+			if err == io.EOF {
+				return msgs, nil
+			}
+			return msgs, err
+		}
+
+		if msgSize < minValidMsgLength || msgSize > maxMsgSize {
+			return msgs, fmt.Errorf("invalid message read size (%d)", msgSize)
+		}
+
+		buf := make([]byte, msgSize)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			if err == io.ErrUnexpectedEOF {
+				// partially written message at the tail
+				return msgs, nil
+			}
+			return msgs, err
+		}
+
+		msg, err := decodeMessage(buf)
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
@@ -799,6 +799,12 @@ func (p *protocolV2) PUB(client *clientV2, params [][]byte) ([]byte, error) {
 	}
 
 	topic := p.ctx.nsqd.GetTopic(topicName)
+	err = topic.ValidateMessage(messageBody)
+	if err != nil {
+		return nil, protocol.NewClientErr(err, "E_BAD_MESSAGE",
+			fmt.Sprintf("PUB message failed schema validation - %s", err))
+	}
+
 	msg := NewMessage(topic.GenerateID(), messageBody)
 	err = topic.PutMessage(msg)
 	if err != nil {
@@ -850,6 +856,14 @@ func (p *protocolV2) MPUB(client *clientV2, params [][]byte) ([]byte, error) {
 		return nil, err
 	}
 
+	for i, msg := range messages {
+		err = topic.ValidateMessage(msg.Body)
+		if err != nil {
+			return nil, protocol.NewClientErr(err, "E_BAD_MESSAGE",
+				fmt.Sprintf("MPUB message(%d) failed schema validation - %s", i, err))
+		}
+	}
+
 	// if we've made it this far we've validated all the input,
 	// the only possible error is that the topic is exiting during
 	// this next call (and no messages will be queued in that case)
@@ -915,6 +929,16 @@ func (p *protocolV2) DPUB(client *clientV2, params [][]byte) ([]byte, error) {
 	}
 
 	topic := p.ctx.nsqd.GetTopic(topicName)
+	if timeoutDuration > 0 && topic.GetDurability() != DurabilityMemory {
+		return nil, protocol.NewClientErr(errDeferredNotDurable, "E_DPUB_FAILED",
+			"DPUB failed "+errDeferredNotDurable.Error())
+	}
+	err = topic.ValidateMessage(messageBody)
+	if err != nil {
+		return nil, protocol.NewClientErr(err, "E_BAD_MESSAGE",
+			fmt.Sprintf("DPUB message failed schema validation - %s", err))
+	}
+
 	msg := NewMessage(topic.GenerateID(), messageBody)
 	msg.deferred = timeoutDuration
 	err = topic.PutMessage(msg)
@@ -563,6 +563,52 @@ func TestSizeLimits(t *testing.T) {
 	test.Equal(t, fmt.Sprintf("E_BAD_MESSAGE MPUB message too big 101 > 100"), string(data))
 }
 
+func TestPUBSchemaValidation(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	tcpAddr, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	topicName := "test_schema_v2" + strconv.Itoa(int(time.Now().Unix()))
+	schema, err := NewSchema([]byte(`{"type": "object", "required": ["id"]}`))
+	test.Nil(t, err)
+	nsqd.GetTopic(topicName).SetSchema(schema)
+
+	conn, err := mustConnectNSQD(tcpAddr)
+	test.Nil(t, err)
+	defer conn.Close()
+
+	identify(t, conn, nil, frameTypeResponse)
+
+	nsq.Publish(topicName, []byte(`{"id": 1}`)).WriteTo(conn)
+	resp, _ := nsq.ReadResponse(conn)
+	frameType, data, _ := nsq.UnpackResponse(resp)
+	test.Equal(t, frameTypeResponse, frameType)
+	test.Equal(t, []byte("OK"), data)
+
+	nsq.Publish(topicName, []byte(`{"name": "a"}`)).WriteTo(conn)
+	resp, _ = nsq.ReadResponse(conn)
+	frameType, data, _ = nsq.UnpackResponse(resp)
+	t.Logf("frameType: %d, data: %s", frameType, data)
+	test.Equal(t, frameTypeError, frameType)
+	test.Equal(t, `E_BAD_MESSAGE PUB message failed schema validation - /: missing required property "id"`,
+		string(data))
+
+	// the connection is still usable
+	cmd, _ := nsq.MultiPublish(topicName, [][]byte{[]byte(`{"id": 2}`), []byte(`[]`)})
+	cmd.WriteTo(conn)
+	resp, _ = nsq.ReadResponse(conn)
+	frameType, data, _ = nsq.UnpackResponse(resp)
+	t.Logf("frameType: %d, data: %s", frameType, data)
+	test.Equal(t, frameTypeError, frameType)
+	test.Equal(t, `E_BAD_MESSAGE MPUB message(1) failed schema validation - /: expected object, got array`,
+		string(data))
+
+	topic, _ := nsqd.GetExistingTopic(topicName)
+	test.Equal(t, uint64(1), topic.messageCount)
+}
+
 func TestDPUB(t *testing.T) {
 	opts := NewOptions()
 	opts.Logger = test.NewTestLogger(t)
@@ -606,6 +652,62 @@ func TestDPUB(t *testing.T) {
 	test.Equal(t, fmt.Sprintf("E_INVALID DPUB timeout 3600100 out of range 0-3600000"), string(data))
 }
 
+func TestDPUBDurability(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	tcpAddr, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	conn, err := mustConnectNSQD(tcpAddr)
+	test.Nil(t, err)
+	defer conn.Close()
+	identify(t, conn, nil, frameTypeResponse)
+
+	// deferred messages are only held in memory, they would be lost (or
+	// delivered right away) with durability disk or fsync
+	for _, d := range []Durability{DurabilityMemory, DurabilityDisk, DurabilityFsync} {
+		topicName := "test_dpub_durability_" + d.String() + strconv.Itoa(int(time.Now().Unix()))
+		topic := nsqd.GetTopic(topicName)
+		channel := topic.GetChannel("ch")
+		test.Nil(t, topic.SetDurability(d))
+
+		nsq.DeferredPublish(topicName, time.Second, []byte("deferred")).WriteTo(conn)
+		resp, _ := nsq.ReadResponse(conn)
+		frameType, data, _ := nsq.UnpackResponse(resp)
+		t.Logf("%s frameType: %d, data: %s", d, frameType, data)
+
+		if d == DurabilityMemory {
+			test.Equal(t, frameTypeResponse, frameType)
+			test.Equal(t, []byte("OK"), data)
+			for i := 0; channel.Depth() == 0; i++ {
+				channel.deferredMutex.Lock()
+				numDef := len(channel.deferredMessages)
+				channel.deferredMutex.Unlock()
+				if numDef == 1 {
+					break
+				}
+				if i > 100 {
+					t.Fatal("message was not deferred")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			test.Equal(t, int64(0), channel.Depth())
+			continue
+		}
+
+		test.Equal(t, frameTypeError, frameType)
+		test.Equal(t, "E_DPUB_FAILED DPUB failed "+errDeferredNotDurable.Error(), string(data))
+		test.Equal(t, int64(0), topic.Depth())
+
+		// a timeout of 0 isn't deferred
+		nsq.DeferredPublish(topicName, 0, []byte("now")).WriteTo(conn)
+		resp, _ = nsq.ReadResponse(conn)
+		frameType, _, _ = nsq.UnpackResponse(resp)
+		test.Equal(t, frameTypeResponse, frameType)
+	}
+}
+
 func TestTouch(t *testing.T) {
 	opts := NewOptions()
 	opts.Logger = test.NewTestLogger(t)
@@ -0,0 +1,491 @@
+package nsqd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xeipuuv/gojsonreference"
+)
+
+// maxSchemaDepth bounds the recursion of validation so that a schema
+// which $refs itself without consuming any input can't loop forever
+const maxSchemaDepth = 256
+
+// maxSchemaEvaluations bounds the number of (sub)schemas evaluated to
+// validate a message, the depth alone doesn't bound the work of schemas
+// which branch (eg. an anyOf of $refs to itself is exponential in depth)
+const maxSchemaEvaluations = 100000
+
+var errSchemaTooComplex = errors.New("schema evaluation exceeded the maximum number of steps")
+
+// Schema is a JSON Schema that message bodies published to a topic
+// must validate against.
+//
+// The supported keywords are a subset of draft-07: type, enum, const,
+// properties, required, additionalProperties, items, minItems, maxItems,
+// minLength, maxLength, pattern, minimum, maximum, exclusiveMinimum,
+// exclusiveMaximum, allOf, anyOf, oneOf, not and local (fragment only) $ref.
+type Schema struct {
+	raw  json.RawMessage
+	root interface{}
+	res  map[string]*regexp.Regexp
+}
+
+// NewSchema parses and checks a JSON Schema document, all patterns must
+// compile and all $refs must resolve within the document
+func NewSchema(raw []byte) (*Schema, error) {
+	s := &Schema{
+		raw: append(json.RawMessage(nil), raw...),
+		res: make(map[string]*regexp.Regexp),
+	}
+	err := json.Unmarshal(raw, &s.root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON - %s", err)
+	}
+	if _, ok := s.root.(map[string]interface{}); !ok {
+		if _, ok := s.root.(bool); !ok {
+			return nil, errors.New("schema must be an object or a boolean")
+		}
+	}
+	err = s.compile(s.root)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MarshalJSON returns the schema document as it was supplied
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	return s.raw, nil
+}
+
+// Validate checks that body is a JSON document matching the schema
+func (s *Schema) Validate(body []byte) error {
+	var doc interface{}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	err := d.Decode(&doc)
+	if err != nil {
+		return fmt.Errorf("invalid JSON - %s", err)
+	}
+	if d.More() {
+		return errors.New("invalid JSON - trailing data")
+	}
+	budget := maxSchemaEvaluations
+	return s.validate(s.root, doc, "", 0, &budget)
+}
+
+func (s *Schema) compile(node interface{}) error {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		if ref, ok := v["$ref"]; ok {
+			refStr, ok := ref.(string)
+			if !ok {
+				return errors.New("$ref must be a string")
+			}
+			_, err := s.resolve(refStr)
+			if err != nil {
+				return err
+			}
+		}
+		if p, ok := v["pattern"]; ok {
+			ps, ok := p.(string)
+			if !ok {
+				return errors.New("pattern must be a string")
+			}
+			re, err := regexp.Compile(ps)
+			if err != nil {
+				return fmt.Errorf("invalid pattern %q - %s", ps, err)
+			}
+			s.res[ps] = re
+		}
+		for _, child := range v {
+			err := s.compile(child)
+			if err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			err := s.compile(child)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve looks up a $ref, only references into this document are
+// supported since nsqd should never fetch remote schemas on the publish path
+func (s *Schema) resolve(ref string) (interface{}, error) {
+	r, err := gojsonreference.NewJsonReference(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid $ref %q - %s", ref, err)
+	}
+	if ref == "#" {
+		return s.root, nil
+	}
+	if !r.HasFragmentOnly {
+		return nil, fmt.Errorf("unsupported $ref %q - only local references are allowed", ref)
+	}
+	node, _, err := r.GetPointer().Get(s.root)
+	if err != nil {
+		return nil, fmt.Errorf("unresolvable $ref %q - %s", ref, err)
+	}
+	return node, nil
+}
+
+func (s *Schema) validate(schema interface{}, doc interface{}, path string, depth int, budget *int) error {
+	if depth > maxSchemaDepth {
+		return fmt.Errorf("%s: schema nested too deeply", pathOrRoot(path))
+	}
+	*budget--
+	if *budget < 0 {
+		return errSchemaTooComplex
+	}
+
+	switch sv := schema.(type) {
+	case bool:
+		if !sv {
+			return fmt.Errorf("%s: not allowed", pathOrRoot(path))
+		}
+		return nil
+	case map[string]interface{}:
+		return s.validateObject(sv, doc, path, depth, budget)
+	}
+	return nil
+}
+
+func (s *Schema) validateObject(schema map[string]interface{}, doc interface{}, path string, depth int, budget *int) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		// per draft-07 all other keywords are ignored next to $ref
+		node, err := s.resolve(ref)
+		if err != nil {
+			return err
+		}
+		return s.validate(node, doc, path, depth+1, budget)
+	}
+
+	if t, ok := schema["type"]; ok {
+		err := validateType(t, doc, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(e, doc) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value is not one of the enumerated values", pathOrRoot(path))
+		}
+	}
+
+	if c, ok := schema["const"]; ok && !jsonEqual(c, doc) {
+		return fmt.Errorf("%s: value does not match const", pathOrRoot(path))
+	}
+
+	switch dv := doc.(type) {
+	case map[string]interface{}:
+		err := s.validateProperties(schema, dv, path, depth, budget)
+		if err != nil {
+			return err
+		}
+	case []interface{}:
+		err := s.validateItems(schema, dv, path, depth, budget)
+		if err != nil {
+			return err
+		}
+	case string:
+		err := s.validateString(schema, dv, path)
+		if err != nil {
+			return err
+		}
+	case json.Number:
+		err := validateNumber(schema, dv, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			err := s.validate(sub, doc, path, depth+1, budget)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		var firstErr error
+		for _, sub := range anyOf {
+			err := s.validate(sub, doc, path, depth+1, budget)
+			if err == nil {
+				firstErr = nil
+				break
+			}
+			if err == errSchemaTooComplex {
+				return err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if firstErr != nil {
+			return fmt.Errorf("%s: does not match any of anyOf (%s)", pathOrRoot(path), firstErr)
+		}
+	}
+
+	if one, ok := schema["oneOf"].([]interface{}); ok {
+		matches := 0
+		for _, sub := range one {
+			err := s.validate(sub, doc, path, depth+1, budget)
+			if err == errSchemaTooComplex {
+				return err
+			}
+			if err == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s: matches %d of oneOf, expected exactly 1", pathOrRoot(path), matches)
+		}
+	}
+
+	if not, ok := schema["not"]; ok {
+		err := s.validate(not, doc, path, depth+1, budget)
+		if err == errSchemaTooComplex {
+			return err
+		}
+		if err == nil {
+			return fmt.Errorf("%s: must not match schema in not", pathOrRoot(path))
+		}
+	}
+
+	return nil
+}
+
+func (s *Schema) validateProperties(schema map[string]interface{}, doc map[string]interface{}, path string, depth int, budget *int) error {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, ok := doc[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", pathOrRoot(path), name)
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+
+	// iterate in a stable order so that errors are deterministic
+	keys := make([]string, 0, len(doc))
+	for k := range doc {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		childPath := path + "/" + escapePointerToken(k)
+		if sub, ok := properties[k]; ok {
+			err := s.validate(sub, doc[k], childPath, depth+1, budget)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if additional, ok := schema["additionalProperties"]; ok {
+			if b, ok := additional.(bool); ok && !b {
+				return fmt.Errorf("%s: additional property %q is not allowed", pathOrRoot(path), k)
+			}
+			err := s.validate(additional, doc[k], childPath, depth+1, budget)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateItems(schema map[string]interface{}, doc []interface{}, path string, depth int, budget *int) error {
+	if min, ok := schemaInt(schema, "minItems"); ok && len(doc) < min {
+		return fmt.Errorf("%s: array has %d items, minimum is %d", pathOrRoot(path), len(doc), min)
+	}
+	if max, ok := schemaInt(schema, "maxItems"); ok && len(doc) > max {
+		return fmt.Errorf("%s: array has %d items, maximum is %d", pathOrRoot(path), len(doc), max)
+	}
+	items, ok := schema["items"]
+	if !ok {
+		return nil
+	}
+	if tuple, ok := items.([]interface{}); ok {
+		for i, sub := range tuple {
+			if i >= len(doc) {
+				break
+			}
+			err := s.validate(sub, doc[i], fmt.Sprintf("%s/%d", path, i), depth+1, budget)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i, item := range doc {
+		err := s.validate(items, item, fmt.Sprintf("%s/%d", path, i), depth+1, budget)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateString(schema map[string]interface{}, doc string, path string) error {
+	n := utf8.RuneCountInString(doc)
+	if min, ok := schemaInt(schema, "minLength"); ok && n < min {
+		return fmt.Errorf("%s: string length %d is less than minLength %d", pathOrRoot(path), n, min)
+	}
+	if max, ok := schemaInt(schema, "maxLength"); ok && n > max {
+		return fmt.Errorf("%s: string length %d is greater than maxLength %d", pathOrRoot(path), n, max)
+	}
+	if p, ok := schema["pattern"].(string); ok {
+		if !s.res[p].MatchString(doc) {
+			return fmt.Errorf("%s: string does not match pattern %q", pathOrRoot(path), p)
+		}
+	}
+	return nil
+}
+
+func validateNumber(schema map[string]interface{}, doc json.Number, path string) error {
+	f, err := doc.Float64()
+	if err != nil {
+		return fmt.Errorf("%s: invalid number %s", pathOrRoot(path), doc)
+	}
+	if min, ok := schema["minimum"].(float64); ok && f < min {
+		return fmt.Errorf("%s: %s is less than minimum %v", pathOrRoot(path), doc, min)
+	}
+	if max, ok := schema["maximum"].(float64); ok && f > max {
+		return fmt.Errorf("%s: %s is greater than maximum %v", pathOrRoot(path), doc, max)
+	}
+	if min, ok := schema["exclusiveMinimum"].(float64); ok && f <= min {
+		return fmt.Errorf("%s: %s is not greater than exclusiveMinimum %v", pathOrRoot(path), doc, min)
+	}
+	if max, ok := schema["exclusiveMaximum"].(float64); ok && f >= max {
+		return fmt.Errorf("%s: %s is not less than exclusiveMaximum %v", pathOrRoot(path), doc, max)
+	}
+	return nil
+}
+
+func validateType(t interface{}, doc interface{}, path string) error {
+	actual := jsonType(doc)
+	var allowed []string
+	switch tv := t.(type) {
+	case string:
+		allowed = []string{tv}
+	case []interface{}:
+		for _, v := range tv {
+			if s, ok := v.(string); ok {
+				allowed = append(allowed, s)
+			}
+		}
+	}
+	for _, a := range allowed {
+		if a == actual || (a == "number" && actual == "integer") {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: expected %s, got %s", pathOrRoot(path), strings.Join(allowed, " or "), actual)
+}
+
+func jsonType(v interface{}) string {
+	switch dv := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case json.Number:
+		f, err := dv.Float64()
+		if err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	case float64:
+		if dv == math.Trunc(dv) {
+			return "integer"
+		}
+		return "number"
+	}
+	return "unknown"
+}
+
+// jsonEqual compares a schema value (decoded as float64 numbers) with a
+// document value (decoded as json.Number)
+func jsonEqual(a interface{}, b interface{}) bool {
+	if n, ok := b.(json.Number); ok {
+		f, err := n.Float64()
+		if err != nil {
+			return false
+		}
+		b = f
+	}
+	switch bv := b.(type) {
+	case map[string]interface{}:
+		av, ok := a.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range bv {
+			if !jsonEqual(av[k], v) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		av, ok := a.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range bv {
+			if !jsonEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func schemaInt(schema map[string]interface{}, key string) (int, bool) {
+	f, ok := schema[key].(float64)
+	return int(f), ok
+}
+
+func escapePointerToken(s string) string {
+	return strings.Replace(strings.Replace(s, "~", "~0", -1), "/", "~1", -1)
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
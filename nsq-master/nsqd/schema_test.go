@@ -0,0 +1,96 @@
+package nsqd
+
+import (
+	"testing"
+
+	"github.com/nsqio/nsq/internal/test"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	schema, err := NewSchema([]byte(`{
+		"type": "object",
+		"required": ["id", "user"],
+		"additionalProperties": false,
+		"properties": {
+			"id": {"type": "integer", "minimum": 1},
+			"kind": {"enum": ["created", "deleted"]},
+			"tags": {"type": "array", "items": {"type": "string", "pattern": "^[a-z]+$"}, "maxItems": 2},
+			"user": {"$ref": "#/definitions/user"}
+		},
+		"definitions": {
+			"user": {
+				"type": "object",
+				"required": ["name"],
+				"properties": {"name": {"type": "string", "minLength": 1}}
+			}
+		}
+	}`))
+	test.Nil(t, err)
+
+	tests := []struct {
+		body string
+		err  string
+	}{
+		{`{"id": 1, "user": {"name": "a"}, "kind": "created", "tags": ["x"]}`, ""},
+		{`{"id": 1.5, "user": {"name": "a"}}`, "/id: expected integer, got number"},
+		{`{"id": 0, "user": {"name": "a"}}`, "/id: 0 is less than minimum 1"},
+		{`{"id": 1}`, `/: missing required property "user"`},
+		{`{"id": 1, "user": {"name": ""}}`, "/user/name: string length 0 is less than minLength 1"},
+		{`{"id": 1, "user": {}}`, `/user: missing required property "name"`},
+		{`{"id": 1, "user": {"name": "a"}, "kind": "updated"}`, "/kind: value is not one of the enumerated values"},
+		{`{"id": 1, "user": {"name": "a"}, "tags": ["A"]}`, `/tags/0: string does not match pattern "^[a-z]+$"`},
+		{`{"id": 1, "user": {"name": "a"}, "tags": ["a", "b", "c"]}`, "/tags: array has 3 items, maximum is 2"},
+		{`{"id": 1, "user": {"name": "a"}, "extra": true}`, `/: additional property "extra" is not allowed`},
+		{`[1, 2]`, "/: expected object, got array"},
+		{`not json`, "invalid JSON - invalid character 'o' in literal null (expecting 'u')"},
+	}
+	for _, tt := range tests {
+		err := schema.Validate([]byte(tt.body))
+		if tt.err == "" {
+			test.Nil(t, err)
+			continue
+		}
+		test.NotNil(t, err)
+		test.Equal(t, tt.err, err.Error())
+	}
+}
+
+func TestSchemaInvalid(t *testing.T) {
+	_, err := NewSchema([]byte(`{"$ref": "#/definitions/missing"}`))
+	test.NotNil(t, err)
+
+	_, err = NewSchema([]byte(`{"$ref": "http://example.com/schema.json"}`))
+	test.NotNil(t, err)
+
+	_, err = NewSchema([]byte(`{"type": "string", "pattern": "("}`))
+	test.NotNil(t, err)
+
+	_, err = NewSchema([]byte(`"string"`))
+	test.NotNil(t, err)
+
+	// recursive schemas are fine as long as they consume input
+	schema, err := NewSchema([]byte(`{"type": "array", "items": {"$ref": "#"}}`))
+	test.Nil(t, err)
+	test.Nil(t, schema.Validate([]byte(`[[], [[]]]`)))
+	test.NotNil(t, schema.Validate([]byte(`[[], [1]]`)))
+
+	// but those that don't are bounded
+	schema, err = NewSchema([]byte(`{"$ref": "#"}`))
+	test.Nil(t, err)
+	test.NotNil(t, schema.Validate([]byte(`{}`)))
+}
+
+func TestSchemaEvaluationBudget(t *testing.T) {
+	// schemas branching on themselves are exponential in the depth, they
+	// fail once the evaluation budget is spent instead of hanging publishes
+	for _, raw := range []string{
+		`{"anyOf": [{"$ref": "#"}, {"$ref": "#"}]}`,
+		`{"oneOf": [{"$ref": "#"}, {"$ref": "#"}, {"$ref": "#"}]}`,
+		`{"not": {"anyOf": [{"$ref": "#"}, {"$ref": "#"}]}}`,
+	} {
+		schema, err := NewSchema([]byte(raw))
+		test.Nil(t, err)
+		err = schema.Validate([]byte(`{}`))
+		test.Equal(t, errSchemaTooComplex, err)
+	}
+}
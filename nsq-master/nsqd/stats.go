@@ -16,11 +16,16 @@ type TopicStats struct {
 	MessageCount uint64         `json:"message_count"`
 	MessageBytes uint64         `json:"message_bytes"`
 	Paused       bool           `json:"paused"`
+	Durability   string         `json:"durability"`
+
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
 
 	E2eProcessingLatency *quantile.Result `json:"e2e_processing_latency"`
 }
 
 func NewTopicStats(t *Topic, channels []ChannelStats) TopicStats {
+	m := t.GetMetadata()
 	return TopicStats{
 		TopicName:    t.name,
 		Channels:     channels,
@@ -29,6 +34,10 @@ func NewTopicStats(t *Topic, channels []ChannelStats) TopicStats {
 		MessageCount: atomic.LoadUint64(&t.messageCount),
 		MessageBytes: atomic.LoadUint64(&t.messageBytes),
 		Paused:       t.IsPaused(),
+		Durability:   t.GetDurability().String(),
+
+		Description: m.Description,
+		Labels:      m.Labels,
 
 		E2eProcessingLatency: t.AggregateChannelE2eProcessingLatency().Result(),
 	}
@@ -47,6 +56,9 @@ type ChannelStats struct {
 	Clients       []ClientStats `json:"clients"`
 	Paused        bool          `json:"paused"`
 
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+
 	E2eProcessingLatency *quantile.Result `json:"e2e_processing_latency"`
 }
 
@@ -57,6 +69,7 @@ func NewChannelStats(c *Channel, clients []ClientStats, clientCount int) Channel
 	c.deferredMutex.Lock()
 	deferred := len(c.deferredMessages)
 	c.deferredMutex.Unlock()
+	m := c.GetMetadata()
 
 	return ChannelStats{
 		ChannelName:   c.name,
@@ -71,6 +84,9 @@ func NewChannelStats(c *Channel, clients []ClientStats, clientCount int) Channel
 		Clients:       clients,
 		Paused:        c.IsPaused(),
 
+		Description: m.Description,
+		Labels:      m.Labels,
+
 		E2eProcessingLatency: c.e2eProcessingLatencyStream.Result(),
 	}
 }
@@ -18,6 +18,10 @@ type Topic struct {
 	// 64bit atomic vars need to be first for proper alignment on 32bit platforms
 	messageCount uint64
 	messageBytes uint64
+	// the number of messages written to the backend, and read from it by
+	// messagePump and put in the channels, the journal's checkpoint
+	backendWrites int64
+	backendReads  int64
 
 	sync.RWMutex
 
@@ -39,6 +43,14 @@ type Topic struct {
 	paused    int32
 	pauseChan chan int
 
+	metadataHolder
+
+	schemaMutex sync.RWMutex
+	schema      *Schema
+
+	durability int32
+	journal    *journal
+
 	ctx *context
 }
 
@@ -79,6 +91,14 @@ func NewTopic(topicName string, ctx *context, deleteCallback func(*Topic)) *Topi
 			ctx.nsqd.getOpts().SyncTimeout,
 			dqLogf,
 		)
+		// a previous process may have crashed before the diskqueue synced
+		// messages that were acknowledged with DurabilityFsync
+		if hasJournal(topicName, ctx.nsqd.getOpts().DataPath) {
+			err := t.openJournal()
+			if err != nil {
+				ctx.nsqd.logf(LOG_ERROR, "TOPIC(%s): failed to open journal - %s", t.name, err)
+			}
+		}
 	}
 
 	t.waitGroup.Wrap(t.messagePump)
@@ -127,6 +147,7 @@ func (t *Topic) getOrCreateChannel(channelName string) (*Channel, bool) {
 			t.DeleteExistingChannel(c.name)
 		}
 		channel = NewChannel(t.name, channelName, t.ctx, deleteCallback)
+		channel.setMemoryBypass(t.GetDurability() != DurabilityMemory)
 		t.channelMap[channelName] = channel
 		t.ctx.nsqd.logf(LOG_INFO, "TOPIC(%s): new channel(%s)", t.name, channel.name)
 		return channel, true
@@ -183,6 +204,15 @@ func (t *Topic) PutMessage(m *Message) error {
 	if atomic.LoadInt32(&t.exitFlag) == 1 {
 		return errors.New("exiting")
 	}
+	if t.journal != nil && t.GetDurability() == DurabilityFsync {
+		fileNum, err := t.journal.Write([]*Message{m})
+		if err != nil {
+			return err
+		}
+		defer func() {
+			t.journal.Queued(fileNum, atomic.LoadInt64(&t.backendWrites))
+		}()
+	}
 	err := t.put(m)
 	if err != nil {
 		return err
@@ -200,6 +230,16 @@ func (t *Topic) PutMessages(msgs []*Message) error {
 		return errors.New("exiting")
 	}
 
+	if t.journal != nil && t.GetDurability() == DurabilityFsync {
+		fileNum, err := t.journal.Write(msgs)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			t.journal.Queued(fileNum, atomic.LoadInt64(&t.backendWrites))
+		}()
+	}
+
 	messageTotalBytes := 0
 
 	for i, m := range msgs {
@@ -218,23 +258,38 @@ func (t *Topic) PutMessages(msgs []*Message) error {
 }
 
 func (t *Topic) put(m *Message) error {
-	select {
-	case t.memoryMsgChan <- m:
-	default:
-		b := bufferPoolGet()
-		err := writeMessageToBackend(b, m, t.backend)
-		bufferPoolPut(b)
-		t.ctx.nsqd.SetHealth(err)
-		if err != nil {
-			t.ctx.nsqd.logf(LOG_ERROR,
-				"TOPIC(%s) ERROR: failed to write message to backend - %s",
-				t.name, err)
-			return err
+	if t.GetDurability() == DurabilityMemory {
+		select {
+		case t.memoryMsgChan <- m:
+			return nil
+		default:
 		}
 	}
+
+	err := t.writeToBackend(m)
+	t.ctx.nsqd.SetHealth(err)
+	if err != nil {
+		t.ctx.nsqd.logf(LOG_ERROR,
+			"TOPIC(%s) ERROR: failed to write message to backend - %s",
+			t.name, err)
+		return err
+	}
 	return nil
 }
 
+func (t *Topic) writeToBackend(m *Message) error {
+	// counted before the write so that once it returns the count is at
+	// least the message's position in the backend
+	atomic.AddInt64(&t.backendWrites, 1)
+	b := bufferPoolGet()
+	err := writeMessageToBackend(b, m, t.backend)
+	bufferPoolPut(b)
+	if err != nil {
+		atomic.AddInt64(&t.backendWrites, -1)
+	}
+	return err
+}
+
 func (t *Topic) Depth() int64 {
 	return int64(len(t.memoryMsgChan)) + t.backend.Depth()
 }
@@ -248,6 +303,7 @@ func (t *Topic) messagePump() {
 	var chans []*Channel
 	var memoryMsgChan chan *Message
 	var backendChan <-chan []byte
+	var fromBackend bool
 
 	// do not pass messages before Start(), but avoid blocking Pause() or GetChannel()
 	for {
@@ -276,10 +332,13 @@ func (t *Topic) messagePump() {
 	for {
 		select {
 		case msg = <-memoryMsgChan:
+			fromBackend = false
 		case buf = <-backendChan:
+			fromBackend = true
 			msg, err = decodeMessage(buf)
 			if err != nil {
 				t.ctx.nsqd.logf(LOG_ERROR, "failed to decode message - %s", err)
+				atomic.AddInt64(&t.backendReads, 1)
 				continue
 			}
 		case <-t.channelUpdateChan:
@@ -332,6 +391,9 @@ func (t *Topic) messagePump() {
 					t.name, msg.ID, channel.name, err)
 			}
 		}
+		if fromBackend {
+			atomic.AddInt64(&t.backendReads, 1)
+		}
 	}
 
 exit:
@@ -378,7 +440,9 @@ func (t *Topic) exit(deleted bool) error {
 
 		// empty the queue (deletes the backend files, too)
 		t.Empty()
-		return t.backend.Delete()
+		err := t.backend.Delete()
+		t.closeJournal()
+		return err
 	}
 
 	// close all the channels
@@ -392,7 +456,10 @@ func (t *Topic) exit(deleted bool) error {
 
 	// write anything leftover to disk
 	t.flush()
-	return t.backend.Close()
+	err := t.backend.Close()
+	// closing the backend synced it, the journal isn't needed anymore
+	t.closeJournal()
+	return err
 }
 
 func (t *Topic) Empty() error {
@@ -405,7 +472,19 @@ func (t *Topic) Empty() error {
 	}
 
 finish:
-	return t.backend.Empty()
+	err := t.backend.Empty()
+	if err != nil {
+		return err
+	}
+	// the emptied messages will never be read, and must not be replayed
+	atomic.StoreInt64(&t.backendReads, atomic.LoadInt64(&t.backendWrites))
+	t.RLock()
+	j := t.journal
+	t.RUnlock()
+	if j != nil {
+		return j.Empty()
+	}
+	return nil
 }
 
 func (t *Topic) flush() error {
@@ -483,6 +562,74 @@ func (t *Topic) IsPaused() bool {
 	return atomic.LoadInt32(&t.paused) == 1
 }
 
+// SetMetadata replaces the description and labels of this topic,
+// persists them and propagates them to nsqlookupd
+func (t *Topic) SetMetadata(m Metadata) error {
+	err := m.Validate()
+	if err != nil {
+		return err
+	}
+	t.setMetadata(m)
+	t.ctx.nsqd.Notify(t)
+	return nil
+}
+
+// SetSchema attaches a JSON Schema that all messages published to this
+// topic must validate against (nil removes it) and persists it
+func (t *Topic) SetSchema(s *Schema) {
+	t.setSchema(s)
+	t.ctx.nsqd.Notify(t)
+}
+
+func (t *Topic) setSchema(s *Schema) {
+	t.schemaMutex.Lock()
+	t.schema = s
+	t.schemaMutex.Unlock()
+}
+
+// GetSchema returns the schema attached to this topic, if any
+func (t *Topic) GetSchema() *Schema {
+	t.schemaMutex.RLock()
+	defer t.schemaMutex.RUnlock()
+	return t.schema
+}
+
+// ValidateMessage checks a message body against the topic's schema
+func (t *Topic) ValidateMessage(body []byte) error {
+	s := t.GetSchema()
+	if s == nil {
+		return nil
+	}
+	return s.Validate(body)
+}
+
+// Peek returns up to count messages queued in the disk backend of this
+// topic without removing them, bodies are truncated to previewSize, and the
+// number of messages queued in memory
+func (t *Topic) Peek(count int, previewSize int) (*PeekResult, error) {
+	t.RLock()
+	defer t.RUnlock()
+	if atomic.LoadInt32(&t.exitFlag) == 1 {
+		return nil, errors.New("exiting")
+	}
+
+	result := &PeekResult{
+		MemoryDepth: int64(len(t.memoryMsgChan)),
+		Messages:    []PeekedMessage{},
+	}
+	if t.ephemeral {
+		return result, nil
+	}
+
+	opts := t.ctx.nsqd.getOpts()
+	msgs, err := peekDiskQueue(opts.DataPath, t.name, count,
+		int32(opts.MaxMsgSize)+minValidMsgLength)
+	for _, msg := range msgs {
+		result.Messages = append(result.Messages, newPeekedMessage(msg, peekSourceDisk, previewSize))
+	}
+	return result, err
+}
+
 func (t *Topic) GenerateID() MessageID {
 retry:
 	id, err := t.idFactory.NewGUID()
@@ -492,3 +639,91 @@ retry:
 	}
 	return id.Hex()
 }
+
+// SetDurability changes the point messages published to this topic have to
+// reach before they are acknowledged, it applies to messages published from
+// now on (and to the topic's channels) and is persisted
+func (t *Topic) SetDurability(d Durability) error {
+	if d != DurabilityMemory && t.ephemeral {
+		return errors.New("ephemeral topics are memory only")
+	}
+	t.Lock()
+	err := t.setDurability(d)
+	t.Unlock()
+	if err != nil {
+		return err
+	}
+	t.ctx.nsqd.Notify(t)
+	return nil
+}
+
+// this expects the caller to handle locking
+func (t *Topic) setDurability(d Durability) error {
+	if d == DurabilityFsync {
+		err := t.openJournal()
+		if err != nil {
+			return err
+		}
+	}
+	atomic.StoreInt32(&t.durability, int32(d))
+	for _, c := range t.channelMap {
+		c.setMemoryBypass(d != DurabilityMemory)
+	}
+	return nil
+}
+
+// GetDurability returns the durability level of this topic
+func (t *Topic) GetDurability() Durability {
+	return Durability(atomic.LoadInt32(&t.durability))
+}
+
+// openJournal opens the journal (unless it already is) and re-queues the
+// messages a previous process left in it, the journal stays open when the
+// durability is lowered again so that it can expire its segments
+//
+// this expects the caller to handle locking
+func (t *Topic) openJournal() error {
+	if t.journal != nil {
+		return nil
+	}
+
+	opts := t.ctx.nsqd.getOpts()
+	checkpoint := func() int64 {
+		return atomic.LoadInt64(&t.backendReads)
+	}
+	j, msgs, err := newJournal(t.name, opts.DataPath, opts.SyncTimeout,
+		int32(opts.MaxMsgSize)+minValidMsgLength, checkpoint, t.ctx.nsqd.logf)
+	if err != nil {
+		return err
+	}
+
+	if len(msgs) > 0 {
+		t.ctx.nsqd.logf(LOG_WARN, "TOPIC(%s): re-queueing %d messages from journal",
+			t.name, len(msgs))
+	}
+	for _, msg := range msgs {
+		err := t.writeToBackend(msg)
+		if err != nil {
+			t.ctx.nsqd.logf(LOG_ERROR,
+				"TOPIC(%s) ERROR: failed to write message to backend - %s",
+				t.name, err)
+		}
+	}
+	j.Replayed(atomic.LoadInt64(&t.backendWrites))
+
+	t.journal = j
+	return nil
+}
+
+func (t *Topic) closeJournal() {
+	t.RLock()
+	j := t.journal
+	t.RUnlock()
+	if j == nil {
+		return
+	}
+	err := j.Close(true)
+	if err != nil {
+		t.ctx.nsqd.logf(LOG_ERROR, "TOPIC(%s): failed to close journal - %s", t.name, err)
+	}
+}
@@ -0,0 +1,176 @@
+package nsqlookupd
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nsqio/nsq/internal/http_api"
+)
+
+// roles that can be granted to HTTP auth tokens, reads are never restricted
+// as consumers have to be able to /lookup
+const (
+	RoleWrite = "write" // create, delete and tombstone topics and channels
+	RolePeer  = "peer"  // replicate state between nsqlookupd peers
+	RoleAdmin = "admin" // all of the above
+)
+
+// parseHTTPAuthTokens parses the <role>:<token> pairs of --http-auth-token
+func parseHTTPAuthTokens(tokens []string) (map[string]string, error) {
+	roles := make(map[string]string)
+	for _, t := range tokens {
+		parts := strings.SplitN(t, ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid --http-auth-token %q, it has to be <role>:<token>", t)
+		}
+		switch parts[0] {
+		case RoleWrite, RolePeer, RoleAdmin:
+		default:
+			return nil, fmt.Errorf("invalid --http-auth-token role %q", parts[0])
+		}
+		roles[parts[1]] = parts[0]
+	}
+	return roles, nil
+}
+
+// requireRole restricts a handler to requests with a bearer token that was
+// granted the role (or admin), when any tokens are configured
+func (s *httpServer) requireRole(role string) http_api.Decorator {
+	return func(f http_api.APIHandler) http_api.APIHandler {
+		return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+			if len(s.ctx.nsqlookupd.authRoles) == 0 {
+				return f(w, req, ps)
+			}
+			auth := req.Header.Get("Authorization")
+			if !strings.HasPrefix(auth, "Bearer ") {
+				return nil, http_api.Err{401, "UNAUTHORIZED"}
+			}
+			granted, ok := s.ctx.nsqlookupd.lookupAuthToken(strings.TrimPrefix(auth, "Bearer "))
+			if !ok {
+				return nil, http_api.Err{401, "UNAUTHORIZED"}
+			}
+			if granted != role && granted != RoleAdmin {
+				return nil, http_api.Err{403, "FORBIDDEN"}
+			}
+			return f(w, req, ps)
+		}
+	}
+}
+
+// lookupAuthToken returns the role of a token, comparing in constant time
+func (l *NSQLookupd) lookupAuthToken(token string) (string, bool) {
+	var role string
+	var found bool
+	for t, r := range l.authRoles {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			role = r
+			found = true
+		}
+	}
+	return role, found
+}
+
+// checkRegistrationSecret returns true if no secret is configured or the
+// given one matches
+func (l *NSQLookupd) checkRegistrationSecret(secret string) bool {
+	if l.opts.RegistrationSecret == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(l.opts.RegistrationSecret), []byte(secret)) == 1
+}
+
+func buildTLSConfig(opts *Options) (*tls.Config, error) {
+	var tlsConfig *tls.Config
+
+	if opts.TLSCert == "" && opts.TLSKey == "" {
+		return nil, nil
+	}
+
+	tlsClientAuthPolicy := tls.VerifyClientCertIfGiven
+
+	cert, err := tls.LoadX509KeyPair(opts.TLSCert, opts.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	switch opts.TLSClientAuthPolicy {
+	case "require":
+		tlsClientAuthPolicy = tls.RequireAnyClientCert
+	case "require-verify":
+		tlsClientAuthPolicy = tls.RequireAndVerifyClientCert
+	default:
+		tlsClientAuthPolicy = tls.NoClientCert
+	}
+
+	tlsConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tlsClientAuthPolicy,
+		MinVersion:   opts.TLSMinVersion,
+	}
+
+	if opts.TLSRootCAFile != "" {
+		tlsCertPool := x509.NewCertPool()
+		caCertFile, err := ioutil.ReadFile(opts.TLSRootCAFile)
+		if err != nil {
+			return nil, err
+		}
+		if !tlsCertPool.AppendCertsFromPEM(caCertFile) {
+			return nil, errors.New("failed to append certificate to pool")
+		}
+		tlsConfig.ClientCAs = tlsCertPool
+		// peers are verified with the same CA
+		tlsConfig.RootCAs = tlsCertPool
+	}
+
+	return tlsConfig, nil
+}
+
+// tlsRecordTypeHandshake is the first byte a TLS client sends, the lookup
+// protocol starts with a space (its magic is "  V1")
+const tlsRecordTypeHandshake = 0x16
+
+const tlsHandshakeTimeout = 10 * time.Second
+
+// peekedConn is a net.Conn whose first bytes have been peeked at
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// maybeTLS returns the connection, upgraded to TLS if that's what the
+// client started with, so that TLS and plaintext clients share a port
+func maybeTLS(conn net.Conn, tlsConfig *tls.Config) (net.Conn, bool, error) {
+	if tlsConfig == nil {
+		return conn, false, nil
+	}
+	r := bufio.NewReader(conn)
+	b, err := r.Peek(1)
+	if err != nil {
+		return conn, false, err
+	}
+	conn = &peekedConn{conn, r}
+	if b[0] != tlsRecordTypeHandshake {
+		return conn, false, nil
+	}
+	tlsConn := tls.Server(conn, tlsConfig)
+	tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	err = tlsConn.Handshake()
+	if err != nil {
+		return conn, true, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, true, nil
+}
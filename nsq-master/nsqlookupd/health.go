@@ -0,0 +1,190 @@
+package nsqlookupd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nsqio/nsq/internal/http_api"
+)
+
+// EventProducerHealthy and EventProducerUnhealthy are emitted (for every
+// topic of the nsqd) when a health check changes the state of a producer
+const (
+	EventProducerHealthy   = "producer_healthy"
+	EventProducerUnhealthy = "producer_unhealthy"
+)
+
+type nodeHealth struct {
+	healthy   bool
+	failures  int
+	lastCheck time.Time
+	lastErr   error
+}
+
+// healthChecker probes the HTTP /ping endpoint of every registered nsqd.
+//
+// An nsqd is unhealthy after --health-check-failures consecutive failed
+// probes and healthy again after a successful one, nodes that weren't
+// probed (yet) are healthy. This catches an nsqd that is wedged but still
+// holds on to its connection to nsqlookupd (and keeps PINGing).
+type healthChecker struct {
+	ctx    *Context
+	client *http.Client
+
+	sync.RWMutex
+	nodes map[string]*nodeHealth
+}
+
+func newHealthChecker(ctx *Context) *healthChecker {
+	timeout := ctx.nsqlookupd.opts.HealthCheckTimeout
+	return &healthChecker{
+		ctx: ctx,
+		client: &http.Client{
+			Transport: http_api.NewDeadlineTransport(timeout, timeout),
+			Timeout:   timeout,
+		},
+		nodes: make(map[string]*nodeHealth),
+	}
+}
+
+// nodeAddr is the address of an nsqd's HTTP endpoint, the same format
+// /topic/tombstone takes
+func nodeAddr(pi *PeerInfo) string {
+	return fmt.Sprintf("%s:%d", pi.BroadcastAddress, pi.HTTPPort)
+}
+
+func (h *healthChecker) loop() {
+	ticker := time.NewTicker(h.ctx.nsqlookupd.opts.HealthCheckInterval)
+	for {
+		select {
+		case <-ticker.C:
+			h.checkAll()
+		case <-h.ctx.nsqlookupd.exitChan:
+			goto exit
+		}
+	}
+
+exit:
+	ticker.Stop()
+}
+
+// checkAll probes all nodes concurrently and forgets the ones that are
+// no longer registered
+func (h *healthChecker) checkAll() {
+	addrs := make(map[string]struct{})
+	for _, p := range h.ctx.nsqlookupd.DB.FindProducers("client", "", "") {
+		addrs[nodeAddr(p.peerInfo)] = struct{}{}
+	}
+
+	h.Lock()
+	for addr := range h.nodes {
+		if _, ok := addrs[addr]; !ok {
+			delete(h.nodes, addr)
+		}
+	}
+	h.Unlock()
+
+	var wg sync.WaitGroup
+	for addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			h.record(addr, h.probe(addr))
+		}(addr)
+	}
+	wg.Wait()
+}
+
+func (h *healthChecker) probe(addr string) error {
+	resp, err := h.client.Get(fmt.Sprintf("http://%s/ping", addr))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		// nsqd responds with the reason it's unhealthy
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("got response %s %q", resp.Status, body)
+	}
+	return nil
+}
+
+func (h *healthChecker) record(addr string, err error) {
+	h.Lock()
+	n, ok := h.nodes[addr]
+	if !ok {
+		n = &nodeHealth{healthy: true}
+		h.nodes[addr] = n
+	}
+	wasHealthy := n.healthy
+	n.lastCheck = time.Now()
+	n.lastErr = err
+	if err == nil {
+		n.failures = 0
+		n.healthy = true
+	} else {
+		n.failures++
+		if n.failures >= h.ctx.nsqlookupd.opts.HealthCheckFailures {
+			n.healthy = false
+		}
+	}
+	healthy := n.healthy
+	h.Unlock()
+
+	if healthy == wasHealthy {
+		return
+	}
+	if healthy {
+		h.ctx.nsqlookupd.logf(LOG_INFO, "HEALTH: nsqd(%s) is healthy again", addr)
+		h.ctx.nsqlookupd.DB.NotifyNodeHealth(addr, EventProducerHealthy)
+	} else {
+		h.ctx.nsqlookupd.logf(LOG_WARN, "HEALTH: nsqd(%s) is unhealthy - %s", addr, err)
+		h.ctx.nsqlookupd.DB.NotifyNodeHealth(addr, EventProducerUnhealthy)
+	}
+}
+
+// Status returns whether the nsqd is healthy and the error of its last
+// failed probe
+func (h *healthChecker) Status(pi *PeerInfo) (bool, error) {
+	h.RLock()
+	defer h.RUnlock()
+	n, ok := h.nodes[nodeAddr(pi)]
+	if !ok {
+		return true, nil
+	}
+	return n.healthy, n.lastErr
+}
+
+// FilterHealthy returns the healthy producers. In case none of them are
+// it's more likely that nsqlookupd can't reach them than that they're all
+// down, then all are returned.
+func (h *healthChecker) FilterHealthy(pp Producers) Producers {
+	results := Producers{}
+	for _, p := range pp {
+		if healthy, _ := h.Status(p.peerInfo); healthy {
+			results = append(results, p)
+		}
+	}
+	if len(results) == 0 {
+		return pp
+	}
+	return results
+}
+
+// NotifyNodeHealth emits a health event for every topic the nsqd at the
+// given HTTP address is a producer of
+func (r *RegistrationDB) NotifyNodeHealth(addr string, typ string) {
+	r.Lock()
+	defer r.Unlock()
+	for k := range r.index.byCategory["topic"] {
+		for _, p := range r.registrationMap[k] {
+			if nodeAddr(p.peerInfo) == addr {
+				r.emit(typ, k, p.peerInfo)
+			}
+		}
+	}
+}
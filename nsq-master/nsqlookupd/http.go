@@ -1,10 +1,13 @@
 package nsqlookupd
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/pprof"
 	"sync/atomic"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/nsqio/nsq/internal/http_api"
@@ -13,11 +16,13 @@ import (
 )
 
 type httpServer struct {
-	ctx    *Context
-	router http.Handler
+	ctx         *Context
+	tlsEnabled  bool
+	tlsRequired bool
+	router      http.Handler
 }
 
-func newHTTPServer(ctx *Context) *httpServer {
+func newHTTPServer(ctx *Context, tlsEnabled bool, tlsRequired bool) *httpServer {
 	log := http_api.Log(ctx.nsqlookupd.logf)
 
 	router := httprouter.New()
@@ -26,8 +31,10 @@ func newHTTPServer(ctx *Context) *httpServer {
 	router.NotFound = http_api.LogNotFoundHandler(ctx.nsqlookupd.logf)
 	router.MethodNotAllowed = http_api.LogMethodNotAllowedHandler(ctx.nsqlookupd.logf)
 	s := &httpServer{
-		ctx:    ctx,
-		router: router,
+		ctx:         ctx,
+		tlsEnabled:  tlsEnabled,
+		tlsRequired: tlsRequired,
+		router:      router,
 	}
 
 	router.Handle("GET", "/ping", http_api.Decorate(s.pingHandler, log, http_api.PlainText))
@@ -39,13 +46,19 @@ func newHTTPServer(ctx *Context) *httpServer {
 	router.Handle("GET", "/topics", http_api.Decorate(s.doTopics, log, http_api.V1))
 	router.Handle("GET", "/channels", http_api.Decorate(s.doChannels, log, http_api.V1))
 	router.Handle("GET", "/nodes", http_api.Decorate(s.doNodes, log, http_api.V1))
+	router.Handle("GET", "/watch", http_api.Decorate(s.doWatch, log, http_api.V1))
+	router.Handle("GET", "/watch/stream", http_api.Decorate(s.doWatchStream, log))
 
 	// only v1
-	router.Handle("POST", "/topic/create", http_api.Decorate(s.doCreateTopic, log, http_api.V1))
-	router.Handle("POST", "/topic/delete", http_api.Decorate(s.doDeleteTopic, log, http_api.V1))
-	router.Handle("POST", "/channel/create", http_api.Decorate(s.doCreateChannel, log, http_api.V1))
-	router.Handle("POST", "/channel/delete", http_api.Decorate(s.doDeleteChannel, log, http_api.V1))
-	router.Handle("POST", "/topic/tombstone", http_api.Decorate(s.doTombstoneTopicProducer, log, http_api.V1))
+	router.Handle("POST", "/topic/create", http_api.Decorate(s.doCreateTopic, s.requireRole(RoleWrite), log, http_api.V1))
+	router.Handle("POST", "/topic/delete", http_api.Decorate(s.doDeleteTopic, s.requireRole(RoleWrite), log, http_api.V1))
+	router.Handle("POST", "/channel/create", http_api.Decorate(s.doCreateChannel, s.requireRole(RoleWrite), log, http_api.V1))
+	router.Handle("POST", "/channel/delete", http_api.Decorate(s.doDeleteChannel, s.requireRole(RoleWrite), log, http_api.V1))
+	router.Handle("POST", "/topic/tombstone", http_api.Decorate(s.doTombstoneTopicProducer, s.requireRole(RoleWrite), log, http_api.V1))
+
+	// replication between nsqlookupd peers
+	router.Handle("GET", "/peer/state", http_api.Decorate(s.doPeerState, s.requireRole(RolePeer), log, http_api.V1))
+	router.Handle("POST", "/peer/apply", http_api.Decorate(s.doPeerApply, s.requireRole(RolePeer), log, http_api.V1))
 
 	// debug
 	router.HandlerFunc("GET", "/debug/pprof", pprof.Index)
@@ -62,6 +75,15 @@ func newHTTPServer(ctx *Context) *httpServer {
 }
 
 func (s *httpServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !s.tlsEnabled && s.tlsRequired {
+		resp := fmt.Sprintf(`{"message": "TLS_REQUIRED", "https_port": %d}`,
+			s.ctx.nsqlookupd.RealHTTPSAddr().Port)
+		w.Header().Set("X-NSQ-Content-Type", "nsq; version=1.0")
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(403)
+		io.WriteString(w, resp)
+		return
+	}
 	s.router.ServeHTTP(w, req)
 }
 
@@ -78,10 +100,33 @@ func (s *httpServer) doInfo(w http.ResponseWriter, req *http.Request, ps httprou
 }
 
 func (s *httpServer) doTopics(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
-	topics := s.ctx.nsqlookupd.DB.FindRegistrations("topic", "*", "").Keys()
-	return map[string]interface{}{
-		"topics": topics,
-	}, nil
+	reqParams, err := http_api.NewReqParams(req)
+	if err != nil {
+		return nil, http_api.Err{400, "INVALID_REQUEST"}
+	}
+
+	args, err := getListArgs(reqParams)
+	if err != nil {
+		return nil, err
+	}
+
+	registrations := s.ctx.nsqlookupd.DB.FindRegistrationsByPrefix("topic", "*", "", args.prefix)
+	registrations = s.filterByLabels(registrations, reqParams.Values["label"])
+
+	topics, total, next := args.applySorted(registrations.Keys())
+
+	page := make(Registrations, len(topics))
+	for i, t := range topics {
+		page[i] = Registration{"topic", t, ""}
+	}
+	topicsMetadata := make(map[string]Metadata)
+	for k, m := range s.ctx.nsqlookupd.DB.FindMetadata(page) {
+		topicsMetadata[k.Key] = m
+	}
+	return args.listResponse(map[string]interface{}{
+		"topics":   topics,
+		"metadata": topicsMetadata,
+	}, total, next), nil
 }
 
 func (s *httpServer) doChannels(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
@@ -95,10 +140,44 @@ func (s *httpServer) doChannels(w http.ResponseWriter, req *http.Request, ps htt
 		return nil, http_api.Err{400, "MISSING_ARG_TOPIC"}
 	}
 
-	channels := s.ctx.nsqlookupd.DB.FindRegistrations("channel", topicName, "*").SubKeys()
-	return map[string]interface{}{
+	args, err := getListArgs(reqParams)
+	if err != nil {
+		return nil, err
+	}
+
+	registrations := s.ctx.nsqlookupd.DB.FindRegistrationsByPrefix("channel", topicName, "*", args.prefix)
+	registrations = s.filterByLabels(registrations, reqParams.Values["label"])
+
+	channels, total, next := args.applySorted(registrations.SubKeys())
+
+	page := make(Registrations, len(channels))
+	for i, c := range channels {
+		page[i] = Registration{"channel", topicName, c}
+	}
+	channelsMetadata := make(map[string]Metadata)
+	for k, m := range s.ctx.nsqlookupd.DB.FindMetadata(page) {
+		channelsMetadata[k.SubKey] = m
+	}
+	return args.listResponse(map[string]interface{}{
 		"channels": channels,
-	}, nil
+		"metadata": channelsMetadata,
+	}, total, next), nil
+}
+
+// filterByLabels returns the registrations whose metadata has all of the
+// given labels, each either "name" (label is set) or "name:value"
+func (s *httpServer) filterByLabels(rr Registrations, labels []string) Registrations {
+	if len(labels) == 0 {
+		return rr
+	}
+	metadata := s.ctx.nsqlookupd.DB.FindMetadata(rr)
+	output := Registrations{}
+	for _, r := range rr {
+		if metadata[r].HasLabels(labels) {
+			output = append(output, r)
+		}
+	}
+	return output
 }
 
 func (s *httpServer) doLookup(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
@@ -112,6 +191,10 @@ func (s *httpServer) doLookup(w http.ResponseWriter, req *http.Request, ps httpr
 		return nil, http_api.Err{400, "MISSING_ARG_TOPIC"}
 	}
 
+	// read first, watching from it may repeat changes included below but
+	// never miss any
+	revision := s.ctx.nsqlookupd.DB.Revision()
+
 	registration := s.ctx.nsqlookupd.DB.FindRegistrations("topic", topicName, "")
 	if len(registration) == 0 {
 		return nil, http_api.Err{404, "TOPIC_NOT_FOUND"}
@@ -121,9 +204,32 @@ func (s *httpServer) doLookup(w http.ResponseWriter, req *http.Request, ps httpr
 	producers := s.ctx.nsqlookupd.DB.FindProducers("topic", topicName, "")
 	producers = producers.FilterByActive(s.ctx.nsqlookupd.opts.InactiveProducerTimeout,
 		s.ctx.nsqlookupd.opts.TombstoneLifetime)
+	producers = s.ctx.nsqlookupd.health.FilterHealthy(producers)
+
+	// topology-aware lookups, closest producers first
+	preferZone, _ := reqParams.Get("prefer_zone")
+	preferRegion, _ := reqParams.Get("prefer_region")
+	if preferZone != "" || preferRegion != "" {
+		var filter bool
+		switch locality, _ := reqParams.Get("locality"); locality {
+		case "", "order":
+		case "filter":
+			filter = true
+		default:
+			return nil, http_api.Err{400, "INVALID_ARG_LOCALITY"}
+		}
+		producers = producers.SortByLocality(preferZone, preferRegion, filter)
+	}
+
+	var metadata Metadata
+	for _, m := range s.ctx.nsqlookupd.DB.FindMetadata(registration) {
+		metadata = m
+	}
 	return map[string]interface{}{
 		"channels":  channels,
 		"producers": producers.PeerInfo(),
+		"metadata":  metadata,
+		"revision":  s.ctx.nsqlookupd.DB.RevisionToken(revision),
 	}, nil
 }
 
@@ -142,11 +248,17 @@ func (s *httpServer) doCreateTopic(w http.ResponseWriter, req *http.Request, ps
 		return nil, http_api.Err{400, "INVALID_ARG_TOPIC"}
 	}
 
+	s.createTopic(topicName)
+	s.ctx.nsqlookupd.peers.replicate(peerOp{op: peerOpCreateTopic, topic: topicName})
+
+	return nil, nil
+}
+
+func (s *httpServer) createTopic(topicName string) {
 	s.ctx.nsqlookupd.logf(LOG_INFO, "DB: adding topic(%s)", topicName)
 	key := Registration{"topic", topicName, ""}
 	s.ctx.nsqlookupd.DB.AddRegistration(key)
-
-	return nil, nil
+	s.ctx.nsqlookupd.peers.forgetDeleted(key)
 }
 
 func (s *httpServer) doDeleteTopic(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
@@ -160,10 +272,19 @@ func (s *httpServer) doDeleteTopic(w http.ResponseWriter, req *http.Request, ps
 		return nil, http_api.Err{400, "MISSING_ARG_TOPIC"}
 	}
 
+	s.deleteTopic(topicName)
+	s.ctx.nsqlookupd.peers.replicate(peerOp{op: peerOpDeleteTopic, topic: topicName})
+
+	return nil, nil
+}
+
+func (s *httpServer) deleteTopic(topicName string) {
+	now := time.Now()
 	registrations := s.ctx.nsqlookupd.DB.FindRegistrations("channel", topicName, "*")
 	for _, registration := range registrations {
 		s.ctx.nsqlookupd.logf(LOG_INFO, "DB: removing channel(%s) from topic(%s)", registration.SubKey, topicName)
 		s.ctx.nsqlookupd.DB.RemoveRegistration(registration)
+		s.ctx.nsqlookupd.peers.recordDeleted(registration, now)
 	}
 
 	registrations = s.ctx.nsqlookupd.DB.FindRegistrations("topic", topicName, "")
@@ -171,8 +292,7 @@ func (s *httpServer) doDeleteTopic(w http.ResponseWriter, req *http.Request, ps
 		s.ctx.nsqlookupd.logf(LOG_INFO, "DB: removing topic(%s)", topicName)
 		s.ctx.nsqlookupd.DB.RemoveRegistration(registration)
 	}
-
-	return nil, nil
+	s.ctx.nsqlookupd.peers.recordDeleted(Registration{"topic", topicName, ""}, now)
 }
 
 func (s *httpServer) doTombstoneTopicProducer(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
@@ -191,16 +311,22 @@ func (s *httpServer) doTombstoneTopicProducer(w http.ResponseWriter, req *http.R
 		return nil, http_api.Err{400, "MISSING_ARG_NODE"}
 	}
 
+	s.tombstoneTopicProducer(topicName, node)
+	s.ctx.nsqlookupd.peers.replicate(peerOp{op: peerOpTombstoneTopic, topic: topicName, node: node})
+
+	return nil, nil
+}
+
+func (s *httpServer) tombstoneTopicProducer(topicName string, node string) {
 	s.ctx.nsqlookupd.logf(LOG_INFO, "DB: setting tombstone for producer@%s of topic(%s)", node, topicName)
-	producers := s.ctx.nsqlookupd.DB.FindProducers("topic", topicName, "")
+	key := Registration{"topic", topicName, ""}
+	producers := s.ctx.nsqlookupd.DB.FindProducers(key.Category, key.Key, key.SubKey)
 	for _, p := range producers {
 		thisNode := fmt.Sprintf("%s:%d", p.peerInfo.BroadcastAddress, p.peerInfo.HTTPPort)
 		if thisNode == node {
-			p.Tombstone()
+			s.ctx.nsqlookupd.DB.TombstoneProducer(key, p)
 		}
 	}
-
-	return nil, nil
 }
 
 func (s *httpServer) doCreateChannel(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
@@ -214,15 +340,19 @@ func (s *httpServer) doCreateChannel(w http.ResponseWriter, req *http.Request, p
 		return nil, http_api.Err{400, err.Error()}
 	}
 
+	s.createChannel(topicName, channelName)
+	s.ctx.nsqlookupd.peers.replicate(peerOp{op: peerOpCreateChannel, topic: topicName, channel: channelName})
+
+	return nil, nil
+}
+
+func (s *httpServer) createChannel(topicName string, channelName string) {
 	s.ctx.nsqlookupd.logf(LOG_INFO, "DB: adding channel(%s) in topic(%s)", channelName, topicName)
 	key := Registration{"channel", topicName, channelName}
 	s.ctx.nsqlookupd.DB.AddRegistration(key)
+	s.ctx.nsqlookupd.peers.forgetDeleted(key)
 
-	s.ctx.nsqlookupd.logf(LOG_INFO, "DB: adding topic(%s)", topicName)
-	key = Registration{"topic", topicName, ""}
-	s.ctx.nsqlookupd.DB.AddRegistration(key)
-
-	return nil, nil
+	s.createTopic(topicName)
 }
 
 func (s *httpServer) doDeleteChannel(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
@@ -241,9 +371,67 @@ func (s *httpServer) doDeleteChannel(w http.ResponseWriter, req *http.Request, p
 		return nil, http_api.Err{404, "CHANNEL_NOT_FOUND"}
 	}
 
+	s.deleteChannel(topicName, channelName)
+	s.ctx.nsqlookupd.peers.replicate(peerOp{op: peerOpDeleteChannel, topic: topicName, channel: channelName})
+
+	return nil, nil
+}
+
+func (s *httpServer) deleteChannel(topicName string, channelName string) {
 	s.ctx.nsqlookupd.logf(LOG_INFO, "DB: removing channel(%s) from topic(%s)", channelName, topicName)
-	for _, registration := range registrations {
-		s.ctx.nsqlookupd.DB.RemoveRegistration(registration)
+	key := Registration{"channel", topicName, channelName}
+	s.ctx.nsqlookupd.DB.RemoveRegistration(key)
+	s.ctx.nsqlookupd.peers.recordDeleted(key, time.Now())
+}
+
+func (s *httpServer) doPeerState(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	return s.ctx.nsqlookupd.peers.State(), nil
+}
+
+// doPeerApply applies an operation replicated by a peer (without
+// replicating it any further)
+func (s *httpServer) doPeerApply(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	reqParams, err := http_api.NewReqParams(req)
+	if err != nil {
+		return nil, http_api.Err{400, "INVALID_REQUEST"}
+	}
+
+	op, err := reqParams.Get("op")
+	if err != nil {
+		return nil, http_api.Err{400, "MISSING_ARG_OP"}
+	}
+
+	topicName, err := reqParams.Get("topic")
+	if err != nil {
+		return nil, http_api.Err{400, "MISSING_ARG_TOPIC"}
+	}
+	if !protocol.IsValidTopicName(topicName) {
+		return nil, http_api.Err{400, "INVALID_ARG_TOPIC"}
+	}
+
+	switch op {
+	case peerOpCreateTopic:
+		s.createTopic(topicName)
+	case peerOpDeleteTopic:
+		s.deleteTopic(topicName)
+	case peerOpCreateChannel, peerOpDeleteChannel:
+		_, channelName, err := http_api.GetTopicChannelArgs(reqParams)
+		if err != nil {
+			return nil, http_api.Err{400, err.Error()}
+		}
+		if op == peerOpCreateChannel {
+			s.createChannel(topicName, channelName)
+		} else {
+			s.deleteChannel(topicName, channelName)
+		}
+	case peerOpTombstoneTopic:
+		node, err := reqParams.Get("node")
+		if err != nil {
+			return nil, http_api.Err{400, "MISSING_ARG_NODE"}
+		}
+		s.tombstoneTopicProducer(topicName, node)
+	default:
+		return nil, http_api.Err{400, "INVALID_ARG_OP"}
 	}
 
 	return nil, nil
@@ -256,14 +444,48 @@ type node struct {
 	TCPPort          int      `json:"tcp_port"`
 	HTTPPort         int      `json:"http_port"`
 	Version          string   `json:"version"`
+	Zone             string   `json:"zone,omitempty"`
+	Region           string   `json:"region,omitempty"`
+	Healthy          bool     `json:"healthy"`
+	HealthError      string   `json:"health_error,omitempty"`
 	Tombstones       []bool   `json:"tombstones"`
 	Topics           []string `json:"topics"`
 }
 
 func (s *httpServer) doNodes(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	reqParams, err := http_api.NewReqParams(req)
+	if err != nil {
+		return nil, http_api.Err{400, "INVALID_REQUEST"}
+	}
+
+	args, err := getListArgs(reqParams)
+	if err != nil {
+		return nil, err
+	}
+
 	// dont filter out tombstoned nodes
-	producers := s.ctx.nsqlookupd.DB.FindProducers("client", "", "").FilterByActive(
+	active := s.ctx.nsqlookupd.DB.FindProducers("client", "", "").FilterByActive(
 		s.ctx.nsqlookupd.opts.InactiveProducerTimeout, 0)
+
+	// nodes are filtered, sorted and paged by their HTTP address
+	byAddr := make(map[string]Producers)
+	for _, p := range active {
+		addr := nodeAddr(p.peerInfo)
+		byAddr[addr] = append(byAddr[addr], p)
+	}
+	addrs := make([]string, 0, len(byAddr))
+	for addr := range byAddr {
+		addrs = append(addrs, addr)
+	}
+	addrs, total, next := args.apply(addrs)
+	if args.countOnly {
+		return args.listResponse(nil, total, next), nil
+	}
+	var producers Producers
+	for _, addr := range addrs {
+		producers = append(producers, byAddr[addr]...)
+	}
+
 	nodes := make([]*node, len(producers))
 	topicProducersMap := make(map[string]Producers)
 	for i, p := range producers {
@@ -286,6 +508,12 @@ func (s *httpServer) doNodes(w http.ResponseWriter, req *http.Request, ps httpro
 			}
 		}
 
+		var healthErr string
+		healthy, err := s.ctx.nsqlookupd.health.Status(p.peerInfo)
+		if err != nil {
+			healthErr = err.Error()
+		}
+
 		nodes[i] = &node{
 			RemoteAddress:    p.peerInfo.RemoteAddress,
 			Hostname:         p.peerInfo.Hostname,
@@ -293,14 +521,18 @@ func (s *httpServer) doNodes(w http.ResponseWriter, req *http.Request, ps httpro
 			TCPPort:          p.peerInfo.TCPPort,
 			HTTPPort:         p.peerInfo.HTTPPort,
 			Version:          p.peerInfo.Version,
+			Zone:             p.peerInfo.Zone,
+			Region:           p.peerInfo.Region,
+			Healthy:          healthy,
+			HealthError:      healthErr,
 			Tombstones:       tombstones,
 			Topics:           topics,
 		}
 	}
 
-	return map[string]interface{}{
+	return args.listResponse(map[string]interface{}{
 		"producers": nodes,
-	}, nil
+	}, total, next), nil
 }
 
 func (s *httpServer) doDebug(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
@@ -328,3 +560,188 @@ func (s *httpServer) doDebug(w http.ResponseWriter, req *http.Request, ps httpro
 
 	return data, nil
 }
+
+const (
+	defaultWatchTimeout = 30 * time.Second
+	maxWatchTimeout     = 5 * time.Minute
+	watchKeepalive      = 15 * time.Second
+)
+
+type watchArgs struct {
+	topic       string
+	revision    uint64
+	hasRevision bool
+	compacted   bool
+	timeout     time.Duration
+}
+
+func getWatchArgs(req *http.Request, db *RegistrationDB) (*watchArgs, error) {
+	reqParams, err := http_api.NewReqParams(req)
+	if err != nil {
+		return nil, http_api.Err{400, "INVALID_REQUEST"}
+	}
+
+	args := &watchArgs{timeout: defaultWatchTimeout}
+	args.topic, _ = reqParams.Get("topic")
+	if args.topic != "" && !protocol.IsValidTopicName(args.topic) {
+		return nil, http_api.Err{400, "INVALID_ARG_TOPIC"}
+	}
+
+	revisionStr, _ := reqParams.Get("revision")
+	if revisionStr == "" {
+		// resuming an event stream
+		revisionStr = req.Header.Get("Last-Event-ID")
+	}
+	if revisionStr != "" {
+		args.revision, err = db.ParseRevisionToken(revisionStr)
+		switch err {
+		case nil:
+		case ErrRevisionCompacted:
+			args.compacted = true
+		default:
+			return nil, http_api.Err{400, "INVALID_ARG_REVISION"}
+		}
+		args.hasRevision = true
+	}
+
+	if timeoutStr, _ := reqParams.Get("timeout"); timeoutStr != "" {
+		args.timeout, err = time.ParseDuration(timeoutStr)
+		if err != nil || args.timeout <= 0 || args.timeout > maxWatchTimeout {
+			return nil, http_api.Err{400, "INVALID_ARG_TIMEOUT"}
+		}
+	}
+
+	return args, nil
+}
+
+// doWatch long-polls for the changes after a revision (of the given topic),
+// without a revision it returns the current one right away. When the
+// revision is no longer available the caller has to /lookup again.
+func (s *httpServer) doWatch(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	db := s.ctx.nsqlookupd.DB
+	args, err := getWatchArgs(req, db)
+	if err != nil {
+		return nil, err
+	}
+	if args.compacted {
+		return nil, http_api.Err{410, "REVISION_COMPACTED"}
+	}
+
+	timer := time.NewTimer(args.timeout)
+	defer timer.Stop()
+
+	revision := args.revision
+	for {
+		events, current, changed, err := db.EventsSince(revision)
+		if err == ErrRevisionCompacted {
+			return nil, http_api.Err{410, "REVISION_COMPACTED"}
+		}
+		events = filterEvents(events, args.topic)
+		if len(events) > 0 || !args.hasRevision {
+			return map[string]interface{}{
+				"revision": db.RevisionToken(current),
+				"events":   events,
+			}, nil
+		}
+		// none of the changes so far are relevant
+		revision = current
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return map[string]interface{}{
+				"revision": db.RevisionToken(current),
+				"events":   []Event{},
+			}, nil
+		case <-req.Context().Done():
+			return nil, nil
+		case <-s.ctx.nsqlookupd.exitChan:
+			return nil, http_api.Err{503, "EXITING"}
+		}
+	}
+}
+
+// doWatchStream streams the changes (of the given topic) as server-sent
+// events, starting after the given revision or the current one
+func (s *httpServer) doWatchStream(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	db := s.ctx.nsqlookupd.DB
+	args, err := getWatchArgs(req, db)
+	if err != nil {
+		http_api.RespondV1(w, err.(http_api.Err).Code, err)
+		return nil, err
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		err := http_api.Err{500, "STREAMING_UNSUPPORTED"}
+		http_api.RespondV1(w, err.Code, err)
+		return nil, err
+	}
+
+	revision := args.revision
+	if !args.hasRevision || args.compacted {
+		revision = db.Revision()
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+
+	writeEvent := func(revision uint64, typ string, data interface{}) error {
+		body, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", db.RevisionToken(revision), typ, body)
+		return err
+	}
+	revisionData := func(revision uint64) map[string]string {
+		return map[string]string{"revision": db.RevisionToken(revision)}
+	}
+
+	err = writeEvent(revision, "ready", revisionData(revision))
+	if err == nil && args.compacted {
+		// resuming a stream of a previous nsqlookupd
+		err = writeEvent(revision, "reset", revisionData(revision))
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(watchKeepalive)
+	defer keepalive.Stop()
+
+	for err == nil {
+		events, current, changed, innerErr := db.EventsSince(revision)
+		if innerErr == ErrRevisionCompacted {
+			// the watcher missed changes, it has to /lookup again
+			err = writeEvent(current, "reset", revisionData(current))
+		}
+		for _, e := range filterEvents(events, args.topic) {
+			if err != nil {
+				break
+			}
+			err = writeEvent(e.Revision, e.Type, e)
+		}
+		if err != nil {
+			break
+		}
+		revision = current
+		flusher.Flush()
+
+	wait:
+		select {
+		case <-changed:
+		case <-keepalive.C:
+			_, err = io.WriteString(w, ": keepalive\n\n")
+			if err != nil {
+				break
+			}
+			flusher.Flush()
+			goto wait
+		case <-req.Context().Done():
+			return nil, nil
+		case <-s.ctx.nsqlookupd.exitChan:
+			return nil, nil
+		}
+	}
+	return nil, nil
+}
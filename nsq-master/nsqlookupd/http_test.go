@@ -1,15 +1,20 @@
 package nsqlookupd
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/nsqio/go-nsq"
+	"github.com/nsqio/nsq/internal/http_api"
 	"github.com/nsqio/nsq/internal/test"
 	"github.com/nsqio/nsq/internal/version"
 	"github.com/nsqio/nsq/nsqd"
@@ -482,3 +487,227 @@ func TestDeleteChannel(t *testing.T) {
 	t.Logf("%s", body)
 	test.Equal(t, []byte(""), body)
 }
+
+func TestTLSAndAuth(t *testing.T) {
+	lgr := test.NewTestLogger(t)
+
+	opts := NewOptions()
+	opts.Logger = lgr
+	opts.TCPAddress = "127.0.0.1:0"
+	opts.HTTPAddress = "127.0.0.1:0"
+	opts.HTTPSAddress = "127.0.0.1:0"
+	opts.TLSCert = "../nsqd/test/certs/server.pem"
+	opts.TLSKey = "../nsqd/test/certs/server.key"
+	opts.TLSRootCAFile = "../nsqd/test/certs/ca.pem"
+	// implies TLSRequired
+	opts.TLSClientAuthPolicy = "require-verify"
+	opts.RegistrationSecret = "s3cret"
+	opts.HTTPAuthTokens = []string{"write:writer", "peer:peer"}
+	nsqlookupd, err := New(opts)
+	test.Nil(t, err)
+	go nsqlookupd.Main()
+	defer nsqlookupd.Exit()
+
+	// plaintext registration is refused
+	conn := mustConnectLookupd(t, nsqlookupd.RealTCPAddr())
+	resp, err := nsq.ReadResponse(conn)
+	test.Nil(t, err)
+	test.Equal(t, "E_TLS_REQUIRED", string(resp))
+	conn.Close()
+
+	ca, err := ioutil.ReadFile("../nsqd/test/certs/ca.pem")
+	test.Nil(t, err)
+	rootCAs := x509.NewCertPool()
+	rootCAs.AppendCertsFromPEM(ca)
+	cert, err := tls.LoadX509KeyPair("../nsqd/test/certs/client.pem", "../nsqd/test/certs/client.key")
+	test.Nil(t, err)
+	tlsConfig := &tls.Config{
+		RootCAs:      rootCAs,
+		Certificates: []tls.Certificate{cert},
+		ServerName:   "127.0.0.1",
+	}
+
+	// so is a client without a certificate
+	tlsConn, err := tls.Dial("tcp", nsqlookupd.RealTCPAddr().String(),
+		&tls.Config{RootCAs: rootCAs, ServerName: "127.0.0.1"})
+	if err == nil {
+		tlsConn.Write(nsq.MagicV1)
+		_, err = nsq.ReadResponse(tlsConn)
+		tlsConn.Close()
+	}
+	test.NotNil(t, err)
+
+	// and one without the secret
+	tlsConn, err = tls.Dial("tcp", nsqlookupd.RealTCPAddr().String(), tlsConfig)
+	test.Nil(t, err)
+	tlsConn.Write(nsq.MagicV1)
+	cmd, _ := nsq.Identify(map[string]interface{}{
+		"tcp_port":          TCPPort,
+		"http_port":         HTTPPort,
+		"broadcast_address": HostAddr,
+		"version":           NSQDVersion,
+		"secret":            "guess",
+	})
+	cmd.WriteTo(tlsConn)
+	resp, err = nsq.ReadResponse(tlsConn)
+	test.Nil(t, err)
+	test.Equal(t, true, strings.HasPrefix(string(resp), "E_UNAUTHORIZED"))
+	tlsConn.Close()
+
+	// an nsqd with TLS, a client certificate and the secret registers
+	nsqdOpts := nsqd.NewOptions()
+	nsqdOpts.Logger = lgr
+	nsqdOpts.TCPAddress = "127.0.0.1:0"
+	nsqdOpts.HTTPAddress = "127.0.0.1:0"
+	nsqdOpts.HTTPSAddress = "127.0.0.1:0"
+	nsqdOpts.BroadcastAddress = "127.0.0.1"
+	nsqdOpts.NSQLookupdTCPAddresses = []string{nsqlookupd.RealTCPAddr().String()}
+	nsqdOpts.NSQLookupdTLS = true
+	nsqdOpts.NSQLookupdSecret = "s3cret"
+	nsqdOpts.TLSCert = "../nsqd/test/certs/client.pem"
+	nsqdOpts.TLSKey = "../nsqd/test/certs/client.key"
+	nsqdOpts.TLSRootCAFile = "../nsqd/test/certs/ca.pem"
+	nsqdOpts.DataPath, err = ioutil.TempDir("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(nsqdOpts.DataPath)
+	nsqd1, err := nsqd.New(nsqdOpts)
+	test.Nil(t, err)
+	go nsqd1.Main()
+	defer nsqd1.Exit()
+
+	topicName := "secured"
+	nsqd1.GetTopic(topicName)
+	for i := 0; len(nsqlookupd.DB.FindProducers("topic", topicName, "")) == 0; i++ {
+		if i > 100 {
+			t.Fatal("nsqd did not register")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// HTTP requests are redirected to HTTPS, mutating ones need a token
+	client := http_api.NewClient(tlsConfig, ConnectTimeout, RequestTimeout)
+	var ld map[string]interface{}
+	endpoint := fmt.Sprintf("http://%s/lookup?topic=%s", nsqlookupd.RealHTTPAddr(), topicName)
+	test.Nil(t, client.GETV1(endpoint, &ld))
+	test.Equal(t, 1, len(ld["producers"].([]interface{})))
+
+	endpoint = fmt.Sprintf("http://%s/topic/create?topic=created", nsqlookupd.RealHTTPAddr())
+	err = client.POSTV1(endpoint)
+	test.NotNil(t, err)
+	test.Equal(t, true, strings.Contains(err.Error(), "401"))
+
+	client.SetAuthToken("peer")
+	err = client.POSTV1(endpoint)
+	test.NotNil(t, err)
+	test.Equal(t, true, strings.Contains(err.Error(), "FORBIDDEN"))
+
+	client.SetAuthToken("writer")
+	test.Nil(t, client.POSTV1(endpoint))
+	test.Equal(t, 1, len(nsqlookupd.DB.FindRegistrations("topic", "created", "")))
+}
+
+func TestTLSRequiredWithoutHTTPS(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.TCPAddress = "127.0.0.1:0"
+	opts.HTTPAddress = "127.0.0.1:0"
+	opts.HTTPSAddress = ""
+	opts.TLSCert = "../nsqd/test/certs/server.pem"
+	opts.TLSKey = "../nsqd/test/certs/server.key"
+	opts.TLSRequired = true
+	_, err := New(opts)
+	test.NotNil(t, err)
+}
+
+func TestListTopics(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	_, httpAddr, nsqlookupd1 := mustStartLookupd(opts)
+	defer nsqlookupd1.Exit()
+
+	for _, name := range []string{"orders", "orders_eu", "orders_us", "payments", "users"} {
+		makeTopic(nsqlookupd1, name)
+	}
+	for _, name := range []string{"archive", "billing", "billing_eu"} {
+		makeChannel(nsqlookupd1, "orders", name)
+	}
+
+	type listDoc struct {
+		Topics     []string `json:"topics"`
+		Channels   []string `json:"channels"`
+		Count      int      `json:"count"`
+		NextCursor string   `json:"next_cursor"`
+	}
+	list := func(query string) (int, listDoc, string) {
+		var doc listDoc
+		var em ErrMessage
+		endpoint := fmt.Sprintf("http://%s%s", httpAddr, query)
+		resp, err := http.Get(endpoint)
+		test.Nil(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		t.Logf("%s %s", query, body)
+		if resp.StatusCode != 200 {
+			json.Unmarshal(body, &em)
+		} else {
+			test.Nil(t, json.Unmarshal(body, &doc))
+		}
+		return resp.StatusCode, doc, em.Message
+	}
+
+	_, doc, _ := list("/topics?prefix=orders_")
+	test.Equal(t, []string{"orders_eu", "orders_us"}, doc.Topics)
+	test.Equal(t, 2, doc.Count)
+
+	_, doc, _ = list("/topics?regex=s$&order=desc")
+	test.Equal(t, []string{"users", "payments", "orders_us", "orders"}, doc.Topics)
+
+	_, doc, _ = list("/channels?topic=orders&prefix=billing")
+	test.Equal(t, []string{"billing", "billing_eu"}, doc.Channels)
+
+	// paginate through all topics
+	var topics []string
+	query := "/topics?limit=2"
+	for pages := 0; ; pages++ {
+		test.Equal(t, true, pages < 3)
+		_, doc, _ = list(query)
+		test.Equal(t, 5, doc.Count)
+		topics = append(topics, doc.Topics...)
+		if doc.NextCursor == "" {
+			break
+		}
+		query = "/topics?limit=2&cursor=" + doc.NextCursor
+	}
+	test.Equal(t, []string{"orders", "orders_eu", "orders_us", "payments", "users"}, topics)
+
+	// and backwards
+	topics = nil
+	query = "/topics?limit=2&order=desc"
+	for pages := 0; ; pages++ {
+		test.Equal(t, true, pages < 3)
+		_, doc, _ = list(query)
+		topics = append(topics, doc.Topics...)
+		if doc.NextCursor == "" {
+			break
+		}
+		query = "/topics?limit=2&order=desc&cursor=" + doc.NextCursor
+	}
+	test.Equal(t, []string{"users", "payments", "orders_us", "orders_eu", "orders"}, topics)
+
+	_, doc, _ = list("/topics?prefix=orders&count_only=true")
+	test.Equal(t, 3, doc.Count)
+	test.Equal(t, 0, len(doc.Topics))
+
+	code, _, msg := list("/topics?regex=(")
+	test.Equal(t, 400, code)
+	test.Equal(t, "INVALID_ARG_REGEX", msg)
+	code, _, msg = list("/topics?order=sideways")
+	test.Equal(t, 400, code)
+	test.Equal(t, "INVALID_ARG_ORDER", msg)
+	code, _, msg = list("/topics?limit=0")
+	test.Equal(t, 400, code)
+	test.Equal(t, "INVALID_ARG_LIMIT", msg)
+	code, _, msg = list("/topics?cursor=!")
+	test.Equal(t, 400, code)
+	test.Equal(t, "INVALID_ARG_CURSOR", msg)
+}
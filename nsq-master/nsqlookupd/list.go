@@ -0,0 +1,154 @@
+package nsqlookupd
+
+import (
+	"encoding/base64"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nsqio/nsq/internal/http_api"
+)
+
+// maxListLimit caps the page size of /topics, /channels and /nodes
+const maxListLimit = 10000
+
+// listArgs are the filtering, sorting and pagination parameters of
+// /topics, /channels and /nodes
+type listArgs struct {
+	prefix    string
+	re        *regexp.Regexp
+	desc      bool
+	limit     int
+	cursor    string
+	countOnly bool
+}
+
+func getListArgs(reqParams *http_api.ReqParams) (*listArgs, error) {
+	args := &listArgs{}
+	args.prefix, _ = reqParams.Get("prefix")
+
+	if expr, _ := reqParams.Get("regex"); expr != "" {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, http_api.Err{400, "INVALID_ARG_REGEX"}
+		}
+		args.re = re
+	}
+
+	switch order, _ := reqParams.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		args.desc = true
+	default:
+		return nil, http_api.Err{400, "INVALID_ARG_ORDER"}
+	}
+
+	if limitStr, _ := reqParams.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			return nil, http_api.Err{400, "INVALID_ARG_LIMIT"}
+		}
+		args.limit = limit
+	}
+
+	if cursor, _ := reqParams.Get("cursor"); cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, http_api.Err{400, "INVALID_ARG_CURSOR"}
+		}
+		args.cursor = string(b)
+	}
+
+	if countOnly, _ := reqParams.Get("count_only"); countOnly != "" {
+		v, err := strconv.ParseBool(countOnly)
+		if err != nil {
+			return nil, http_api.Err{400, "INVALID_ARG_COUNT_ONLY"}
+		}
+		args.countOnly = v
+	}
+
+	return args, nil
+}
+
+// apply sorts the names and returns the requested page, see applySorted
+func (a *listArgs) apply(names []string) ([]string, int, string) {
+	sorted := make([]string, len(names))
+	copy(sorted, names)
+	sort.Strings(sorted)
+	return a.applySorted(sorted)
+}
+
+// applySorted filters the (ascending) sorted names and returns the requested
+// page, the total number of matches and the cursor of the next page (empty
+// if this is the last one). Only a regex has to look at every name with the
+// prefix, the prefix and cursor are found with a binary search.
+func (a *listArgs) applySorted(names []string) ([]string, int, string) {
+	start := sort.SearchStrings(names, a.prefix)
+	end := start + sort.Search(len(names)-start, func(i int) bool {
+		return !strings.HasPrefix(names[start+i], a.prefix)
+	})
+	matches := names[start:end]
+
+	if a.re != nil {
+		filtered := make([]string, 0, len(matches))
+		for _, name := range matches {
+			if a.re.MatchString(name) {
+				filtered = append(filtered, name)
+			}
+		}
+		matches = filtered
+	}
+
+	total := len(matches)
+	if a.countOnly {
+		return nil, total, ""
+	}
+
+	if a.cursor != "" {
+		// the cursor is the last name of the previous page
+		if a.desc {
+			matches = matches[:sort.SearchStrings(matches, a.cursor)]
+		} else {
+			matches = matches[sort.Search(len(matches), func(i int) bool {
+				return matches[i] > a.cursor
+			}):]
+		}
+	}
+
+	more := a.limit > 0 && len(matches) > a.limit
+	if more {
+		if a.desc {
+			matches = matches[len(matches)-a.limit:]
+		} else {
+			matches = matches[:a.limit]
+		}
+	}
+
+	page := make([]string, len(matches))
+	for i, name := range matches {
+		if a.desc {
+			page[len(matches)-1-i] = name
+		} else {
+			page[i] = name
+		}
+	}
+	var next string
+	if more {
+		next = base64.RawURLEncoding.EncodeToString([]byte(page[len(page)-1]))
+	}
+	return page, total, next
+}
+
+// listResponse adds the count and next cursor to a response, or returns
+// just the count
+func (a *listArgs) listResponse(data map[string]interface{}, total int, next string) map[string]interface{} {
+	if a.countOnly {
+		return map[string]interface{}{"count": total}
+	}
+	data["count"] = total
+	if next != "" {
+		data["next_cursor"] = next
+	}
+	return data
+}
@@ -17,6 +17,10 @@ import (
 	"github.com/nsqio/nsq/internal/version"
 )
 
+// the metadata of a single topic or channel is bounded by nsqd, this is
+// merely a sanity check
+const maxMetadataBodySize = 1024 * 1024
+
 type LookupProtocolV1 struct {
 	ctx *Context
 }
@@ -90,6 +94,8 @@ func (p *LookupProtocolV1) Exec(client *ClientV1, reader *bufio.Reader, params [
 		return p.REGISTER(client, reader, params[1:])
 	case "UNREGISTER":
 		return p.UNREGISTER(client, reader, params[1:])
+	case "METADATA":
+		return p.METADATA(client, reader, params[1:])
 	}
 	return nil, protocol.NewFatalClientErr(nil, "E_INVALID", fmt.Sprintf("invalid command %s", params[0]))
 }
@@ -191,6 +197,52 @@ func (p *LookupProtocolV1) UNREGISTER(client *ClientV1, reader *bufio.Reader, pa
 	return []byte("OK"), nil
 }
 
+func (p *LookupProtocolV1) METADATA(client *ClientV1, reader *bufio.Reader, params []string) ([]byte, error) {
+	var err error
+
+	if client.peerInfo == nil {
+		return nil, protocol.NewFatalClientErr(nil, "E_INVALID", "client must IDENTIFY")
+	}
+
+	topic, channel, err := getTopicChan("METADATA", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var bodyLen int32
+	err = binary.Read(reader, binary.BigEndian, &bodyLen)
+	if err != nil {
+		return nil, protocol.NewFatalClientErr(err, "E_BAD_BODY", "METADATA failed to read body size")
+	}
+
+	if bodyLen <= 0 || bodyLen > maxMetadataBodySize {
+		return nil, protocol.NewFatalClientErr(nil, "E_BAD_BODY",
+			fmt.Sprintf("METADATA invalid body size %d", bodyLen))
+	}
+
+	body := make([]byte, bodyLen)
+	_, err = io.ReadFull(reader, body)
+	if err != nil {
+		return nil, protocol.NewFatalClientErr(err, "E_BAD_BODY", "METADATA failed to read body")
+	}
+
+	var m Metadata
+	err = json.Unmarshal(body, &m)
+	if err != nil {
+		return nil, protocol.NewFatalClientErr(err, "E_BAD_BODY", "METADATA failed to decode JSON body")
+	}
+
+	key := Registration{"topic", topic, ""}
+	if channel != "" {
+		key = Registration{"channel", topic, channel}
+	}
+	p.ctx.nsqlookupd.DB.SetMetadata(key, m)
+	p.ctx.nsqlookupd.logf(LOG_INFO, "DB: client(%s) METADATA category:%s key:%s subkey:%s",
+		client, key.Category, key.Key, key.SubKey)
+
+	return []byte("OK"), nil
+}
+
 func (p *LookupProtocolV1) IDENTIFY(client *ClientV1, reader *bufio.Reader, params []string) ([]byte, error) {
 	var err error
 
@@ -219,6 +271,14 @@ func (p *LookupProtocolV1) IDENTIFY(client *ClientV1, reader *bufio.Reader, para
 
 	peerInfo.RemoteAddress = client.RemoteAddr().String()
 
+	var auth struct {
+		Secret string `json:"secret"`
+	}
+	json.Unmarshal(body, &auth)
+	if !p.ctx.nsqlookupd.checkRegistrationSecret(auth.Secret) {
+		return nil, protocol.NewFatalClientErr(nil, "E_UNAUTHORIZED", "IDENTIFY invalid secret")
+	}
+
 	// require all fields
 	if peerInfo.BroadcastAddress == "" || peerInfo.TCPPort == 0 || peerInfo.HTTPPort == 0 || peerInfo.Version == "" {
 		return nil, protocol.NewFatalClientErr(nil, "E_BAD_BODY", "IDENTIFY missing fields")
@@ -226,8 +286,9 @@ func (p *LookupProtocolV1) IDENTIFY(client *ClientV1, reader *bufio.Reader, para
 
 	atomic.StoreInt64(&peerInfo.lastUpdate, time.Now().UnixNano())
 
-	p.ctx.nsqlookupd.logf(LOG_INFO, "CLIENT(%s): IDENTIFY Address:%s TCP:%d HTTP:%d Version:%s",
-		client, peerInfo.BroadcastAddress, peerInfo.TCPPort, peerInfo.HTTPPort, peerInfo.Version)
+	p.ctx.nsqlookupd.logf(LOG_INFO, "CLIENT(%s): IDENTIFY Address:%s TCP:%d HTTP:%d Version:%s Zone:%s Region:%s",
+		client, peerInfo.BroadcastAddress, peerInfo.TCPPort, peerInfo.HTTPPort, peerInfo.Version,
+		peerInfo.Zone, peerInfo.Region)
 
 	client.peerInfo = &peerInfo
 	if p.ctx.nsqlookupd.DB.AddProducer(Registration{"client", "", ""}, &Producer{peerInfo: client.peerInfo}) {
@@ -245,6 +306,7 @@ func (p *LookupProtocolV1) IDENTIFY(client *ClientV1, reader *bufio.Reader, para
 	}
 	data["broadcast_address"] = p.ctx.nsqlookupd.opts.BroadcastAddress
 	data["hostname"] = hostname
+	data["metadata"] = true
 
 	response, err := json.Marshal(data)
 	if err != nil {
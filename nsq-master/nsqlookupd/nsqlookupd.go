@@ -1,16 +1,20 @@
 package nsqlookupd
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/nsqio/nsq/internal/http_api"
 	"github.com/nsqio/nsq/internal/protocol"
 	"github.com/nsqio/nsq/internal/util"
 	"github.com/nsqio/nsq/internal/version"
+	bolt "go.etcd.io/bbolt"
 )
 
 type NSQLookupd struct {
@@ -19,8 +23,19 @@ type NSQLookupd struct {
 	tcpListener  net.Listener
 	httpListener net.Listener
 	tcpServer    *tcpServer
-	waitGroup    util.WaitGroupWrapper
-	DB           *RegistrationDB
+
+	httpsListener net.Listener
+	tlsConfig     *tls.Config
+	authRoles     map[string]string
+
+	waitGroup util.WaitGroupWrapper
+	exitChan  chan int
+	DB        *RegistrationDB
+	peers     *peers
+	health    *healthChecker
+
+	store         *bolt.DB
+	finalSnapshot bool
 }
 
 func New(opts *Options) (*NSQLookupd, error) {
@@ -30,12 +45,36 @@ func New(opts *Options) (*NSQLookupd, error) {
 		opts.Logger = log.New(os.Stderr, opts.LogPrefix, log.Ldate|log.Ltime|log.Lmicroseconds)
 	}
 	l := &NSQLookupd{
-		opts: opts,
-		DB:   NewRegistrationDB(),
+		opts:     opts,
+		exitChan: make(chan int),
+		DB:       NewRegistrationDB(),
 	}
 
 	l.logf(LOG_INFO, version.String("nsqlookupd"))
 
+	if opts.TLSClientAuthPolicy != "" {
+		opts.TLSRequired = true
+	}
+
+	l.tlsConfig, err = buildTLSConfig(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build TLS config - %s", err)
+	}
+	if l.tlsConfig == nil && opts.TLSRequired {
+		return nil, errors.New("cannot require TLS client connections without TLS key and cert")
+	}
+	if opts.TLSRequired && opts.HTTPSAddress == "" {
+		// plaintext HTTP is redirected to the HTTPS port
+		return nil, errors.New("cannot require TLS client connections without an HTTPS address")
+	}
+	l.authRoles, err = parseHTTPAuthTokens(opts.HTTPAuthTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	l.peers = newPeers(&Context{l})
+	l.health = newHealthChecker(&Context{l})
+
 	l.tcpListener, err = net.Listen("tcp", opts.TCPAddress)
 	if err != nil {
 		return nil, fmt.Errorf("listen (%s) failed - %s", opts.TCPAddress, err)
@@ -44,6 +83,28 @@ func New(opts *Options) (*NSQLookupd, error) {
 	if err != nil {
 		return nil, fmt.Errorf("listen (%s) failed - %s", opts.HTTPAddress, err)
 	}
+	if l.tlsConfig != nil && opts.HTTPSAddress != "" {
+		l.httpsListener, err = tls.Listen("tcp", opts.HTTPSAddress, l.tlsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("listen (%s) failed - %s", opts.HTTPSAddress, err)
+		}
+	}
+
+	if opts.DataPath != "" {
+		l.store, err = openStore(opts.DataPath)
+		if err != nil {
+			return nil, err
+		}
+		snaps, deleted, err := loadSnapshot(l.store)
+		if err != nil {
+			l.store.Close()
+			return nil, err
+		}
+		l.DB.Restore(snaps)
+		l.peers.RestoreDeleted(deleted)
+		l.logf(LOG_INFO, "restored %d registrations (and %d deletions) from %s",
+			len(snaps), len(deleted), l.store.Path())
+	}
 
 	return l, nil
 }
@@ -68,10 +129,26 @@ func (l *NSQLookupd) Main() error {
 	l.waitGroup.Wrap(func() {
 		exitFunc(protocol.TCPServer(l.tcpListener, l.tcpServer, l.logf))
 	})
-	httpServer := newHTTPServer(ctx)
+	httpServer := newHTTPServer(ctx, false, l.opts.TLSRequired)
 	l.waitGroup.Wrap(func() {
 		exitFunc(http_api.Serve(l.httpListener, httpServer, "HTTP", l.logf))
 	})
+	if l.httpsListener != nil {
+		httpsServer := newHTTPServer(ctx, true, true)
+		l.waitGroup.Wrap(func() {
+			exitFunc(http_api.Serve(l.httpsListener, httpsServer, "HTTPS", l.logf))
+		})
+	}
+	if l.store != nil {
+		l.waitGroup.Wrap(l.snapshotLoop)
+	}
+	if len(l.opts.PeerHTTPAddresses) > 0 {
+		l.waitGroup.Wrap(l.peers.pushLoop)
+		l.waitGroup.Wrap(l.peers.syncLoop)
+	}
+	if l.opts.HealthCheckInterval > 0 {
+		l.waitGroup.Wrap(l.health.loop)
+	}
 
 	err := <-exitCh
 	return err
@@ -85,7 +162,17 @@ func (l *NSQLookupd) RealHTTPAddr() *net.TCPAddr {
 	return l.httpListener.Addr().(*net.TCPAddr)
 }
 
+func (l *NSQLookupd) RealHTTPSAddr() *net.TCPAddr {
+	return l.httpsListener.Addr().(*net.TCPAddr)
+}
+
 func (l *NSQLookupd) Exit() {
+	if l.store != nil {
+		// closing the client connections below unregisters their producers,
+		// persist them first so that they survive the restart
+		l.persistSnapshot(true)
+	}
+
 	if l.tcpListener != nil {
 		l.tcpListener.Close()
 	}
@@ -97,5 +184,51 @@ func (l *NSQLookupd) Exit() {
 	if l.httpListener != nil {
 		l.httpListener.Close()
 	}
+
+	if l.httpsListener != nil {
+		l.httpsListener.Close()
+	}
+
+	close(l.exitChan)
 	l.waitGroup.Wait()
+
+	if l.store != nil {
+		l.store.Close()
+	}
+}
+
+// snapshotLoop periodically persists the registrations so that they
+// survive a restart
+func (l *NSQLookupd) snapshotLoop() {
+	ticker := time.NewTicker(l.opts.SnapshotInterval)
+	for {
+		select {
+		case <-ticker.C:
+			removed := l.DB.RemoveRestoredProducers(l.opts.InactiveProducerTimeout)
+			if removed > 0 {
+				l.logf(LOG_INFO, "DB: removed %d inactive restored producers", removed)
+			}
+			l.persistSnapshot(false)
+		case <-l.exitChan:
+			goto exit
+		}
+	}
+
+exit:
+	ticker.Stop()
+}
+
+// persistSnapshot saves the registrations, nothing is saved after the
+// final snapshot (taken when exiting)
+func (l *NSQLookupd) persistSnapshot(final bool) {
+	l.Lock()
+	defer l.Unlock()
+	if l.finalSnapshot {
+		return
+	}
+	err := saveSnapshot(l.store, l.DB.Snapshot(), l.peers.Deleted())
+	if err != nil {
+		l.logf(LOG_ERROR, "failed to persist registrations - %s", err)
+	}
+	l.finalSnapshot = final
 }
@@ -1,8 +1,18 @@
 package nsqlookupd
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -73,6 +83,31 @@ func identify(t *testing.T, conn net.Conn) {
 	test.Nil(t, err)
 }
 
+// mustRegisterProducer identifies as an nsqd with the given HTTP address
+// (and any other fields) and registers the topic
+func mustRegisterProducer(t *testing.T, tcpAddr *net.TCPAddr, topicName string,
+	broadcastAddress string, httpPort int, fields map[string]interface{}) net.Conn {
+	conn := mustConnectLookupd(t, tcpAddr)
+	ci := make(map[string]interface{})
+	ci["tcp_port"] = TCPPort
+	ci["http_port"] = httpPort
+	ci["broadcast_address"] = broadcastAddress
+	ci["hostname"] = broadcastAddress
+	ci["version"] = NSQDVersion
+	for k, v := range fields {
+		ci[k] = v
+	}
+	cmd, _ := nsq.Identify(ci)
+	_, err := cmd.WriteTo(conn)
+	test.Nil(t, err)
+	_, err = nsq.ReadResponse(conn)
+	test.Nil(t, err)
+	nsq.Register(topicName, "").WriteTo(conn)
+	_, err = nsq.ReadResponse(conn)
+	test.Nil(t, err)
+	return conn
+}
+
 func TestBasicLookupd(t *testing.T) {
 	opts := NewOptions()
 	opts.Logger = test.NewTestLogger(t)
@@ -357,3 +392,464 @@ func TestTombstonedNodes(t *testing.T) {
 	test.Equal(t, topicName, producers[0].Topics[0].Topic)
 	test.Equal(t, true, producers[0].Topics[0].Tombstoned)
 }
+
+func TestPersistentRegistrations(t *testing.T) {
+	dataPath, err := ioutil.TempDir("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(dataPath)
+
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.DataPath = dataPath
+	tcpAddr, httpAddr, nsqlookupd1 := mustStartLookupd(opts)
+
+	client := http_api.NewClient(nil, ConnectTimeout, RequestTimeout)
+
+	endpoint := fmt.Sprintf("http://%s/topic/create?topic=persist_created", httpAddr)
+	err = client.POSTV1(endpoint)
+	test.Nil(t, err)
+
+	conn := mustConnectLookupd(t, tcpAddr)
+	identify(t, conn)
+	nsq.Register("persist", "ch").WriteTo(conn)
+	_, err = nsq.ReadResponse(conn)
+	test.Nil(t, err)
+	nsq.Register("persist_tombstoned", "").WriteTo(conn)
+	_, err = nsq.ReadResponse(conn)
+	test.Nil(t, err)
+
+	endpoint = fmt.Sprintf("http://%s/topic/tombstone?topic=persist_tombstoned&node=%s:%d",
+		httpAddr, HostAddr, HTTPPort)
+	err = client.POSTV1(endpoint)
+	test.Nil(t, err)
+
+	nsqlookupd1.Exit()
+	conn.Close()
+
+	// restart
+	opts = NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.DataPath = dataPath
+	tcpAddr, httpAddr, nsqlookupd2 := mustStartLookupd(opts)
+	defer nsqlookupd2.Exit()
+
+	var td struct {
+		Topics []string `json:"topics"`
+	}
+	endpoint = fmt.Sprintf("http://%s/topics", httpAddr)
+	err = client.GETV1(endpoint, &td)
+	test.Nil(t, err)
+	sort.Strings(td.Topics)
+	test.Equal(t, []string{"persist", "persist_created", "persist_tombstoned"}, td.Topics)
+
+	var ld LookupDoc
+	endpoint = fmt.Sprintf("http://%s/lookup?topic=persist", httpAddr)
+	err = client.GETV1(endpoint, &ld)
+	test.Nil(t, err)
+	test.Equal(t, []interface{}{"ch"}, ld.Channels)
+	test.Equal(t, 1, len(ld.Producers))
+	test.Equal(t, HostAddr, ld.Producers[0].BroadcastAddress)
+	test.Equal(t, TCPPort, ld.Producers[0].TCPPort)
+
+	endpoint = fmt.Sprintf("http://%s/lookup?topic=persist_tombstoned", httpAddr)
+	err = client.GETV1(endpoint, &ld)
+	test.Nil(t, err)
+	test.Equal(t, 0, len(ld.Producers))
+
+	// the nsqd reconnecting takes over the restored registrations
+	conn = mustConnectLookupd(t, tcpAddr)
+	defer conn.Close()
+	identify(t, conn)
+	nsq.Register("persist", "").WriteTo(conn)
+	_, err = nsq.ReadResponse(conn)
+	test.Nil(t, err)
+	nsq.Register("persist_tombstoned", "").WriteTo(conn)
+	_, err = nsq.ReadResponse(conn)
+	test.Nil(t, err)
+
+	producers := nsqlookupd2.DB.FindProducers("topic", "persist", "")
+	test.Equal(t, 1, len(producers))
+	test.Equal(t, false, producers[0].isRestored())
+	producers = nsqlookupd2.DB.FindProducers("topic", "persist_tombstoned", "")
+	test.Equal(t, 1, len(producers))
+	test.Equal(t, true, producers[0].IsTombstoned(opts.TombstoneLifetime))
+}
+
+func TestPeerReplication(t *testing.T) {
+	var lookupds []*NSQLookupd
+	for i := 0; i < 2; i++ {
+		opts := NewOptions()
+		opts.Logger = test.NewTestLogger(t)
+		opts.TCPAddress = "127.0.0.1:0"
+		opts.HTTPAddress = "127.0.0.1:0"
+		opts.PeerSyncInterval = 25 * time.Millisecond
+		nsqlookupd, err := New(opts)
+		test.Nil(t, err)
+		defer nsqlookupd.Exit()
+		lookupds = append(lookupds, nsqlookupd)
+	}
+	lookupds[0].opts.PeerHTTPAddresses = []string{lookupds[1].RealHTTPAddr().String()}
+	lookupds[1].opts.PeerHTTPAddresses = []string{lookupds[0].RealHTTPAddr().String()}
+	for _, nsqlookupd := range lookupds {
+		go nsqlookupd.Main()
+	}
+	httpAddr0 := lookupds[0].RealHTTPAddr()
+	httpAddr1 := lookupds[1].RealHTTPAddr()
+
+	client := http_api.NewClient(nil, ConnectTimeout, RequestTimeout)
+	lookup := func(httpAddr *net.TCPAddr, topicName string) LookupDoc {
+		var ld LookupDoc
+		endpoint := fmt.Sprintf("http://%s/lookup?topic=%s", httpAddr, topicName)
+		client.GETV1(endpoint, &ld)
+		return ld
+	}
+	eventually := func(cond func() bool) {
+		for i := 0; !cond(); i++ {
+			if i > 100 {
+				t.Fatal("peers did not converge")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// an nsqd connected to the first lookupd only
+	conn := mustConnectLookupd(t, lookupds[0].RealTCPAddr())
+	defer conn.Close()
+	identify(t, conn)
+	nsq.Register("replicated", "ch").WriteTo(conn)
+	_, err := nsq.ReadResponse(conn)
+	test.Nil(t, err)
+
+	eventually(func() bool { return len(lookup(httpAddr1, "replicated").Producers) == 1 })
+	ld := lookup(httpAddr1, "replicated")
+	test.Equal(t, []interface{}{"ch"}, ld.Channels)
+	test.Equal(t, HostAddr, ld.Producers[0].BroadcastAddress)
+
+	// connecting to the second one as well doesn't duplicate it
+	conn2 := mustConnectLookupd(t, lookupds[1].RealTCPAddr())
+	defer conn2.Close()
+	identify(t, conn2)
+	nsq.Register("replicated", "").WriteTo(conn2)
+	_, err = nsq.ReadResponse(conn2)
+	test.Nil(t, err)
+	time.Sleep(50 * time.Millisecond)
+	test.Equal(t, 1, len(lookup(httpAddr1, "replicated").Producers))
+	test.Equal(t, 1, len(lookup(httpAddr0, "replicated").Producers))
+
+	// operations are pushed
+	endpoint := fmt.Sprintf("http://%s/topic/create?topic=created", httpAddr1)
+	test.Nil(t, client.POSTV1(endpoint))
+	eventually(func() bool {
+		return len(lookupds[0].DB.FindRegistrations("topic", "created", "")) == 1
+	})
+
+	endpoint = fmt.Sprintf("http://%s/topic/tombstone?topic=replicated&node=%s:%d",
+		httpAddr1, HostAddr, HTTPPort)
+	test.Nil(t, client.POSTV1(endpoint))
+	eventually(func() bool { return len(lookup(httpAddr0, "replicated").Producers) == 0 })
+	test.Equal(t, 0, len(lookup(httpAddr1, "replicated").Producers))
+
+	endpoint = fmt.Sprintf("http://%s/topic/delete?topic=created", httpAddr0)
+	test.Nil(t, client.POSTV1(endpoint))
+	eventually(func() bool {
+		return len(lookupds[1].DB.FindRegistrations("topic", "created", "")) == 0
+	})
+	// and not resurrected by the periodic sync
+	time.Sleep(75 * time.Millisecond)
+	test.Equal(t, 0, len(lookupds[0].DB.FindRegistrations("topic", "created", "")))
+	test.Equal(t, 0, len(lookupds[1].DB.FindRegistrations("topic", "created", "")))
+}
+
+func TestPeerDeletionsOutliveStalePeers(t *testing.T) {
+	dataPath, err := ioutil.TempDir("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(dataPath)
+
+	// a peer stuck with a registration deleted while it was unreachable
+	gone := Registration{"topic", "gone", ""}
+	var stale int32 = 1
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		state := peerState{Registrations: []registrationSnapshot{}}
+		if atomic.LoadInt32(&stale) == 1 {
+			state.Registrations = append(state.Registrations, registrationSnapshot{Registration: gone})
+		}
+		json.NewEncoder(w).Encode(state)
+	}))
+	defer peer.Close()
+	peerAddr := strings.TrimPrefix(peer.URL, "http://")
+
+	newOpts := func() *Options {
+		opts := NewOptions()
+		opts.Logger = test.NewTestLogger(t)
+		opts.DataPath = dataPath
+		opts.PeerHTTPAddresses = []string{peerAddr}
+		opts.PeerSyncInterval = 10 * time.Millisecond
+		opts.InactiveProducerTimeout = 20 * time.Millisecond
+		return opts
+	}
+	_, httpAddr, nsqlookupd1 := mustStartLookupd(newOpts())
+
+	client := http_api.NewClient(nil, ConnectTimeout, RequestTimeout)
+	endpoint := fmt.Sprintf("http://%s/topic/delete?topic=gone", httpAddr)
+	test.Nil(t, client.POSTV1(endpoint))
+
+	// the deletion is remembered for longer than its lifetime, as long as
+	// the peer still has the registration
+	time.Sleep(100 * time.Millisecond)
+	test.Equal(t, 0, len(nsqlookupd1.DB.FindRegistrations("topic", "gone", "")))
+	test.Equal(t, true, nsqlookupd1.peers.isDeleted(gone))
+
+	// and across restarts
+	nsqlookupd1.Exit()
+	_, _, nsqlookupd2 := mustStartLookupd(newOpts())
+	defer nsqlookupd2.Exit()
+	time.Sleep(100 * time.Millisecond)
+	test.Equal(t, 0, len(nsqlookupd2.DB.FindRegistrations("topic", "gone", "")))
+	test.Equal(t, true, nsqlookupd2.peers.isDeleted(gone))
+
+	// it's forgotten once the peer caught up
+	atomic.StoreInt32(&stale, 0)
+	for i := 0; nsqlookupd2.peers.isDeleted(gone); i++ {
+		if i > 100 {
+			t.Fatal("deletion was not forgotten")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLookupPreferZone(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	tcpAddr, httpAddr, nsqlookupd := mustStartLookupd(opts)
+	defer nsqlookupd.Exit()
+
+	topicName := "zoned"
+	for i, zone := range []string{"us-east-1a", "us-east-1b", "eu-west-1a", "us-east-1a"} {
+		conn := mustRegisterProducer(t, tcpAddr, topicName, HostAddr, HTTPPort+i, map[string]interface{}{
+			"tcp_port": TCPPort + i,
+			"zone":     zone,
+			"region":   zone[:len(zone)-1],
+		})
+		defer conn.Close()
+	}
+
+	client := http_api.NewClient(nil, ConnectTimeout, RequestTimeout)
+	zones := func(qs string) []string {
+		var ld LookupDoc
+		endpoint := fmt.Sprintf("http://%s/lookup?topic=%s&%s", httpAddr, topicName, qs)
+		err := client.GETV1(endpoint, &ld)
+		test.Nil(t, err)
+		var zones []string
+		for _, p := range ld.Producers {
+			zones = append(zones, p.Zone)
+		}
+		return zones
+	}
+
+	z := zones("prefer_zone=us-east-1b&prefer_region=us-east-1")
+	test.Equal(t, 4, len(z))
+	test.Equal(t, "us-east-1b", z[0])
+	test.Equal(t, "us-east-1", z[1][:len(z[1])-1])
+	test.Equal(t, "us-east-1", z[2][:len(z[2])-1])
+	test.Equal(t, "eu-west-1a", z[3])
+
+	test.Equal(t, []string{"us-east-1a", "us-east-1a"}, zones("prefer_zone=us-east-1a&locality=filter"))
+	test.Equal(t, []string{"eu-west-1a"}, zones("prefer_region=eu-west-1&locality=filter"))
+	// falls back to the next closest tier
+	z = zones("prefer_zone=us-east-1c&prefer_region=us-east-1&locality=filter")
+	sort.Strings(z)
+	test.Equal(t, []string{"us-east-1a", "us-east-1a", "us-east-1b"}, z)
+	test.Equal(t, 4, len(zones("prefer_zone=ap-south-1a&locality=filter")))
+
+	endpoint := fmt.Sprintf("http://%s/lookup?topic=%s&prefer_zone=a&locality=nearest", httpAddr, topicName)
+	err := client.GETV1(endpoint, nil)
+	test.NotNil(t, err)
+}
+
+type WatchDoc struct {
+	Revision string  `json:"revision"`
+	Events   []Event `json:"events"`
+}
+
+func TestWatch(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	tcpAddr, httpAddr, nsqlookupd := mustStartLookupd(opts)
+	defer nsqlookupd.Exit()
+
+	client := http_api.NewClient(nil, ConnectTimeout, RequestTimeout)
+
+	var wd WatchDoc
+	err := client.GETV1(fmt.Sprintf("http://%s/watch", httpAddr), &wd)
+	test.Nil(t, err)
+	test.Equal(t, 0, len(wd.Events))
+	revision := wd.Revision
+
+	// nothing changes
+	endpoint := fmt.Sprintf("http://%s/watch?revision=%s&timeout=50ms", httpAddr, revision)
+	err = client.GETV1(endpoint, &wd)
+	test.Nil(t, err)
+	test.Equal(t, 0, len(wd.Events))
+	test.Equal(t, revision, wd.Revision)
+
+	// the event stream
+	resp, err := http.Get(fmt.Sprintf("http://%s/watch/stream?topic=watched", httpAddr))
+	test.Nil(t, err)
+	defer resp.Body.Close()
+	test.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	stream := bufio.NewReader(resp.Body)
+	nextEvent := func() string {
+		for {
+			line, err := stream.ReadString('\n')
+			test.Nil(t, err)
+			if strings.HasPrefix(line, "event: ") {
+				return strings.TrimSpace(strings.TrimPrefix(line, "event: "))
+			}
+		}
+	}
+	test.Equal(t, "ready", nextEvent())
+
+	type result struct {
+		wd  WatchDoc
+		err error
+	}
+	resultChan := make(chan result)
+	go func() {
+		var wd WatchDoc
+		endpoint := fmt.Sprintf("http://%s/watch?topic=watched&revision=%s", httpAddr, revision)
+		err := client.GETV1(endpoint, &wd)
+		resultChan <- result{wd, err}
+	}()
+
+	time.Sleep(25 * time.Millisecond)
+	conn := mustConnectLookupd(t, tcpAddr)
+	identify(t, conn)
+	nsq.Register("watched", "").WriteTo(conn)
+	_, err = nsq.ReadResponse(conn)
+	test.Nil(t, err)
+
+	r := <-resultChan
+	test.Nil(t, r.err)
+	test.Equal(t, 2, len(r.wd.Events))
+	test.Equal(t, EventRegistrationAdded, r.wd.Events[0].Type)
+	test.Equal(t, EventProducerAdded, r.wd.Events[1].Type)
+	test.Equal(t, "watched", r.wd.Events[1].Topic)
+	test.Equal(t, HostAddr, r.wd.Events[1].Producer.BroadcastAddress)
+	test.Equal(t, nsqlookupd.DB.RevisionToken(r.wd.Events[1].Revision), r.wd.Revision)
+
+	test.Equal(t, EventRegistrationAdded, nextEvent())
+	test.Equal(t, EventProducerAdded, nextEvent())
+	conn.Close()
+	test.Equal(t, EventProducerRemoved, nextEvent())
+
+	// never handed out
+	current := r.wd.Events[1].Revision
+	endpoint = fmt.Sprintf("http://%s/watch?revision=%s", httpAddr, nsqlookupd.DB.RevisionToken(current+1000))
+	err = client.GETV1(endpoint, &wd)
+	test.NotNil(t, err)
+
+	// from before a restart, even though the revision was reached again
+	endpoint = fmt.Sprintf("http://%s/watch?revision=%s", httpAddr, NewRegistrationDB().RevisionToken(current))
+	err = client.GETV1(endpoint, &wd)
+	test.NotNil(t, err)
+	test.Equal(t, true, strings.Contains(err.Error(), "410"))
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/watch/stream", httpAddr), nil)
+	test.Nil(t, err)
+	req.Header.Set("Last-Event-ID", NewRegistrationDB().RevisionToken(current))
+	resp2, err := http.DefaultClient.Do(req)
+	test.Nil(t, err)
+	defer resp2.Body.Close()
+	stream = bufio.NewReader(resp2.Body)
+	test.Equal(t, "ready", nextEvent())
+	test.Equal(t, "reset", nextEvent())
+
+	endpoint = fmt.Sprintf("http://%s/watch?revision=%d", httpAddr, current)
+	err = client.GETV1(endpoint, &wd)
+	test.NotNil(t, err)
+	test.Equal(t, true, strings.Contains(err.Error(), "400"))
+}
+
+func TestHealthCheck(t *testing.T) {
+	var failing int32
+	nsqd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			http.Error(w, "NOK - wedged", 500)
+			return
+		}
+		w.Write([]byte("OK"))
+	}))
+	defer nsqd.Close()
+	nsqdAddr := nsqd.Listener.Addr().(*net.TCPAddr)
+
+	// nothing listens here
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	test.Nil(t, err)
+	downAddr := l.Addr().(*net.TCPAddr)
+	l.Close()
+
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.HealthCheckInterval = 10 * time.Millisecond
+	opts.HealthCheckTimeout = 100 * time.Millisecond
+	opts.HealthCheckFailures = 2
+	tcpAddr, httpAddr, nsqlookupd := mustStartLookupd(opts)
+	defer nsqlookupd.Exit()
+
+	topicName := "health_checked"
+	conn1 := mustRegisterProducer(t, tcpAddr, topicName, "127.0.0.1", nsqdAddr.Port, nil)
+	defer conn1.Close()
+	conn2 := mustRegisterProducer(t, tcpAddr, topicName, "127.0.0.1", downAddr.Port,
+		map[string]interface{}{"tcp_port": TCPPort + 1})
+	defer conn2.Close()
+
+	client := http_api.NewClient(nil, ConnectTimeout, RequestTimeout)
+	lookup := func() []int {
+		var ld LookupDoc
+		endpoint := fmt.Sprintf("http://%s/lookup?topic=%s", httpAddr, topicName)
+		err := client.GETV1(endpoint, &ld)
+		test.Nil(t, err)
+		var ports []int
+		for _, p := range ld.Producers {
+			ports = append(ports, p.HTTPPort)
+		}
+		sort.Ints(ports)
+		return ports
+	}
+	eventually := func(ports []int) {
+		sort.Ints(ports)
+		for i := 0; !reflect.DeepEqual(lookup(), ports); i++ {
+			if i > 100 {
+				t.Fatalf("producers %v never became %v", lookup(), ports)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	eventually([]int{nsqdAddr.Port})
+
+	var nodes struct {
+		Producers []*struct {
+			HTTPPort    int    `json:"http_port"`
+			Healthy     bool   `json:"healthy"`
+			HealthError string `json:"health_error"`
+		} `json:"producers"`
+	}
+	err = client.GETV1(fmt.Sprintf("http://%s/nodes", httpAddr), &nodes)
+	test.Nil(t, err)
+	test.Equal(t, 2, len(nodes.Producers))
+	for _, n := range nodes.Producers {
+		test.Equal(t, n.HTTPPort == nsqdAddr.Port, n.Healthy)
+		test.Equal(t, n.HTTPPort == nsqdAddr.Port, n.HealthError == "")
+	}
+
+	// when none are healthy all are returned
+	atomic.StoreInt32(&failing, 1)
+	eventually([]int{nsqdAddr.Port, downAddr.Port})
+	healthy, err := nsqlookupd.health.Status(&PeerInfo{BroadcastAddress: "127.0.0.1", HTTPPort: nsqdAddr.Port})
+	test.Equal(t, false, healthy)
+	test.NotNil(t, err)
+
+	atomic.StoreInt32(&failing, 0)
+	eventually([]int{nsqdAddr.Port})
+}
@@ -1,6 +1,7 @@
 package nsqlookupd
 
 import (
+	"crypto/tls"
 	"log"
 	"os"
 	"time"
@@ -15,10 +16,34 @@ type Options struct {
 
 	TCPAddress       string `flag:"tcp-address"`
 	HTTPAddress      string `flag:"http-address"`
+	HTTPSAddress     string `flag:"https-address"`
 	BroadcastAddress string `flag:"broadcast-address"`
 
 	InactiveProducerTimeout time.Duration `flag:"inactive-producer-timeout"`
 	TombstoneLifetime       time.Duration `flag:"tombstone-lifetime"`
+
+	DataPath         string        `flag:"data-path"`
+	SnapshotInterval time.Duration `flag:"snapshot-interval"`
+
+	PeerHTTPAddresses []string      `flag:"peer-http-address" cfg:"peer_http_addresses"`
+	PeerSyncInterval  time.Duration `flag:"peer-sync-interval"`
+
+	HealthCheckInterval time.Duration `flag:"health-check-interval"`
+	HealthCheckTimeout  time.Duration `flag:"health-check-timeout"`
+	HealthCheckFailures int           `flag:"health-check-failures"`
+
+	// TLS config
+	TLSCert             string `flag:"tls-cert"`
+	TLSKey              string `flag:"tls-key"`
+	TLSClientAuthPolicy string `flag:"tls-client-auth-policy"`
+	TLSRootCAFile       string `flag:"tls-root-ca-file"`
+	TLSRequired         bool   `flag:"tls-required"`
+	TLSMinVersion       uint16 `flag:"tls-min-version"`
+
+	// authentication
+	RegistrationSecret string   `flag:"registration-secret"`
+	HTTPAuthTokens     []string `flag:"http-auth-token" cfg:"http_auth_tokens"`
+	PeerHTTPAuthToken  string   `flag:"peer-http-auth-token"`
 }
 
 func NewOptions() *Options {
@@ -32,9 +57,22 @@ func NewOptions() *Options {
 		LogLevel:         lg.INFO,
 		TCPAddress:       "0.0.0.0:4160",
 		HTTPAddress:      "0.0.0.0:4161",
+		HTTPSAddress:     "0.0.0.0:4162",
 		BroadcastAddress: hostname,
 
 		InactiveProducerTimeout: 300 * time.Second,
 		TombstoneLifetime:       45 * time.Second,
+
+		SnapshotInterval: 10 * time.Second,
+
+		PeerHTTPAddresses: make([]string, 0),
+		PeerSyncInterval:  5 * time.Second,
+
+		HealthCheckTimeout:  2 * time.Second,
+		HealthCheckFailures: 3,
+
+		TLSMinVersion: tls.VersionTLS10,
+
+		HTTPAuthTokens: make([]string, 0),
 	}
 }
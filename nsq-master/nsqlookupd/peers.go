@@ -0,0 +1,413 @@
+package nsqlookupd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nsqio/nsq/internal/http_api"
+)
+
+const remoteIDPrefix = "peer:"
+
+// operations that are replicated to peers as they happen
+const (
+	peerOpCreateTopic     = "create_topic"
+	peerOpDeleteTopic     = "delete_topic"
+	peerOpCreateChannel   = "create_channel"
+	peerOpDeleteChannel   = "delete_channel"
+	peerOpTombstoneTopic  = "tombstone_topic"
+	maxPendingPeerOps     = 1024
+	peerOpDeliverAttempts = 3
+)
+
+type peerOp struct {
+	op      string
+	topic   string
+	channel string
+	node    string
+}
+
+func (o peerOp) values() url.Values {
+	v := url.Values{}
+	v.Set("op", o.op)
+	v.Set("topic", o.topic)
+	if o.channel != "" {
+		v.Set("channel", o.channel)
+	}
+	if o.node != "" {
+		v.Set("node", o.node)
+	}
+	return v
+}
+
+type deletedRegistration struct {
+	Registration
+	DeletedAt time.Time `json:"deleted_at"`
+	// Unconfirmed are the peers that could still have the registration,
+	// only persisted (they aren't part of the state shared with peers)
+	Unconfirmed []string `json:"unconfirmed,omitempty"`
+}
+
+// tombstone is a deletion that is remembered until it has expired and all
+// peers have been seen without the registration, so that the state of a
+// peer that was unreachable for longer than the expiry can't revive it
+type tombstone struct {
+	at          time.Time
+	unconfirmed map[string]bool
+}
+
+// peerState is the part of the cluster state a nsqlookupd is the source of:
+// the producers connected to it (and its restored ones), all registrations
+// it knows of and the ones it recently saw deleted
+type peerState struct {
+	Registrations []registrationSnapshot `json:"registrations"`
+	Deleted       []deletedRegistration  `json:"deleted"`
+}
+
+// peers replicates state between nsqlookupd instances.
+//
+// Operations made through the HTTP API (creating, deleting and tombstoning)
+// are pushed to all peers right away. In addition the state of every peer
+// is pulled periodically, which is how producers connected to other
+// instances are learned and how peers that missed a push (eg. because they
+// were down) catch up. The result is eventually consistent.
+type peers struct {
+	ctx    *Context
+	client *http_api.Client
+
+	sync.Mutex
+	deleted map[Registration]*tombstone
+
+	opChan chan peerOp
+}
+
+func newPeers(ctx *Context) *peers {
+	return &peers{
+		ctx:     ctx,
+		client:  newPeerClient(ctx),
+		deleted: make(map[Registration]*tombstone),
+		opChan:  make(chan peerOp, maxPendingPeerOps),
+	}
+}
+
+func newPeerClient(ctx *Context) *http_api.Client {
+	var tlsConfig *tls.Config
+	if ctx.nsqlookupd.tlsConfig != nil {
+		// present our certificate, in case peers require one
+		tlsConfig = &tls.Config{
+			Certificates: ctx.nsqlookupd.tlsConfig.Certificates,
+			RootCAs:      ctx.nsqlookupd.tlsConfig.RootCAs,
+			MinVersion:   ctx.nsqlookupd.tlsConfig.MinVersion,
+		}
+	}
+	client := http_api.NewClient(tlsConfig, 2*time.Second, 5*time.Second)
+	client.SetAuthToken(ctx.nsqlookupd.opts.PeerHTTPAuthToken)
+	return client
+}
+
+// replicate queues an operation for delivery to all peers, it is dropped
+// (and left to the periodic sync) if too many are pending
+func (p *peers) replicate(op peerOp) {
+	if len(p.ctx.nsqlookupd.opts.PeerHTTPAddresses) == 0 {
+		return
+	}
+	select {
+	case p.opChan <- op:
+	default:
+		p.ctx.nsqlookupd.logf(LOG_WARN, "PEERS: too many pending operations, dropping %s %s:%s",
+			op.op, op.topic, op.channel)
+	}
+}
+
+// recordDeleted remembers a deletion so that it isn't undone by the
+// state of a peer that hasn't seen it yet
+func (p *peers) recordDeleted(k Registration, at time.Time) {
+	p.Lock()
+	defer p.Unlock()
+	if cur, ok := p.deleted[k]; ok && !at.After(cur.at) {
+		return
+	}
+	ts := &tombstone{at: at, unconfirmed: make(map[string]bool)}
+	for _, addr := range p.ctx.nsqlookupd.opts.PeerHTTPAddresses {
+		ts.unconfirmed[addr] = true
+	}
+	p.deleted[k] = ts
+}
+
+// Deleted returns the remembered deletions, to be persisted
+func (p *peers) Deleted() []deletedRegistration {
+	p.Lock()
+	defer p.Unlock()
+	deleted := make([]deletedRegistration, 0, len(p.deleted))
+	for k, ts := range p.deleted {
+		d := deletedRegistration{Registration: k, DeletedAt: ts.at}
+		for addr := range ts.unconfirmed {
+			d.Unconfirmed = append(d.Unconfirmed, addr)
+		}
+		deleted = append(deleted, d)
+	}
+	return deleted
+}
+
+// RestoreDeleted adds persisted deletions, peers that are no longer
+// configured don't have to confirm them anymore
+func (p *peers) RestoreDeleted(deleted []deletedRegistration) {
+	configured := make(map[string]bool)
+	for _, addr := range p.ctx.nsqlookupd.opts.PeerHTTPAddresses {
+		configured[addr] = true
+	}
+	p.Lock()
+	defer p.Unlock()
+	for _, d := range deleted {
+		ts := &tombstone{at: d.DeletedAt, unconfirmed: make(map[string]bool)}
+		for _, addr := range d.Unconfirmed {
+			if configured[addr] {
+				ts.unconfirmed[addr] = true
+			}
+		}
+		p.deleted[d.Registration] = ts
+	}
+}
+
+func (p *peers) forgetDeleted(k Registration) {
+	p.Lock()
+	delete(p.deleted, k)
+	p.Unlock()
+}
+
+func (p *peers) isDeleted(k Registration) bool {
+	p.Lock()
+	_, ok := p.deleted[k]
+	p.Unlock()
+	return ok
+}
+
+// State returns the state this nsqlookupd shares with its peers
+func (p *peers) State() peerState {
+	p.Lock()
+	deleted := make([]deletedRegistration, 0, len(p.deleted))
+	for k, ts := range p.deleted {
+		deleted = append(deleted, deletedRegistration{Registration: k, DeletedAt: ts.at})
+	}
+	p.Unlock()
+	return peerState{
+		Registrations: p.ctx.nsqlookupd.DB.Snapshot(),
+		Deleted:       deleted,
+	}
+}
+
+func (p *peers) pushLoop() {
+	for {
+		select {
+		case op := <-p.opChan:
+			qs := op.values().Encode()
+			for _, addr := range p.ctx.nsqlookupd.opts.PeerHTTPAddresses {
+				endpoint := fmt.Sprintf("http://%s/peer/apply?%s", addr, qs)
+				var err error
+				for i := 0; i < peerOpDeliverAttempts; i++ {
+					err = p.client.POSTV1(endpoint)
+					if err == nil {
+						break
+					}
+				}
+				if err != nil {
+					p.ctx.nsqlookupd.logf(LOG_WARN, "PEERS: failed to replicate %s to %s - %s",
+						op.op, addr, err)
+				}
+			}
+		case <-p.ctx.nsqlookupd.exitChan:
+			return
+		}
+	}
+}
+
+func (p *peers) syncLoop() {
+	ticker := time.NewTicker(p.ctx.nsqlookupd.opts.PeerSyncInterval)
+	for {
+		select {
+		case <-ticker.C:
+			for _, addr := range p.ctx.nsqlookupd.opts.PeerHTTPAddresses {
+				err := p.sync(addr)
+				if err != nil {
+					p.ctx.nsqlookupd.logf(LOG_WARN, "PEERS: failed to sync with %s - %s", addr, err)
+				}
+			}
+			p.pruneDeleted()
+		case <-p.ctx.nsqlookupd.exitChan:
+			goto exit
+		}
+	}
+
+exit:
+	ticker.Stop()
+}
+
+// sync pulls the state of a peer and applies it
+func (p *peers) sync(addr string) error {
+	var state peerState
+	err := p.client.GETV1(fmt.Sprintf("http://%s/peer/state", addr), &state)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range state.Deleted {
+		p.Lock()
+		_, seen := p.deleted[d.Registration]
+		p.Unlock()
+		if seen {
+			continue
+		}
+		p.recordDeleted(d.Registration, d.DeletedAt)
+		// a producer connected to us has registered it since
+		if len(p.ctx.nsqlookupd.DB.FindProducers(d.Category, d.Key, d.SubKey).Local()) > 0 {
+			continue
+		}
+		p.ctx.nsqlookupd.logf(LOG_INFO, "PEERS: removing %s(%s:%s) deleted on %s",
+			d.Category, d.Key, d.SubKey, addr)
+		p.ctx.nsqlookupd.DB.RemoveRegistration(d.Registration)
+	}
+
+	p.confirmDeleted(addr, state)
+
+	var snaps []registrationSnapshot
+	for _, snap := range state.Registrations {
+		if p.isDeleted(snap.Registration) {
+			continue
+		}
+		snaps = append(snaps, snap)
+	}
+	p.ctx.nsqlookupd.DB.ReplaceRemoteProducers(addr, snaps)
+	return nil
+}
+
+// confirmDeleted marks the deletions the state of a peer shows it has seen,
+// it either doesn't have the registration anymore or knows of the deletion
+func (p *peers) confirmDeleted(addr string, state peerState) {
+	has := make(map[Registration]bool, len(state.Registrations))
+	for _, snap := range state.Registrations {
+		has[snap.Registration] = true
+	}
+	for _, d := range state.Deleted {
+		has[d.Registration] = false
+	}
+	p.Lock()
+	for k, ts := range p.deleted {
+		if ts.unconfirmed[addr] && !has[k] {
+			delete(ts.unconfirmed, addr)
+		}
+	}
+	p.Unlock()
+}
+
+// pruneDeleted forgets deletions that all peers have seen, once they are
+// older than the time a producer can stay inactive
+func (p *peers) pruneDeleted() {
+	lifetime := p.ctx.nsqlookupd.opts.InactiveProducerTimeout
+	if lifetime < 2*p.ctx.nsqlookupd.opts.PeerSyncInterval {
+		lifetime = 2 * p.ctx.nsqlookupd.opts.PeerSyncInterval
+	}
+	now := time.Now()
+	p.Lock()
+	for k, ts := range p.deleted {
+		if now.Sub(ts.at) > lifetime && len(ts.unconfirmed) == 0 {
+			delete(p.deleted, k)
+		}
+	}
+	p.Unlock()
+}
+
+// isRemote returns true if the producer is connected to a peer
+func (p *Producer) isRemote() bool {
+	return strings.HasPrefix(p.peerInfo.id, remoteIDPrefix)
+}
+
+// Local returns the producers connected to this nsqlookupd (or restored)
+func (pp Producers) Local() Producers {
+	results := Producers{}
+	for _, p := range pp {
+		if !p.isRemote() {
+			results = append(results, p)
+		}
+	}
+	return results
+}
+
+// ReplaceRemoteProducers replaces all producers learned from the given peer
+// with the ones in its current state, adding any registrations that are new.
+// Producers of an nsqd that is also connected to this nsqlookupd are skipped.
+func (r *RegistrationDB) ReplaceRemoteProducers(peer string, snaps []registrationSnapshot) {
+	prefix := remoteIDPrefix + peer + "/"
+
+	r.Lock()
+	defer r.Unlock()
+
+	// to only emit events for what actually changed
+	previous := make(map[Registration]ProducerMap)
+	for id, registrations := range r.index.byProducer {
+		if !strings.HasPrefix(id, prefix) {
+			continue
+		}
+		for k := range registrations {
+			if previous[k] == nil {
+				previous[k] = make(ProducerMap)
+			}
+			previous[k][id] = r.registrationMap[k][id]
+			r.deleteProducer(k, id)
+		}
+	}
+
+	peerInfos := make(map[string]*PeerInfo)
+	for _, snap := range snaps {
+		producers := r.addRegistration(snap.Registration)
+	producers:
+		for _, ps := range snap.Producers {
+			if ps.PeerInfo == nil {
+				continue
+			}
+			for _, p := range producers {
+				if p.peerInfo.BroadcastAddress == ps.BroadcastAddress &&
+					p.peerInfo.TCPPort == ps.TCPPort {
+					// connected here too (or known through another peer)
+					continue producers
+				}
+			}
+			id := prefix + ps.ID
+			pi, ok := peerInfos[id]
+			if !ok {
+				pi = ps.PeerInfo
+				pi.id = id
+				atomic.StoreInt64(&pi.lastUpdate, ps.LastUpdate)
+				peerInfos[id] = pi
+			}
+			r.putProducer(snap.Registration, &Producer{
+				peerInfo:     pi,
+				tombstoned:   ps.Tombstoned,
+				tombstonedAt: ps.TombstonedAt,
+			})
+			old, existed := previous[snap.Registration][id]
+			delete(previous[snap.Registration], id)
+			switch {
+			case !existed:
+				r.emit(EventProducerAdded, snap.Registration, pi)
+			case ps.Tombstoned && !old.tombstoned:
+				r.emit(EventProducerTombstoned, snap.Registration, pi)
+			}
+		}
+		// metadata is reported by the nsqd, prefer what a local one reported
+		if snap.Metadata != nil && !snap.Metadata.IsEmpty() &&
+			len(ProducerMap2Slice(producers).Local()) == 0 {
+			r.metadataMap[snap.Registration] = *snap.Metadata
+		}
+	}
+
+	for k, producers := range previous {
+		for _, p := range producers {
+			r.emit(EventProducerRemoved, k, p.peerInfo)
+		}
+	}
+}
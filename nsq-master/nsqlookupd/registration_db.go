@@ -2,6 +2,8 @@ package nsqlookupd
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -10,6 +12,39 @@ import (
 type RegistrationDB struct {
 	sync.RWMutex
 	registrationMap map[Registration]ProducerMap
+	metadataMap     map[Registration]Metadata
+	index           registrationIndex
+
+	// changes, for watchers
+	epoch    string
+	revision uint64
+	events   []Event
+	changed  chan struct{}
+}
+
+// Metadata is the description and labels an nsqd reported for a
+// topic or channel registration
+type Metadata struct {
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// IsEmpty returns true if neither a description nor any labels are set
+func (m Metadata) IsEmpty() bool {
+	return m.Description == "" && len(m.Labels) == 0
+}
+
+// HasLabels returns true if all of the given labels match, each either
+// "name" (label is set to any value) or "name:value"
+func (m Metadata) HasLabels(labels []string) bool {
+	for _, l := range labels {
+		parts := strings.SplitN(l, ":", 2)
+		v, ok := m.Labels[parts[0]]
+		if !ok || (len(parts) == 2 && v != parts[1]) {
+			return false
+		}
+	}
+	return true
 }
 
 type Registration struct {
@@ -28,6 +63,8 @@ type PeerInfo struct {
 	TCPPort          int    `json:"tcp_port"`
 	HTTPPort         int    `json:"http_port"`
 	Version          string `json:"version"`
+	Zone             string `json:"zone,omitempty"`
+	Region           string `json:"region,omitempty"`
 }
 
 type Producer struct {
@@ -43,6 +80,12 @@ func (p *Producer) String() string {
 	return fmt.Sprintf("%s [%d, %d]", p.peerInfo.BroadcastAddress, p.peerInfo.TCPPort, p.peerInfo.HTTPPort)
 }
 
+// isRestored returns true if the producer was restored from a snapshot and
+// no client has connected for it (yet)
+func (p *Producer) isRestored() bool {
+	return strings.HasPrefix(p.peerInfo.id, restoredIDPrefix)
+}
+
 func (p *Producer) Tombstone() {
 	p.tombstoned = true
 	p.tombstonedAt = time.Now()
@@ -55,6 +98,10 @@ func (p *Producer) IsTombstoned(lifetime time.Duration) bool {
 func NewRegistrationDB() *RegistrationDB {
 	return &RegistrationDB{
 		registrationMap: make(map[Registration]ProducerMap),
+		metadataMap:     make(map[Registration]Metadata),
+		index:           newRegistrationIndex(),
+		epoch:           newEpoch(),
+		changed:         make(chan struct{}),
 	}
 }
 
@@ -62,24 +109,32 @@ func NewRegistrationDB() *RegistrationDB {
 func (r *RegistrationDB) AddRegistration(k Registration) {
 	r.Lock()
 	defer r.Unlock()
-	_, ok := r.registrationMap[k]
-	if !ok {
-		r.registrationMap[k] = make(map[string]*Producer)
-	}
+	r.addRegistration(k)
 }
 
 // add a producer to a registration
 func (r *RegistrationDB) AddProducer(k Registration, p *Producer) bool {
 	r.Lock()
 	defer r.Unlock()
-	_, ok := r.registrationMap[k]
-	if !ok {
-		r.registrationMap[k] = make(map[string]*Producer)
-	}
-	producers := r.registrationMap[k]
+	producers := r.addRegistration(k)
 	_, found := producers[p.peerInfo.id]
 	if found == false {
-		producers[p.peerInfo.id] = p
+		// the nsqd reconnected after a restart of nsqlookupd (or connected
+		// to a peer as well), it takes over the restored (or remote) registration
+		for id, rp := range producers {
+			if !(rp.isRestored() || rp.isRemote()) || rp.peerInfo.BroadcastAddress != p.peerInfo.BroadcastAddress ||
+				rp.peerInfo.TCPPort != p.peerInfo.TCPPort {
+				continue
+			}
+			if rp.tombstoned && !p.tombstoned {
+				p.tombstoned = true
+				p.tombstonedAt = rp.tombstonedAt
+			}
+			r.deleteProducer(k, id)
+			r.emit(EventProducerRemoved, k, rp.peerInfo)
+		}
+		r.putProducer(k, p)
+		r.emit(EventProducerAdded, k, p.peerInfo)
 	}
 	return !found
 }
@@ -93,12 +148,13 @@ func (r *RegistrationDB) RemoveProducer(k Registration, id string) (bool, int) {
 		return false, 0
 	}
 	removed := false
-	if _, exists := producers[id]; exists {
+	if p, exists := producers[id]; exists {
 		removed = true
+		r.emit(EventProducerRemoved, k, p.peerInfo)
 	}
 
 	// Note: this leaves keys in the DB even if they have empty lists
-	delete(producers, id)
+	r.deleteProducer(k, id)
 	return removed, len(producers)
 }
 
@@ -106,7 +162,31 @@ func (r *RegistrationDB) RemoveProducer(k Registration, id string) (bool, int) {
 func (r *RegistrationDB) RemoveRegistration(k Registration) {
 	r.Lock()
 	defer r.Unlock()
-	delete(r.registrationMap, k)
+	r.removeRegistration(k)
+}
+
+// set (or clear, when empty) the metadata of a registration
+func (r *RegistrationDB) SetMetadata(k Registration, m Metadata) {
+	r.Lock()
+	defer r.Unlock()
+	if m.IsEmpty() {
+		delete(r.metadataMap, k)
+		return
+	}
+	r.metadataMap[k] = m
+}
+
+// returns the metadata of all the given registrations that have any
+func (r *RegistrationDB) FindMetadata(rr Registrations) map[Registration]Metadata {
+	r.RLock()
+	defer r.RUnlock()
+	results := make(map[Registration]Metadata)
+	for _, k := range rr {
+		if m, ok := r.metadataMap[k]; ok {
+			results[k] = m
+		}
+	}
+	return results
 }
 
 func (r *RegistrationDB) needFilter(key string, subkey string) bool {
@@ -124,7 +204,7 @@ func (r *RegistrationDB) FindRegistrations(category string, key string, subkey s
 		return Registrations{}
 	}
 	results := Registrations{}
-	for k := range r.registrationMap {
+	for k := range r.candidates(category, key) {
 		if !k.IsMatch(category, key, subkey) {
 			continue
 		}
@@ -143,11 +223,11 @@ func (r *RegistrationDB) FindProducers(category string, key string, subkey strin
 
 	results := make(map[string]struct{})
 	var retProducers Producers
-	for k, producers := range r.registrationMap {
+	for k := range r.candidates(category, key) {
 		if !k.IsMatch(category, key, subkey) {
 			continue
 		}
-		for _, producer := range producers {
+		for _, producer := range r.registrationMap[k] {
 			_, found := results[producer.peerInfo.id]
 			if found == false {
 				results[producer.peerInfo.id] = struct{}{}
@@ -162,10 +242,8 @@ func (r *RegistrationDB) LookupRegistrations(id string) Registrations {
 	r.RLock()
 	defer r.RUnlock()
 	results := Registrations{}
-	for k, producers := range r.registrationMap {
-		if _, exists := producers[id]; exists {
-			results = append(results, k)
-		}
+	for k := range r.index.byProducer[id] {
+		results = append(results, k)
 	}
 	return results
 }
@@ -230,6 +308,43 @@ func (pp Producers) PeerInfo() []*PeerInfo {
 	return results
 }
 
+// locality tiers of a producer relative to a consumer
+const (
+	localitySameZone = iota
+	localitySameRegion
+	localityOther
+)
+
+func (p *Producer) locality(zone string, region string) int {
+	if zone != "" && p.peerInfo.Zone == zone {
+		return localitySameZone
+	}
+	if region != "" && p.peerInfo.Region == region {
+		return localitySameRegion
+	}
+	return localityOther
+}
+
+// SortByLocality orders the producers in the given zone first, followed by
+// the ones in the given region and then all others. If filter is true only
+// the producers of the closest tier that has any are returned.
+func (pp Producers) SortByLocality(zone string, region string, filter bool) Producers {
+	results := make(Producers, len(pp))
+	copy(results, pp)
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].locality(zone, region) < results[j].locality(zone, region)
+	})
+	if filter && len(results) > 0 {
+		closest := results[0].locality(zone, region)
+		for i, p := range results {
+			if p.locality(zone, region) != closest {
+				return results[:i]
+			}
+		}
+	}
+	return results
+}
+
 func ProducerMap2Slice(pm ProducerMap) Producers {
 	var producers Producers
 	for _, producer := range pm {
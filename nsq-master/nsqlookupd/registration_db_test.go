@@ -12,9 +12,9 @@ import (
 func TestRegistrationDB(t *testing.T) {
 	sec30 := 30 * time.Second
 	beginningOfTime := time.Unix(1348797047, 0)
-	pi1 := &PeerInfo{beginningOfTime.UnixNano(), "1", "remote_addr:1", "host", "b_addr", 1, 2, "v1"}
-	pi2 := &PeerInfo{beginningOfTime.UnixNano(), "2", "remote_addr:2", "host", "b_addr", 2, 3, "v1"}
-	pi3 := &PeerInfo{beginningOfTime.UnixNano(), "3", "remote_addr:3", "host", "b_addr", 3, 4, "v1"}
+	pi1 := &PeerInfo{beginningOfTime.UnixNano(), "1", "remote_addr:1", "host", "b_addr", 1, 2, "v1", "", ""}
+	pi2 := &PeerInfo{beginningOfTime.UnixNano(), "2", "remote_addr:2", "host", "b_addr", 2, 3, "v1", "", ""}
+	pi3 := &PeerInfo{beginningOfTime.UnixNano(), "3", "remote_addr:3", "host", "b_addr", 3, 4, "v1", "", ""}
 	p1 := &Producer{pi1, false, beginningOfTime}
 	p2 := &Producer{pi2, false, beginningOfTime}
 	p3 := &Producer{pi3, false, beginningOfTime}
@@ -119,6 +119,70 @@ func fillRegDB(registrations int, producers int) *RegistrationDB {
 	return regDB
 }
 
+func TestRegistrationDBMetadata(t *testing.T) {
+	db := NewRegistrationDB()
+
+	topic := Registration{"topic", "a", ""}
+	channel := Registration{"channel", "a", "ch"}
+	db.AddRegistration(topic)
+	db.AddRegistration(channel)
+
+	db.SetMetadata(topic, Metadata{Description: "d", Labels: map[string]string{"owner": "x"}})
+	db.SetMetadata(channel, Metadata{Labels: map[string]string{"sla": "1m"}})
+
+	m := db.FindMetadata(db.FindRegistrations("topic", "*", ""))
+	test.Equal(t, 1, len(m))
+	test.Equal(t, "d", m[topic].Description)
+	test.Equal(t, true, m[topic].HasLabels([]string{"owner"}))
+	test.Equal(t, true, m[topic].HasLabels([]string{"owner:x"}))
+	test.Equal(t, false, m[topic].HasLabels([]string{"owner:y"}))
+	test.Equal(t, false, m[topic].HasLabels([]string{"sla"}))
+
+	// clearing
+	db.SetMetadata(topic, Metadata{})
+	m = db.FindMetadata(Registrations{topic, channel})
+	test.Equal(t, 1, len(m))
+
+	db.RemoveRegistration(channel)
+	m = db.FindMetadata(Registrations{topic, channel})
+	test.Equal(t, 0, len(m))
+}
+
+func TestRegistrationDBSnapshot(t *testing.T) {
+	now := time.Now()
+	pi := &PeerInfo{now.UnixNano(), "1", "remote_addr:1", "host", "b_addr", 1, 2, "v1", "", ""}
+	stale := &PeerInfo{now.Add(-time.Hour).UnixNano(), "2", "remote_addr:2", "host", "b_addr", 2, 3, "v1", "", ""}
+
+	db := NewRegistrationDB()
+	topic := Registration{"topic", "a", ""}
+	db.AddProducer(topic, &Producer{pi, true, now})
+	db.AddProducer(topic, &Producer{stale, false, now})
+	db.AddRegistration(Registration{"topic", "b", ""})
+	db.SetMetadata(topic, Metadata{Description: "d"})
+
+	restored := NewRegistrationDB()
+	restored.Restore(db.Snapshot())
+	test.Equal(t, 2, len(restored.FindRegistrations("topic", "*", "")))
+	test.Equal(t, "d", restored.FindMetadata(Registrations{topic})[topic].Description)
+
+	producers := restored.FindProducers("topic", "a", "")
+	test.Equal(t, 2, len(producers))
+	for _, p := range producers {
+		test.Equal(t, true, p.isRestored())
+		test.Equal(t, p.peerInfo.TCPPort == 1, p.IsTombstoned(time.Minute))
+	}
+
+	test.Equal(t, 1, restored.RemoveRestoredProducers(time.Minute))
+
+	// a new connection of the same nsqd takes over
+	p := &Producer{peerInfo: &PeerInfo{id: "3", BroadcastAddress: "b_addr", TCPPort: 1}}
+	restored.AddProducer(topic, p)
+	producers = restored.FindProducers("topic", "a", "")
+	test.Equal(t, 1, len(producers))
+	test.Equal(t, p, producers[0])
+	test.Equal(t, true, p.IsTombstoned(time.Minute))
+}
+
 func benchmarkLookupRegistrations(b *testing.B, registrations int, producers int) {
 	regDB := fillRegDB(registrations, producers)
 	b.ResetTimer()
@@ -216,3 +280,119 @@ func BenchmarkDoLookup512x512(b *testing.B) {
 func BenchmarkDoLookup512x2048(b *testing.B) {
 	benchmarkDoLookup(b, 512, 2048)
 }
+
+func TestRegistrationDBEvents(t *testing.T) {
+	db := NewRegistrationDB()
+	pi := &PeerInfo{id: "1", BroadcastAddress: "b_addr", TCPPort: 1}
+	k := Registration{"topic", "a", ""}
+
+	db.AddProducer(k, &Producer{peerInfo: pi})
+	db.AddProducer(k, &Producer{peerInfo: pi})
+	db.TombstoneProducer(k, db.FindProducers("topic", "a", "")[0])
+	db.RemoveProducer(k, "1")
+	db.RemoveRegistration(k)
+
+	events, revision, _, err := db.EventsSince(0)
+	test.Nil(t, err)
+	test.Equal(t, uint64(5), revision)
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	test.Equal(t, []string{EventRegistrationAdded, EventProducerAdded, EventProducerTombstoned,
+		EventProducerRemoved, EventRegistrationRemoved}, types)
+
+	events, _, changed, err := db.EventsSince(5)
+	test.Nil(t, err)
+	test.Equal(t, 0, len(events))
+	db.AddRegistration(Registration{"topic", "b", ""})
+	<-changed
+	events, _, _, _ = db.EventsSince(3)
+	test.Equal(t, 3, len(events))
+	test.Equal(t, uint64(4), events[0].Revision)
+
+	for i := 0; i < 2*maxWatchEvents; i++ {
+		db.AddProducer(k, &Producer{peerInfo: pi})
+		db.RemoveProducer(k, "1")
+	}
+	_, _, _, err = db.EventsSince(3)
+	test.Equal(t, ErrRevisionCompacted, err)
+	events, _, _, err = db.EventsSince(db.Revision() - 10)
+	test.Nil(t, err)
+	test.Equal(t, 10, len(events))
+}
+
+func TestRegistrationDBRevisionToken(t *testing.T) {
+	db := NewRegistrationDB()
+	db.AddRegistration(Registration{"topic", "a", ""})
+
+	revision, err := db.ParseRevisionToken(db.RevisionToken(db.Revision()))
+	test.Nil(t, err)
+	test.Equal(t, uint64(1), revision)
+
+	// a restarted nsqlookupd is at the same revision again
+	_, err = db.ParseRevisionToken(NewRegistrationDB().RevisionToken(1))
+	test.Equal(t, ErrRevisionCompacted, err)
+
+	for _, token := range []string{"", "1", "-1", "abc-", "abc-x"} {
+		_, err = db.ParseRevisionToken(token)
+		test.Equal(t, ErrInvalidRevision, err)
+	}
+}
+
+func TestRegistrationDBFindByPrefix(t *testing.T) {
+	db := NewRegistrationDB()
+	for _, name := range []string{"b", "ab", "a", "abc", "c"} {
+		db.AddRegistration(Registration{"topic", name, ""})
+		db.AddRegistration(Registration{"channel", "t", name})
+	}
+	db.AddRegistration(Registration{"channel", "u", "ab"})
+
+	test.Equal(t, []string{"a", "ab", "abc", "b", "c"}, db.FindRegistrationsByPrefix("topic", "*", "", "").Keys())
+	test.Equal(t, []string{"ab", "abc"}, db.FindRegistrationsByPrefix("topic", "*", "", "ab").Keys())
+	test.Equal(t, 0, len(db.FindRegistrationsByPrefix("topic", "*", "", "d")))
+	test.Equal(t, []string{"ab", "abc"}, db.FindRegistrationsByPrefix("channel", "t", "*", "ab").SubKeys())
+
+	db.RemoveRegistration(Registration{"topic", "ab", ""})
+	db.RemoveRegistration(Registration{"channel", "t", "abc"})
+	test.Equal(t, []string{"abc"}, db.FindRegistrationsByPrefix("topic", "*", "", "ab").Keys())
+	test.Equal(t, []string{"ab"}, db.FindRegistrationsByPrefix("channel", "t", "*", "ab").SubKeys())
+	test.Equal(t, []string{"ab"}, db.FindRegistrationsByPrefix("channel", "u", "*", "").SubKeys())
+}
+
+func TestRegistrationDBIndex(t *testing.T) {
+	pi1 := &PeerInfo{id: "1", BroadcastAddress: "b_addr", TCPPort: 1, HTTPPort: 2}
+	pi2 := &PeerInfo{id: "2", BroadcastAddress: "b_addr", TCPPort: 3, HTTPPort: 4}
+
+	db := NewRegistrationDB()
+	db.AddProducer(Registration{"topic", "a", ""}, &Producer{peerInfo: pi1})
+	db.AddProducer(Registration{"channel", "a", "x"}, &Producer{peerInfo: pi1})
+	db.AddProducer(Registration{"channel", "a", "y"}, &Producer{peerInfo: pi2})
+	db.AddProducer(Registration{"topic", "b", ""}, &Producer{peerInfo: pi2})
+	db.AddProducer(Registration{"channel", "b", "x"}, &Producer{peerInfo: pi2})
+
+	test.Equal(t, 2, len(db.FindRegistrations("topic", "*", "")))
+	test.Equal(t, 2, len(db.FindRegistrations("channel", "a", "*")))
+	test.Equal(t, 2, len(db.FindRegistrations("channel", "*", "x")))
+	test.Equal(t, 2, len(db.LookupRegistrations("1")))
+	test.Equal(t, 3, len(db.LookupRegistrations("2")))
+
+	db.RemoveProducer(Registration{"channel", "a", "y"}, "2")
+	test.Equal(t, 2, len(db.LookupRegistrations("2")))
+	// the registration is kept without producers
+	test.Equal(t, 2, len(db.FindRegistrations("channel", "a", "*")))
+	test.Equal(t, 0, len(db.FindProducers("channel", "a", "y")))
+
+	db.RemoveRegistration(Registration{"channel", "a", "x"})
+	test.Equal(t, 1, len(db.LookupRegistrations("1")))
+	test.Equal(t, 1, len(db.FindRegistrations("channel", "a", "*")))
+	test.Equal(t, 1, len(db.FindRegistrations("channel", "*", "x")))
+
+	db.RemoveRegistration(Registration{"topic", "a", ""})
+	db.RemoveRegistration(Registration{"channel", "a", "y"})
+	test.Equal(t, 0, len(db.LookupRegistrations("1")))
+	test.Equal(t, 0, len(db.FindRegistrations("channel", "a", "*")))
+	test.Equal(t, Registrations{{"topic", "b", ""}}, db.FindRegistrations("topic", "*", ""))
+	test.Equal(t, 0, len(db.index.byProducer["1"]))
+	test.Equal(t, 2, len(db.index.byKey))
+}
@@ -0,0 +1,209 @@
+package nsqlookupd
+
+import (
+	"sort"
+	"strings"
+)
+
+// registrationIndex allows finding registrations without scanning all of
+// them, by category (eg. all topics), by category and key (eg. all channels
+// of a topic) and by producer (eg. all registrations of an nsqd). The
+// registrations by category and by key are also kept sorted, for prefix
+// queries and pagination.
+type registrationIndex struct {
+	byCategory map[string]map[Registration]struct{}
+	byKey      map[Registration]map[Registration]struct{}
+	byProducer map[string]map[Registration]struct{}
+
+	sortedByCategory map[string]sortedRegistrations
+	sortedByKey      map[Registration]sortedRegistrations
+}
+
+func newRegistrationIndex() registrationIndex {
+	return registrationIndex{
+		byCategory:       make(map[string]map[Registration]struct{}),
+		byKey:            make(map[Registration]map[Registration]struct{}),
+		byProducer:       make(map[string]map[Registration]struct{}),
+		sortedByCategory: make(map[string]sortedRegistrations),
+		sortedByKey:      make(map[Registration]sortedRegistrations),
+	}
+}
+
+// sortedRegistrations are ordered by key, then subkey
+type sortedRegistrations []Registration
+
+func registrationLess(a, b Registration) bool {
+	if a.Key != b.Key {
+		return a.Key < b.Key
+	}
+	return a.SubKey < b.SubKey
+}
+
+func (s sortedRegistrations) search(k Registration) int {
+	return sort.Search(len(s), func(i int) bool { return !registrationLess(s[i], k) })
+}
+
+func (s sortedRegistrations) insert(k Registration) sortedRegistrations {
+	i := s.search(k)
+	if i < len(s) && s[i] == k {
+		return s
+	}
+	s = append(s, Registration{})
+	copy(s[i+1:], s[i:])
+	s[i] = k
+	return s
+}
+
+func (s sortedRegistrations) remove(k Registration) sortedRegistrations {
+	i := s.search(k)
+	if i == len(s) || s[i] != k {
+		return s
+	}
+	return append(s[:i], s[i+1:]...)
+}
+
+// withPrefix returns the registrations whose name starts with prefix, the
+// name being the key, or the subkey if bySubKey
+func (s sortedRegistrations) withPrefix(prefix string, bySubKey bool) sortedRegistrations {
+	name := func(i int) string {
+		if bySubKey {
+			return s[i].SubKey
+		}
+		return s[i].Key
+	}
+	start := sort.Search(len(s), func(i int) bool { return name(i) >= prefix })
+	end := start + sort.Search(len(s)-start, func(i int) bool {
+		return !strings.HasPrefix(name(start+i), prefix)
+	})
+	return s[start:end]
+}
+
+// keyOf is the byKey index entry of a registration
+func keyOf(k Registration) Registration {
+	return Registration{k.Category, k.Key, ""}
+}
+
+// addRegistration returns the producers of a registration, adding it if
+// it doesn't exist, the caller has to hold the write lock
+func (r *RegistrationDB) addRegistration(k Registration) ProducerMap {
+	producers, ok := r.registrationMap[k]
+	if ok {
+		return producers
+	}
+	producers = make(ProducerMap)
+	r.registrationMap[k] = producers
+
+	set, ok := r.index.byCategory[k.Category]
+	if !ok {
+		set = make(map[Registration]struct{})
+		r.index.byCategory[k.Category] = set
+	}
+	set[k] = struct{}{}
+
+	set, ok = r.index.byKey[keyOf(k)]
+	if !ok {
+		set = make(map[Registration]struct{})
+		r.index.byKey[keyOf(k)] = set
+	}
+	set[k] = struct{}{}
+
+	r.index.sortedByCategory[k.Category] = r.index.sortedByCategory[k.Category].insert(k)
+	r.index.sortedByKey[keyOf(k)] = r.index.sortedByKey[keyOf(k)].insert(k)
+
+	r.emit(EventRegistrationAdded, k, nil)
+	return producers
+}
+
+// removeRegistration removes a registration and all its producers, the
+// caller has to hold the write lock
+func (r *RegistrationDB) removeRegistration(k Registration) {
+	producers, ok := r.registrationMap[k]
+	if !ok {
+		return
+	}
+	for id := range producers {
+		r.unindexProducer(k, id)
+	}
+	delete(r.registrationMap, k)
+	delete(r.metadataMap, k)
+
+	delete(r.index.byCategory[k.Category], k)
+	if len(r.index.byCategory[k.Category]) == 0 {
+		delete(r.index.byCategory, k.Category)
+	}
+	delete(r.index.byKey[keyOf(k)], k)
+	if len(r.index.byKey[keyOf(k)]) == 0 {
+		delete(r.index.byKey, keyOf(k))
+	}
+
+	r.index.sortedByCategory[k.Category] = r.index.sortedByCategory[k.Category].remove(k)
+	if len(r.index.sortedByCategory[k.Category]) == 0 {
+		delete(r.index.sortedByCategory, k.Category)
+	}
+	r.index.sortedByKey[keyOf(k)] = r.index.sortedByKey[keyOf(k)].remove(k)
+	if len(r.index.sortedByKey[keyOf(k)]) == 0 {
+		delete(r.index.sortedByKey, keyOf(k))
+	}
+
+	r.emit(EventRegistrationRemoved, k, nil)
+}
+
+// putProducer adds (or replaces) a producer of a registration, the caller
+// has to hold the write lock
+func (r *RegistrationDB) putProducer(k Registration, p *Producer) {
+	id := p.peerInfo.id
+	r.addRegistration(k)[id] = p
+	set, ok := r.index.byProducer[id]
+	if !ok {
+		set = make(map[Registration]struct{})
+		r.index.byProducer[id] = set
+	}
+	set[k] = struct{}{}
+}
+
+// deleteProducer removes a producer from a registration (which is kept
+// even if it has no producers left), the caller has to hold the write lock
+func (r *RegistrationDB) deleteProducer(k Registration, id string) {
+	producers, ok := r.registrationMap[k]
+	if !ok {
+		return
+	}
+	delete(producers, id)
+	r.unindexProducer(k, id)
+}
+
+func (r *RegistrationDB) unindexProducer(k Registration, id string) {
+	delete(r.index.byProducer[id], k)
+	if len(r.index.byProducer[id]) == 0 {
+		delete(r.index.byProducer, id)
+	}
+}
+
+// candidates returns the registrations that can match the query, the
+// caller has to hold the read lock
+func (r *RegistrationDB) candidates(category string, key string) map[Registration]struct{} {
+	if key == "*" {
+		return r.index.byCategory[category]
+	}
+	return r.index.byKey[Registration{category, key, ""}]
+}
+
+// FindRegistrationsByPrefix returns, in order, the registrations of a
+// category (key "*") whose key starts with prefix, or those of a key
+// (subkey "*") whose subkey does
+func (r *RegistrationDB) FindRegistrationsByPrefix(category string, key string, subkey string, prefix string) Registrations {
+	r.RLock()
+	defer r.RUnlock()
+	var matches sortedRegistrations
+	switch {
+	case key == "*":
+		matches = r.index.sortedByCategory[category].withPrefix(prefix, false)
+	case subkey == "*":
+		matches = r.index.sortedByKey[Registration{category, key, ""}].withPrefix(prefix, true)
+	default:
+		return nil
+	}
+	results := make(Registrations, len(matches))
+	copy(results, matches)
+	return results
+}
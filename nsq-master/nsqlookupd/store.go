@@ -0,0 +1,232 @@
+package nsqlookupd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	// bbolt rather than the unmaintained boltdb/bolt, whose unsafe pointer
+	// conversions fail the checkptr instrumentation of -race on go1.14+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	registrationsBucket = []byte("registrations")
+	deletedBucket       = []byte("deleted")
+)
+
+const restoredIDPrefix = "restored:"
+
+type producerSnapshot struct {
+	ID           string    `json:"id"`
+	LastUpdate   int64     `json:"last_update"`
+	Tombstoned   bool      `json:"tombstoned,omitempty"`
+	TombstonedAt time.Time `json:"tombstoned_at,omitempty"`
+	*PeerInfo
+}
+
+// registrationSnapshot is how a registration, its producers and its
+// metadata are stored on disk
+type registrationSnapshot struct {
+	Registration
+	Producers []producerSnapshot `json:"producers"`
+	Metadata  *Metadata          `json:"metadata,omitempty"`
+}
+
+// Snapshot returns the current state of all registrations
+func (r *RegistrationDB) Snapshot() []registrationSnapshot {
+	r.RLock()
+	defer r.RUnlock()
+	snaps := make([]registrationSnapshot, 0, len(r.registrationMap))
+	for k, producers := range r.registrationMap {
+		snap := registrationSnapshot{
+			Registration: k,
+			Producers:    make([]producerSnapshot, 0, len(producers)),
+		}
+		for _, p := range producers {
+			if p.isRemote() {
+				// the peer it's connected to is the source of truth
+				continue
+			}
+			pi := PeerInfo{
+				RemoteAddress:    p.peerInfo.RemoteAddress,
+				Hostname:         p.peerInfo.Hostname,
+				BroadcastAddress: p.peerInfo.BroadcastAddress,
+				TCPPort:          p.peerInfo.TCPPort,
+				HTTPPort:         p.peerInfo.HTTPPort,
+				Version:          p.peerInfo.Version,
+				Zone:             p.peerInfo.Zone,
+				Region:           p.peerInfo.Region,
+			}
+			snap.Producers = append(snap.Producers, producerSnapshot{
+				ID:           p.peerInfo.id,
+				LastUpdate:   atomic.LoadInt64(&p.peerInfo.lastUpdate),
+				Tombstoned:   p.tombstoned,
+				TombstonedAt: p.tombstonedAt,
+				PeerInfo:     &pi,
+			})
+		}
+		if m, ok := r.metadataMap[k]; ok {
+			snap.Metadata = &m
+		}
+		snaps = append(snaps, snap)
+	}
+	return snaps
+}
+
+// Restore adds the registrations of a snapshot, their producers are kept
+// apart as restored until the nsqd they belong to connects and registers again
+func (r *RegistrationDB) Restore(snaps []registrationSnapshot) {
+	r.Lock()
+	defer r.Unlock()
+	peerInfos := make(map[string]*PeerInfo)
+	for _, snap := range snaps {
+		producers := r.addRegistration(snap.Registration)
+		for _, ps := range snap.Producers {
+			if ps.PeerInfo == nil {
+				continue
+			}
+			// registrations of the same nsqd share its PeerInfo
+			pi, ok := peerInfos[ps.ID]
+			if !ok {
+				pi = ps.PeerInfo
+				// keep clear of the ids (remote addresses) of new connections
+				pi.id = ps.ID
+				if !strings.HasPrefix(pi.id, restoredIDPrefix) {
+					pi.id = restoredIDPrefix + pi.id
+				}
+				pi.lastUpdate = ps.LastUpdate
+				peerInfos[ps.ID] = pi
+			}
+			if _, exists := producers[pi.id]; exists {
+				continue
+			}
+			r.putProducer(snap.Registration, &Producer{
+				peerInfo:     pi,
+				tombstoned:   ps.Tombstoned,
+				tombstonedAt: ps.TombstonedAt,
+			})
+			r.emit(EventProducerAdded, snap.Registration, pi)
+		}
+		if snap.Metadata != nil && !snap.Metadata.IsEmpty() {
+			r.metadataMap[snap.Registration] = *snap.Metadata
+		}
+	}
+}
+
+// RemoveRestoredProducers removes the restored producers that haven't been
+// taken over by a reconnecting nsqd and have been inactive for longer
+// than inactivityTimeout, it returns how many were removed
+func (r *RegistrationDB) RemoveRestoredProducers(inactivityTimeout time.Duration) int {
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now()
+	removed := 0
+	for k, producers := range r.registrationMap {
+		for id, p := range producers {
+			if !p.isRestored() {
+				continue
+			}
+			cur := time.Unix(0, atomic.LoadInt64(&p.peerInfo.lastUpdate))
+			if now.Sub(cur) > inactivityTimeout {
+				r.deleteProducer(k, id)
+				r.emit(EventProducerRemoved, k, p.peerInfo)
+				removed++
+			}
+		}
+	}
+	return removed
+}
+
+func openStore(dataPath string) (*bolt.DB, error) {
+	fn := path.Join(dataPath, "nsqlookupd.db")
+	db, err := bolt.Open(fn, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s - %s", fn, err)
+	}
+	return db, nil
+}
+
+// saveSnapshot atomically replaces the stored snapshot, the registrations
+// and the deletions remembered for peers
+func saveSnapshot(db *bolt.DB, snaps []registrationSnapshot, deleted []deletedRegistration) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := recreateBucket(tx, registrationsBucket)
+		if err != nil {
+			return err
+		}
+		for i, snap := range snaps {
+			data, err := json.Marshal(snap)
+			if err != nil {
+				return err
+			}
+			err = b.Put([]byte(fmt.Sprintf("%08d", i)), data)
+			if err != nil {
+				return err
+			}
+		}
+
+		b, err = recreateBucket(tx, deletedBucket)
+		if err != nil {
+			return err
+		}
+		for i, d := range deleted {
+			data, err := json.Marshal(d)
+			if err != nil {
+				return err
+			}
+			err = b.Put([]byte(fmt.Sprintf("%08d", i)), data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func recreateBucket(tx *bolt.Tx, name []byte) (*bolt.Bucket, error) {
+	if tx.Bucket(name) != nil {
+		err := tx.DeleteBucket(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tx.CreateBucket(name)
+}
+
+func loadSnapshot(db *bolt.DB) ([]registrationSnapshot, []deletedRegistration, error) {
+	var snaps []registrationSnapshot
+	var deleted []deletedRegistration
+	err := db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(registrationsBucket); b != nil {
+			err := b.ForEach(func(k, v []byte) error {
+				var snap registrationSnapshot
+				err := json.Unmarshal(v, &snap)
+				if err != nil {
+					return fmt.Errorf("failed to parse registration %s - %s", k, err)
+				}
+				snaps = append(snaps, snap)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if b := tx.Bucket(deletedBucket); b != nil {
+			return b.ForEach(func(k, v []byte) error {
+				var d deletedRegistration
+				err := json.Unmarshal(v, &d)
+				if err != nil {
+					return fmt.Errorf("failed to parse deleted registration %s - %s", k, err)
+				}
+				deleted = append(deleted, d)
+				return nil
+			})
+		}
+		return nil
+	})
+	return snaps, deleted, err
+}
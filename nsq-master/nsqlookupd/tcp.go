@@ -16,11 +16,29 @@ type tcpServer struct {
 func (p *tcpServer) Handle(clientConn net.Conn) {
 	p.ctx.nsqlookupd.logf(LOG_INFO, "TCP: new client(%s)", clientConn.RemoteAddr())
 
+	clientConn, isTLS, err := maybeTLS(clientConn, p.ctx.nsqlookupd.tlsConfig)
+	if err != nil {
+		if isTLS {
+			p.ctx.nsqlookupd.logf(LOG_ERROR, "client(%s) failed TLS handshake - %s",
+				clientConn.RemoteAddr(), err)
+		} else {
+			p.ctx.nsqlookupd.logf(LOG_ERROR, "failed to read protocol version - %s", err)
+		}
+		clientConn.Close()
+		return
+	}
+	if !isTLS && p.ctx.nsqlookupd.opts.TLSRequired {
+		protocol.SendResponse(clientConn, []byte("E_TLS_REQUIRED"))
+		clientConn.Close()
+		p.ctx.nsqlookupd.logf(LOG_ERROR, "client(%s) did not use TLS", clientConn.RemoteAddr())
+		return
+	}
+
 	// The client should initialize itself by sending a 4 byte sequence indicating
 	// the version of the protocol that it intends to communicate, this will allow us
 	// to gracefully upgrade the protocol away from text/line oriented to whatever...
 	buf := make([]byte, 4)
-	_, err := io.ReadFull(clientConn, buf)
+	_, err = io.ReadFull(clientConn, buf)
 	if err != nil {
 		p.ctx.nsqlookupd.logf(LOG_ERROR, "failed to read protocol version - %s", err)
 		clientConn.Close()
@@ -0,0 +1,145 @@
+package nsqlookupd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// types of the changes to the RegistrationDB that can be watched
+const (
+	EventRegistrationAdded   = "registration_added"
+	EventRegistrationRemoved = "registration_removed"
+	EventProducerAdded       = "producer_added"
+	EventProducerRemoved     = "producer_removed"
+	EventProducerTombstoned  = "producer_tombstoned"
+)
+
+// maxWatchEvents is how many of the most recent events are kept for
+// watchers to catch up on
+const maxWatchEvents = 4096
+
+// ErrRevisionCompacted is returned when the events after a revision are no
+// longer available, the watcher has to lookup the current state again
+var ErrRevisionCompacted = errors.New("revision compacted")
+
+// ErrInvalidRevision is returned for a revision token that can't be parsed
+var ErrInvalidRevision = errors.New("invalid revision")
+
+// Event is a change to the RegistrationDB, revisions are assigned in order
+// starting at 1 every time nsqlookupd starts. Watchers get them as tokens
+// that include the epoch (see RevisionToken) so that a revision from before
+// a restart isn't mistaken for one of the current process.
+type Event struct {
+	Revision uint64    `json:"revision"`
+	Type     string    `json:"type"`
+	Category string    `json:"category"`
+	Topic    string    `json:"topic,omitempty"`
+	Channel  string    `json:"channel,omitempty"`
+	Producer *PeerInfo `json:"producer,omitempty"`
+}
+
+// emit records an event, the caller has to hold the write lock
+func (r *RegistrationDB) emit(typ string, k Registration, pi *PeerInfo) {
+	r.revision++
+	r.events = append(r.events, Event{
+		Revision: r.revision,
+		Type:     typ,
+		Category: k.Category,
+		Topic:    k.Key,
+		Channel:  k.SubKey,
+		Producer: pi,
+	})
+	// trimmed in bulk so that emitting doesn't copy the whole log every time
+	if len(r.events) >= 2*maxWatchEvents {
+		r.events = append(r.events[:0], r.events[len(r.events)-maxWatchEvents:]...)
+	}
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// newEpoch returns a random ID of this RegistrationDB's lifetime
+func newEpoch() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate epoch - %s", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// RevisionToken returns the revision as handed out to watchers, prefixed
+// with the epoch
+func (r *RegistrationDB) RevisionToken(revision uint64) string {
+	return fmt.Sprintf("%s-%d", r.epoch, revision)
+}
+
+// ParseRevisionToken returns the revision of a token from RevisionToken,
+// ErrRevisionCompacted if it's from another epoch
+func (r *RegistrationDB) ParseRevisionToken(token string) (uint64, error) {
+	i := strings.LastIndexByte(token, '-')
+	if i <= 0 {
+		return 0, ErrInvalidRevision
+	}
+	revision, err := strconv.ParseUint(token[i+1:], 10, 64)
+	if err != nil {
+		return 0, ErrInvalidRevision
+	}
+	if token[:i] != r.epoch {
+		// from before a restart
+		return 0, ErrRevisionCompacted
+	}
+	return revision, nil
+}
+
+// Revision returns the revision of the latest event
+func (r *RegistrationDB) Revision() uint64 {
+	r.RLock()
+	defer r.RUnlock()
+	return r.revision
+}
+
+// EventsSince returns the events after the given revision along with the
+// current revision and a channel that is closed on the next change
+func (r *RegistrationDB) EventsSince(revision uint64) ([]Event, uint64, <-chan struct{}, error) {
+	r.RLock()
+	defer r.RUnlock()
+	if revision > r.revision {
+		// never handed out
+		return nil, r.revision, r.changed, ErrRevisionCompacted
+	}
+	if revision == r.revision {
+		return nil, r.revision, r.changed, nil
+	}
+	if len(r.events) == 0 || r.events[0].Revision > revision+1 {
+		return nil, r.revision, r.changed, ErrRevisionCompacted
+	}
+	events := r.events[revision+1-r.events[0].Revision:]
+	results := make([]Event, len(events))
+	copy(results, events)
+	return results, r.revision, r.changed, nil
+}
+
+// TombstoneProducer tombstones a producer of a registration
+func (r *RegistrationDB) TombstoneProducer(k Registration, p *Producer) {
+	r.Lock()
+	defer r.Unlock()
+	p.Tombstone()
+	r.emit(EventProducerTombstoned, k, p.peerInfo)
+}
+
+// filterEvents returns the events of the given topic (all events if empty)
+func filterEvents(events []Event, topic string) []Event {
+	if topic == "" {
+		return events
+	}
+	results := events[:0]
+	for _, e := range events {
+		if e.Topic == topic {
+			results = append(results, e)
+		}
+	}
+	return results
+}